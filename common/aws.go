@@ -0,0 +1,51 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws/ec2metadata"
+	"github.com/aws/aws-sdk-go/aws/session"
+)
+
+// AWSSessionToken returns the session token of temporary AWS credentials.
+// The value of -session-token (opt) takes precedence over AWS_SESSION_TOKEN.
+func AWSSessionToken(opt string) string {
+	if opt != "" {
+		return opt
+	}
+	return os.Getenv("AWS_SESSION_TOKEN")
+}
+
+// instanceRegion returns the region of the running EC2 instance from the instance metadata.
+// It is a variable to be replaced in tests.
+var instanceRegion = func() (string, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return "", err
+	}
+	return ec2metadata.New(sess).Region()
+}
+
+// Region returns the AWS region to fetch the metrics from, by -region (optRegion) and
+// -prefer-instance-region (preferInstance).
+// The region of the running instance is used when optRegion is empty, or when preferInstance is set
+// and the plugin runs on EC2 (optRegion is used otherwise, e.g. on premises).
+func Region(optRegion string, preferInstance bool) (string, error) {
+	if optRegion != "" && !preferInstance {
+		return optRegion, nil
+	}
+
+	region, err := instanceRegion()
+	if err == nil && region != "" {
+		return region, nil
+	}
+	if optRegion != "" {
+		return optRegion, nil
+	}
+	if err == nil {
+		err = errors.New("empty region")
+	}
+	return "", errors.New(fmt.Sprintf("cannot get the region of the instance (%s). specify -region", err))
+}
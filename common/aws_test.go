@@ -0,0 +1,44 @@
+package common
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAWSSessionToken(t *testing.T) {
+	defer os.Setenv("AWS_SESSION_TOKEN", os.Getenv("AWS_SESSION_TOKEN"))
+
+	os.Setenv("AWS_SESSION_TOKEN", "")
+	assert.Equal(t, AWSSessionToken(""), "")
+
+	os.Setenv("AWS_SESSION_TOKEN", "token-from-env")
+	assert.Equal(t, AWSSessionToken(""), "token-from-env")
+	assert.Equal(t, AWSSessionToken("token-from-flag"), "token-from-flag")
+}
+
+func TestRegion(t *testing.T) {
+	defer func(f func() (string, error)) { instanceRegion = f }(instanceRegion)
+
+	// on EC2
+	instanceRegion = func() (string, error) { return "ap-northeast-1", nil }
+	region, err := Region("", false)
+	assert.Nil(t, err)
+	assert.Equal(t, region, "ap-northeast-1")
+	region, _ = Region("us-east-1", false)
+	assert.Equal(t, region, "us-east-1")
+	region, _ = Region("us-east-1", true)
+	assert.Equal(t, region, "ap-northeast-1")
+
+	// not on EC2
+	instanceRegion = func() (string, error) { return "", errors.New("EC2MetadataRequestError") }
+	_, err = Region("", false)
+	assert.NotNil(t, err)
+	region, err = Region("us-east-1", true)
+	assert.Nil(t, err)
+	assert.Equal(t, region, "us-east-1")
+	_, err = Region("", true)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,53 @@
+package common
+
+import mp "github.com/mackerelio/go-mackerel-plugin"
+
+// DimensionMetric is a metric fetched for each value of a dimension (e.g. AZs of ELB),
+// which is named Prefix + value.
+type DimensionMetric struct {
+	Prefix  string
+	Label   string
+	Unit    string
+	Diff    bool
+	Stacked bool
+
+	// the graph which has a series for each value of the dimension
+	Graph      string
+	GraphLabel string
+
+	// the graph which has the metrics of the same group for a value, with grouping by the dimension
+	Group      string
+	GroupLabel string
+}
+
+// DimensionGraphs generates the graphs of metrics for the values of a dimension.
+// By default there is a graph "<key>.<Graph>" for each metric, which has a series for each value.
+// With groupByDimension, there is a graph "<key>.<value>.<Group>" for each value and group instead,
+// which has a series for each metric of the group. The series are in the order of values (metrics).
+// The values should be valid in metric names. No graphs are generated without values,
+// since Mackerel rejects graphs without metrics.
+func DimensionGraphs(key string, metrics []DimensionMetric, values []string, groupByDimension bool) map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs))
+
+	for _, met := range metrics {
+		for _, value := range values {
+			name := key + "." + met.Graph
+			label := met.GraphLabel
+			series := mp.Metrics{Name: met.Prefix + value, Label: value, Diff: met.Diff, Stacked: met.Stacked}
+			if groupByDimension {
+				name = key + "." + value + "." + met.Group
+				label = met.GroupLabel + " (" + value + ")"
+				series.Label = met.Label
+			}
+
+			g, ok := graphs[name]
+			if !ok {
+				g = mp.Graphs{Label: label, Unit: met.Unit}
+			}
+			g.Metrics = append(g.Metrics, series)
+			graphs[name] = g
+		}
+	}
+
+	return graphs
+}
@@ -0,0 +1,45 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var dimensionMetrics = []DimensionMetric{
+	DimensionMetric{Prefix: "Healthy_", Label: "Healthy", Unit: "integer", Stacked: true,
+		Graph: "healthy", GraphLabel: "Healthy Hosts", Group: "hosts", GroupLabel: "Hosts"},
+	DimensionMetric{Prefix: "Unhealthy_", Label: "Unhealthy", Unit: "integer", Stacked: true,
+		Graph: "unhealthy", GraphLabel: "Unhealthy Hosts", Group: "hosts", GroupLabel: "Hosts"},
+	DimensionMetric{Prefix: "Latency_", Label: "Latency", Unit: "float",
+		Graph: "latency", GraphLabel: "Latency", Group: "latency", GroupLabel: "Latency"},
+	DimensionMetric{Prefix: "Requests_", Label: "Requests", Unit: "integer", Diff: true,
+		Graph: "requests", GraphLabel: "Requests", Group: "requests", GroupLabel: "Requests"},
+}
+
+func TestDimensionGraphs(t *testing.T) {
+	graphs := DimensionGraphs("elb", dimensionMetrics, []string{"a", "c"}, false)
+	assert.Equal(t, len(graphs), 4)
+	assert.Equal(t, graphs["elb.healthy"].Label, "Healthy Hosts")
+	assert.Equal(t, len(graphs["elb.healthy"].Metrics), 2)
+	assert.Equal(t, graphs["elb.healthy"].Metrics[1].Name, "Healthy_c")
+	assert.Equal(t, graphs["elb.healthy"].Metrics[1].Label, "c")
+	assert.Equal(t, graphs["elb.latency"].Unit, "float")
+	assert.True(t, graphs["elb.requests"].Metrics[0].Diff)
+	assert.False(t, graphs["elb.latency"].Metrics[0].Diff)
+}
+
+func TestDimensionGraphsGroupByDimension(t *testing.T) {
+	graphs := DimensionGraphs("elb", dimensionMetrics, []string{"a", "c"}, true)
+	assert.Equal(t, len(graphs), 6)
+	assert.Equal(t, graphs["elb.a.hosts"].Label, "Hosts (a)")
+	assert.Equal(t, len(graphs["elb.a.hosts"].Metrics), 2)
+	assert.Equal(t, graphs["elb.a.hosts"].Metrics[0].Name, "Healthy_a")
+	assert.Equal(t, graphs["elb.a.hosts"].Metrics[1].Name, "Unhealthy_a")
+	assert.Equal(t, graphs["elb.c.hosts"].Metrics[1].Label, "Unhealthy")
+	assert.False(t, graphs["elb.c.latency"].Metrics[0].Stacked)
+}
+
+func TestDimensionGraphsWithoutValues(t *testing.T) {
+	assert.Equal(t, len(DimensionGraphs("elb", dimensionMetrics, nil, true)), 0)
+}
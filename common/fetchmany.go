@@ -0,0 +1,30 @@
+package common
+
+import "sync"
+
+// DefaultConcurrency is the default value of -concurrency flags of the plugins fanning out outbound calls
+const DefaultConcurrency = 5
+
+// FetchMany calls fetch(0) ... fetch(n-1) with at most concurrency calls running at once,
+// and returns when all of them have finished.
+// fetch should store its result at the index given, so that callers need no locks.
+func FetchMany(n int, concurrency int, fetch func(i int)) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			fetch(i)
+		}(i)
+	}
+	wg.Wait()
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFetchMany(t *testing.T) {
+	var mu sync.Mutex
+	running, peak := 0, 0
+
+	results := make([]int, 20)
+	FetchMany(len(results), 3, func(i int) {
+		mu.Lock()
+		running++
+		if running > peak {
+			peak = running
+		}
+		mu.Unlock()
+
+		time.Sleep(5 * time.Millisecond)
+		results[i] = i * 2
+
+		mu.Lock()
+		running--
+		mu.Unlock()
+	})
+
+	for i, v := range results {
+		assert.Equal(t, v, i*2)
+	}
+	assert.Equal(t, peak <= 3, true)
+
+	// non-positive concurrency runs them one by one
+	called := 0
+	FetchMany(4, 0, func(i int) { called++ })
+	assert.Equal(t, called, 4)
+}
@@ -0,0 +1,37 @@
+// Package common provides helpers shared by mackerel-agent plugins.
+package common
+
+// HitRatio returns hits / (hits + misses) in percentage.
+// It returns 0 when there have been neither hits nor misses.
+func HitRatio(hits, misses float64) float64 {
+	return HitRatioWithEmpty(hits, misses, 0)
+}
+
+// HitRatioWithEmpty is the same as HitRatio except that it returns empty
+// when there have been neither hits nor misses.
+// Plugins which regard an unused cache as healthy can pass 100.
+func HitRatioWithEmpty(hits, misses, empty float64) float64 {
+	if hits+misses <= 0 {
+		return empty
+	}
+	return hits / (hits + misses) * 100
+}
+
+// HitRatioSince returns the hit ratio from the counters of hitsKey and missesKey
+// since the last run, as the counters since the start hide a recent drop of the ratio.
+// It is not defined (false) at the first run, without hits nor misses since the last run,
+// or when the counters are reset (e.g. by a restart).
+func HitRatioSince(stat, last map[string]float64, hitsKey, missesKey string) (float64, bool) {
+	hits, ok1 := last[hitsKey]
+	misses, ok2 := last[missesKey]
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+
+	hits = stat[hitsKey] - hits
+	misses = stat[missesKey] - misses
+	if hits < 0 || misses < 0 || hits+misses == 0 {
+		return 0, false
+	}
+	return HitRatio(hits, misses), true
+}
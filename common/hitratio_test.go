@@ -0,0 +1,37 @@
+package common
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestHitRatio(t *testing.T) {
+	assert.Equal(t, HitRatio(3, 1), 75.0)
+	assert.Equal(t, HitRatio(0, 5), 0.0)
+	assert.Equal(t, HitRatio(5, 0), 100.0)
+	assert.Equal(t, HitRatio(0, 0), 0.0)
+}
+
+func TestHitRatioWithEmpty(t *testing.T) {
+	assert.Equal(t, HitRatioWithEmpty(1, 1, 100), 50.0)
+	assert.Equal(t, HitRatioWithEmpty(0, 0, 100), 100.0)
+	assert.Equal(t, HitRatioWithEmpty(0, 0, 0), 0.0)
+}
+
+func TestHitRatioSince(t *testing.T) {
+	last := map[string]float64{"hits": 800, "misses": 200}
+	v, ok := HitRatioSince(map[string]float64{"hits": 890, "misses": 210}, last, "hits", "misses")
+	assert.True(t, ok)
+	assert.Equal(t, v, 90.0)
+
+	// the first run
+	_, ok = HitRatioSince(map[string]float64{"hits": 890, "misses": 210}, nil, "hits", "misses")
+	assert.False(t, ok)
+	// no hits nor misses since the last run
+	_, ok = HitRatioSince(last, last, "hits", "misses")
+	assert.False(t, ok)
+	// restarted
+	_, ok = HitRatioSince(map[string]float64{"hits": 10, "misses": 0}, last, "hits", "misses")
+	assert.False(t, ok)
+}
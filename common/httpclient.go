@@ -0,0 +1,92 @@
+package common
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"time"
+)
+
+// HTTPOptions holds the TLS options for fetching status pages served by HTTPS
+// with self-signed or internal CA certificates, and the network to dial them with.
+type HTTPOptions struct {
+	Insecure bool
+	CACert   string
+	Network  string
+}
+
+// HTTPFlags defines -insecure, -ca-cert and -network. Call it before flag.Parse, and Setup after it.
+func HTTPFlags() *HTTPOptions {
+	o := &HTTPOptions{}
+	flag.BoolVar(&o.Insecure, "insecure", false, "Skip verifying the certificate of HTTPS")
+	flag.StringVar(&o.CACert, "ca-cert", "", "CA certificate (PEM) file to verify the certificate of HTTPS with")
+	flag.StringVar(&o.Network, "network", "tcp", "Network to dial (tcp, tcp4 or tcp6)")
+	return o
+}
+
+// TLSConfig returns the TLS config for the options, or nil for the defaults.
+func (o *HTTPOptions) TLSConfig() (*tls.Config, error) {
+	if !o.Insecure && o.CACert == "" {
+		return nil, nil
+	}
+
+	config := &tls.Config{InsecureSkipVerify: o.Insecure}
+	if o.CACert != "" {
+		pem, err := ioutil.ReadFile(o.CACert)
+		if err != nil {
+			return nil, err
+		}
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			pool = x509.NewCertPool()
+		}
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificates in " + o.CACert)
+		}
+		config.RootCAs = pool
+	}
+	return config, nil
+}
+
+// DialContext returns the dial function forcing the network, or nil for the defaults.
+// The timeouts are the ones of http.DefaultTransport.
+func (o *HTTPOptions) DialContext() func(ctx context.Context, network, addr string) (net.Conn, error) {
+	if o.Network == "" || o.Network == "tcp" {
+		return nil
+	}
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	return func(ctx context.Context, network, addr string) (net.Conn, error) {
+		return dialer.DialContext(ctx, o.Network, addr)
+	}
+}
+
+// Setup applies the options to http.DefaultTransport, which http.Get and http.Client without Transport use.
+// It exits when the CA certificate cannot be loaded or the network is invalid, as flag.Parse does for invalid flags.
+func (o *HTTPOptions) Setup() {
+	if o.Network != "" {
+		if err := CheckNetwork(o.Network); err != nil {
+			log.Fatalln(err)
+		}
+	}
+	config, err := o.TLSConfig()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	t, ok := http.DefaultTransport.(*http.Transport)
+	if !ok {
+		return
+	}
+	if config != nil {
+		t.TLSClientConfig = config
+	}
+	if dial := o.DialContext(); dial != nil {
+		t.DialContext = dial
+	}
+}
@@ -0,0 +1,73 @@
+package common
+
+import (
+	"io/ioutil"
+	"net"
+	"net/http"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTLSConfig(t *testing.T) {
+	config, err := (&HTTPOptions{}).TLSConfig()
+	assert.Nil(t, err)
+	assert.Nil(t, config)
+
+	config, err = (&HTTPOptions{Insecure: true}).TLSConfig()
+	assert.Nil(t, err)
+	assert.True(t, config.InsecureSkipVerify)
+}
+
+func TestTLSConfigWithoutCertificates(t *testing.T) {
+	f, err := ioutil.TempFile("", "ca-cert")
+	assert.Nil(t, err)
+	defer os.Remove(f.Name())
+	f.WriteString("not a certificate\n")
+	f.Close()
+
+	_, err = (&HTTPOptions{CACert: f.Name()}).TLSConfig()
+	assert.NotNil(t, err)
+
+	_, err = (&HTTPOptions{CACert: f.Name() + ".notfound"}).TLSConfig()
+	assert.NotNil(t, err)
+}
+
+func TestSetupNetwork(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 is not available:", err)
+	}
+	defer l.Close()
+	go http.Serve(l, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("ok"))
+	}))
+
+	transport := http.DefaultTransport.(*http.Transport)
+	dial := transport.DialContext
+	defer func() { transport.DialContext = dial }()
+
+	url := "http://" + l.Addr().String() + "/"
+	assert.Equal(t, "http://[::1]:", url[:len("http://[::1]:")])
+
+	(&HTTPOptions{Network: "tcp6"}).Setup()
+	resp, err := http.Get(url)
+	assert.Nil(t, err)
+	if err == nil {
+		resp.Body.Close()
+		assert.Equal(t, http.StatusOK, resp.StatusCode)
+	}
+
+	// an IPv6 literal cannot be dialed by IPv4
+	(&HTTPOptions{Network: "tcp4"}).Setup()
+	transport.CloseIdleConnections()
+	_, err = http.Get(url)
+	assert.NotNil(t, err)
+}
+
+func TestDialContext(t *testing.T) {
+	assert.Nil(t, (&HTTPOptions{}).DialContext())
+	assert.Nil(t, (&HTTPOptions{Network: "tcp"}).DialContext())
+	assert.NotNil(t, (&HTTPOptions{Network: "tcp6"}).DialContext())
+}
@@ -0,0 +1,17 @@
+//go:build !windows
+// +build !windows
+
+package common
+
+import (
+	"os"
+	"syscall"
+)
+
+// fileInode returns the inode of the file, which is changed by the rotation of a log
+func fileInode(fi os.FileInfo) uint64 {
+	if st, ok := fi.Sys().(*syscall.Stat_t); ok {
+		return uint64(st.Ino)
+	}
+	return 0
+}
@@ -0,0 +1,11 @@
+//go:build windows
+// +build windows
+
+package common
+
+import "os"
+
+// fileInode returns 0, as the files have no inodes on Windows. the rotation of a log is detected by the truncation only
+func fileInode(fi os.FileInfo) uint64 {
+	return 0
+}
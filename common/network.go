@@ -0,0 +1,32 @@
+package common
+
+import (
+	"errors"
+	"flag"
+	"net"
+	"strings"
+)
+
+// NetworkFlag defines -network, the network to dial: "tcp" dials either of IPv4 and IPv6,
+// and "tcp4" or "tcp6" forces the address family.
+func NetworkFlag() *string {
+	return flag.String("network", "tcp", "Network to dial (tcp, tcp4 or tcp6)")
+}
+
+// CheckNetwork returns an error for the networks other than the ones of -network.
+func CheckNetwork(network string) error {
+	switch network {
+	case "tcp", "tcp4", "tcp6":
+		return nil
+	}
+	return errors.New("invalid network (tcp, tcp4 or tcp6): " + network)
+}
+
+// HostPort joins -host and -port into the address to dial, or to put into a URL.
+// The IPv6 literal addresses are bracketed, whether -host is bracketed (e.g. [::1]) or not.
+func HostPort(host, port string) string {
+	if strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]") {
+		host = host[1 : len(host)-1]
+	}
+	return net.JoinHostPort(host, port)
+}
@@ -0,0 +1,41 @@
+package common
+
+import (
+	"net"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckNetwork(t *testing.T) {
+	for _, network := range []string{"tcp", "tcp4", "tcp6"} {
+		assert.Nil(t, CheckNetwork(network))
+	}
+	assert.NotNil(t, CheckNetwork("udp"))
+	assert.NotNil(t, CheckNetwork(""))
+}
+
+func TestHostPort(t *testing.T) {
+	assert.Equal(t, "localhost:11211", HostPort("localhost", "11211"))
+	assert.Equal(t, "192.168.0.1:80", HostPort("192.168.0.1", "80"))
+	assert.Equal(t, "[::1]:8080", HostPort("::1", "8080"))
+	assert.Equal(t, "[::1]:8080", HostPort("[::1]", "8080"))
+	assert.Equal(t, "[fe80::1%eth0]:8080", HostPort("fe80::1%eth0", "8080"))
+}
+
+func TestHostPortDial(t *testing.T) {
+	l, err := net.Listen("tcp6", "[::1]:0")
+	if err != nil {
+		t.Skip("IPv6 is not available:", err)
+	}
+	defer l.Close()
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	conn, err := net.Dial("tcp6", HostPort("[::1]", port))
+	assert.Nil(t, err)
+	if err == nil {
+		conn.Close()
+	}
+	_, err = net.Dial("tcp4", HostPort("::1", port))
+	assert.NotNil(t, err)
+}
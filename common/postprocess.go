@@ -0,0 +1,140 @@
+package common
+
+import (
+	"bytes"
+	"errors"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultPostProcessTimeout is the default timeout (sec) of the post-process command
+const DefaultPostProcessTimeout = 10
+
+// PostProcess holds the command to pipe the output for mackerel-agent through,
+// for the derived metrics which are not worth a flag of the plugin.
+type PostProcess struct {
+	Command string
+	Timeout int
+}
+
+// PostProcessFlags defines -post-process and -post-process-timeout. Call it before flag.Parse.
+func PostProcessFlags() *PostProcess {
+	p := &PostProcess{}
+	flag.StringVar(&p.Command, "post-process", "", "Command to pipe the metric lines (name, value and time separated by tabs) through before the output")
+	flag.IntVar(&p.Timeout, "post-process-timeout", DefaultPostProcessTimeout, "Timeout (sec) of the post-process command")
+	return p
+}
+
+// run feeds the input to the command, and returns its stdout.
+// The command is split by spaces, and not run by a shell.
+func (p PostProcess) run(input []byte) ([]byte, error) {
+	args := strings.Fields(p.Command)
+	if len(args) == 0 {
+		return nil, errors.New("empty command")
+	}
+
+	// stdout is a pipe rather than a buffer, as cmd.Wait waits for the copy to a buffer
+	// until the children of the command (e.g. of "sh -c") which inherit stdout exit as well
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = bytes.NewReader(input)
+	cmd.Stdout = w
+	cmd.Stderr = os.Stderr
+	err = cmd.Start()
+	w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	output := make(chan []byte, 1)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		output <- b
+	}()
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+
+	timeout := time.Duration(p.Timeout) * time.Second
+	if timeout <= 0 {
+		timeout = DefaultPostProcessTimeout * time.Second
+	}
+	deadline := time.After(timeout)
+	select {
+	case err := <-done:
+		if err != nil {
+			return nil, err
+		}
+	case <-deadline:
+		// not to wait for the command after the kill, which can be blocked by its children
+		cmd.Process.Kill()
+		return nil, errors.New("timed out after " + timeout.String())
+	}
+
+	// the children can still write to stdout after the command exits
+	select {
+	case b := <-output:
+		return b, nil
+	case <-deadline:
+		return nil, errors.New("timed out after " + timeout.String() + " waiting for the output")
+	}
+}
+
+// captureStdout returns what output writes to os.Stdout, where helper.OutputValues prints the metrics
+func captureStdout(output func()) ([]byte, error) {
+	r, w, err := os.Pipe()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	// read concurrently not to block output on a full pipe
+	captured := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(r)
+		captured <- b
+	}()
+
+	stdout := os.Stdout
+	os.Stdout = w
+	func() {
+		defer func() { os.Stdout = stdout }()
+		output()
+	}()
+	w.Close()
+
+	return <-captured, nil
+}
+
+// Output runs output, and pipes what it prints through the command if any.
+// If the command fails or times out, the original output is printed with a warning.
+func (p *PostProcess) Output(output func()) {
+	if p == nil || p.Command == "" {
+		output()
+		return
+	}
+
+	original, err := captureStdout(output)
+	if err != nil {
+		log.Printf("post-process: %s", err)
+		output()
+		return
+	}
+
+	processed, err := p.run(original)
+	if err != nil {
+		log.Printf("post-process: %s: %s. the original output is used", p.Command, err)
+		os.Stdout.Write(original)
+		return
+	}
+	os.Stdout.Write(processed)
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCaptureStdout(t *testing.T) {
+	out, err := captureStdout(func() {
+		fmt.Printf("redis.keys.keys\t%f\t%d\n", 10.0, 1420070400)
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), "redis.keys.keys\t10.000000\t1420070400\n")
+}
+
+func TestPostProcessRun(t *testing.T) {
+	p := PostProcess{Command: "tr a-z A-Z", Timeout: 1}
+	out, err := p.run([]byte("redis.keys.keys\t10\t1420070400\n"))
+	assert.Nil(t, err)
+	assert.Equal(t, string(out), "REDIS.KEYS.KEYS\t10\t1420070400\n")
+}
+
+func TestPostProcessRunFailure(t *testing.T) {
+	p := PostProcess{Command: "false", Timeout: 1}
+	_, err := p.run([]byte("redis.keys.keys\t10\t1420070400\n"))
+	assert.NotNil(t, err)
+
+	p = PostProcess{Command: "/nonexistent/command", Timeout: 1}
+	_, err = p.run(nil)
+	assert.NotNil(t, err)
+}
+
+func TestPostProcessRunTimeout(t *testing.T) {
+	p := PostProcess{Command: "sleep 5", Timeout: 1}
+	_, err := p.run(nil)
+	assert.NotNil(t, err)
+}
+
+func TestPostProcessRunTimeoutWithChildren(t *testing.T) {
+	dir, err := ioutil.TempDir("", "postprocess")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	// the children (sleep and cat) inherit stdout of the shell
+	script := filepath.Join(dir, "post-process.sh")
+	assert.Nil(t, ioutil.WriteFile(script, []byte("#!/bin/sh\nsleep 3 | cat\n"), 0755))
+
+	p := PostProcess{Command: script, Timeout: 1}
+	start := time.Now()
+	_, err = p.run(nil)
+	assert.NotNil(t, err)
+	assert.True(t, time.Since(start) < 2*time.Second)
+}
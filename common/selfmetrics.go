@@ -0,0 +1,101 @@
+package common
+
+import (
+	"flag"
+	"log"
+	"sort"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+var fetchErrors int64
+
+// LogFetchError logs the failure of fetching a metric, which is skipped in the output,
+// and counts it for fetch_errors of the self metrics. It is safe to call from FetchMany.
+func LogFetchError(name string, err error) {
+	atomic.AddInt64(&fetchErrors, 1)
+	log.Printf("%s: %s", name, err)
+}
+
+// SelfMetrics holds the option to add the metrics of the collection itself,
+// <prefix>.plugin.collect_time_ms and <prefix>.plugin.fetch_errors, to the output.
+type SelfMetrics struct {
+	Enabled bool
+}
+
+// SelfMetricsFlags defines -self-metrics. Call it before flag.Parse.
+func SelfMetricsFlags() *SelfMetrics {
+	s := &SelfMetrics{}
+	flag.BoolVar(&s.Enabled, "self-metrics", false, "Output the time of the collection and the number of the metrics failed to fetch")
+	return s
+}
+
+// Wrap returns the plugin adding the self metrics, or the plugin itself without -self-metrics.
+func (s *SelfMetrics) Wrap(plugin mp.Plugin) mp.Plugin {
+	if s == nil || !s.Enabled {
+		return plugin
+	}
+	return selfMetricsPlugin{plugin}
+}
+
+type selfMetricsPlugin struct {
+	mp.Plugin
+}
+
+func (p selfMetricsPlugin) FetchMetrics() (map[string]float64, error) {
+	atomic.StoreInt64(&fetchErrors, 0)
+	start := time.Now()
+
+	stat, err := p.Plugin.FetchMetrics()
+	if err != nil {
+		return nil, err
+	}
+	stat["collect_time_ms"] = float64(time.Since(start)) / float64(time.Millisecond)
+	stat["fetch_errors"] = float64(atomic.LoadInt64(&fetchErrors))
+	return stat, nil
+}
+
+func (p selfMetricsPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs))
+	for k, v := range p.Plugin.GraphDefinition() {
+		graphs[k] = v
+	}
+
+	graphs[graphPrefix(graphs)+".plugin"] = mp.Graphs{
+		Label: "Plugin Self Metrics",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "collect_time_ms", Label: "Collect Time (msec)"},
+			mp.Metrics{Name: "fetch_errors", Label: "Fetch Errors"},
+		},
+	}
+	return graphs
+}
+
+// graphPrefix returns the most common first component of the graph names (e.g. "elb" of "elb.latency"),
+// so that the self metrics of plugins running on the same host don't conflict
+func graphPrefix(graphs map[string](mp.Graphs)) string {
+	counts := make(map[string]int)
+	for k := range graphs {
+		counts[strings.SplitN(k, ".", 2)[0]]++
+	}
+
+	prefixes := make([]string, 0, len(counts))
+	for prefix := range counts {
+		prefixes = append(prefixes, prefix)
+	}
+	sort.Strings(prefixes)
+
+	best := "plugin"
+	max := 0
+	for _, prefix := range prefixes {
+		if counts[prefix] > max {
+			best = prefix
+			max = counts[prefix]
+		}
+	}
+	return best
+}
@@ -0,0 +1,52 @@
+package common
+
+import (
+	"errors"
+	"testing"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+type failingPlugin struct{}
+
+func (p failingPlugin) FetchMetrics() (map[string]float64, error) {
+	LogFetchError("Latency", errors.New("Throttling"))
+	LogFetchError("RequestCount", errors.New("Throttling"))
+	return map[string]float64{"HealthyHostCount": 2}, nil
+}
+
+func (p failingPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return map[string](mp.Graphs){
+		"elb.latency":            mp.Graphs{},
+		"elb.requests":           mp.Graphs{},
+		"rds.healthy_host_count": mp.Graphs{},
+	}
+}
+
+func TestSelfMetrics(t *testing.T) {
+	plugin := (&SelfMetrics{Enabled: true}).Wrap(failingPlugin{})
+
+	stat, err := plugin.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, stat["HealthyHostCount"], 2.0)
+	assert.Equal(t, stat["fetch_errors"], 2.0)
+	_, ok := stat["collect_time_ms"]
+	assert.True(t, ok)
+
+	// counted for each run
+	stat, _ = plugin.FetchMetrics()
+	assert.Equal(t, stat["fetch_errors"], 2.0)
+
+	graphs := plugin.GraphDefinition()
+	_, ok = graphs["elb.plugin"]
+	assert.True(t, ok)
+	assert.Equal(t, len(graphs), 4)
+}
+
+func TestSelfMetricsDisabled(t *testing.T) {
+	plugin := (&SelfMetrics{}).Wrap(failingPlugin{})
+	stat, _ := plugin.FetchMetrics()
+	_, ok := stat["collect_time_ms"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,139 @@
+package common
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"log"
+	"net"
+	"regexp"
+	"sort"
+	"strconv"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+)
+
+// packets larger than this may be dropped on the way (e.g. by fragmentation)
+const statsdMaxPacketSize = 512
+
+var invalidStatsdChars = regexp.MustCompile("[^-a-zA-Z0-9_.]+")
+
+// Statsd holds the options to send the metrics to StatsD,
+// for running a StatsD (or Datadog) agent alongside mackerel-agent.
+type Statsd struct {
+	Addr   string
+	Prefix string
+	Only   bool
+}
+
+// StatsdFlags defines -statsd, -statsd-prefix and -statsd-only. Call it before flag.Parse.
+func StatsdFlags() *Statsd {
+	s := &Statsd{}
+	flag.StringVar(&s.Addr, "statsd", "", "StatsD address (host:port) to send the metrics to as gauges")
+	flag.StringVar(&s.Prefix, "statsd-prefix", "mackerel", "Prefix of the stat names sent to StatsD")
+	flag.BoolVar(&s.Only, "statsd-only", false, "Send the metrics only to StatsD, without printing them for mackerel-agent")
+	return s
+}
+
+// statsdPackets returns gauge packets of the metrics in the graphs, named <prefix>.<graph>.<metric>.
+// The values are the ones returned by FetchMetrics, that is, counters are sent as they are.
+func statsdPackets(graphs map[string](mp.Graphs), stat map[string]float64, prefix string) [][]byte {
+	keys := make([]string, 0, len(graphs))
+	for key := range graphs {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var lines []string
+	for _, key := range keys {
+		for _, metric := range graphs[key].Metrics {
+			v, ok := stat[metric.Name]
+			if !ok {
+				continue
+			}
+			name := invalidStatsdChars.ReplaceAllString(key+"."+metric.Name, "_")
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			value := strconv.FormatFloat(v, 'f', -1, 64)
+			// a signed gauge means a relative change, so set it to 0 first
+			if v < 0 {
+				lines = append(lines, name+":0|g")
+			}
+			lines = append(lines, name+":"+value+"|g")
+		}
+	}
+
+	var packets [][]byte
+	var buf bytes.Buffer
+	for _, line := range lines {
+		if buf.Len() > 0 && buf.Len()+1+len(line) > statsdMaxPacketSize {
+			packets = append(packets, append([]byte(nil), buf.Bytes()...))
+			buf.Reset()
+		}
+		if buf.Len() > 0 {
+			buf.WriteByte('\n')
+		}
+		buf.WriteString(line)
+	}
+	if buf.Len() > 0 {
+		packets = append(packets, buf.Bytes())
+	}
+	return packets
+}
+
+// Send sends the metrics in the graphs to StatsD.
+func (s Statsd) Send(graphs map[string](mp.Graphs), stat map[string]float64) error {
+	conn, err := net.Dial("udp", s.Addr)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	var lastErr error
+	for _, packet := range statsdPackets(graphs, stat, s.Prefix) {
+		if _, err := conn.Write(packet); err != nil {
+			lastErr = err
+		}
+	}
+	if lastErr != nil {
+		return fmt.Errorf("failed to send some packets to %s: %s", s.Addr, lastErr)
+	}
+	return nil
+}
+
+type prefetchedPlugin struct {
+	mp.Plugin
+	stat map[string]float64
+}
+
+func (p prefetchedPlugin) FetchMetrics() (map[string]float64, error) {
+	return p.stat, nil
+}
+
+// OutputValues replaces helper.OutputValues.
+// With -statsd, the metrics are fetched only once and sent to StatsD in addition to (with -statsd-only, instead of) the output for mackerel-agent.
+// Failures of StatsD are only logged.
+// With -post-process, the output for mackerel-agent is piped through the command.
+func OutputValues(helper *mp.MackerelPlugin, s *Statsd, p *PostProcess) {
+	if s == nil || s.Addr == "" {
+		p.Output(helper.OutputValues)
+		return
+	}
+
+	stat, err := helper.FetchMetrics()
+	if err != nil {
+		log.Fatalln("OutputValues: ", err)
+	}
+
+	if err := s.Send(helper.GraphDefinition(), stat); err != nil {
+		log.Printf("statsd: %s", err)
+	}
+	if s.Only {
+		return
+	}
+
+	prefetched := mp.NewMackerelPlugin(prefetchedPlugin{helper.Plugin, stat})
+	prefetched.Tempfile = helper.Tempfile
+	p.Output(prefetched.OutputValues)
+}
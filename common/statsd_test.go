@@ -0,0 +1,83 @@
+package common
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/stretchr/testify/assert"
+)
+
+var statsdGraphs = map[string](mp.Graphs){
+	"redis.memory": mp.Graphs{
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "used_memory"},
+			mp.Metrics{Name: "used_memory_rss"},
+		},
+	},
+	"elb.latency_per_az": mp.Graphs{
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "Latency_ap-northeast-1a"},
+		},
+	},
+	"loadavg.diff": mp.Graphs{
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "diff"},
+		},
+	},
+}
+
+func TestStatsdPackets(t *testing.T) {
+	stat := map[string]float64{
+		"used_memory":             1048576,
+		"Latency_ap-northeast-1a": 0.25,
+		"diff":                    -1.5,
+		"not_in_graphs":           1,
+	}
+
+	packets := statsdPackets(statsdGraphs, stat, "mackerel")
+	assert.Equal(t, len(packets), 1)
+	assert.Equal(t, string(packets[0]), "mackerel.elb.latency_per_az.Latency_ap-northeast-1a:0.25|g\n"+
+		"mackerel.loadavg.diff.diff:0|g\n"+
+		"mackerel.loadavg.diff.diff:-1.5|g\n"+
+		"mackerel.redis.memory.used_memory:1048576|g")
+}
+
+func TestStatsdPacketsBatched(t *testing.T) {
+	graphs := map[string](mp.Graphs){"g": mp.Graphs{}}
+	stat := make(map[string]float64)
+	for i := 0; i < 100; i++ {
+		name := "metric_" + strings.Repeat("x", 10) + string(rune('a'+i%26)) + string(rune('a'+i/26))
+		g := graphs["g"]
+		g.Metrics = append(g.Metrics, mp.Metrics{Name: name})
+		graphs["g"] = g
+		stat[name] = float64(i)
+	}
+
+	packets := statsdPackets(graphs, stat, "")
+	assert.Equal(t, len(packets) > 1, true)
+	lines := 0
+	for _, packet := range packets {
+		assert.Equal(t, len(packet) <= statsdMaxPacketSize, true)
+		lines += len(strings.Split(string(packet), "\n"))
+	}
+	assert.Equal(t, lines, 100)
+}
+
+func TestStatsdSend(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer conn.Close()
+
+	s := Statsd{Addr: conn.LocalAddr().String(), Prefix: "test"}
+	err = s.Send(statsdGraphs, map[string]float64{"used_memory": 1})
+	assert.Nil(t, err)
+
+	buf := make([]byte, statsdMaxPacketSize)
+	conn.SetReadDeadline(time.Now().Add(time.Second))
+	n, _, err := conn.ReadFrom(buf)
+	assert.Nil(t, err)
+	assert.Equal(t, string(buf[:n]), "test.redis.memory.used_memory:1|g")
+}
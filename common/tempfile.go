@@ -0,0 +1,211 @@
+package common
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+)
+
+func readValues(tempfile string) (map[string]float64, error) {
+	f, err := os.Open(tempfile)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var values map[string]float64
+	if err := json.NewDecoder(f).Decode(&values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// LastValues returns the values which go-mackerel-plugin saved into tempfile in the last run,
+// for plugins which derive metrics from the differences of several counters.
+// It returns nil if tempfile does not exist or cannot be parsed.
+func LastValues(tempfile string) map[string]float64 {
+	values, err := readValues(tempfile)
+	if err != nil {
+		return nil
+	}
+	return values
+}
+
+// RecoverTempfile removes tempfile if it cannot be parsed (e.g. partially written by a killed process).
+// Then go-mackerel-plugin regards the run as the first one, which outputs only the values
+// of non-diff metrics and saves a fresh tempfile, instead of diffing with broken values.
+// It returns true if tempfile has been removed.
+func RecoverTempfile(tempfile string) bool {
+	_, err := readValues(tempfile)
+	if err == nil || os.IsNotExist(err) {
+		return false
+	}
+	if _, ok := err.(*os.PathError); ok {
+		// not readable, which removing doesn't fix
+		return false
+	}
+
+	log.Printf("tempfile %s is broken (%s). removing it and starting over", tempfile, err)
+	if err := os.Remove(tempfile); err != nil {
+		log.Printf("failed to remove tempfile: %s", err)
+		return false
+	}
+	return true
+}
+
+// LoadState reads the state which the plugin saved by SaveState in the last run into v,
+// for plugins which keep more than the values (e.g. the position in a log file).
+func LoadState(path string, v interface{}) error {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+// SaveState writes v as JSON to a temporary file and renames it to path,
+// not to leave a truncated state if the plugin is killed while writing.
+func SaveState(path string, v interface{}) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path))
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
+
+// LogPosition is the position in a log file read up to by the last run, kept by ReadNewLog
+type LogPosition struct {
+	Inode  uint64 `json:"inode"`
+	Offset int64  `json:"offset"`
+	Time   int64  `json:"time"`
+}
+
+// ReadNewLog returns the complete lines appended to the log since the last run, and the seconds since then.
+// The position in the log is kept in stateFile. The first run (or with a broken state) only saves the end
+// of the log as the position, and returns false not to count the whole log at once.
+// When the log is rotated (the inode is changed) or truncated, it is read from the beginning, and the lines
+// written to the old log after the last run are lost.
+func ReadNewLog(path, stateFile string, now time.Time) ([]byte, float64, bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	defer f.Close()
+
+	fi, err := f.Stat()
+	if err != nil {
+		return nil, 0, false, err
+	}
+	inode := fileInode(fi)
+
+	var last LogPosition
+	if err := LoadState(stateFile, &last); err != nil || last.Time <= 0 || last.Time >= now.Unix() {
+		return nil, 0, false, SaveState(stateFile, LogPosition{Inode: inode, Offset: fi.Size(), Time: now.Unix()})
+	}
+
+	offset := startOffset(last, inode, fi.Size())
+	if _, err := f.Seek(offset, 0); err != nil {
+		return nil, 0, false, err
+	}
+	b, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, 0, false, err
+	}
+	b = readComplete(b)
+
+	if err := SaveState(stateFile, LogPosition{Inode: inode, Offset: offset + int64(len(b)), Time: now.Unix()}); err != nil {
+		return nil, 0, false, err
+	}
+	return b, float64(now.Unix() - last.Time), true, nil
+}
+
+// startOffset returns the offset to read the log from. it is reset to 0 when the log is
+// rotated (the inode is changed) or truncated (smaller than the last offset)
+func startOffset(last LogPosition, inode uint64, size int64) int64 {
+	if last.Inode != inode || size < last.Offset {
+		return 0
+	}
+	return last.Offset
+}
+
+// readComplete returns the complete lines in b, not to parse a line being written.
+// the rest is read at the next run
+func readComplete(b []byte) []byte {
+	i := bytes.LastIndexByte(b, '\n')
+	if i < 0 {
+		return nil
+	}
+	return b[:i+1]
+}
+
+// Tempfile returns the default tempfile of the plugin (e.g. /tmp/mackerel-plugin-elb for "mackerel-plugin-elb")
+// suffixed by the values of the scoping flags set in the command line (e.g. -alb), so that the instances
+// of the plugin run with different scopes don't share (and clobber) the values of the last run.
+// The tempfile of the plugin run without the scoping flags is the same as before. Call it after flag.Parse.
+func Tempfile(name string, scopeFlags ...string) string {
+	return tempfileOf(flag.CommandLine, name, scopeFlags...)
+}
+
+func tempfileOf(fs *flag.FlagSet, name string, scopeFlags ...string) string {
+	set := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		set[f.Name] = f.Value.String()
+	})
+
+	var scopes []string
+	for _, n := range scopeFlags {
+		if v := set[n]; v != "" {
+			scopes = append(scopes, v)
+		}
+	}
+	return "/tmp/" + name + tempfileSuffix(scopes)
+}
+
+var invalidTempfileChars = regexp.MustCompile("[^-a-zA-Z0-9_.]+")
+
+// the length of the suffix kept as it is, which is well within the limit of file names
+const maxTempfileSuffix = 64
+
+// tempfileSuffix returns "-" and the scopes joined by "-". The scopes are kept as they are if they are
+// valid in file names and short, e.g. "-ap-northeast-1-my-lb". Otherwise they are sanitized (and truncated),
+// and the hash of the original ones is appended not to collide with the others sanitized into the same,
+// e.g. "-http_localhost_8080_status-1a2b3c4d" for http://localhost:8080/status.
+func tempfileSuffix(scopes []string) string {
+	if len(scopes) == 0 {
+		return ""
+	}
+	s := strings.Join(scopes, "-")
+	clean := strings.Trim(invalidTempfileChars.ReplaceAllString(s, "_"), "_")
+	if clean == s && len(s) <= maxTempfileSuffix {
+		return "-" + s
+	}
+
+	// "-" + clean + "-" + 8 digits of the hash
+	if len(clean) > maxTempfileSuffix-10 {
+		clean = clean[:maxTempfileSuffix-10]
+	}
+	sum := sha1.Sum([]byte(s))
+	return "-" + clean + "-" + hex.EncodeToString(sum[:])[:8]
+}
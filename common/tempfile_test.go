@@ -0,0 +1,166 @@
+package common
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeTempfile(content string) string {
+	f, _ := ioutil.TempFile("", "mackerel-plugin-test")
+	f.WriteString(content)
+	f.Close()
+	return f.Name()
+}
+
+func TestLastValues(t *testing.T) {
+	tempfile := writeTempfile(`{"events_in":100,"_lastTime":1420070400}`)
+	defer os.Remove(tempfile)
+
+	values := LastValues(tempfile)
+	assert.Equal(t, values["events_in"], 100.0)
+	assert.Equal(t, values["_lastTime"], 1420070400.0)
+
+	assert.Nil(t, LastValues(tempfile+".notfound"))
+}
+
+func TestRecoverTempfile(t *testing.T) {
+	tempfile := writeTempfile(`{"events_in":100,"_lastTime":1420070400}`)
+	defer os.Remove(tempfile)
+
+	assert.False(t, RecoverTempfile(tempfile))
+	_, err := os.Stat(tempfile)
+	assert.Nil(t, err)
+
+	assert.False(t, RecoverTempfile(tempfile+".notfound"))
+}
+
+func TestRecoverTruncatedTempfile(t *testing.T) {
+	// written partially by a killed process
+	tempfile := writeTempfile(`{"events_in":100,"_lastT`)
+	defer os.Remove(tempfile)
+
+	assert.Nil(t, LastValues(tempfile))
+	assert.True(t, RecoverTempfile(tempfile))
+	_, err := os.Stat(tempfile)
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestRecoverEmptyTempfile(t *testing.T) {
+	tempfile := writeTempfile("")
+	defer os.Remove(tempfile)
+
+	assert.True(t, RecoverTempfile(tempfile))
+}
+
+func TestState(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	type position struct {
+		Inode  uint64 `json:"inode"`
+		Offset int64  `json:"offset"`
+	}
+
+	path := filepath.Join(dir, "state")
+	var state position
+	assert.NotNil(t, LoadState(path, &state))
+
+	assert.Nil(t, SaveState(path, position{Inode: 1234, Offset: 5678}))
+	assert.Nil(t, LoadState(path, &state))
+	assert.Equal(t, state.Inode, uint64(1234))
+	assert.Equal(t, state.Offset, int64(5678))
+
+	// no temporary files are left
+	files, _ := ioutil.ReadDir(dir)
+	assert.Equal(t, len(files), 1)
+}
+
+func TestTempfile(t *testing.T) {
+	fs := flag.NewFlagSet("test", flag.ContinueOnError)
+	fs.String("region", "", "")
+	fs.String("alb", "", "")
+	fs.String("smooth", "1", "")
+	fs.Parse([]string{})
+	assert.Equal(t, tempfileOf(fs, "mackerel-plugin-elb", "region", "alb"), "/tmp/mackerel-plugin-elb", "without the scoping flags")
+
+	fs.Parse([]string{"-region=ap-northeast-1", "-smooth=3"})
+	assert.Equal(t, tempfileOf(fs, "mackerel-plugin-elb", "region", "alb"), "/tmp/mackerel-plugin-elb-ap-northeast-1")
+
+	fs.Parse([]string{"-alb=app/my-alb/50dc6c495c0c9188"})
+	tempfile := tempfileOf(fs, "mackerel-plugin-elb", "region", "alb")
+	assert.True(t, strings.HasPrefix(tempfile, "/tmp/mackerel-plugin-elb-ap-northeast-1-app_my-alb_50dc6c495c0c9188-"))
+	assert.Equal(t, filepath.Dir(tempfile), "/tmp")
+}
+
+func TestTempfileSuffix(t *testing.T) {
+	assert.Equal(t, tempfileSuffix(nil), "")
+	assert.Equal(t, tempfileSuffix([]string{"db-1"}), "-db-1")
+	assert.Equal(t, tempfileSuffix([]string{"ap-northeast-1", "db-1"}), "-ap-northeast-1-db-1")
+
+	// sanitized into the same, but the hashes differ
+	a := tempfileSuffix([]string{"http://localhost:8080/status"})
+	b := tempfileSuffix([]string{"http://localhost/8080/status"})
+	assert.True(t, strings.HasPrefix(a, "-http_localhost_8080_status-"))
+	assert.True(t, strings.HasPrefix(b, "-http_localhost_8080_status-"))
+	assert.NotEqual(t, a, b)
+
+	long := tempfileSuffix([]string{strings.Repeat("m1:AWS/ELB:RequestCount:Sum,", 10)})
+	assert.True(t, len(long) <= maxTempfileSuffix, long)
+}
+
+func TestReadNewLog(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mackerel-plugin-test")
+	assert.Nil(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "mail.log")
+	state := filepath.Join(dir, "state")
+	assert.Nil(t, ioutil.WriteFile(path, []byte("old line\n"), 0644))
+	now := time.Unix(1420070400, 0)
+
+	// the first run only saves the position
+	_, _, ok, err := ReadNewLog(path, state, now)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	// a line being written is read at the next run
+	f, _ := os.OpenFile(path, os.O_APPEND|os.O_WRONLY, 0644)
+	f.WriteString("new line\nbeing writ")
+	b, elapsed, ok, err := ReadNewLog(path, state, now.Add(60*time.Second))
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, string(b), "new line\n")
+	assert.Equal(t, elapsed, 60.0)
+
+	f.WriteString("ten\n")
+	f.Close()
+	b, _, _, _ = ReadNewLog(path, state, now.Add(120*time.Second))
+	assert.Equal(t, string(b), "being written\n")
+
+	// truncated
+	assert.Nil(t, ioutil.WriteFile(path, []byte("a\n"), 0644))
+	b, _, _, _ = ReadNewLog(path, state, now.Add(180*time.Second))
+	assert.Equal(t, string(b), "a\n")
+}
+
+func TestStartOffset(t *testing.T) {
+	last := LogPosition{Inode: 1234, Offset: 5000}
+	assert.EqualValues(t, startOffset(last, 1234, 6000), 5000)
+	// rotated
+	assert.EqualValues(t, startOffset(last, 5678, 6000), 0)
+	// truncated
+	assert.EqualValues(t, startOffset(last, 1234, 100), 0)
+}
+
+func TestReadComplete(t *testing.T) {
+	assert.Equal(t, string(readComplete([]byte("# Query_time: 1\nSELECT 1;\n# Query_t"))), "# Query_time: 1\nSELECT 1;\n")
+	assert.Nil(t, readComplete([]byte("# Query_t")))
+}
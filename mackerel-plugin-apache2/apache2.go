@@ -12,6 +12,7 @@ import (
 
 	"github.com/codegangsta/cli"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 )
 
 // metric value structure
@@ -66,6 +67,7 @@ var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
 
 // for fetching metrics
 type Apache2Plugin struct {
+	Scheme   string
 	Host     string
 	Port     uint16
 	Path     string
@@ -80,25 +82,39 @@ func (c Apache2Plugin) GraphDefinition() map[string](mp.Graphs) {
 // main function
 func doMain(c *cli.Context) {
 
+	(&common.HTTPOptions{
+		Insecure: c.Bool("insecure"),
+		CACert:   c.String("ca_cert"),
+	}).Setup()
+
 	var apache2 Apache2Plugin
 
+	apache2.Scheme = c.String("http_scheme")
 	apache2.Host = c.String("http_host")
 	apache2.Port = uint16(c.Int("http_port"))
 	apache2.Path = c.String("status_page")
 
-	helper := mp.NewMackerelPlugin(apache2)
+	helper := mp.NewMackerelPlugin((&common.SelfMetrics{Enabled: c.Bool("self_metrics")}).Wrap(apache2))
 	helper.Tempfile = c.String("tempfile")
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, &common.Statsd{
+			Addr:   c.String("statsd"),
+			Prefix: c.String("statsd_prefix"),
+			Only:   c.Bool("statsd_only"),
+		}, &common.PostProcess{
+			Command: c.String("post_process"),
+			Timeout: c.Int("post_process_timeout"),
+		})
 	}
 }
 
 // fetch metrics
 func (c Apache2Plugin) FetchMetrics() (map[string]float64, error) {
-	data, err := getApache2Metrics(c.Host, c.Port, c.Path)
+	data, err := getApache2Metrics(c.Scheme, c.Host, c.Port, c.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -171,8 +187,8 @@ func parseApache2Status(str string, p *map[string]float64) error {
 }
 
 // Getting apache2 status from server-status module data.
-func getApache2Metrics(host string, port uint16, path string) (string, error) {
-	uri := "http://" + host + ":" + strconv.FormatUint(uint64(port), 10) + path
+func getApache2Metrics(scheme string, host string, port uint16, path string) (string, error) {
+	uri := scheme + "://" + host + ":" + strconv.FormatUint(uint64(port), 10) + path
 	resp, err := http.Get(uri)
 	if err != nil {
 		return "", err
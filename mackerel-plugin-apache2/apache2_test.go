@@ -78,7 +78,7 @@ Scoreboard: W_.__...........................`
 	port, _ := strconv.Atoi(found[3])
 	path := found[4]
 
-	ret, err := getApache2Metrics(host, uint16(port), path)
+	ret, err := getApache2Metrics(found[1], host, uint16(port), path)
 	assert.Nil(t, err)
 	assert.NotNil(t, ret)
 	assert.NotEmpty(t, ret)
@@ -5,10 +5,26 @@ import (
 )
 
 var Flags = []cli.Flag{
+	cliHttpScheme,
 	cliHttpHost,
 	cliHttpPort,
 	cliStatusPage,
 	cliTempFile,
+	cliStatsd,
+	cliStatsdPrefix,
+	cliStatsdOnly,
+	cliSelfMetrics,
+	cliPostProcess,
+	cliPostProcessTimeout,
+	cliInsecure,
+	cliCACert,
+}
+
+var cliHttpScheme = cli.StringFlag{
+	Name:   "http_scheme",
+	Value:  "http",
+	Usage:  "Set scheme (http or https) of the status page.",
+	EnvVar: "ENVVAR_HTTP_SCHEME",
 }
 
 var cliHttpHost = cli.StringFlag{
@@ -38,3 +54,56 @@ var cliTempFile = cli.StringFlag{
 	Usage:  "Set temporary file path.",
 	EnvVar: "ENVVAR_TEMPFILE",
 }
+
+var cliStatsd = cli.StringFlag{
+	Name:   "statsd",
+	Value:  "",
+	Usage:  "Set StatsD address (host:port) to send the metrics to as gauges.",
+	EnvVar: "ENVVAR_STATSD",
+}
+
+var cliStatsdPrefix = cli.StringFlag{
+	Name:   "statsd_prefix",
+	Value:  "mackerel",
+	Usage:  "Set prefix of the stat names sent to StatsD.",
+	EnvVar: "ENVVAR_STATSD_PREFIX",
+}
+
+var cliStatsdOnly = cli.BoolFlag{
+	Name:   "statsd_only",
+	Usage:  "Send the metrics only to StatsD, without printing them for mackerel-agent.",
+	EnvVar: "ENVVAR_STATSD_ONLY",
+}
+
+var cliSelfMetrics = cli.BoolFlag{
+	Name:   "self_metrics",
+	Usage:  "Output the time of the collection and the number of the metrics failed to fetch.",
+	EnvVar: "ENVVAR_SELF_METRICS",
+}
+
+var cliPostProcess = cli.StringFlag{
+	Name:   "post_process",
+	Value:  "",
+	Usage:  "Set command to pipe the metric lines through before the output.",
+	EnvVar: "ENVVAR_POST_PROCESS",
+}
+
+var cliPostProcessTimeout = cli.IntFlag{
+	Name:   "post_process_timeout",
+	Value:  10,
+	Usage:  "Set timeout (sec) of the post-process command.",
+	EnvVar: "ENVVAR_POST_PROCESS_TIMEOUT",
+}
+
+var cliInsecure = cli.BoolFlag{
+	Name:   "insecure",
+	Usage:  "Skip verifying the certificate of HTTPS.",
+	EnvVar: "ENVVAR_INSECURE",
+}
+
+var cliCACert = cli.StringFlag{
+	Name:   "ca_cert",
+	Value:  "",
+	Usage:  "Set CA certificate (PEM) file to verify the certificate of HTTPS with.",
+	EnvVar: "ENVVAR_CA_CERT",
+}
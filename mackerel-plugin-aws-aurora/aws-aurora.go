@@ -0,0 +1,208 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"aurora.ReplicaLag": mp.Graphs{
+		Label: "Aurora Replica Lag in millisecond",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "AuroraReplicaLag", Label: "AuroraReplicaLag"},
+		},
+	},
+	"aurora.VolumeIOPS": mp.Graphs{
+		Label: "Aurora Volume IOPS",
+		Unit:  "iops",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "VolumeReadIOPs", Label: "Read"},
+			mp.Metrics{Name: "VolumeWriteIOPs", Label: "Write"},
+		},
+	},
+	"aurora.VolumeBytesUsed": mp.Graphs{
+		Label: "Aurora Volume Bytes Used",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "VolumeBytesUsed", Label: "VolumeBytesUsed"},
+		},
+	},
+	"aurora.BufferCacheHitRatio": mp.Graphs{
+		Label: "Aurora Buffer Cache Hit Ratio",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "BufferCacheHitRatio", Label: "BufferCacheHitRatio"},
+		},
+	},
+	"aurora.Deadlocks": mp.Graphs{
+		Label: "Aurora Deadlocks",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "Deadlocks", Label: "Deadlocks"},
+		},
+	},
+	"aurora.CommitLatency": mp.Graphs{
+		Label: "Aurora Commit Latency in millisecond",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "CommitLatency", Label: "CommitLatency"},
+		},
+	},
+}
+
+// auroraMetric is a CloudWatch metric of the cluster and how to fetch it
+type auroraMetric struct {
+	Name      string
+	Statistic string
+	Period    int
+	PerSecond bool
+}
+
+// the volume metrics are published every 5 minutes, and the IOPs are the counts in the period
+var auroraMetrics = []auroraMetric{
+	auroraMetric{Name: "AuroraReplicaLag", Statistic: "Average", Period: 60},
+	auroraMetric{Name: "BufferCacheHitRatio", Statistic: "Average", Period: 60},
+	auroraMetric{Name: "Deadlocks", Statistic: "Average", Period: 60},
+	auroraMetric{Name: "CommitLatency", Statistic: "Average", Period: 60},
+	auroraMetric{Name: "VolumeBytesUsed", Statistic: "Average", Period: 300},
+	auroraMetric{Name: "VolumeReadIOPs", Statistic: "Sum", Period: 300, PerSecond: true},
+	auroraMetric{Name: "VolumeWriteIOPs", Statistic: "Sum", Period: 300, PerSecond: true},
+}
+
+type AuroraPlugin struct {
+	Region            string
+	AccessKeyId       string
+	SecretAccessKey   string
+	SessionToken      string
+	ClusterIdentifier string
+}
+
+// latestValue returns the statistic of the newest datapoint, divided by the period if perSecond
+func latestValue(datapoints []cloudwatch.Datapoint, met auroraMetric) (float64, error) {
+	if len(datapoints) == 0 {
+		return 0, errors.New("fetched no datapoints")
+	}
+
+	latest := datapoints[0]
+	for _, dp := range datapoints[1:] {
+		if dp.Timestamp.After(latest.Timestamp) {
+			latest = dp
+		}
+	}
+
+	v := latest.Average
+	if met.Statistic == "Sum" {
+		v = latest.Sum
+	}
+	if met.PerSecond {
+		v /= float64(met.Period)
+	}
+	return v, nil
+}
+
+func GetLastPoint(cloudWatch *cloudwatch.CloudWatch, dimension *cloudwatch.Dimension, met auroraMetric) (float64, error) {
+	now := time.Now()
+
+	response, err := cloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: []cloudwatch.Dimension{*dimension},
+		StartTime:  now.Add(time.Duration(3*met.Period) * time.Second * -1), // 3 periods (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: met.Name,
+		Period:     met.Period,
+		Statistics: []string{met.Statistic},
+		Namespace:  "AWS/RDS",
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return latestValue(response.GetMetricStatisticsResult.Datapoints, met)
+}
+
+func (p AuroraPlugin) FetchMetrics() (map[string]float64, error) {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	cloudWatch, err := cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+
+	perCluster := &cloudwatch.Dimension{
+		Name:  "DBClusterIdentifier",
+		Value: p.ClusterIdentifier,
+	}
+
+	for _, met := range auroraMetrics {
+		v, err := GetLastPoint(cloudWatch, perCluster, met)
+		if err == nil {
+			stat[met.Name] = v
+		} else {
+			common.LogFetchError(met.Name, err)
+		}
+	}
+
+	return stat, nil
+}
+
+func (p AuroraPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optClusterIdentifier := flag.String("cluster-identifier", "", "DB Cluster Identifier")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var aurora AuroraPlugin
+
+	if *optClusterIdentifier == "" {
+		log.Fatalln("-cluster-identifier is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	aurora.Region = region
+
+	aurora.ClusterIdentifier = *optClusterIdentifier
+	aurora.AccessKeyId = *optAccessKeyId
+	aurora.SecretAccessKey = *optSecretAccessKey
+	aurora.SessionToken = common.AWSSessionToken(*optSessionToken)
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(aurora))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-aurora", "region", "cluster-identifier")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
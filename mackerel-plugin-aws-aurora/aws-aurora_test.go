@@ -0,0 +1,31 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/crowdmob/goamz/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatestValue(t *testing.T) {
+	now := time.Now()
+	lag := auroraMetric{Name: "AuroraReplicaLag", Statistic: "Average", Period: 60}
+	v, err := latestValue([]cloudwatch.Datapoint{
+		cloudwatch.Datapoint{Timestamp: now.Add(-1 * time.Minute), Average: 20.5},
+		cloudwatch.Datapoint{Timestamp: now.Add(-2 * time.Minute), Average: 15},
+	}, lag)
+	assert.Nil(t, err)
+	assert.Equal(t, v, 20.5)
+
+	iops := auroraMetric{Name: "VolumeReadIOPs", Statistic: "Sum", Period: 300, PerSecond: true}
+	v, err = latestValue([]cloudwatch.Datapoint{
+		cloudwatch.Datapoint{Timestamp: now.Add(-10 * time.Minute), Sum: 600},
+		cloudwatch.Datapoint{Timestamp: now.Add(-5 * time.Minute), Sum: 1500},
+	}, iops)
+	assert.Nil(t, err)
+	assert.Equal(t, v, 5.0)
+
+	_, err = latestValue(nil, lag)
+	assert.NotNil(t, err)
+}
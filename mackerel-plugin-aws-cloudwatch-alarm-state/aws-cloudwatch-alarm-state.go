@@ -0,0 +1,126 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"cloudwatch.alarm_state": mp.Graphs{
+		Label: "CloudWatch Alarms by State",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "OK", Label: "OK", Stacked: true},
+			mp.Metrics{Name: "ALARM", Label: "ALARM", Stacked: true},
+			mp.Metrics{Name: "INSUFFICIENT_DATA", Label: "INSUFFICIENT_DATA", Stacked: true},
+		},
+	},
+}
+
+type AlarmStatePlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	AlarmNamePrefix string
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+func (p *AlarmStatePlugin) Prepare() error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	config := aws.NewConfig().WithRegion(p.Region)
+	if p.AccessKeyId != "" && p.SecretAccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyId, p.SecretAccessKey, p.SessionToken))
+	}
+
+	p.CloudWatch = cloudwatch.New(sess, config)
+
+	return nil
+}
+
+func (p AlarmStatePlugin) FetchMetrics() (map[string]float64, error) {
+	stat := map[string]float64{
+		cloudwatch.StateValueOk:               0,
+		cloudwatch.StateValueAlarm:            0,
+		cloudwatch.StateValueInsufficientData: 0,
+	}
+
+	input := &cloudwatch.DescribeAlarmsInput{}
+	if p.AlarmNamePrefix != "" {
+		input.AlarmNamePrefix = aws.String(p.AlarmNamePrefix)
+	}
+
+	err := p.CloudWatch.DescribeAlarmsPages(input, func(page *cloudwatch.DescribeAlarmsOutput, lastPage bool) bool {
+		for _, alarm := range page.MetricAlarms {
+			stat[aws.StringValue(alarm.StateValue)]++
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return stat, nil
+}
+
+func (p AlarmStatePlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optAlarmNamePrefix := flag.String("alarm-name-prefix", "", "Count only alarms whose names start with this prefix")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var alarm AlarmStatePlugin
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	alarm.Region = region
+
+	alarm.AccessKeyId = *optAccessKeyId
+	alarm.SecretAccessKey = *optSecretAccessKey
+	alarm.SessionToken = common.AWSSessionToken(*optSessionToken)
+	alarm.AlarmNamePrefix = *optAlarmNamePrefix
+
+	err = alarm.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(alarm))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-cloudwatch-alarm-state", "region", "alarm-name-prefix")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,297 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var errNoDatapoints = errors.New("fetched no datapoints")
+
+var errNoBand = errors.New("fetched no anomaly detection band. is the anomaly detector set up for the metric and the statistic?")
+
+type AnomalyPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Namespace       string
+	MetricName      string
+	Dimensions      []*cloudwatch.Dimension
+	Stat            string
+	Period          int64
+	Anomaly         bool
+	StdDev          float64
+	Key             string
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// parseDimensions parses "Name=Value,Name=Value"
+func parseDimensions(s string) ([]*cloudwatch.Dimension, error) {
+	var dimensions []*cloudwatch.Dimension
+	if s == "" {
+		return dimensions, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, errors.New("invalid dimension: " + kv)
+		}
+		dimensions = append(dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(pair[0]),
+			Value: aws.String(pair[1]),
+		})
+	}
+	return dimensions, nil
+}
+
+func (p *AnomalyPlugin) Prepare() error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	config := aws.NewConfig().WithRegion(p.Region)
+	if p.AccessKeyId != "" && p.SecretAccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyId, p.SecretAccessKey, p.SessionToken))
+	}
+
+	p.CloudWatch = cloudwatch.New(sess, config)
+	return nil
+}
+
+// queries returns the query of the metric (m1), and of its anomaly detection band (ad1) with -anomaly.
+// the band needs an anomaly detector set up for the metric with the same statistic
+func (p AnomalyPlugin) queries() []*cloudwatch.MetricDataQuery {
+	queries := []*cloudwatch.MetricDataQuery{
+		&cloudwatch.MetricDataQuery{
+			Id: aws.String("m1"),
+			MetricStat: &cloudwatch.MetricStat{
+				Metric: &cloudwatch.Metric{
+					Namespace:  aws.String(p.Namespace),
+					MetricName: aws.String(p.MetricName),
+					Dimensions: p.Dimensions,
+				},
+				Period: aws.Int64(p.Period),
+				Stat:   aws.String(p.Stat),
+			},
+			ReturnData: aws.Bool(true),
+		},
+	}
+	if p.Anomaly {
+		queries = append(queries, &cloudwatch.MetricDataQuery{
+			Id:         aws.String("ad1"),
+			Expression: aws.String(fmt.Sprintf("ANOMALY_DETECTION_BAND(m1, %g)", p.StdDev)),
+			ReturnData: aws.Bool(true),
+		})
+	}
+	return queries
+}
+
+// valueAt returns the value of the result at t, or the newest one if t is zero
+func valueAt(result *cloudwatch.MetricDataResult, t time.Time) (float64, time.Time, bool) {
+	found := -1
+	for i, ts := range result.Timestamps {
+		if i >= len(result.Values) || ts == nil || result.Values[i] == nil {
+			continue
+		}
+		if t.IsZero() {
+			if found < 0 || ts.After(*result.Timestamps[found]) {
+				found = i
+			}
+		} else if ts.Equal(t) {
+			found = i
+		}
+	}
+	if found < 0 {
+		return 0, time.Time{}, false
+	}
+	return *result.Values[found], *result.Timestamps[found], true
+}
+
+// parseResults returns the newest value of m1, and the band at the same time.
+// ANOMALY_DETECTION_BAND returns two time series, the upper and the lower bounds
+func parseResults(results []*cloudwatch.MetricDataResult) (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	var bands []*cloudwatch.MetricDataResult
+	var value *cloudwatch.MetricDataResult
+	for _, r := range results {
+		switch aws.StringValue(r.Id) {
+		case "m1":
+			// the newest datapoints come first with TimestampDescending
+			if value == nil {
+				value = r
+			}
+		case "ad1":
+			bands = append(bands, r)
+		}
+	}
+	if value == nil {
+		return nil, errNoDatapoints
+	}
+	v, t, ok := valueAt(value, time.Time{})
+	if !ok {
+		return nil, errNoDatapoints
+	}
+	stat["value"] = v
+
+	var bounds []float64
+	for _, band := range bands {
+		if b, _, ok := valueAt(band, t); ok {
+			bounds = append(bounds, b)
+		}
+	}
+	if len(bounds) == 2 {
+		if bounds[0] < bounds[1] {
+			bounds[0], bounds[1] = bounds[1], bounds[0]
+		}
+		stat["band_upper"] = bounds[0]
+		stat["band_lower"] = bounds[1]
+	}
+
+	return stat, nil
+}
+
+func (p AnomalyPlugin) FetchMetrics() (map[string]float64, error) {
+	now := time.Now()
+	input := &cloudwatch.GetMetricDataInput{
+		// the band is calculated from the datapoints, which may be reported a few periods late
+		StartTime:         aws.Time(now.Add(time.Duration(-10*p.Period) * time.Second)),
+		EndTime:           aws.Time(now),
+		MetricDataQueries: p.queries(),
+		ScanBy:            aws.String(cloudwatch.ScanByTimestampDescending),
+	}
+
+	var results []*cloudwatch.MetricDataResult
+	for {
+		ret, err := p.CloudWatch.GetMetricData(input)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, ret.MetricDataResults...)
+		if ret.NextToken == nil {
+			break
+		}
+		input.NextToken = ret.NextToken
+	}
+
+	stat, err := parseResults(results)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := stat["band_upper"]; p.Anomaly && !ok {
+		common.LogFetchError("band", errNoBand)
+	}
+	return stat, nil
+}
+
+func (p AnomalyPlugin) GraphDefinition() map[string](mp.Graphs) {
+	metrics := [](mp.Metrics){
+		mp.Metrics{Name: "value", Label: p.Stat},
+	}
+	if p.Anomaly {
+		metrics = append(metrics,
+			mp.Metrics{Name: "band_upper", Label: "Expected Upper"},
+			mp.Metrics{Name: "band_lower", Label: "Expected Lower"},
+		)
+	}
+
+	return map[string](mp.Graphs){
+		"cloudwatch_anomaly." + p.Key: mp.Graphs{
+			Label:   fmt.Sprintf("CloudWatch %s %s", p.Namespace, p.MetricName),
+			Unit:    "float",
+			Metrics: metrics,
+		},
+	}
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optNamespace := flag.String("namespace", "", "Namespace of the metric (e.g. AWS/EC2)")
+	optMetric := flag.String("metric", "", "Name of the metric (e.g. CPUUtilization)")
+	optDimensions := flag.String("dimensions", "", "Dimensions of the metric (e.g. InstanceId=i-1234567890abcdef0), comma separated")
+	optStat := flag.String("stat", "Average", "Statistic of the metric")
+	optPeriod := flag.Int64("period", 300, "Period (sec) of the metric")
+	optAnomaly := flag.Bool("anomaly", false, "Fetch the anomaly detection band of the metric")
+	optStdDev := flag.Float64("stddev", 2, "Width of the anomaly detection band in standard deviations")
+	optKey := flag.String("key", "", "Graph name (default: the metric name)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var anomaly AnomalyPlugin
+
+	if *optNamespace == "" || *optMetric == "" {
+		log.Fatalln("-namespace and -metric are required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	anomaly.Region = region
+
+	anomaly.AccessKeyId = *optAccessKeyId
+	anomaly.SecretAccessKey = *optSecretAccessKey
+	anomaly.SessionToken = common.AWSSessionToken(*optSessionToken)
+	anomaly.Namespace = *optNamespace
+	anomaly.MetricName = *optMetric
+	anomaly.Stat = *optStat
+	anomaly.Period = *optPeriod
+	anomaly.Anomaly = *optAnomaly
+	anomaly.StdDev = *optStdDev
+
+	anomaly.Dimensions, err = parseDimensions(*optDimensions)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if *optKey != "" {
+		anomaly.Key = metricName(*optKey)
+	} else {
+		anomaly.Key = metricName(*optMetric)
+	}
+
+	err = anomaly.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(anomaly))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-cloudwatch-anomaly-" + metricName(*optNamespace) + "-" + anomaly.Key
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDimensions(t *testing.T) {
+	dimensions, err := parseDimensions("LoadBalancerName=web,AvailabilityZone=ap-northeast-1a")
+	assert.Nil(t, err)
+	assert.Equal(t, len(dimensions), 2)
+	assert.Equal(t, *dimensions[1].Name, "AvailabilityZone")
+	assert.Equal(t, *dimensions[1].Value, "ap-northeast-1a")
+
+	dimensions, err = parseDimensions("")
+	assert.Nil(t, err)
+	assert.Equal(t, len(dimensions), 0)
+
+	_, err = parseDimensions("LoadBalancerName")
+	assert.NotNil(t, err)
+}
+
+func TestParseResults(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	before := now.Add(-5 * time.Minute)
+	results := []*cloudwatch.MetricDataResult{
+		&cloudwatch.MetricDataResult{
+			Id:         aws.String("m1"),
+			Timestamps: []*time.Time{aws.Time(now), aws.Time(before)},
+			Values:     []*float64{aws.Float64(42), aws.Float64(10)},
+		},
+		&cloudwatch.MetricDataResult{
+			Id:         aws.String("ad1"),
+			Timestamps: []*time.Time{aws.Time(before), aws.Time(now)},
+			Values:     []*float64{aws.Float64(5), aws.Float64(8)},
+		},
+		&cloudwatch.MetricDataResult{
+			Id:         aws.String("ad1"),
+			Timestamps: []*time.Time{aws.Time(now), aws.Time(before)},
+			Values:     []*float64{aws.Float64(20), aws.Float64(15)},
+		},
+	}
+
+	stat, err := parseResults(results)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["value"], 42)
+	assert.EqualValues(t, stat["band_upper"], 20)
+	assert.EqualValues(t, stat["band_lower"], 8)
+
+	// without the band (no anomaly detector)
+	stat, err = parseResults(results[:1])
+	assert.Nil(t, err)
+	_, ok := stat["band_upper"]
+	assert.False(t, ok)
+
+	_, err = parseResults([]*cloudwatch.MetricDataResult{
+		&cloudwatch.MetricDataResult{Id: aws.String("m1")},
+	})
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,340 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// a metric referred by the expressions, which is not output itself
+type metricDef struct {
+	ID         string
+	Namespace  string
+	MetricName string
+	Stat       string
+	Dimensions []*cloudwatch.Dimension
+}
+
+// an expression output as the metric of the name
+type expressionDef struct {
+	ID         string
+	Expression string
+	Name       string
+}
+
+type MetricMathPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Metrics         []metricDef
+	Expressions     []expressionDef
+	Period          int64
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+// ids of GetMetricData start with a lowercase letter
+var validID = regexp.MustCompile("^[a-z][a-zA-Z0-9_]*$")
+
+// parseDimensions parses "Name=Value,Name=Value"
+func parseDimensions(s string) ([]*cloudwatch.Dimension, error) {
+	var dimensions []*cloudwatch.Dimension
+	if s == "" {
+		return dimensions, nil
+	}
+	for _, kv := range strings.Split(s, ",") {
+		pair := strings.SplitN(kv, "=", 2)
+		if len(pair) != 2 || pair[0] == "" {
+			return nil, errors.New("invalid dimension: " + kv)
+		}
+		dimensions = append(dimensions, &cloudwatch.Dimension{
+			Name:  aws.String(pair[0]),
+			Value: aws.String(pair[1]),
+		})
+	}
+	return dimensions, nil
+}
+
+// parseMetricDef parses "<id>:<namespace>:<metric>:<stat>[:<dimensions>]", e.g.
+// "m1:AWS/ELB:HTTPCode_Backend_5XX:Sum:LoadBalancerName=web"
+func parseMetricDef(s string) (metricDef, error) {
+	fields := strings.SplitN(s, ":", 5)
+	if len(fields) < 4 {
+		return metricDef{}, errors.New("invalid metric (<id>:<namespace>:<metric>:<stat>[:<dimensions>]): " + s)
+	}
+	if !validID.MatchString(fields[0]) {
+		return metricDef{}, errors.New("invalid id of the metric (should start with a lowercase letter): " + fields[0])
+	}
+
+	m := metricDef{ID: fields[0], Namespace: fields[1], MetricName: fields[2], Stat: fields[3]}
+	if len(fields) == 5 {
+		var err error
+		m.Dimensions, err = parseDimensions(fields[4])
+		if err != nil {
+			return metricDef{}, err
+		}
+	}
+	return m, nil
+}
+
+// parseExpressionDef parses "<expression>:<name>", e.g. "m1/m2*100:error_rate".
+// the expression is given the id "e<i>"
+func parseExpressionDef(s string, i int) (expressionDef, error) {
+	j := strings.LastIndex(s, ":")
+	if j <= 0 || j == len(s)-1 {
+		return expressionDef{}, errors.New("invalid expression (<expression>:<name>): " + s)
+	}
+	name := s[j+1:]
+	if invalidChars.MatchString(name) {
+		return expressionDef{}, errors.New("invalid name of the expression: " + name)
+	}
+	return expressionDef{ID: "e" + strconv.Itoa(i+1), Expression: s[:j], Name: name}, nil
+}
+
+// the string literals (e.g. of SEARCH) are not references
+var stringLiteral = regexp.MustCompile(`'[^']*'|"[^"]*"`)
+
+// references are lowercase, while the functions (e.g. SUM, METRICS) are uppercase
+var reference = regexp.MustCompile(`\b[a-z][a-zA-Z0-9_]*\b`)
+
+// validate checks the ids are unique and the expressions refer to the defined ids only
+func validate(metrics []metricDef, expressions []expressionDef) error {
+	if len(expressions) == 0 {
+		return errors.New("-expression is required")
+	}
+
+	ids := make(map[string]bool)
+	for _, m := range metrics {
+		if ids[m.ID] {
+			return errors.New("duplicate id of the metrics: " + m.ID)
+		}
+		ids[m.ID] = true
+	}
+	names := make(map[string]bool)
+	for _, e := range expressions {
+		if ids[e.ID] {
+			return errors.New("the id of the metric is reserved for the expressions: " + e.ID)
+		}
+		if names[e.Name] {
+			return errors.New("duplicate name of the expressions: " + e.Name)
+		}
+		names[e.Name] = true
+	}
+
+	for _, e := range expressions {
+		for _, ref := range reference.FindAllString(stringLiteral.ReplaceAllString(e.Expression, ""), -1) {
+			if !ids[ref] {
+				return errors.New(fmt.Sprintf("undefined id in the expression of %s: %s", e.Name, ref))
+			}
+		}
+	}
+	return nil
+}
+
+func (p *MetricMathPlugin) Prepare() error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	config := aws.NewConfig().WithRegion(p.Region)
+	if p.AccessKeyId != "" && p.SecretAccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyId, p.SecretAccessKey, p.SessionToken))
+	}
+
+	p.CloudWatch = cloudwatch.New(sess, config)
+	return nil
+}
+
+// queries returns the queries of the metrics, which are not returned,
+// and the expressions on them
+func (p MetricMathPlugin) queries() []*cloudwatch.MetricDataQuery {
+	var queries []*cloudwatch.MetricDataQuery
+	for _, m := range p.Metrics {
+		queries = append(queries, &cloudwatch.MetricDataQuery{
+			Id: aws.String(m.ID),
+			MetricStat: &cloudwatch.MetricStat{
+				Metric: &cloudwatch.Metric{
+					Namespace:  aws.String(m.Namespace),
+					MetricName: aws.String(m.MetricName),
+					Dimensions: m.Dimensions,
+				},
+				Period: aws.Int64(p.Period),
+				Stat:   aws.String(m.Stat),
+			},
+			ReturnData: aws.Bool(false),
+		})
+	}
+	for _, e := range p.Expressions {
+		queries = append(queries, &cloudwatch.MetricDataQuery{
+			Id:         aws.String(e.ID),
+			Expression: aws.String(e.Expression),
+			ReturnData: aws.Bool(true),
+		})
+	}
+	return queries
+}
+
+// newestValues returns the newest value of each result by id
+func newestValues(results []*cloudwatch.MetricDataResult) map[string]float64 {
+	values := make(map[string]float64)
+	newest := make(map[string]time.Time)
+	for _, r := range results {
+		id := aws.StringValue(r.Id)
+		for i, ts := range r.Timestamps {
+			if i >= len(r.Values) || ts == nil || r.Values[i] == nil {
+				continue
+			}
+			if t, ok := newest[id]; ok && !ts.After(t) {
+				continue
+			}
+			newest[id] = *ts
+			values[id] = *r.Values[i]
+		}
+	}
+	return values
+}
+
+func (p MetricMathPlugin) FetchMetrics() (map[string]float64, error) {
+	now := time.Now()
+	input := &cloudwatch.GetMetricDataInput{
+		// a few periods, as the datapoints may be reported late
+		StartTime:         aws.Time(now.Add(time.Duration(-5*p.Period) * time.Second)),
+		EndTime:           aws.Time(now),
+		MetricDataQueries: p.queries(),
+		ScanBy:            aws.String(cloudwatch.ScanByTimestampDescending),
+	}
+
+	var results []*cloudwatch.MetricDataResult
+	for {
+		ret, err := p.CloudWatch.GetMetricData(input)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, ret.MetricDataResults...)
+		if ret.NextToken == nil {
+			break
+		}
+		input.NextToken = ret.NextToken
+	}
+
+	values := newestValues(results)
+	stat := make(map[string]float64)
+	for _, e := range p.Expressions {
+		v, ok := values[e.ID]
+		if !ok {
+			// e.g. no datapoints of the metrics, or an error in the expression
+			common.LogFetchError(e.Name, errors.New("fetched no datapoints"))
+			continue
+		}
+		stat[e.Name] = v
+	}
+	return stat, nil
+}
+
+func (p MetricMathPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs))
+	for _, e := range p.Expressions {
+		graphs["cloudwatch_math."+e.Name] = mp.Graphs{
+			Label: "CloudWatch " + e.Name,
+			Unit:  "float",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: e.Name, Label: e.Expression},
+			},
+		}
+	}
+	return graphs
+}
+
+func main() {
+	var optMetrics, optExpressions stringSlice
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	flag.Var(&optMetrics, "metric", "Metric referred by the expressions (<id>:<namespace>:<metric>:<stat>[:<dimensions>]), can be specified multiple times")
+	flag.Var(&optExpressions, "expression", "Metric math expression and its metric name (<expression>:<name>), can be specified multiple times")
+	optPeriod := flag.Int64("period", 60, "Period (sec) of the metrics")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var math MetricMathPlugin
+
+	for _, s := range optMetrics {
+		m, err := parseMetricDef(s)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		math.Metrics = append(math.Metrics, m)
+	}
+	for i, s := range optExpressions {
+		e, err := parseExpressionDef(s, i)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		math.Expressions = append(math.Expressions, e)
+	}
+	if err := validate(math.Metrics, math.Expressions); err != nil {
+		log.Fatalln(err)
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	math.Region = region
+
+	math.AccessKeyId = *optAccessKeyId
+	math.SecretAccessKey = *optSecretAccessKey
+	math.SessionToken = common.AWSSessionToken(*optSessionToken)
+	math.Period = *optPeriod
+
+	err = math.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(math))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-cloudwatch-metric-math", "region", "metric", "expression")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMetricDef(t *testing.T) {
+	m, err := parseMetricDef("m1:AWS/ELB:HTTPCode_Backend_5XX:Sum:LoadBalancerName=web,AvailabilityZone=ap-northeast-1a")
+	assert.Nil(t, err)
+	assert.Equal(t, m.ID, "m1")
+	assert.Equal(t, m.Namespace, "AWS/ELB")
+	assert.Equal(t, m.MetricName, "HTTPCode_Backend_5XX")
+	assert.Equal(t, m.Stat, "Sum")
+	assert.Equal(t, len(m.Dimensions), 2)
+	assert.Equal(t, *m.Dimensions[0].Value, "web")
+
+	m, err = parseMetricDef("requests:AWS/ELB:RequestCount:Sum")
+	assert.Nil(t, err)
+	assert.Equal(t, len(m.Dimensions), 0)
+
+	_, err = parseMetricDef("m1:AWS/ELB:RequestCount")
+	assert.NotNil(t, err)
+	_, err = parseMetricDef("M1:AWS/ELB:RequestCount:Sum")
+	assert.NotNil(t, err, "ids should start with a lowercase letter")
+	_, err = parseMetricDef("m1:AWS/ELB:RequestCount:Sum:LoadBalancerName")
+	assert.NotNil(t, err)
+}
+
+func TestParseExpressionDef(t *testing.T) {
+	e, err := parseExpressionDef("m1/m2*100:error_rate", 0)
+	assert.Nil(t, err)
+	assert.Equal(t, e.ID, "e1")
+	assert.Equal(t, e.Expression, "m1/m2*100")
+	assert.Equal(t, e.Name, "error_rate")
+
+	_, err = parseExpressionDef("m1/m2*100", 0)
+	assert.NotNil(t, err)
+	_, err = parseExpressionDef("m1/m2*100:", 0)
+	assert.NotNil(t, err)
+	_, err = parseExpressionDef("m1/m2*100:error rate", 0)
+	assert.NotNil(t, err)
+}
+
+func TestValidate(t *testing.T) {
+	metrics := []metricDef{
+		metricDef{ID: "m1"},
+		metricDef{ID: "m2"},
+	}
+	expressions := []expressionDef{
+		expressionDef{ID: "e1", Expression: "m1/m2*100", Name: "error_rate"},
+		expressionDef{ID: "e2", Expression: "SUM(METRICS())", Name: "total"},
+		expressionDef{ID: "e3", Expression: "SEARCH('{AWS/EC2,InstanceId} cpu', 'Average', 300)", Name: "search"},
+	}
+	assert.Nil(t, validate(metrics, expressions))
+
+	assert.NotNil(t, validate(metrics, nil), "no expressions")
+	assert.NotNil(t, validate(metrics, []expressionDef{
+		expressionDef{ID: "e1", Expression: "m1/m3", Name: "ratio"},
+	}), "undefined id")
+	assert.NotNil(t, validate(append(metrics, metricDef{ID: "m1"}), expressions), "duplicate id")
+	assert.NotNil(t, validate(append(metrics, metricDef{ID: "e1"}), expressions), "id of the expressions")
+	assert.NotNil(t, validate(metrics, []expressionDef{
+		expressionDef{ID: "e1", Expression: "m1", Name: "value"},
+		expressionDef{ID: "e2", Expression: "m2", Name: "value"},
+	}), "duplicate name")
+}
+
+func TestNewestValues(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	before := now.Add(-time.Minute)
+	results := []*cloudwatch.MetricDataResult{
+		&cloudwatch.MetricDataResult{
+			Id:         aws.String("e1"),
+			Timestamps: []*time.Time{aws.Time(now), aws.Time(before)},
+			Values:     []*float64{aws.Float64(1.5), aws.Float64(2)},
+		},
+		// a page continued by NextToken
+		&cloudwatch.MetricDataResult{
+			Id:         aws.String("e1"),
+			Timestamps: []*time.Time{aws.Time(before.Add(-time.Minute))},
+			Values:     []*float64{aws.Float64(3)},
+		},
+		&cloudwatch.MetricDataResult{
+			Id:         aws.String("e2"),
+			Timestamps: []*time.Time{},
+			Values:     []*float64{},
+		},
+	}
+
+	values := newestValues(results)
+	assert.InDelta(t, values["e1"], 1.5, 0.001)
+	_, ok := values["e2"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/Connect"
+
+var errNoDatapoints = errors.New("fetched no datapoints")
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"connect.calls": mp.Graphs{
+		Label: "Connect Calls",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "CallsPerInterval", Label: "Calls"},
+			mp.Metrics{Name: "MissedCalls", Label: "Missed"},
+			mp.Metrics{Name: "ThrottledCalls", Label: "Throttled"},
+		},
+	},
+	"connect.concurrent_calls": mp.Graphs{
+		Label: "Connect Concurrent Calls",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "ConcurrentCalls", Label: "Concurrent Calls"},
+		},
+	},
+	"connect.concurrent_calls_percentage": mp.Graphs{
+		Label: "Connect Concurrent Calls Percentage",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "ConcurrentCallsPercentage", Label: "Of Quota"},
+		},
+	},
+	"connect.call_recording_upload_error": mp.Graphs{
+		Label: "Connect Call Recording Upload Errors",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "CallRecordingUploadError", Label: "Upload Errors"},
+		},
+	},
+}
+
+type StatType int
+
+const (
+	Sum StatType = iota
+	Maximum
+)
+
+func (s StatType) String() string {
+	switch s {
+	case Sum:
+		return "Sum"
+	case Maximum:
+		return "Maximum"
+	}
+	return ""
+}
+
+type metric struct {
+	metricName  string
+	metricGroup string
+	statType    StatType
+}
+
+// the concurrency is a gauge, and the others are counted per 1 min.
+// the errors are published only when they happen, so no datapoints of them mean 0
+var metrics = []metric{
+	{"CallsPerInterval", "VoiceCalls", Sum},
+	{"ConcurrentCalls", "VoiceCalls", Maximum},
+	{"ConcurrentCallsPercentage", "VoiceCalls", Maximum},
+	{"MissedCalls", "VoiceCalls", Sum},
+	{"ThrottledCalls", "VoiceCalls", Sum},
+	{"CallRecordingUploadError", "CallRecordings", Sum},
+}
+
+type ConnectPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	InstanceId      string
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+func (p *ConnectPlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p ConnectPlugin) GetLastPoint(dimensions []cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: dimensions,
+		StartTime:  now.Add(time.Duration(300) * time.Second * -1), // 5 min (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: metricName,
+		Period:     60,
+		Statistics: []string{statType.String()},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := response.GetMetricStatisticsResult.Datapoints
+	if len(datapoints) == 0 {
+		return 0, errNoDatapoints
+	}
+
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	for _, dp := range datapoints {
+		if dp.Timestamp.Before(latest) {
+			continue
+		}
+
+		latest = dp.Timestamp
+		switch statType {
+		case Sum:
+			latestVal = dp.Sum
+		case Maximum:
+			latestVal = dp.Maximum
+		}
+	}
+
+	return latestVal, nil
+}
+
+func (p ConnectPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	for _, met := range metrics {
+		dimensions := []cloudwatch.Dimension{
+			cloudwatch.Dimension{Name: "InstanceId", Value: p.InstanceId},
+			cloudwatch.Dimension{Name: "MetricGroup", Value: met.metricGroup},
+		}
+		v, err := p.GetLastPoint(dimensions, met.metricName, met.statType)
+		if err == errNoDatapoints && met.statType == Sum {
+			stat[met.metricName] = 0
+		} else if err == nil {
+			stat[met.metricName] = v
+		} else {
+			common.LogFetchError(met.metricName, err)
+		}
+	}
+
+	return stat, nil
+}
+
+func (p ConnectPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optInstanceId := flag.String("instance-id", "", "Amazon Connect Instance ID")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var connect ConnectPlugin
+
+	if *optInstanceId == "" {
+		log.Fatalln("-instance-id is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	connect.Region = region
+
+	connect.AccessKeyId = *optAccessKeyId
+	connect.SecretAccessKey = *optSecretAccessKey
+	connect.SessionToken = common.AWSSessionToken(*optSessionToken)
+	connect.InstanceId = *optInstanceId
+
+	err = connect.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(connect))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-connect-" + *optInstanceId
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
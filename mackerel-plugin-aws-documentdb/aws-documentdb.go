@@ -0,0 +1,229 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/DocDB"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"documentdb.cpu": mp.Graphs{
+		Label: "DocumentDB CPU Utilization",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "CPUUtilization", Label: "CPUUtilization"},
+		},
+	},
+	"documentdb.connections": mp.Graphs{
+		Label: "DocumentDB Database Connections",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "DatabaseConnections", Label: "DatabaseConnections"},
+		},
+	},
+	"documentdb.memory": mp.Graphs{
+		Label: "DocumentDB Freeable Memory",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "FreeableMemory", Label: "FreeableMemory"},
+		},
+	},
+	"documentdb.replica_lag": mp.Graphs{
+		Label: "DocumentDB Replica Lag (msec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "DBInstanceReplicaLag", Label: "DBInstanceReplicaLag"},
+		},
+	},
+	"documentdb.latency": mp.Graphs{
+		Label: "DocumentDB Latency (msec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "ReadLatency", Label: "Read"},
+			mp.Metrics{Name: "WriteLatency", Label: "Write"},
+		},
+	},
+	"documentdb.buffer_cache_hit_ratio": mp.Graphs{
+		Label: "DocumentDB Buffer Cache Hit Ratio",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "BufferCacheHitRatio", Label: "BufferCacheHitRatio"},
+		},
+	},
+}
+
+type StatType int
+
+const (
+	Average StatType = iota
+	Maximum
+)
+
+func (s StatType) String() string {
+	switch s {
+	case Average:
+		return "Average"
+	case Maximum:
+		return "Maximum"
+	}
+	return ""
+}
+
+type DocumentDBPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Dimension       cloudwatch.Dimension
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+func (p *DocumentDBPlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p DocumentDBPlugin) GetLastPoint(dimension *cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: []cloudwatch.Dimension{*dimension},
+		StartTime:  now.Add(time.Duration(180) * time.Second * -1), // 3 min (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: metricName,
+		Period:     60,
+		Statistics: []string{statType.String()},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := response.GetMetricStatisticsResult.Datapoints
+	if len(datapoints) == 0 {
+		return 0, errors.New("fetched no datapoints")
+	}
+
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	for _, dp := range datapoints {
+		if dp.Timestamp.Before(latest) {
+			continue
+		}
+
+		latest = dp.Timestamp
+		switch statType {
+		case Average:
+			latestVal = dp.Average
+		case Maximum:
+			latestVal = dp.Maximum
+		}
+	}
+
+	return latestVal, nil
+}
+
+func (p DocumentDBPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	for _, met := range [...]string{
+		"CPUUtilization", "DatabaseConnections", "FreeableMemory",
+		"ReadLatency", "WriteLatency", "BufferCacheHitRatio",
+	} {
+		v, err := p.GetLastPoint(&p.Dimension, met, Average)
+		if err == nil {
+			stat[met] = v
+		} else {
+			common.LogFetchError(met, err)
+		}
+	}
+
+	// the worst replica matters for a cluster, and it is reported by replica instances only
+	v, err := p.GetLastPoint(&p.Dimension, "DBInstanceReplicaLag", Maximum)
+	if err == nil {
+		stat["DBInstanceReplicaLag"] = v
+	} else {
+		common.LogFetchError("DBInstanceReplicaLag", err)
+	}
+
+	return stat, nil
+}
+
+func (p DocumentDBPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optClusterIdentifier := flag.String("db-cluster-identifier", "", "DB Cluster Identifier")
+	optInstanceIdentifier := flag.String("db-instance-identifier", "", "DB Instance Identifier")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var docdb DocumentDBPlugin
+
+	switch {
+	case *optClusterIdentifier != "" && *optInstanceIdentifier != "":
+		log.Fatalln("-db-cluster-identifier and -db-instance-identifier cannot be specified together")
+	case *optClusterIdentifier != "":
+		docdb.Dimension = cloudwatch.Dimension{Name: "DBClusterIdentifier", Value: *optClusterIdentifier}
+	case *optInstanceIdentifier != "":
+		docdb.Dimension = cloudwatch.Dimension{Name: "DBInstanceIdentifier", Value: *optInstanceIdentifier}
+	default:
+		log.Fatalln("-db-cluster-identifier or -db-instance-identifier is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	docdb.Region = region
+
+	docdb.AccessKeyId = *optAccessKeyId
+	docdb.SecretAccessKey = *optSecretAccessKey
+	docdb.SessionToken = common.AWSSessionToken(*optSessionToken)
+
+	err = docdb.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(docdb))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-documentdb-" + docdb.Dimension.Value
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
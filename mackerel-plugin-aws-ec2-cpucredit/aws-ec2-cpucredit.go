@@ -6,6 +6,8 @@ import (
 	"github.com/crowdmob/goamz/aws"
 	"github.com/crowdmob/goamz/cloudwatch"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+	"log"
 	"os"
 	"time"
 )
@@ -25,6 +27,7 @@ type CPUCreditPlugin struct {
 	Region          string
 	AccessKeyId     string
 	SecretAccessKey string
+	SessionToken    string
 	InstanceId      string
 }
 
@@ -74,7 +77,7 @@ func (p CPUCreditPlugin) FetchMetrics() (map[string]float64, error) {
 		Value: p.InstanceId,
 	}
 
-	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, "", time.Now())
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
 	if err != nil {
 		return nil, err
 	}
@@ -101,35 +104,49 @@ func (n CPUCreditPlugin) GraphDefinition() map[string](mp.Graphs) {
 
 func main() {
 	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
 	optInstanceId := flag.String("instance-id", "", "Instance ID")
 	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
 	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	var cpucredit CPUCreditPlugin
 
+	var region string
+	var err error
 	if *optRegion == "" || *optInstanceId == "" {
-		cpucredit.Region = aws.InstanceRegion()
+		// the credits of the running instance
 		cpucredit.InstanceId = aws.InstanceId()
+		region, err = common.Region("", false)
 	} else {
-		cpucredit.Region = *optRegion
 		cpucredit.InstanceId = *optInstanceId
+		region, err = common.Region(*optRegion, *optPreferInstanceRegion)
 	}
+	if err != nil {
+		log.Fatalln(err)
+	}
+	cpucredit.Region = region
 
 	cpucredit.AccessKeyId = *optAccessKeyId
 	cpucredit.SecretAccessKey = *optSecretAccessKey
+	cpucredit.SessionToken = common.AWSSessionToken(*optSessionToken)
 
-	helper := mp.NewMackerelPlugin(cpucredit)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(cpucredit))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
-		helper.Tempfile = "/tmp/mackerel-plugin-cpucredit"
+		helper.Tempfile = common.Tempfile("mackerel-plugin-cpucredit", "region", "instance-id")
 	}
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
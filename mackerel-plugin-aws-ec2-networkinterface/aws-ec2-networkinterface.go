@@ -0,0 +1,154 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const PathSysClassNet = "/sys/class/net"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"ec2_networkinterface.packets_dropped": mp.Graphs{
+		Label: "EC2 Network Interface Packets Dropped",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "PacketsDroppedIn", Label: "In", Diff: true},
+			mp.Metrics{Name: "PacketsDroppedOut", Label: "Out", Diff: true},
+		},
+	},
+	"ec2_networkinterface.allowance_exceeded": mp.Graphs{
+		Label: "EC2 Network Interface Allowance Exceeded",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "bw_in_allowance_exceeded", Label: "Bandwidth In", Diff: true},
+			mp.Metrics{Name: "bw_out_allowance_exceeded", Label: "Bandwidth Out", Diff: true},
+			mp.Metrics{Name: "pps_allowance_exceeded", Label: "PPS", Diff: true},
+			mp.Metrics{Name: "conntrack_allowance_exceeded", Label: "Conntrack", Diff: true},
+		},
+	},
+}
+
+// the counters of the packets queued or dropped by the limits of the instance, reported by the ENA driver
+var allowanceCounters = []string{
+	"bw_in_allowance_exceeded", "bw_out_allowance_exceeded", "pps_allowance_exceeded", "conntrack_allowance_exceeded",
+}
+
+type NetworkInterfacePlugin struct {
+	Interface string
+	Ethtool   string
+}
+
+// % ethtool -S eth0
+// NIC statistics:
+// tx_timeout: 0
+// ...
+// bw_in_allowance_exceeded: 0
+// bw_out_allowance_exceeded: 12
+// pps_allowance_exceeded: 0
+// conntrack_allowance_exceeded: 0
+// ...
+// queue_0_tx_cnt: 123456
+func parseEthtool(r io.Reader) (map[string]float64, error) {
+	stats := make(map[string]float64)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			continue
+		}
+		stats[strings.TrimSpace(kv[0])] = v
+	}
+
+	return stats, scanner.Err()
+}
+
+// readCounter reads a counter of the interface in /sys/class/net/<interface>/statistics
+func readCounter(iface, name string) (float64, error) {
+	data, err := ioutil.ReadFile(filepath.Join(PathSysClassNet, iface, "statistics", name))
+	if err != nil {
+		return 0, err
+	}
+	return strconv.ParseFloat(strings.TrimSpace(string(data)), 64)
+}
+
+func (p NetworkInterfacePlugin) FetchMetrics() (map[string]float64, error) {
+	out, err := exec.Command(p.Ethtool, "-S", p.Interface).Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s -S %s: %s", p.Ethtool, p.Interface, err))
+	}
+	stats, err := parseEthtool(strings.NewReader(string(out)))
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	for _, name := range allowanceCounters {
+		if v, ok := stats[name]; ok {
+			stat[name] = v
+		}
+	}
+	if len(stat) == 0 {
+		// e.g. the interface is not of ENA, or the driver is too old
+		return nil, errors.New("no allowance counters in the statistics of " + p.Interface)
+	}
+
+	for name, file := range map[string]string{"PacketsDroppedIn": "rx_dropped", "PacketsDroppedOut": "tx_dropped"} {
+		v, err := readCounter(p.Interface, file)
+		if err == nil {
+			stat[name] = v
+		} else {
+			common.LogFetchError(name, err)
+		}
+	}
+
+	return stat, nil
+}
+
+func (p NetworkInterfacePlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optInterface := flag.String("interface", "eth0", "Network interface")
+	optEthtool := flag.String("ethtool", "ethtool", "Path of ethtool")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var eni NetworkInterfacePlugin
+	eni.Interface = *optInterface
+	eni.Ethtool = *optEthtool
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(eni))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-ec2-networkinterface", "interface")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
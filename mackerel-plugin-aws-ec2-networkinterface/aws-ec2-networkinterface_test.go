@@ -0,0 +1,30 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEthtool(t *testing.T) {
+	stub := `NIC statistics:
+     tx_timeout: 0
+     suspend: 0
+     bw_in_allowance_exceeded: 0
+     bw_out_allowance_exceeded: 12
+     pps_allowance_exceeded: 3
+     conntrack_allowance_exceeded: 0
+     linklocal_allowance_exceeded: 0
+     queue_0_tx_cnt: 123456
+`
+
+	stats, err := parseEthtool(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(stats), 8)
+	assert.Equal(t, stats["bw_out_allowance_exceeded"], 12.0)
+	assert.Equal(t, stats["pps_allowance_exceeded"], 3.0)
+	assert.Equal(t, stats["queue_0_tx_cnt"], 123456.0)
+	_, ok := stats["NIC statistics"]
+	assert.False(t, ok)
+}
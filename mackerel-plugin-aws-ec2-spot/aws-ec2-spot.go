@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/ec2"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const metadataURL = "http://169.254.169.254/latest"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"spot.interruption": mp.Graphs{
+		Label: "EC2 Spot Interruption",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "interruption_imminent", Label: "Imminent"},
+			mp.Metrics{Name: "rebalance_recommended", Label: "Rebalance Recommended"},
+		},
+	},
+	"spot.time_until_interruption": mp.Graphs{
+		Label: "EC2 Spot Time until Interruption (sec)",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "seconds_until_interruption", Label: "Seconds"},
+		},
+	},
+}
+
+var priceGraph mp.Graphs = mp.Graphs{
+	Label: "EC2 Spot Price",
+	Unit:  "float",
+	Metrics: [](mp.Metrics){
+		mp.Metrics{Name: "spot_price", Label: "Price (USD per Hour)"},
+	},
+}
+
+type SpotPlugin struct {
+	MetadataURL        string
+	Price              bool
+	Region             string
+	AccessKeyId        string
+	SecretAccessKey    string
+	SessionToken       string
+	InstanceType       string
+	AvailabilityZone   string
+	ProductDescription string
+	EC2                *ec2.EC2
+}
+
+// % curl http://169.254.169.254/latest/meta-data/spot/instance-action
+// {"action": "terminate", "time": "2017-09-18T08:22:00Z"}
+type instanceAction struct {
+	Action string    `json:"action"`
+	Time   time.Time `json:"time"`
+}
+
+// metadataToken returns a token of IMDSv2, or "" to fall back to IMDSv1
+func (p SpotPlugin) metadataToken() string {
+	req, err := http.NewRequest("PUT", p.MetadataURL+"/api/token", nil)
+	if err != nil {
+		return ""
+	}
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return ""
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return ""
+	}
+	token, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return ""
+	}
+	return string(token)
+}
+
+// getMetadata returns the value of the path, or nil if it doesn't exist (404),
+// as the notices are present only when the instance is going to be interrupted
+func (p SpotPlugin) getMetadata(token, path string) ([]byte, error) {
+	req, err := http.NewRequest("GET", p.MetadataURL+"/meta-data/"+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-aws-ec2-metadata-token", token)
+	}
+	client := &http.Client{Timeout: 2 * time.Second}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, nil
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("HTTP status error: %d", resp.StatusCode))
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// interruptionMetrics returns the metrics of the interruption notice (nil if not present)
+func interruptionMetrics(body []byte, now time.Time) (map[string]float64, error) {
+	stat := map[string]float64{"interruption_imminent": 0}
+	if body == nil {
+		return stat, nil
+	}
+
+	var action instanceAction
+	if err := json.Unmarshal(body, &action); err != nil {
+		return nil, err
+	}
+	stat["interruption_imminent"] = 1
+	seconds := action.Time.Sub(now).Seconds()
+	if seconds < 0 {
+		seconds = 0
+	}
+	stat["seconds_until_interruption"] = seconds
+	return stat, nil
+}
+
+func (p *SpotPlugin) Prepare() error {
+	if !p.Price {
+		return nil
+	}
+
+	token := p.metadataToken()
+	if p.InstanceType == "" {
+		v, err := p.getMetadata(token, "instance-type")
+		if err != nil || v == nil {
+			return errors.New("cannot get the instance type. specify -instance-type")
+		}
+		p.InstanceType = string(v)
+	}
+	if p.AvailabilityZone == "" {
+		v, err := p.getMetadata(token, "placement/availability-zone")
+		if err != nil || v == nil {
+			return errors.New("cannot get the availability zone. specify -availability-zone")
+		}
+		p.AvailabilityZone = string(v)
+	}
+
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+	p.EC2 = ec2.New(auth, aws.Regions[p.Region])
+	return nil
+}
+
+// latestPrice returns the newest spot price in the history
+func latestPrice(history []ec2.SpotPriceHistory) (float64, error) {
+	if len(history) == 0 {
+		return 0, errors.New("fetched no spot prices")
+	}
+	latest := history[0]
+	for _, h := range history[1:] {
+		if h.Timestamp.After(latest.Timestamp) {
+			latest = h
+		}
+	}
+	return strconv.ParseFloat(latest.SpotPrice, 64)
+}
+
+func (p SpotPlugin) fetchPrice() (float64, error) {
+	now := time.Now()
+	resp, err := p.EC2.DescribeSpotPriceHistory(&ec2.DescribeSpotPriceHistory{
+		InstanceType:       []string{p.InstanceType},
+		ProductDescription: []string{p.ProductDescription},
+		AvailabilityZone:   p.AvailabilityZone,
+		StartTime:          now.Add(-time.Hour),
+		EndTime:            now,
+	})
+	if err != nil {
+		return 0, err
+	}
+	return latestPrice(resp.History)
+}
+
+func (p SpotPlugin) FetchMetrics() (map[string]float64, error) {
+	token := p.metadataToken()
+
+	body, err := p.getMetadata(token, "spot/instance-action")
+	if err != nil {
+		return nil, err
+	}
+	stat, err := interruptionMetrics(body, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	body, err = p.getMetadata(token, "events/recommendations/rebalance")
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		stat["rebalance_recommended"] = 1
+	} else {
+		stat["rebalance_recommended"] = 0
+	}
+
+	if p.Price {
+		v, err := p.fetchPrice()
+		if err != nil {
+			common.LogFetchError("spot_price", err)
+		} else {
+			stat["spot_price"] = v
+		}
+	}
+
+	return stat, nil
+}
+
+func (p SpotPlugin) GraphDefinition() map[string](mp.Graphs) {
+	if !p.Price {
+		return graphdef
+	}
+
+	graphs := map[string](mp.Graphs){"spot.price": priceGraph}
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optPrice := flag.Bool("price", false, "Fetch the spot price of the instance type by EC2 API")
+	optInstanceType := flag.String("instance-type", "", "Instance type to fetch the spot price of (default: the one of the running instance)")
+	optAvailabilityZone := flag.String("availability-zone", "", "Availability zone to fetch the spot price in (default: the one of the running instance)")
+	optProductDescription := flag.String("product-description", "Linux/UNIX", "Product description to fetch the spot price of")
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var spot SpotPlugin
+
+	spot.MetadataURL = metadataURL
+	spot.Price = *optPrice
+	spot.InstanceType = *optInstanceType
+	spot.AvailabilityZone = *optAvailabilityZone
+	spot.ProductDescription = *optProductDescription
+
+	if spot.Price {
+		region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		spot.Region = region
+	}
+
+	spot.AccessKeyId = *optAccessKeyId
+	spot.SecretAccessKey = *optSecretAccessKey
+	spot.SessionToken = common.AWSSessionToken(*optSessionToken)
+
+	err := spot.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(spot))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-aws-ec2-spot", "region", "instance-type", "availability-zone", "product-description")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/crowdmob/goamz/ec2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestInterruptionMetrics(t *testing.T) {
+	now := time.Date(2017, 9, 18, 8, 20, 0, 0, time.UTC)
+
+	stat, err := interruptionMetrics(nil, now)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["interruption_imminent"], 0.0)
+	_, ok := stat["seconds_until_interruption"]
+	assert.False(t, ok)
+
+	stat, err = interruptionMetrics([]byte(`{"action": "terminate", "time": "2017-09-18T08:22:00Z"}`), now)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["interruption_imminent"], 1.0)
+	assert.Equal(t, stat["seconds_until_interruption"], 120.0)
+
+	// already passed
+	stat, err = interruptionMetrics([]byte(`{"action": "stop", "time": "2017-09-18T08:19:00Z"}`), now)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["seconds_until_interruption"], 0.0)
+}
+
+func TestLatestPrice(t *testing.T) {
+	v, err := latestPrice([]ec2.SpotPriceHistory{
+		ec2.SpotPriceHistory{SpotPrice: "0.031200", Timestamp: time.Date(2017, 9, 18, 7, 0, 0, 0, time.UTC)},
+		ec2.SpotPriceHistory{SpotPrice: "0.032500", Timestamp: time.Date(2017, 9, 18, 8, 0, 0, 0, time.UTC)},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, v, 0.0325)
+
+	_, err = latestPrice(nil)
+	assert.NotNil(t, err)
+}
+
+func TestFetchMetrics(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/api/token":
+			w.Write([]byte("token"))
+		case "/meta-data/spot/instance-action":
+			if r.Header.Get("X-aws-ec2-metadata-token") != "token" {
+				w.WriteHeader(http.StatusUnauthorized)
+				return
+			}
+			w.Write([]byte(`{"action": "terminate", "time": "2017-09-18T08:22:00Z"}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer ts.Close()
+
+	stat, err := SpotPlugin{MetadataURL: ts.URL}.FetchMetrics()
+	assert.Nil(t, err)
+	assert.Equal(t, stat["interruption_imminent"], 1.0)
+	assert.Equal(t, stat["rebalance_recommended"], 0.0)
+}
@@ -3,14 +3,28 @@ package main
 import (
 	"errors"
 	"flag"
+	sdkaws "github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	sdkcloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
 	"github.com/crowdmob/goamz/aws"
 	"github.com/crowdmob/goamz/cloudwatch"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"log"
+	"math"
 	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
 	"time"
 )
 
+// errNoDatapoints is returned when CloudWatch has no datapoints of a metric, which is usual for ELB without traffic,
+// unlike the errors of the API
+var errNoDatapoints = errors.New("fetched no datapoints")
+
 var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
 	"elb.latency": mp.Graphs{
 		Label: "Whole ELB Latency",
@@ -19,6 +33,20 @@ var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
 			mp.Metrics{Name: "Latency", Label: "Latency"},
 		},
 	},
+	"elb.requests": mp.Graphs{
+		Label: "Whole ELB Requests",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "RequestCount", Label: "Requests"},
+		},
+	},
+	"elb.requests_per_connection": mp.Graphs{
+		Label: "Whole ELB Requests per New Connection",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "RequestsPerConnection", Label: "Requests per Connection"},
+		},
+	},
 	"elb.http_backend": mp.Graphs{
 		Label: "Whole ELB HTTP Backend Count",
 		Unit:  "integer",
@@ -29,8 +57,139 @@ var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
 			mp.Metrics{Name: "HTTPCode_Backend_5XX", Label: "5XX", Stacked: true},
 		},
 	},
+	"elb.surge_queue_length": mp.Graphs{
+		Label: "Whole ELB Surge Queue Length",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "SurgeQueueLength", Label: "Max Length"},
+		},
+	},
+	"elb.surge_saturated": mp.Graphs{
+		Label: "Whole ELB Surge Queue Saturated",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "SurgeSaturated", Label: "Saturated"},
+		},
+	},
+	"elb.client_vs_server_errors": mp.Graphs{
+		Label: "Whole ELB Client vs Server Errors",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "ClientErrorRatio", Label: "Client Errors (4XX)"},
+			mp.Metrics{Name: "ServerErrorRatio", Label: "Server Errors (5XX)"},
+		},
+	},
+	"elb.success_rate": mp.Graphs{
+		Label: "Whole ELB Backend Success Rate",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "SuccessRate", Label: "2XX"},
+		},
+	},
+	"elb.traffic_ramp": mp.Graphs{
+		Label: "Whole ELB Traffic Ramp",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "TrafficRamp", Label: "Requests / Previous Requests"},
+		},
+	},
+	"elb.rps_per_host": mp.Graphs{
+		Label: "Whole ELB Requests per Second per Healthy Host",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "RequestsPerHost", Label: "Requests per Host"},
+		},
+	},
+	// the surge queue filling up and the resulting spillover in one view.
+	// both are counts (length and requests per 1 min), so they share the integer axis
+	"elb.capacity_pressure": mp.Graphs{
+		Label: "Whole ELB Capacity Pressure",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "SurgeQueueLength", Label: "Surge Queue Max Length"},
+			mp.Metrics{Name: "SpilloverCount", Label: "Spillover"},
+		},
+	},
+	"elb.dropped_requests": mp.Graphs{
+		Label: "Whole ELB Dropped Requests",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "DroppedRequests", Label: "Dropped per 1 min"},
+			mp.Metrics{Name: "DroppedRequestsPerSecond", Label: "Dropped per Second"},
+		},
+	},
+	"elb.dropped_percentage": mp.Graphs{
+		Label: "Whole ELB Dropped Requests Percentage",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "DroppedPercentage", Label: "Dropped"},
+		},
+	},
+	"elb.5xx_acceleration": mp.Graphs{
+		Label: "Whole ELB Backend 5XX Acceleration",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "HTTPCode_Backend_5XX_Acceleration", Label: "Change of 5XX per 1 min"},
+		},
+	},
+	"elb.data_lag": mp.Graphs{
+		Label: "Whole ELB Data Lag (sec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "DataLag", Label: "Age of Newest Datapoint"},
+		},
+	},
+	"elb.all_hosts_down": mp.Graphs{
+		Label: "Whole ELB All Hosts Down",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "AllHostsDown", Label: "All Hosts Down"},
+		},
+	},
+	"elb.throughput": mp.Graphs{
+		Label: "Whole ELB Throughput",
+		Unit:  "bytes/sec",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "EstimatedProcessedBytes", Label: "Estimated Processed"},
+		},
+	},
+	"elb.hosts_deregistering": mp.Graphs{
+		Label: "Whole ELB Hosts Deregistering",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "HostsDeregistering", Label: "Deregistering"},
+		},
+	},
+	"elb.xzone_imbalance": mp.Graphs{
+		Label: "ELB Cross-Zone Request Imbalance",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "XZoneImbalance", Label: "Max Deviation from Even Share"},
+		},
+	},
+	"elb.az_skew": mp.Graphs{
+		Label: "ELB Healthy Host Skew across AZs",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "AZSkew", Label: "Coefficient of Variation"},
+		},
+	},
+
+	// the graphs of azMetrics will be generated dynamically
+}
 
-	// "elb.healthy_host_count", "elb.unhealthy_host_count" will be generated dynamically
+// metrics per AZ, which are drawn in a graph for each metric, or for each AZ with -group-by-dimension
+var azMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "HealthyHostCount_", Label: "Healthy", Unit: "integer", Stacked: true,
+		Graph: "healthy_host_count", GraphLabel: "ELB Healthy Host Count", Group: "host_count", GroupLabel: "ELB Host Count"},
+	common.DimensionMetric{Prefix: "UnHealthyHostCount_", Label: "Unhealthy", Unit: "integer", Stacked: true,
+		Graph: "unhealthy_host_count", GraphLabel: "ELB Unhealthy Host Count", Group: "host_count", GroupLabel: "ELB Host Count"},
+	common.DimensionMetric{Prefix: "Latency_", Label: "Latency", Unit: "float",
+		Graph: "latency_per_az", GraphLabel: "ELB Latency per AZ", Group: "latency", GroupLabel: "ELB Latency"},
+	common.DimensionMetric{Prefix: "HealthyPercentage_", Label: "Healthy", Unit: "percentage",
+		Graph: "healthy_percentage", GraphLabel: "ELB Healthy Host Percentage", Group: "healthy_percentage", GroupLabel: "ELB Healthy Host Percentage"},
+	common.DimensionMetric{Prefix: "UnhealthyExceeded_", Label: "Unhealthy Exceeded", Unit: "integer",
+		Graph: "unhealthy_exceeded", GraphLabel: "ELB Unhealthy Hosts over Threshold", Group: "unhealthy_exceeded", GroupLabel: "ELB Unhealthy Hosts over Threshold"},
 }
 
 type StatType int
@@ -38,6 +197,8 @@ type StatType int
 const (
 	Average StatType = iota
 	Sum
+	Minimum
+	Maximum
 )
 
 func (s StatType) String() string {
@@ -46,20 +207,61 @@ func (s StatType) String() string {
 		return "Average"
 	case Sum:
 		return "Sum"
+	case Minimum:
+		return "Minimum"
+	case Maximum:
+		return "Maximum"
 	}
 	return ""
 }
 
 type ELBPlugin struct {
-	Region          string
-	AccessKeyId     string
-	SecretAccessKey string
-	AZs             []string
-	CloudWatch      *cloudwatch.CloudWatch
+	Region             string
+	AccessKeyId        string
+	SecretAccessKey    string
+	SessionToken       string
+	AZs                []string
+	ALB                string
+	TargetGroups       []string
+	Smooth             int
+	Period             int
+	Statistics         map[string]StatType
+	SurgeCap           float64
+	UnhealthyThreshold float64
+	Concurrency        int
+	GroupByDimension   bool
+	MaxDatapointAge    int
+	SkipIdleSuccess    bool
+	Percentiles        []string
+	Tempfile           string
+	CloudWatch         *cloudwatch.CloudWatch
+	// extended statistics (percentiles) are not supported by goamz
+	ExtendedCloudWatch *sdkcloudwatch.CloudWatch
+	newest             *newestTimestamp
+}
+
+// newestTimestamp keeps the newest timestamp of the datapoints fetched in a run,
+// which are fetched concurrently
+type newestTimestamp struct {
+	sync.Mutex
+	t time.Time
+}
+
+func (n *newestTimestamp) update(datapoints []cloudwatch.Datapoint) {
+	if n == nil {
+		return
+	}
+	n.Lock()
+	defer n.Unlock()
+	for _, dp := range datapoints {
+		if dp.Timestamp.After(n.t) {
+			n.t = dp.Timestamp
+		}
+	}
 }
 
 func (p *ELBPlugin) Prepare() error {
-	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, "", time.Now())
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
 	if err != nil {
 		return err
 	}
@@ -69,6 +271,18 @@ func (p *ELBPlugin) Prepare() error {
 		return err
 	}
 
+	if len(p.Percentiles) > 0 {
+		sess, err := session.NewSession()
+		if err != nil {
+			return err
+		}
+		config := sdkaws.NewConfig().WithRegion(p.Region)
+		if p.AccessKeyId != "" && p.SecretAccessKey != "" {
+			config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyId, p.SecretAccessKey, p.SessionToken))
+		}
+		p.ExtendedCloudWatch = sdkcloudwatch.New(sess, config)
+	}
+
 	ret, err := p.CloudWatch.ListMetrics(&cloudwatch.ListMetricsRequest{
 		Namespace: "AWS/ELB",
 		Dimensions: []cloudwatch.Dimension{
@@ -94,64 +308,476 @@ func (p *ELBPlugin) Prepare() error {
 		p.AZs = append(p.AZs, met.Dimensions[0].Value)
 	}
 
+	if p.ALB == "" {
+		return nil
+	}
+
+	// target groups of the ALB, whose TargetResponseTime is reported per target group (and per AZ)
+	ret, err = p.CloudWatch.ListMetrics(&cloudwatch.ListMetricsRequest{
+		Namespace: "AWS/ApplicationELB",
+		Dimensions: []cloudwatch.Dimension{
+			cloudwatch.Dimension{
+				Name:  "LoadBalancer",
+				Value: p.ALB,
+			},
+		},
+		MetricName: "TargetResponseTime",
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, met := range ret.ListMetricsResult.Metrics {
+		if len(met.Dimensions) != 2 {
+			continue
+		}
+		for _, d := range met.Dimensions {
+			if d.Name == "TargetGroup" {
+				p.TargetGroups = append(p.TargetGroups, d.Value)
+			}
+		}
+	}
+
 	return nil
 }
 
+// fetchTargetResponseTime returns the average of TargetResponseTime of the target groups weighted by their requests
+func (p ELBPlugin) fetchTargetResponseTime() (float64, bool) {
+	type tgQuery struct {
+		targetGroup string
+		metricName  string
+		statType    StatType
+	}
+	var queries []tgQuery
+	for _, tg := range p.TargetGroups {
+		queries = append(queries, tgQuery{tg, "TargetResponseTime", Average}, tgQuery{tg, "RequestCount", Sum})
+	}
+
+	values := make([]float64, len(queries))
+	fetched := make([]bool, len(queries))
+	common.FetchMany(len(queries), p.Concurrency, func(i int) {
+		q := queries[i]
+		v, err := p.getLastPoint("AWS/ApplicationELB", []cloudwatch.Dimension{
+			cloudwatch.Dimension{Name: "LoadBalancer", Value: p.ALB},
+			cloudwatch.Dimension{Name: "TargetGroup", Value: q.targetGroup},
+		}, q.metricName, q.statType)
+		if err == nil {
+			values[i] = v
+			fetched[i] = true
+		}
+	})
+
+	var times, requests []float64
+	for i := 0; i < len(queries); i += 2 {
+		if fetched[i] && fetched[i+1] {
+			times = append(times, values[i])
+			requests = append(requests, values[i+1])
+		}
+	}
+	return weightedAverage(times, requests)
+}
+
+// albConnectionMetrics are the connection metrics of the ALB, which are Sums per 1 min
+var albConnectionMetrics = []string{"ActiveConnectionCount", "NewConnectionCount", "ClientTLSNegotiationErrorCount"}
+
+// fetchALBConnections returns the connection metrics of the ALB which have been fetched
+func (p ELBPlugin) fetchALBConnections() map[string]float64 {
+	values := make([]float64, len(albConnectionMetrics))
+	fetched := make([]bool, len(albConnectionMetrics))
+	common.FetchMany(len(albConnectionMetrics), p.Concurrency, func(i int) {
+		v, err := p.getLastPoint("AWS/ApplicationELB", []cloudwatch.Dimension{
+			cloudwatch.Dimension{Name: "LoadBalancer", Value: p.ALB},
+		}, albConnectionMetrics[i], Sum)
+		if err == nil {
+			values[i] = v
+			fetched[i] = true
+		}
+	})
+
+	stat := make(map[string]float64)
+	for i, met := range albConnectionMetrics {
+		if fetched[i] {
+			stat[met] = values[i]
+		}
+	}
+	return stat
+}
+
+// connectionLifetime estimates the average duration (sec) of the connections by Little's law,
+// the concurrent connections divided by the new connections per second (from the ones per 1 min).
+// It is not defined (false) when there were no new connections.
+func connectionLifetime(active, newConns float64) (float64, bool) {
+	if newConns <= 0 {
+		return 0, false
+	}
+	return active / (newConns / 60), true
+}
+
+// percentilePattern matches the percentiles of the extended statistics, p0 to p100 with up to 2 decimals
+var percentilePattern = regexp.MustCompile(`^p(100|\d{1,2}(\.\d{1,2})?)$`)
+
+// maxPercentiles is the maximum number of the extended statistics in a GetMetricStatistics call
+const maxPercentiles = 10
+
+// parsePercentiles parses the percentiles separated by commas, e.g. "p50,p90,p95,p99"
+func parsePercentiles(s string) ([]string, error) {
+	var percentiles []string
+	seen := make(map[string]bool)
+	for _, pc := range strings.Split(s, ",") {
+		pc = strings.TrimSpace(pc)
+		if pc == "" {
+			continue
+		}
+		if !percentilePattern.MatchString(pc) {
+			return nil, errors.New("invalid percentile (e.g. p99 or p99.9): " + pc)
+		}
+		if seen[pc] {
+			continue
+		}
+		seen[pc] = true
+		percentiles = append(percentiles, pc)
+	}
+	if len(percentiles) > maxPercentiles {
+		return nil, errors.New("too many percentiles (up to 10): " + s)
+	}
+	return percentiles, nil
+}
+
+// percentileMetricName returns the metric name of the percentile of Latency, e.g. Latency_p99_9 for p99.9
+func percentileMetricName(percentile string) string {
+	return "Latency_" + strings.Replace(percentile, ".", "_", -1)
+}
+
+// fetchLatencyPercentiles returns the percentiles of the whole Latency, in a single call of the API
+func (p ELBPlugin) fetchLatencyPercentiles() map[string]float64 {
+	now := time.Now()
+	period := p.Period
+	if period < 1 {
+		period = 60
+	}
+
+	stats := make([]*string, 0, len(p.Percentiles))
+	for _, pc := range p.Percentiles {
+		stats = append(stats, sdkaws.String(pc))
+	}
+	response, err := p.ExtendedCloudWatch.GetMetricStatistics(&sdkcloudwatch.GetMetricStatisticsInput{
+		Dimensions: []*sdkcloudwatch.Dimension{
+			&sdkcloudwatch.Dimension{Name: sdkaws.String("Service"), Value: sdkaws.String("ELB")},
+		},
+		StartTime:          sdkaws.Time(now.Add(time.Duration(period*2) * time.Second * -1)),
+		EndTime:            sdkaws.Time(now),
+		MetricName:         sdkaws.String("Latency"),
+		Period:             sdkaws.Int64(int64(period)),
+		ExtendedStatistics: stats,
+		Namespace:          sdkaws.String("AWS/ELB"),
+	})
+	if err != nil {
+		common.LogFetchError("Latency percentiles", err)
+		return nil
+	}
+	return newestPercentiles(response.Datapoints, p.Percentiles, now, p.MaxDatapointAge)
+}
+
+// newestPercentiles returns the percentiles of the newest datapoint, which are not averaged with the older ones
+// as percentiles cannot be. The percentiles CloudWatch returns no value for (e.g. without requests) are skipped.
+func newestPercentiles(datapoints []*sdkcloudwatch.Datapoint, percentiles []string, now time.Time, maxAge int) map[string]float64 {
+	var newest *sdkcloudwatch.Datapoint
+	for _, dp := range datapoints {
+		if dp == nil || dp.Timestamp == nil {
+			continue
+		}
+		if newest == nil || dp.Timestamp.After(*newest.Timestamp) {
+			newest = dp
+		}
+	}
+	stat := make(map[string]float64)
+	if newest == nil {
+		return stat
+	}
+	if maxAge > 0 && now.Sub(*newest.Timestamp) > time.Duration(maxAge)*time.Second {
+		return stat
+	}
+	for _, pc := range percentiles {
+		if v, ok := newest.ExtendedStatistics[pc]; ok && v != nil {
+			stat[percentileMetricName(pc)] = *v
+		}
+	}
+	return stat
+}
+
+// weightedAverage returns the average of values weighted by weights.
+// It is not defined (false) when the weights sum up to 0.
+func weightedAverage(values, weights []float64) (float64, bool) {
+	var sum, total float64
+	for i, v := range values {
+		sum += v * weights[i]
+		total += weights[i]
+	}
+	if total <= 0 {
+		return 0, false
+	}
+	return sum / total, true
+}
+
 func (p ELBPlugin) GetLastPoint(dimension *cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	return p.getLastPoint("AWS/ELB", []cloudwatch.Dimension{*dimension}, metricName, statType)
+}
+
+func (p ELBPlugin) getLastPoint(namespace string, dimensions []cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
 	now := time.Now()
 
+	n := p.Smooth
+	if n < 1 {
+		n = 1
+	}
+	period := p.Period
+	if period < 1 {
+		period = 60
+	}
+
 	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
-		Dimensions: []cloudwatch.Dimension{*dimension},
-		StartTime:  now.Add(time.Duration(120) * time.Second * -1), // 2 min (to fetch at least 1 data-point)
+		Dimensions: dimensions,
+		StartTime:  now.Add(time.Duration(period*(n+1)) * time.Second * -1), // n+1 periods (to fetch at least n data-points)
 		EndTime:    now,
 		MetricName: metricName,
-		Period:     60,
+		Period:     period,
 		Statistics: []string{statType.String()},
-		Namespace:  "AWS/ELB",
+		Namespace:  namespace,
 	})
 	if err != nil {
+		// e.g. throttled. no datapoints (no traffic) is not counted, as it is usual for ELB
+		common.LogFetchError(metricName, err)
 		return 0, err
 	}
 
 	datapoints := response.GetMetricStatisticsResult.Datapoints
 	if len(datapoints) == 0 {
-		return 0, errors.New("fetched no datapoints")
+		return 0, errNoDatapoints
+	}
+	// stale ones count as well, to show how far behind they are
+	p.newest.update(datapoints)
+	if err := checkDatapointAge(datapoints, now, p.MaxDatapointAge); err != nil {
+		return 0, err
 	}
 
-	latest := time.Unix(0, 0)
-	var latestVal float64
-	for _, dp := range datapoints {
-		if dp.Timestamp.Before(latest) {
+	v := smoothDatapoints(datapoints, statType, n)
+	// Sums are kept per 1 min with coarser periods, as the derived metrics expect
+	if statType == Sum {
+		v = v * 60 / float64(period)
+	}
+	return v, nil
+}
+
+// retention of the datapoints of each period in CloudWatch. the datapoints are aggregated
+// into coarser periods as they get older, and finer ones are no longer returned
+var periodRetentions = []struct {
+	period    int
+	retention time.Duration
+}{
+	{60, 3 * time.Hour}, // less than 60 sec (high resolution)
+	{300, 15 * 24 * time.Hour},
+	{3600, 63 * 24 * time.Hour},
+	{0, 455 * 24 * time.Hour},
+}
+
+// granularityPeriod returns the finest period not shorter than period
+// whose datapoints are still retained for the lookback of n+1 of the given period.
+// It is the period itself unless the StartTime would be out of its retention
+func granularityPeriod(period, n int) int {
+	if period < 1 {
+		period = 60
+	}
+	if n < 1 {
+		n = 1
+	}
+	lookback := time.Duration(period*(n+1)) * time.Second
+	for _, r := range periodRetentions {
+		if r.period != 0 && period >= r.period {
 			continue
 		}
+		if lookback <= r.retention || r.period == 0 {
+			return period
+		}
+		period = r.period
+	}
+	return period
+}
 
-		latest = dp.Timestamp
+// checkDatapointAge returns an error when the newest datapoint is older than maxAge seconds,
+// so that the last value of a metric no longer published is not reported forever. 0 disables the check
+func checkDatapointAge(datapoints []cloudwatch.Datapoint, now time.Time, maxAge int) error {
+	if maxAge <= 0 {
+		return nil
+	}
+	newest := datapoints[0].Timestamp
+	for _, dp := range datapoints[1:] {
+		if dp.Timestamp.After(newest) {
+			newest = dp.Timestamp
+		}
+	}
+	if age := now.Sub(newest); age > time.Duration(maxAge)*time.Second {
+		return errors.New("the newest datapoint is stale: " + age.String() + " old")
+	}
+	return nil
+}
+
+type byTimestampDesc []cloudwatch.Datapoint
+
+func (d byTimestampDesc) Len() int           { return len(d) }
+func (d byTimestampDesc) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byTimestampDesc) Less(i, j int) bool { return d[i].Timestamp.After(d[j].Timestamp) }
+
+// smoothDatapoints averages the newest n datapoints.
+// Sums are averaged as well, so that they are still the values per 1 min period,
+// and Minimums (Maximums) are the lowest (highest) ones of them.
+func smoothDatapoints(datapoints []cloudwatch.Datapoint, statType StatType, n int) float64 {
+	sorted := make([]cloudwatch.Datapoint, len(datapoints))
+	copy(sorted, datapoints)
+	sort.Sort(byTimestampDesc(sorted))
+	if len(sorted) > n {
+		sorted = sorted[:n]
+	}
+
+	switch statType {
+	case Minimum:
+		min := sorted[0].Minimum
+		for _, dp := range sorted[1:] {
+			min = math.Min(min, dp.Minimum)
+		}
+		return min
+	case Maximum:
+		max := sorted[0].Maximum
+		for _, dp := range sorted[1:] {
+			max = math.Max(max, dp.Maximum)
+		}
+		return max
+	}
+
+	var total float64
+	for _, dp := range sorted {
 		switch statType {
 		case Average:
-			latestVal = dp.Average
+			total += dp.Average
 		case Sum:
-			latestVal = dp.Sum
+			total += dp.Sum
 		}
 	}
 
-	return latestVal, nil
+	return total / float64(len(sorted))
+}
+
+// statTypeOf returns the statistic to fetch metricName with
+func (p ELBPlugin) statTypeOf(metricName string, defaultType StatType) StatType {
+	if statType, ok := p.Statistics[metricName]; ok {
+		return statType
+	}
+	return defaultType
 }
 
 func (p ELBPlugin) FetchMetrics() (map[string]float64, error) {
 	stat := make(map[string]float64)
+	p.newest = &newestTimestamp{}
 
-	// HostCount per AZ
+	// HostCount, Latency and RequestCount per AZ
+	type azQuery struct {
+		az         string
+		metricName string
+		statType   StatType
+	}
+	var queries []azQuery
 	for _, az := range p.AZs {
+		for _, met := range []string{"HealthyHostCount", "UnHealthyHostCount"} {
+			queries = append(queries, azQuery{az, met, p.statTypeOf(met, Average)})
+		}
+		queries = append(queries, azQuery{az, "Latency", Average})
+		queries = append(queries, azQuery{az, "RequestCount", Sum})
+	}
+
+	values := make([]float64, len(queries))
+	errs := make([]error, len(queries))
+	common.FetchMany(len(queries), p.Concurrency, func(i int) {
+		q := queries[i]
 		d := &cloudwatch.Dimension{
 			Name:  "AvailabilityZone",
-			Value: az,
+			Value: q.az,
+		}
+		values[i], errs[i] = p.GetLastPoint(d, q.metricName, q.statType)
+	})
+	healthyNoData := len(p.AZs) > 0
+	var azRequests []float64
+	var azRequestErrs []error
+	for i, q := range queries {
+		if errs[i] == nil {
+			stat[q.metricName+"_"+q.az] = values[i]
 		}
+		if q.metricName == "HealthyHostCount" && errs[i] != errNoDatapoints {
+			healthyNoData = false
+		}
+		if q.metricName == "RequestCount" {
+			azRequests = append(azRequests, values[i])
+			azRequestErrs = append(azRequestErrs, errs[i])
+		}
+	}
 
-		for _, met := range []string{"HealthyHostCount", "UnHealthyHostCount"} {
-			v, err := p.GetLastPoint(d, met, Average)
-			if err == nil {
-				stat[met+"_"+az] = v
-			}
+	// a single rule ("> 0") alerts on any AZ, whatever the threshold is
+	for _, az := range p.AZs {
+		if v, ok := stat["UnHealthyHostCount_"+az]; ok {
+			stat["UnhealthyExceeded_"+az] = unhealthyExceeded(v, p.UnhealthyThreshold)
+		}
+	}
+
+	// uneven distribution causes hot spots, which cannot be seen in the whole metrics
+	healthy := make([]float64, 0, len(p.AZs))
+	for _, az := range p.AZs {
+		if v, ok := stat["HealthyHostCount_"+az]; ok {
+			healthy = append(healthy, v)
+		}
+	}
+	if len(p.AZs) > 0 {
+		stat["AZSkew"] = coefficientOfVariation(healthy)
+	}
+
+	// one AZ taking a disproportionate share of the requests, e.g. with cross-zone load balancing disabled
+	if requests, ok := requestsPerAZ(azRequests, azRequestErrs); ok {
+		if v, ok := xzoneImbalance(requests); ok {
+			stat["XZoneImbalance"] = v
+		}
+	}
+
+	// a full outage, which should not be mistaken for a gap of the metrics
+	var unhealthy float64
+	for _, az := range p.AZs {
+		unhealthy += stat["UnHealthyHostCount_"+az]
+	}
+	if v, ok := allHostsDown(healthy, healthyNoData, unhealthy); ok {
+		stat["AllHostsDown"] = v
+	}
+
+	// the ratio can be alerted on regardless of the fleet size
+	var healthyTotal, unhealthyTotal float64
+	var counted bool
+	for _, az := range p.AZs {
+		h, ok := stat["HealthyHostCount_"+az]
+		u, ok2 := stat["UnHealthyHostCount_"+az]
+		if !ok || !ok2 {
+			continue
+		}
+		if v, ok := healthyPercentage(h, u); ok {
+			stat["HealthyPercentage_"+az] = v
+		}
+		healthyTotal += h
+		unhealthyTotal += u
+		counted = true
+	}
+	if counted {
+		if v, ok := healthyPercentage(healthyTotal, unhealthyTotal); ok {
+			stat["HealthyPercentage"] = v
+		}
+
+		// hosts leaving with connection draining drop from the healthy ones without turning unhealthy,
+		// which explains the latency blips of deploys
+		if v, ok := hostsDeregistering(healthyTotal, unhealthyTotal, p.AZs, common.LastValues(p.Tempfile)); ok {
+			stat["HostsDeregistering"] = v
 		}
 	}
 
@@ -165,76 +791,480 @@ func (p ELBPlugin) FetchMetrics() (map[string]float64, error) {
 		stat["Latency"] = v
 	}
 
-	for _, met := range [...]string{"HTTPCode_Backend_2XX", "HTTPCode_Backend_3XX", "HTTPCode_Backend_4XX", "HTTPCode_Backend_5XX"} {
+	// the average hides the slow tail of the requests
+	if len(p.Percentiles) > 0 {
+		for k, v := range p.fetchLatencyPercentiles() {
+			stat[k] = v
+		}
+	}
+
+	// the time in the backends, to tell from the time in the load balancer
+	if p.ALB != "" {
+		if v, ok := p.fetchTargetResponseTime(); ok {
+			stat["TargetResponseTime"] = v
+		}
+
+		// connections kept long (e.g. idle ones hoarded by clients) hold the backend slots
+		for k, v := range p.fetchALBConnections() {
+			stat[k] = v
+		}
+		active, ok := stat["ActiveConnectionCount"]
+		if newConns, ok2 := stat["NewConnectionCount"]; ok && ok2 {
+			if v, ok := connectionLifetime(active, newConns); ok {
+				stat["ConnectionLifetime"] = v
+			}
+		}
+	}
+
+	// requests are rejected (spillover) once the surge queue is full
+	v, err = p.GetLastPoint(glb, "SurgeQueueLength", Maximum)
+	if err == nil {
+		stat["SurgeQueueLength"] = v
+		stat["SurgeSaturated"] = surgeSaturated(v, p.SurgeCap)
+	}
+
+	for _, met := range [...]string{
+		"HTTPCode_Backend_2XX", "HTTPCode_Backend_3XX", "HTTPCode_Backend_4XX", "HTTPCode_Backend_5XX",
+		"HTTPCode_ELB_5XX", "RequestCount", "EstimatedALBNewConnectionCount", "SpilloverCount",
+	} {
 		v, err := p.GetLastPoint(glb, met, Sum)
 		if err == nil {
 			stat[met] = v
 		}
 	}
 
+	// the throughput, which CloudWatch doesn't publish for all the ELBs. it is skipped without datapoints
+	if v, err := p.GetLastPoint(glb, "EstimatedProcessedBytes", Sum); err == nil {
+		stat["EstimatedProcessedBytes"] = v / 60
+	}
+
+	// requests rejected by the full surge queue never reach RequestCount,
+	// so the traffic lost during a capacity incident is estimated from them
+	if spill, ok := stat["SpilloverCount"]; ok {
+		stat["DroppedRequests"] = spill
+		stat["DroppedRequestsPerSecond"] = spill / 60
+		if v, ok := droppedPercentage(stat["RequestCount"], spill); ok {
+			stat["DroppedPercentage"] = v
+		}
+	}
+
+	// a sudden ramp of traffic often comes with latency of an ELB not pre-warmed
+	if req, ok := stat["RequestCount"]; ok {
+		stat["TrafficRamp"] = trafficRamp(req, common.LastValues(p.Tempfile))
+	}
+
+	// a sudden onset of errors is caught before the count itself crosses a threshold
+	if _, ok := stat["RequestCount"]; ok {
+		if v, ok := errorAcceleration(stat["HTTPCode_Backend_5XX"], common.LastValues(p.Tempfile), time.Now()); ok {
+			stat["HTTPCode_Backend_5XX_Acceleration"] = v
+		}
+	}
+
+	// the load of each backend instance, which capacity planning needs
+	if req, ok := stat["RequestCount"]; ok && counted {
+		if v, ok := requestsPerHost(req, healthyTotal); ok {
+			stat["RequestsPerHost"] = v
+		}
+	}
+
+	// low values mean that clients or backends don't reuse connections (keep-alive)
+	conns, ok := stat["EstimatedALBNewConnectionCount"]
+	if req, ok2 := stat["RequestCount"]; ok && ok2 && conns > 0 {
+		stat["RequestsPerConnection"] = req / conns
+	}
+
+	// 4XX caused by bad client requests should not be confused with backend failures
+	stat["ClientErrorRatio"], stat["ServerErrorRatio"] = errorRatios(stat)
+
+	// the availability, which SLOs and error budgets are defined on
+	if v, ok := successRate(stat, p.SkipIdleSuccess); ok {
+		stat["SuccessRate"] = v
+	}
+
+	// how far behind CloudWatch is, which keeps climbing once the ELB stops publishing
+	if v, ok := dataLag(p.newest.t, common.LastValues(p.Tempfile), time.Now()); ok {
+		stat["DataLag"] = v
+	}
+
 	return stat, nil
 }
 
+// dataLag returns the age (sec) of the newest datapoint fetched in the run.
+// Without any datapoints, it is the one of the last run (kept in the tempfile) plus the time elapsed,
+// and it is not defined (false) if the lag has never been observed.
+func dataLag(newest time.Time, last map[string]float64, now time.Time) (float64, bool) {
+	if !newest.IsZero() {
+		return now.Sub(newest).Seconds(), true
+	}
+	lag, ok := last["DataLag"]
+	lastTime, ok2 := last["_lastTime"]
+	if !ok || !ok2 {
+		return 0, false
+	}
+	return lag + float64(now.Unix()) - lastTime, true
+}
+
+// trafficRamp returns the ratio of the requests to the ones at the last run.
+// It is 1 at the first run, or when there were no requests at the last run.
+func trafficRamp(requests float64, last map[string]float64) float64 {
+	prev, ok := last["RequestCount"]
+	if !ok || prev <= 0 {
+		return 1
+	}
+	return requests / prev
+}
+
+// hostsDeregistering returns the decrease of the healthy hosts from the last run (kept in the tempfile),
+// less the increase of the unhealthy hosts, i.e. the hosts likely deregistered (draining) rather than failed.
+// It is 0 when the healthy hosts don't decrease, and not defined (false) at the first run.
+func hostsDeregistering(healthy, unhealthy float64, azs []string, last map[string]float64) (float64, bool) {
+	var prevHealthy, prevUnhealthy float64
+	for _, az := range azs {
+		h, ok := last["HealthyHostCount_"+az]
+		u, ok2 := last["UnHealthyHostCount_"+az]
+		if !ok || !ok2 {
+			return 0, false
+		}
+		prevHealthy += h
+		prevUnhealthy += u
+	}
+
+	failed := math.Max(unhealthy-prevUnhealthy, 0)
+	return math.Max(prevHealthy-healthy-failed, 0), true
+}
+
+// errorAcceleration returns the change of backend 5XX (per 1 min) from the last run, per minute elapsed.
+// No datapoints of 5XX means no errors, so the missing ones are 0.
+// It is not defined (false) at the first run.
+func errorAcceleration(count float64, last map[string]float64, now time.Time) (float64, bool) {
+	lastTime, ok := last["_lastTime"]
+	if !ok {
+		return 0, false
+	}
+	minutes := (float64(now.Unix()) - lastTime) / 60
+	if minutes <= 0 {
+		return 0, false
+	}
+	return (count - last["HTTPCode_Backend_5XX"]) / minutes, true
+}
+
+// errorRatios returns the percentages of client errors (backend 4XX) and server errors (backend and ELB 5XX)
+// in all the responses. Both are 0 when there were no responses.
+func errorRatios(stat map[string]float64) (float64, float64) {
+	client := stat["HTTPCode_Backend_4XX"]
+	server := stat["HTTPCode_Backend_5XX"] + stat["HTTPCode_ELB_5XX"]
+	total := stat["HTTPCode_Backend_2XX"] + stat["HTTPCode_Backend_3XX"] + client + server
+	if total == 0 {
+		return 0, 0
+	}
+	return client / total * 100, server / total * 100
+}
+
+// successRate returns the percentage of backend 2XX in all the backend responses.
+// It is 100 when there were no responses, as nothing failed, or not defined (false) with skipIdle.
+func successRate(stat map[string]float64, skipIdle bool) (float64, bool) {
+	total := stat["HTTPCode_Backend_2XX"] + stat["HTTPCode_Backend_3XX"] + stat["HTTPCode_Backend_4XX"] + stat["HTTPCode_Backend_5XX"]
+	if total == 0 {
+		return 100, !skipIdle
+	}
+	return stat["HTTPCode_Backend_2XX"] / total * 100, true
+}
+
+// requestsPerHost returns the requests per second per healthy host from RequestCount per 1 min.
+// It is not defined (false) when no hosts are healthy.
+func requestsPerHost(requests, healthy float64) (float64, bool) {
+	if healthy <= 0 {
+		return 0, false
+	}
+	return requests / 60 / healthy, true
+}
+
+// droppedPercentage returns the percentage of the spillover in all the attempted requests (served and dropped).
+// It is not defined (false) when there were no requests.
+func droppedPercentage(requests, spillover float64) (float64, bool) {
+	total := requests + spillover
+	if total <= 0 {
+		return 0, false
+	}
+	return spillover / total * 100, true
+}
+
+// healthyPercentage returns the percentage of healthy hosts in all the hosts.
+// It is not defined (false) when no hosts are registered.
+func healthyPercentage(healthy, unhealthy float64) (float64, bool) {
+	total := healthy + unhealthy
+	if total <= 0 {
+		return 0, false
+	}
+	return healthy / total * 100, true
+}
+
+// surgeSaturated returns 1 if the surge queue has reached its capacity, or 0
+func surgeSaturated(max, capacity float64) float64 {
+	if max >= capacity {
+		return 1
+	}
+	return 0
+}
+
+// allHostsDown returns 1 if no hosts are healthy in any AZ, or 0.
+// When HealthyHostCount has no datapoints in every AZ (not by the errors of the API), the healthy hosts are regarded as 0
+// if unhealthy hosts are reported. Otherwise nothing is known of the hosts, and it is not defined (false).
+func allHostsDown(healthy []float64, healthyNoData bool, unhealthy float64) (float64, bool) {
+	if len(healthy) == 0 {
+		if healthyNoData && unhealthy > 0 {
+			return 1, true
+		}
+		return 0, false
+	}
+	for _, v := range healthy {
+		if v > 0 {
+			return 0, true
+		}
+	}
+	return 1, true
+}
+
+// unhealthyExceeded returns 1 if the unhealthy hosts are more than threshold, or 0
+func unhealthyExceeded(unhealthy, threshold float64) float64 {
+	if unhealthy > threshold {
+		return 1
+	}
+	return 0
+}
+
+// requestsPerAZ returns RequestCount of each AZ, where no datapoints means no requests there.
+// It is not defined (false) if the fetch of any AZ failed (e.g. throttled), as the share of the AZ is unknown.
+func requestsPerAZ(values []float64, errs []error) ([]float64, bool) {
+	requests := make([]float64, len(values))
+	for i, err := range errs {
+		switch err {
+		case nil:
+			requests[i] = values[i]
+		case errNoDatapoints:
+			requests[i] = 0
+		default:
+			return nil, false
+		}
+	}
+	return requests, true
+}
+
+// xzoneImbalance returns the maximum deviation (percentage points) of the share of the requests of an AZ
+// from the even share (1/N of the AZs).
+// It is not defined (false) for less than 2 AZs, or without requests.
+func xzoneImbalance(requests []float64) (float64, bool) {
+	if len(requests) < 2 {
+		return 0, false
+	}
+
+	var total float64
+	for _, v := range requests {
+		total += v
+	}
+	if total <= 0 {
+		return 0, false
+	}
+
+	even := 1 / float64(len(requests))
+	var max float64
+	for _, v := range requests {
+		max = math.Max(max, math.Abs(v/total-even))
+	}
+	return max * 100, true
+}
+
+// coefficientOfVariation returns the standard deviation divided by the mean.
+// It is 0 for less than 2 values, where no skew can be observed.
+func coefficientOfVariation(values []float64) float64 {
+	if len(values) < 2 {
+		return 0
+	}
+
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	mean := sum / float64(len(values))
+	if mean == 0 {
+		return 0
+	}
+
+	var sq float64
+	for _, v := range values {
+		sq += (v - mean) * (v - mean)
+	}
+
+	return math.Sqrt(sq/float64(len(values))) / mean
+}
+
 func (p ELBPlugin) GraphDefinition() map[string](mp.Graphs) {
-	for _, grp := range [...]string{"elb.healthy_host_count", "elb.unhealthy_host_count"} {
-		var name_pre string
-		var label string
-		switch grp {
-		case "elb.healthy_host_count":
-			name_pre = "HealthyHostCount_"
-			label = "ELB Healthy Host Count"
-		case "elb.unhealthy_host_count":
-			name_pre = "UnHealthyHostCount_"
-			label = "ELB Unhealthy Host Count"
+	graphs := common.DimensionGraphs("elb", azMetrics, p.AZs, p.GroupByDimension)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	if p.ALB != "" {
+		graphs["elb.backend_vs_lb_latency"] = mp.Graphs{
+			Label: "ELB Backend vs Whole Latency",
+			Unit:  "float",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: "Latency", Label: "Whole Latency"},
+				mp.Metrics{Name: "TargetResponseTime", Label: "Target Response Time"},
+			},
+		}
+		graphs["elb.alb_connections"] = mp.Graphs{
+			Label: "ELB ALB Connections",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: "ActiveConnectionCount", Label: "Active"},
+				mp.Metrics{Name: "NewConnectionCount", Label: "New"},
+				mp.Metrics{Name: "ClientTLSNegotiationErrorCount", Label: "Client TLS Negotiation Errors"},
+			},
+		}
+		graphs["elb.connection_lifetime"] = mp.Graphs{
+			Label: "ELB ALB Estimated Connection Lifetime (sec)",
+			Unit:  "float",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: "ConnectionLifetime", Label: "Active / New per Second"},
+			},
 		}
+	}
 
+	// an approximate histogram of the latency over time
+	if len(p.Percentiles) > 0 {
 		var metrics [](mp.Metrics)
-		for _, az := range p.AZs {
-			metrics = append(metrics, mp.Metrics{Name: name_pre + az, Label: az, Stacked: true})
+		for _, pc := range p.Percentiles {
+			metrics = append(metrics, mp.Metrics{Name: percentileMetricName(pc), Label: pc})
 		}
-		graphdef[grp] = mp.Graphs{
-			Label:   label,
-			Unit:    "integer",
+		graphs["elb.latency_distribution"] = mp.Graphs{
+			Label:   "Whole ELB Latency Distribution",
+			Unit:    "float",
 			Metrics: metrics,
 		}
 	}
 
-	return graphdef
+	// Mackerel rejects graphs without metrics (e.g. an ELB which has never served traffic)
+	if len(p.AZs) > 0 {
+		total := mp.Metrics{Name: "HealthyPercentage", Label: "Total"}
+		if g, ok := graphs["elb.healthy_percentage"]; ok {
+			g.Metrics = append(g.Metrics, total)
+			graphs["elb.healthy_percentage"] = g
+		} else {
+			graphs["elb.healthy_percentage"] = mp.Graphs{
+				Label:   "ELB Healthy Host Percentage",
+				Unit:    "percentage",
+				Metrics: [](mp.Metrics){total},
+			}
+		}
+	}
+
+	return graphs
+}
+
+// prefetchedPlugin returns metrics fetched in advance,
+// so that main can inspect them without calling CloudWatch API twice.
+type prefetchedPlugin struct {
+	mp.Plugin
+	stat map[string]float64
+}
+
+func (p prefetchedPlugin) FetchMetrics() (map[string]float64, error) {
+	return p.stat, nil
 }
 
 func main() {
 	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
 	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
 	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optSmooth := flag.Int("smooth", 1, "Number of the newest datapoints to average")
+	optPeriod := flag.Int("period", 60, "Period (sec) of the datapoints to fetch")
+	optSurgeCap := flag.Float64("surge-cap", 1024, "Capacity of the surge queue")
+	optUnhealthyThreshold := flag.Float64("unhealthy-threshold", 0, "Number of unhealthy hosts in an AZ over which UnhealthyExceeded is 1")
+	optHealthyMin := flag.Bool("healthy-min", false, "Use the minimum of HealthyHostCount in the period instead of the average")
+	optMaxDatapointAge := flag.Int("max-datapoint-age", 0, "Skip metrics whose newest datapoint is older than this (sec), 0 to disable")
+	optSkipIdleSuccess := flag.Bool("skip-idle-success-rate", false, "Skip SuccessRate when there were no backend responses instead of reporting 100")
+	optALB := flag.String("alb", "", "LoadBalancer dimension (e.g. app/my-alb/50dc6c495c0c9188) of the ALB to fetch TargetResponseTime")
+	optPercentiles := flag.String("percentiles", "", "Percentiles of the whole Latency to graph, comma separated (e.g. p50,p90,p95,p99)")
+	optGroupByDimension := flag.Bool("group-by-dimension", false, "Make a graph of the metrics per AZ for each AZ instead of each metric")
+	optConcurrency := flag.Int("concurrency", common.DefaultConcurrency, "Maximum number of simultaneous CloudWatch API calls")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	var elb ELBPlugin
 
-	if *optRegion == "" {
-		elb.Region = aws.InstanceRegion()
-	} else {
-		elb.Region = *optRegion
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
 	}
+	elb.Region = region
 
 	elb.AccessKeyId = *optAccessKeyId
 	elb.SecretAccessKey = *optSecretAccessKey
-
-	err := elb.Prepare()
+	elb.SessionToken = common.AWSSessionToken(*optSessionToken)
+	elb.Smooth = *optSmooth
+	elb.Period = granularityPeriod(*optPeriod, *optSmooth)
+	if elb.Period != *optPeriod && *optPeriod > 0 {
+		// the same time span is smoothed with the fewer datapoints
+		elb.Smooth = *optSmooth * *optPeriod / elb.Period
+		if elb.Smooth < 1 {
+			elb.Smooth = 1
+		}
+		log.Printf("the datapoints of %d sec period are not retained for %d periods. %d sec period is used instead", *optPeriod, *optSmooth+1, elb.Period)
+	}
+	elb.SurgeCap = *optSurgeCap
+	elb.UnhealthyThreshold = *optUnhealthyThreshold
+	elb.Concurrency = *optConcurrency
+	elb.GroupByDimension = *optGroupByDimension
+	elb.ALB = *optALB
+	elb.MaxDatapointAge = *optMaxDatapointAge
+	elb.SkipIdleSuccess = *optSkipIdleSuccess
+	percentiles, err := parsePercentiles(*optPercentiles)
 	if err != nil {
 		log.Fatalln(err)
 	}
+	elb.Percentiles = percentiles
+	if *optHealthyMin {
+		elb.Statistics = map[string]StatType{"HealthyHostCount": Minimum}
+	}
 
-	helper := mp.NewMackerelPlugin(elb)
+	var tempfile string
 	if *optTempfile != "" {
-		helper.Tempfile = *optTempfile
+		tempfile = *optTempfile
 	} else {
-		helper.Tempfile = "/tmp/mackerel-plugin-elb"
+		tempfile = common.Tempfile("mackerel-plugin-elb", "region", "alb")
+	}
+	elb.Tempfile = tempfile
+
+	err = elb.Prepare()
+	if err != nil {
+		log.Fatalln(err)
 	}
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper := mp.NewMackerelPlugin(selfMetrics.Wrap(elb))
+		helper.Tempfile = tempfile
 		helper.OutputDefinitions()
-	} else {
-		helper.OutputValues()
+		return
 	}
+
+	plugin := selfMetrics.Wrap(elb)
+	stat, err := plugin.FetchMetrics()
+	if err != nil {
+		log.Fatalln(err)
+	}
+	if len(stat) == 0 {
+		log.Println("fetched no metrics")
+		os.Exit(0)
+	}
+
+	helper := mp.NewMackerelPlugin(prefetchedPlugin{plugin, stat})
+	helper.Tempfile = tempfile
+	common.RecoverTempfile(helper.Tempfile)
+	common.OutputValues(&helper, statsd, postProcess)
 }
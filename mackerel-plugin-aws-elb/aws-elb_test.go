@@ -0,0 +1,404 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	sdkcloudwatch "github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/crowdmob/goamz/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestGraphDefinitionWithoutAZs(t *testing.T) {
+	var elb ELBPlugin
+
+	graphs := elb.GraphDefinition()
+	_, ok := graphs["elb.healthy_host_count"]
+	assert.False(t, ok)
+	_, ok = graphs["elb.unhealthy_host_count"]
+	assert.False(t, ok)
+	_, ok = graphs["elb.latency_per_az"]
+	assert.False(t, ok)
+	_, ok = graphs["elb.healthy_percentage"]
+	assert.False(t, ok)
+	for name, graph := range graphs {
+		assert.NotEmpty(t, graph.Metrics, name)
+	}
+}
+
+func TestGraphDefinitionWithAZs(t *testing.T) {
+	var elb ELBPlugin
+	elb.AZs = []string{"ap-northeast-1a", "ap-northeast-1c"}
+
+	graphs := elb.GraphDefinition()
+	assert.Equal(t, len(graphs["elb.healthy_host_count"].Metrics), 2)
+	assert.Equal(t, graphs["elb.healthy_host_count"].Metrics[0].Name, "HealthyHostCount_ap-northeast-1a")
+	assert.Equal(t, len(graphs["elb.unhealthy_host_count"].Metrics), 2)
+	assert.Equal(t, graphs["elb.unhealthy_host_count"].Metrics[1].Name, "UnHealthyHostCount_ap-northeast-1c")
+	assert.Equal(t, len(graphs["elb.latency_per_az"].Metrics), 2)
+	assert.Equal(t, graphs["elb.latency_per_az"].Metrics[0].Name, "Latency_ap-northeast-1a")
+	assert.Equal(t, graphs["elb.latency_per_az"].Unit, "float")
+	assert.False(t, graphs["elb.latency_per_az"].Metrics[0].Stacked)
+	assert.Equal(t, len(graphs["elb.healthy_percentage"].Metrics), 3)
+	assert.Equal(t, graphs["elb.healthy_percentage"].Metrics[2].Name, "HealthyPercentage")
+	assert.Equal(t, graphs["elb.healthy_percentage"].Unit, "percentage")
+}
+
+func TestRequestsPerHost(t *testing.T) {
+	v, ok := requestsPerHost(1200, 4)
+	assert.True(t, ok)
+	assert.Equal(t, v, 5.0)
+
+	_, ok = requestsPerHost(1200, 0)
+	assert.False(t, ok)
+}
+
+func TestErrorAcceleration(t *testing.T) {
+	now := time.Unix(1420070520, 0)
+
+	v, ok := errorAcceleration(50, map[string]float64{"HTTPCode_Backend_5XX": 10, "_lastTime": 1420070400}, now)
+	assert.True(t, ok)
+	assert.Equal(t, v, 20.0)
+
+	// no 5XX at the last run
+	v, ok = errorAcceleration(6, map[string]float64{"_lastTime": 1420070460}, now)
+	assert.True(t, ok)
+	assert.Equal(t, v, 6.0)
+
+	_, ok = errorAcceleration(50, nil, now)
+	assert.False(t, ok)
+}
+
+func TestHostsDeregistering(t *testing.T) {
+	azs := []string{"ap-northeast-1a", "ap-northeast-1c"}
+	last := map[string]float64{
+		"HealthyHostCount_ap-northeast-1a": 4, "UnHealthyHostCount_ap-northeast-1a": 0,
+		"HealthyHostCount_ap-northeast-1c": 4, "UnHealthyHostCount_ap-northeast-1c": 1,
+	}
+
+	// draining: 2 hosts left the healthy ones without turning unhealthy
+	v, ok := hostsDeregistering(6, 1, azs, last)
+	assert.True(t, ok)
+	assert.Equal(t, v, 2.0)
+
+	// failing: 1 of the 2 hosts turned unhealthy
+	v, ok = hostsDeregistering(6, 2, azs, last)
+	assert.True(t, ok)
+	assert.Equal(t, v, 1.0)
+
+	// scaled out
+	v, ok = hostsDeregistering(10, 0, azs, last)
+	assert.True(t, ok)
+	assert.Equal(t, v, 0.0)
+
+	// the first run
+	_, ok = hostsDeregistering(6, 1, azs, nil)
+	assert.False(t, ok)
+}
+
+func TestRequestsPerAZ(t *testing.T) {
+	requests, ok := requestsPerAZ([]float64{120, 0}, []error{nil, errNoDatapoints})
+	assert.True(t, ok)
+	assert.Equal(t, requests, []float64{120, 0})
+
+	// throttled in an AZ, which should not be taken as no requests
+	_, ok = requestsPerAZ([]float64{120, 0}, []error{nil, errors.New("Throttling: Rate exceeded")})
+	assert.False(t, ok)
+}
+
+func TestXZoneImbalance(t *testing.T) {
+	v, ok := xzoneImbalance([]float64{100, 100, 100})
+	assert.True(t, ok)
+	assert.Equal(t, v, 0.0)
+
+	// all the requests to one of 2 AZs
+	v, ok = xzoneImbalance([]float64{200, 0})
+	assert.True(t, ok)
+	assert.Equal(t, v, 50.0)
+
+	v, ok = xzoneImbalance([]float64{60, 20, 20})
+	assert.True(t, ok)
+	assert.InDelta(t, v, 26.6667, 0.0001)
+
+	_, ok = xzoneImbalance([]float64{100})
+	assert.False(t, ok)
+	_, ok = xzoneImbalance([]float64{0, 0})
+	assert.False(t, ok)
+}
+
+func TestDataLag(t *testing.T) {
+	now := time.Unix(1420070520, 0)
+
+	v, ok := dataLag(time.Unix(1420070400, 0), nil, now)
+	assert.True(t, ok)
+	assert.Equal(t, v, 120.0)
+
+	// no datapoints, e.g. the ELB has stopped publishing
+	v, ok = dataLag(time.Time{}, map[string]float64{"DataLag": 300, "_lastTime": 1420070460}, now)
+	assert.True(t, ok)
+	assert.Equal(t, v, 360.0)
+
+	_, ok = dataLag(time.Time{}, nil, now)
+	assert.False(t, ok)
+}
+
+func TestSuccessRate(t *testing.T) {
+	stat := map[string]float64{"HTTPCode_Backend_2XX": 990, "HTTPCode_Backend_3XX": 5, "HTTPCode_Backend_4XX": 3, "HTTPCode_Backend_5XX": 2}
+	v, ok := successRate(stat, false)
+	assert.True(t, ok)
+	assert.InDelta(t, v, 99.0, 0.0001)
+
+	// no traffic
+	v, ok = successRate(map[string]float64{}, false)
+	assert.True(t, ok)
+	assert.Equal(t, v, 100.0)
+	_, ok = successRate(map[string]float64{}, true)
+	assert.False(t, ok)
+}
+
+func TestDroppedPercentage(t *testing.T) {
+	v, ok := droppedPercentage(900, 100)
+	assert.True(t, ok)
+	assert.Equal(t, v, 10.0)
+
+	v, ok = droppedPercentage(0, 50)
+	assert.True(t, ok)
+	assert.Equal(t, v, 100.0)
+
+	_, ok = droppedPercentage(0, 0)
+	assert.False(t, ok)
+}
+
+func TestHealthyPercentage(t *testing.T) {
+	v, ok := healthyPercentage(3, 1)
+	assert.True(t, ok)
+	assert.Equal(t, v, 75.0)
+
+	_, ok = healthyPercentage(0, 0)
+	assert.False(t, ok)
+}
+
+func TestGraphDefinitionGroupByDimension(t *testing.T) {
+	var elb ELBPlugin
+	elb.AZs = []string{"ap-northeast-1a", "ap-northeast-1c"}
+	elb.GroupByDimension = true
+
+	graphs := elb.GraphDefinition()
+	_, ok := graphs["elb.healthy_host_count"]
+	assert.False(t, ok)
+	assert.Equal(t, len(graphs["elb.ap-northeast-1a.host_count"].Metrics), 2)
+	assert.Equal(t, graphs["elb.ap-northeast-1a.host_count"].Metrics[1].Name, "UnHealthyHostCount_ap-northeast-1a")
+	assert.Equal(t, graphs["elb.ap-northeast-1c.latency"].Metrics[0].Name, "Latency_ap-northeast-1c")
+	assert.Equal(t, graphs["elb.healthy_percentage"].Metrics[0].Name, "HealthyPercentage")
+}
+
+func TestBackendVsLBLatencyGraph(t *testing.T) {
+	var elb ELBPlugin
+	_, ok := elb.GraphDefinition()["elb.backend_vs_lb_latency"]
+	assert.False(t, ok)
+
+	elb.ALB = "app/my-alb/50dc6c495c0c9188"
+	graph := elb.GraphDefinition()["elb.backend_vs_lb_latency"]
+	assert.Equal(t, graph.Metrics[0].Name, "Latency")
+	assert.Equal(t, graph.Metrics[1].Name, "TargetResponseTime")
+}
+
+func TestWeightedAverage(t *testing.T) {
+	v, ok := weightedAverage([]float64{0.1, 0.4}, []float64{300, 100})
+	assert.True(t, ok)
+	assert.InDelta(t, v, 0.175, 1e-9)
+
+	_, ok = weightedAverage([]float64{0.1}, []float64{0})
+	assert.False(t, ok)
+	_, ok = weightedAverage(nil, nil)
+	assert.False(t, ok)
+}
+
+func TestCapacityPressureGraph(t *testing.T) {
+	var elb ELBPlugin
+
+	graph := elb.GraphDefinition()["elb.capacity_pressure"]
+	assert.Equal(t, graph.Unit, "integer")
+	assert.Equal(t, len(graph.Metrics), 2)
+	assert.Equal(t, graph.Metrics[0].Name, "SurgeQueueLength")
+	assert.Equal(t, graph.Metrics[1].Name, "SpilloverCount")
+	// stacking would hide the queue length under the spillover
+	assert.False(t, graph.Metrics[0].Stacked)
+}
+
+func TestTrafficRamp(t *testing.T) {
+	assert.Equal(t, trafficRamp(300, map[string]float64{"RequestCount": 100}), 3.0)
+	assert.Equal(t, trafficRamp(50, map[string]float64{"RequestCount": 100}), 0.5)
+	// the first run
+	assert.Equal(t, trafficRamp(300, nil), 1.0)
+	assert.Equal(t, trafficRamp(300, map[string]float64{"RequestCount": 0}), 1.0)
+}
+
+func TestErrorRatios(t *testing.T) {
+	stat := map[string]float64{
+		"HTTPCode_Backend_2XX": 150,
+		"HTTPCode_Backend_3XX": 10,
+		"HTTPCode_Backend_4XX": 30,
+		"HTTPCode_Backend_5XX": 6,
+		"HTTPCode_ELB_5XX":     4,
+	}
+	client, server := errorRatios(stat)
+	assert.Equal(t, client, 15.0)
+	assert.Equal(t, server, 5.0)
+
+	// no responses (or no datapoints)
+	client, server = errorRatios(map[string]float64{})
+	assert.Equal(t, client, 0.0)
+	assert.Equal(t, server, 0.0)
+}
+
+func TestCoefficientOfVariation(t *testing.T) {
+	assert.Equal(t, coefficientOfVariation([]float64{}), 0.0)
+	assert.Equal(t, coefficientOfVariation([]float64{3}), 0.0)
+	assert.Equal(t, coefficientOfVariation([]float64{0, 0}), 0.0)
+	assert.Equal(t, coefficientOfVariation([]float64{2, 2, 2}), 0.0)
+	assert.Equal(t, coefficientOfVariation([]float64{1, 3}), 0.5)
+}
+
+func TestGranularityPeriod(t *testing.T) {
+	assert.Equal(t, granularityPeriod(60, 1), 60)
+	assert.Equal(t, granularityPeriod(300, 10), 300)
+	// 1 sec datapoints are kept only for 3 hours
+	assert.Equal(t, granularityPeriod(1, 60), 1)
+	assert.Equal(t, granularityPeriod(10, 1200), 60)
+	// 1 min datapoints are kept for 15 days
+	assert.Equal(t, granularityPeriod(60, 30*24*60), 300)
+	assert.Equal(t, granularityPeriod(60, 100*24*60), 3600)
+	assert.Equal(t, granularityPeriod(3600, 24), 3600)
+	assert.Equal(t, granularityPeriod(0, 1), 60)
+}
+
+func TestSmoothDatapoints(t *testing.T) {
+	now := time.Now()
+	datapoints := []cloudwatch.Datapoint{
+		cloudwatch.Datapoint{Timestamp: now.Add(-3 * time.Minute), Average: 1.0, Sum: 10, Minimum: 0, Maximum: 3},
+		cloudwatch.Datapoint{Timestamp: now.Add(-1 * time.Minute), Average: 3.0, Sum: 30, Minimum: 3, Maximum: 3},
+		cloudwatch.Datapoint{Timestamp: now.Add(-2 * time.Minute), Average: 2.0, Sum: 20, Minimum: 1, Maximum: 2},
+	}
+
+	assert.Equal(t, smoothDatapoints(datapoints, Average, 1), 3.0)
+	assert.Equal(t, smoothDatapoints(datapoints, Average, 2), 2.5)
+	assert.Equal(t, smoothDatapoints(datapoints, Sum, 3), 20.0)
+	// fewer datapoints than n
+	assert.Equal(t, smoothDatapoints(datapoints, Sum, 5), 20.0)
+	assert.Equal(t, smoothDatapoints(datapoints, Minimum, 1), 3.0)
+	assert.Equal(t, smoothDatapoints(datapoints, Minimum, 2), 1.0)
+	assert.Equal(t, smoothDatapoints(datapoints, Maximum, 3), 3.0)
+}
+
+func TestCheckDatapointAge(t *testing.T) {
+	now := time.Now()
+	datapoints := []cloudwatch.Datapoint{
+		cloudwatch.Datapoint{Timestamp: now.Add(-3 * time.Minute)},
+		cloudwatch.Datapoint{Timestamp: now.Add(-2 * time.Minute)},
+	}
+
+	assert.Nil(t, checkDatapointAge(datapoints, now, 0))
+	assert.Nil(t, checkDatapointAge(datapoints, now, 180))
+	assert.NotNil(t, checkDatapointAge(datapoints, now, 60))
+}
+
+func TestStatTypeOf(t *testing.T) {
+	var elb ELBPlugin
+	assert.Equal(t, elb.statTypeOf("HealthyHostCount", Average), Average)
+
+	elb.Statistics = map[string]StatType{"HealthyHostCount": Minimum}
+	assert.Equal(t, elb.statTypeOf("HealthyHostCount", Average), Minimum)
+	assert.Equal(t, elb.statTypeOf("UnHealthyHostCount", Average), Average)
+}
+
+func TestSurgeSaturated(t *testing.T) {
+	assert.Equal(t, surgeSaturated(0, 1024), 0.0)
+	assert.Equal(t, surgeSaturated(1023, 1024), 0.0)
+	assert.Equal(t, surgeSaturated(1024, 1024), 1.0)
+	assert.Equal(t, surgeSaturated(300, 256), 1.0)
+}
+
+func TestConnectionLifetime(t *testing.T) {
+	v, ok := connectionLifetime(600, 120)
+	assert.True(t, ok)
+	assert.InDelta(t, v, 300, 0.001)
+
+	_, ok = connectionLifetime(600, 0)
+	assert.False(t, ok)
+}
+
+func TestUnhealthyExceeded(t *testing.T) {
+	assert.Equal(t, unhealthyExceeded(0, 0), 0.0)
+	assert.Equal(t, unhealthyExceeded(1, 0), 1.0)
+	assert.Equal(t, unhealthyExceeded(2, 2), 0.0)
+	assert.Equal(t, unhealthyExceeded(3, 2), 1.0)
+}
+
+func TestAllHostsDown(t *testing.T) {
+	v, ok := allHostsDown([]float64{2, 0}, false, 1)
+	assert.True(t, ok)
+	assert.Equal(t, v, 0.0)
+
+	v, ok = allHostsDown([]float64{0, 0}, false, 3)
+	assert.True(t, ok)
+	assert.Equal(t, v, 1.0)
+
+	// no datapoints of HealthyHostCount while unhealthy hosts are reported
+	v, ok = allHostsDown(nil, true, 3)
+	assert.True(t, ok)
+	assert.Equal(t, v, 1.0)
+
+	_, ok = allHostsDown(nil, true, 0)
+	assert.False(t, ok, "nothing is known of the hosts")
+	_, ok = allHostsDown(nil, false, 3)
+	assert.False(t, ok, "errors of the API")
+}
+
+func TestParsePercentiles(t *testing.T) {
+	percentiles, err := parsePercentiles("p50,p90, p99.9,p100,p50")
+	assert.Nil(t, err)
+	assert.Equal(t, percentiles, []string{"p50", "p90", "p99.9", "p100"})
+
+	percentiles, err = parsePercentiles("")
+	assert.Nil(t, err)
+	assert.Empty(t, percentiles)
+
+	for _, s := range []string{"99", "p101", "p99.999", "P99", "tm99", "p50,p"} {
+		_, err = parsePercentiles(s)
+		assert.NotNil(t, err, s)
+	}
+
+	_, err = parsePercentiles("p1,p2,p3,p4,p5,p6,p7,p8,p9,p10,p11")
+	assert.NotNil(t, err)
+}
+
+func TestNewestPercentiles(t *testing.T) {
+	now := time.Now()
+	older := now.Add(-2 * time.Minute)
+	newer := now.Add(-1 * time.Minute)
+	v := func(f float64) *float64 { return &f }
+	datapoints := []*sdkcloudwatch.Datapoint{
+		&sdkcloudwatch.Datapoint{Timestamp: &older, ExtendedStatistics: map[string]*float64{"p50": v(0.1), "p99": v(2.0)}},
+		&sdkcloudwatch.Datapoint{Timestamp: &newer, ExtendedStatistics: map[string]*float64{"p50": v(0.2), "p99.9": v(3.5)}},
+	}
+
+	stat := newestPercentiles(datapoints, []string{"p50", "p99", "p99.9"}, now, 0)
+	assert.Equal(t, stat, map[string]float64{"Latency_p50": 0.2, "Latency_p99_9": 3.5}, "p99 without the value is skipped")
+
+	assert.Empty(t, newestPercentiles(datapoints, []string{"p50"}, now, 30), "stale")
+	assert.Empty(t, newestPercentiles(nil, []string{"p50"}, now, 0))
+}
+
+func TestGraphDefinitionWithPercentiles(t *testing.T) {
+	elb := ELBPlugin{Percentiles: []string{"p50", "p99.9"}}
+	graphs := elb.GraphDefinition()
+	g, ok := graphs["elb.latency_distribution"]
+	assert.True(t, ok)
+	assert.Equal(t, len(g.Metrics), 2)
+	assert.Equal(t, g.Metrics[1].Name, "Latency_p99_9")
+
+	_, ok = (ELBPlugin{}).GraphDefinition()["elb.latency_distribution"]
+	assert.False(t, ok)
+}
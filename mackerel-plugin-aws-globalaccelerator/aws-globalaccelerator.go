@@ -0,0 +1,287 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/GlobalAccelerator"
+
+// the metrics of Global Accelerator are available only in us-west-2,
+// wherever the accelerator and its endpoints are
+const metricsRegion = "us-west-2"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"globalaccelerator.flows": mp.Graphs{
+		Label: "Global Accelerator New Flows",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "NewFlowCount", Label: "New Flows per sec"},
+		},
+	},
+	"globalaccelerator.bytes": mp.Graphs{
+		Label: "Global Accelerator Traffic",
+		Unit:  "bytes/sec",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "ProcessedBytesIn", Label: "In"},
+			mp.Metrics{Name: "ProcessedBytesOut", Label: "Out"},
+		},
+	},
+
+	// "globalaccelerator.healthy_endpoint_count", "globalaccelerator.unhealthy_endpoint_count" will be generated dynamically
+}
+
+type StatType int
+
+const (
+	Average StatType = iota
+	Sum
+)
+
+func (s StatType) String() string {
+	switch s {
+	case Average:
+		return "Average"
+	case Sum:
+		return "Sum"
+	}
+	return ""
+}
+
+type GlobalAcceleratorPlugin struct {
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Accelerator     string
+	Concurrency     int
+	EndpointGroups  map[string][][]cloudwatch.Dimension
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+func (p *GlobalAcceleratorPlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[metricsRegion].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	ret, err := p.CloudWatch.ListMetrics(&cloudwatch.ListMetricsRequest{
+		Namespace: namespace,
+		Dimensions: []cloudwatch.Dimension{
+			cloudwatch.Dimension{
+				Name:  "Accelerator",
+				Value: p.Accelerator,
+			},
+		},
+		MetricName: "HealthyEndpointCount",
+	})
+	if err != nil {
+		return err
+	}
+
+	// an endpoint group (named by its region) may belong to several listeners
+	p.EndpointGroups = make(map[string][][]cloudwatch.Dimension)
+	for _, met := range ret.ListMetricsResult.Metrics {
+		for _, d := range met.Dimensions {
+			if d.Name == "EndpointGroup" {
+				p.EndpointGroups[d.Value] = append(p.EndpointGroups[d.Value], met.Dimensions)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p GlobalAcceleratorPlugin) GetLastPoint(dimensions []cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: dimensions,
+		StartTime:  now.Add(time.Duration(180) * time.Second * -1), // 3 min (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: metricName,
+		Period:     60,
+		Statistics: []string{statType.String()},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := response.GetMetricStatisticsResult.Datapoints
+	if len(datapoints) == 0 {
+		return 0, errors.New("fetched no datapoints")
+	}
+
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	for _, dp := range datapoints {
+		if dp.Timestamp.Before(latest) {
+			continue
+		}
+
+		latest = dp.Timestamp
+		switch statType {
+		case Average:
+			latestVal = dp.Average
+		case Sum:
+			latestVal = dp.Sum
+		}
+	}
+
+	return latestVal, nil
+}
+
+func (p GlobalAcceleratorPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	d := []cloudwatch.Dimension{
+		cloudwatch.Dimension{
+			Name:  "Accelerator",
+			Value: p.Accelerator,
+		},
+	}
+
+	// sums of 1 min period, converted to per second
+	for _, met := range [...]string{"NewFlowCount", "ProcessedBytesIn", "ProcessedBytesOut"} {
+		v, err := p.GetLastPoint(d, met, Sum)
+		if err == nil {
+			stat[met] = v / 60
+		} else {
+			common.LogFetchError(met, err)
+		}
+	}
+
+	// EndpointCount per endpoint group, summed up over the listeners
+	type groupQuery struct {
+		group      string
+		metricName string
+		dimensions []cloudwatch.Dimension
+	}
+	var queries []groupQuery
+	for _, group := range p.endpointGroupNames() {
+		for _, met := range []string{"HealthyEndpointCount", "UnhealthyEndpointCount"} {
+			for _, d := range p.EndpointGroups[group] {
+				queries = append(queries, groupQuery{group, met, d})
+			}
+		}
+	}
+
+	values := make([]float64, len(queries))
+	errs := make([]error, len(queries))
+	common.FetchMany(len(queries), p.Concurrency, func(i int) {
+		values[i], errs[i] = p.GetLastPoint(queries[i].dimensions, queries[i].metricName, Average)
+	})
+	for i, q := range queries {
+		if errs[i] == nil {
+			stat[q.metricName+"_"+q.group] += values[i]
+		} else {
+			common.LogFetchError(q.metricName, errs[i])
+		}
+	}
+
+	return stat, nil
+}
+
+// endpointGroupNames returns the endpoint groups in order, not to shuffle the metrics of the graphs
+func (p GlobalAcceleratorPlugin) endpointGroupNames() []string {
+	names := make([]string, 0, len(p.EndpointGroups))
+	for group := range p.EndpointGroups {
+		names = append(names, group)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p GlobalAcceleratorPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), len(graphdef)+2)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	for _, grp := range [...]string{"globalaccelerator.healthy_endpoint_count", "globalaccelerator.unhealthy_endpoint_count"} {
+		var name_pre string
+		var label string
+		switch grp {
+		case "globalaccelerator.healthy_endpoint_count":
+			name_pre = "HealthyEndpointCount_"
+			label = "Global Accelerator Healthy Endpoint Count"
+		case "globalaccelerator.unhealthy_endpoint_count":
+			name_pre = "UnhealthyEndpointCount_"
+			label = "Global Accelerator Unhealthy Endpoint Count"
+		}
+
+		var metrics [](mp.Metrics)
+		for _, group := range p.endpointGroupNames() {
+			metrics = append(metrics, mp.Metrics{Name: name_pre + group, Label: group, Stacked: true})
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		graphs[grp] = mp.Graphs{
+			Label:   label,
+			Unit:    "integer",
+			Metrics: metrics,
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optAccelerator := flag.String("accelerator", "", "Accelerator ID (the last part of the accelerator ARN)")
+	optConcurrency := flag.Int("concurrency", common.DefaultConcurrency, "Maximum number of simultaneous CloudWatch API calls")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var ga GlobalAcceleratorPlugin
+
+	if *optAccelerator == "" {
+		log.Fatalln("-accelerator is required")
+	}
+
+	ga.AccessKeyId = *optAccessKeyId
+	ga.SecretAccessKey = *optSecretAccessKey
+	ga.SessionToken = common.AWSSessionToken(*optSessionToken)
+	ga.Accelerator = *optAccelerator
+	ga.Concurrency = *optConcurrency
+
+	err := ga.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(ga))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-globalaccelerator-" + *optAccelerator
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,344 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/Kafka"
+
+const (
+	clusterNameDimension = "Cluster Name"
+	brokerIdDimension    = "Broker ID"
+	topicDimension       = "Topic"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"kafka.offline_partitions": mp.Graphs{
+		Label: "MSK Offline Partitions",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "OfflinePartitionsCount", Label: "Offline Partitions"},
+		},
+	},
+
+	// the graphs of brokerMetrics and "kafka.messages_in_per_topic" will be generated dynamically
+}
+
+type StatType int
+
+const (
+	Average StatType = iota
+	Maximum
+)
+
+func (s StatType) String() string {
+	switch s {
+	case Average:
+		return "Average"
+	case Maximum:
+		return "Maximum"
+	}
+	return ""
+}
+
+// metrics per broker, with the statistics to fetch them with
+var brokerMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "CpuIdle_", Unit: "percentage", Graph: "cpu_idle", GraphLabel: "MSK CPU Idle"},
+	common.DimensionMetric{Prefix: "CpuUser_", Unit: "percentage", Graph: "cpu_user", GraphLabel: "MSK CPU User"},
+	common.DimensionMetric{Prefix: "MemoryUsed_", Unit: "bytes", Graph: "memory_used", GraphLabel: "MSK Memory Used"},
+	common.DimensionMetric{Prefix: "KafkaDataLogsDiskUsed_", Unit: "percentage", Graph: "data_logs_disk_used", GraphLabel: "MSK Data Logs Disk Used"},
+	common.DimensionMetric{Prefix: "BytesInPerSec_", Unit: "bytes/sec", Stacked: true, Graph: "bytes_in", GraphLabel: "MSK Bytes In"},
+	common.DimensionMetric{Prefix: "BytesOutPerSec_", Unit: "bytes/sec", Stacked: true, Graph: "bytes_out", GraphLabel: "MSK Bytes Out"},
+	common.DimensionMetric{Prefix: "UnderReplicatedPartitions_", Unit: "integer", Graph: "under_replicated_partitions", GraphLabel: "MSK Under Replicated Partitions"},
+}
+
+var brokerStatistics = map[string]StatType{
+	// a partition under replicated even for a moment should be noticed
+	"UnderReplicatedPartitions": Maximum,
+}
+
+type KafkaMSKPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	ClusterName     string
+	BrokerId        string
+	Brokers         []string
+	Topics          []string
+	Concurrency     int
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+func dimensionValue(dimensions []cloudwatch.Dimension, name string) string {
+	for _, d := range dimensions {
+		if d.Name == name {
+			return d.Value
+		}
+	}
+	return ""
+}
+
+// appendUnique appends s to list unless list has s
+func appendUnique(list []string, s string) []string {
+	for _, v := range list {
+		if v == s {
+			return list
+		}
+	}
+	return append(list, s)
+}
+
+// listDimensionValues returns the values of the dimension of the metric in the cluster,
+// following NextToken as the metrics are listed by 500 (a topic is listed once per broker)
+func (p *KafkaMSKPlugin) listDimensionValues(metricName, dimension string) ([]string, error) {
+	var values []string
+	req := &cloudwatch.ListMetricsRequest{
+		Namespace: namespace,
+		Dimensions: []cloudwatch.Dimension{
+			cloudwatch.Dimension{Name: clusterNameDimension, Value: p.ClusterName},
+			cloudwatch.Dimension{Name: dimension},
+		},
+		MetricName: metricName,
+	}
+	for {
+		ret, err := p.CloudWatch.ListMetrics(req)
+		if err != nil {
+			return nil, err
+		}
+		for _, met := range ret.ListMetricsResult.Metrics {
+			if v := dimensionValue(met.Dimensions, dimension); v != "" {
+				values = appendUnique(values, v)
+			}
+		}
+		if ret.ListMetricsResult.NextToken == "" {
+			break
+		}
+		req.NextToken = ret.ListMetricsResult.NextToken
+	}
+	return values, nil
+}
+
+func (p *KafkaMSKPlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	if p.BrokerId != "" {
+		p.Brokers = []string{p.BrokerId}
+	} else {
+		p.Brokers, err = p.listDimensionValues("CpuIdle", brokerIdDimension)
+		if err != nil {
+			return err
+		}
+		sort.Strings(p.Brokers)
+	}
+	if len(p.Brokers) == 0 {
+		return errors.New("no brokers found in " + p.ClusterName)
+	}
+
+	// reported only with the PER_TOPIC_PER_BROKER monitoring level
+	p.Topics, err = p.listDimensionValues("MessagesInPerSec", topicDimension)
+	if err != nil {
+		return err
+	}
+	sort.Strings(p.Topics)
+
+	return nil
+}
+
+func (p KafkaMSKPlugin) GetLastPoint(dimensions []cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: dimensions,
+		StartTime:  now.Add(time.Duration(180) * time.Second * -1), // 3 min (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: metricName,
+		Period:     60,
+		Statistics: []string{statType.String()},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := response.GetMetricStatisticsResult.Datapoints
+	if len(datapoints) == 0 {
+		return 0, errors.New("fetched no datapoints")
+	}
+
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	for _, dp := range datapoints {
+		if dp.Timestamp.Before(latest) {
+			continue
+		}
+
+		latest = dp.Timestamp
+		switch statType {
+		case Average:
+			latestVal = dp.Average
+		case Maximum:
+			latestVal = dp.Maximum
+		}
+	}
+
+	return latestVal, nil
+}
+
+func (p KafkaMSKPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	type query struct {
+		name       string
+		dimensions []cloudwatch.Dimension
+		metricName string
+		statType   StatType
+	}
+	cluster := cloudwatch.Dimension{Name: clusterNameDimension, Value: p.ClusterName}
+
+	// the offline partitions are reported by the active controller, for the whole cluster
+	queries := []query{
+		query{"OfflinePartitionsCount", []cloudwatch.Dimension{cluster}, "OfflinePartitionsCount", Maximum},
+	}
+	for _, broker := range p.Brokers {
+		dims := []cloudwatch.Dimension{cluster, cloudwatch.Dimension{Name: brokerIdDimension, Value: broker}}
+		for _, met := range brokerMetrics {
+			metricName := strings.TrimSuffix(met.Prefix, "_")
+			statType, ok := brokerStatistics[metricName]
+			if !ok {
+				statType = Average
+			}
+			queries = append(queries, query{met.Prefix + broker, dims, metricName, statType})
+		}
+		for _, topic := range p.Topics {
+			topicDims := append([]cloudwatch.Dimension{cloudwatch.Dimension{Name: topicDimension, Value: topic}}, dims...)
+			queries = append(queries, query{"MessagesInPerSec_" + metricName(topic), topicDims, "MessagesInPerSec", Average})
+		}
+	}
+
+	values := make([]float64, len(queries))
+	fetched := make([]bool, len(queries))
+	common.FetchMany(len(queries), p.Concurrency, func(i int) {
+		q := queries[i]
+		v, err := p.GetLastPoint(q.dimensions, q.metricName, q.statType)
+		if err == nil {
+			values[i] = v
+			fetched[i] = true
+		} else {
+			common.LogFetchError(q.name, err)
+		}
+	})
+
+	// the messages of a topic are summed up over the brokers
+	for i, q := range queries {
+		if fetched[i] {
+			stat[q.name] += values[i]
+		}
+	}
+
+	return stat, nil
+}
+
+func (p KafkaMSKPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := common.DimensionGraphs("kafka", brokerMetrics, p.Brokers, false)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	var metrics [](mp.Metrics)
+	for _, topic := range p.Topics {
+		metrics = append(metrics, mp.Metrics{Name: "MessagesInPerSec_" + metricName(topic), Label: topic, Stacked: true})
+	}
+	if len(metrics) > 0 {
+		graphs["kafka.messages_in_per_topic"] = mp.Graphs{
+			Label:   "MSK Messages In per Topic",
+			Unit:    "float",
+			Metrics: metrics,
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optClusterName := flag.String("cluster-name", "", "MSK Cluster Name")
+	optBrokerId := flag.String("broker-id", "", "Broker ID (default: all brokers)")
+	optConcurrency := flag.Int("concurrency", common.DefaultConcurrency, "Maximum number of simultaneous CloudWatch API calls")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var msk KafkaMSKPlugin
+
+	if *optClusterName == "" {
+		log.Fatalln("-cluster-name is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	msk.Region = region
+
+	msk.AccessKeyId = *optAccessKeyId
+	msk.SecretAccessKey = *optSecretAccessKey
+	msk.SessionToken = common.AWSSessionToken(*optSessionToken)
+	msk.ClusterName = *optClusterName
+	msk.BrokerId = *optBrokerId
+	msk.Concurrency = *optConcurrency
+
+	err = msk.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(msk))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-kafka-msk-" + metricName(*optClusterName)
+		if *optBrokerId != "" {
+			helper.Tempfile += "-" + metricName(*optBrokerId)
+		}
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
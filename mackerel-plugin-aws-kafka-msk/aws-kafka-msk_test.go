@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/crowdmob/goamz/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDimensionValue(t *testing.T) {
+	dims := []cloudwatch.Dimension{
+		cloudwatch.Dimension{Name: "Cluster Name", Value: "my-cluster"},
+		cloudwatch.Dimension{Name: "Broker ID", Value: "2"},
+	}
+	assert.Equal(t, dimensionValue(dims, "Broker ID"), "2")
+	assert.Equal(t, dimensionValue(dims, "Topic"), "")
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var msk KafkaMSKPlugin
+	msk.Brokers = []string{"1", "2", "3"}
+
+	graphs := msk.GraphDefinition()
+	assert.Equal(t, len(graphs["kafka.under_replicated_partitions"].Metrics), 3)
+	assert.Equal(t, graphs["kafka.under_replicated_partitions"].Metrics[2].Name, "UnderReplicatedPartitions_3")
+	assert.Equal(t, graphs["kafka.bytes_in"].Unit, "bytes/sec")
+	_, ok := graphs["kafka.offline_partitions"]
+	assert.True(t, ok)
+	_, ok = graphs["kafka.messages_in_per_topic"]
+	assert.False(t, ok)
+
+	msk.Topics = []string{"orders", "__consumer_offsets"}
+	graphs = msk.GraphDefinition()
+	assert.Equal(t, graphs["kafka.messages_in_per_topic"].Metrics[1].Name, "MessagesInPerSec_consumer_offsets")
+}
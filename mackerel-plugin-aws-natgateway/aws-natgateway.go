@@ -0,0 +1,221 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/NATGateway"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"natgateway.error_port_allocation": mp.Graphs{
+		Label: "NAT Gateway Port Allocation Errors",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "ErrorPortAllocation", Label: "ErrorPortAllocation"},
+		},
+	},
+	"natgateway.connections": mp.Graphs{
+		Label: "NAT Gateway Connections",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "ActiveConnectionCount", Label: "Active"},
+			mp.Metrics{Name: "ConnectionEstablishedCount", Label: "Established per sec"},
+		},
+	},
+	"natgateway.bytes": mp.Graphs{
+		Label: "NAT Gateway Traffic",
+		Unit:  "bytes/sec",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "BytesInFromSource", Label: "In from Source"},
+			mp.Metrics{Name: "BytesOutToDestination", Label: "Out to Destination"},
+		},
+	},
+	"natgateway.packets_drop": mp.Graphs{
+		Label: "NAT Gateway Dropped Packets",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "PacketsDropCount", Label: "Dropped per sec"},
+		},
+	},
+}
+
+type StatType int
+
+const (
+	Sum StatType = iota
+	Maximum
+)
+
+func (s StatType) String() string {
+	switch s {
+	case Sum:
+		return "Sum"
+	case Maximum:
+		return "Maximum"
+	}
+	return ""
+}
+
+type NATGatewayPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	NatGatewayId    string
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+func (p *NATGatewayPlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p NATGatewayPlugin) GetLastPoint(dimension *cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: []cloudwatch.Dimension{*dimension},
+		StartTime:  now.Add(time.Duration(180) * time.Second * -1), // 3 min (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: metricName,
+		Period:     60,
+		Statistics: []string{statType.String()},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := response.GetMetricStatisticsResult.Datapoints
+	if len(datapoints) == 0 {
+		return 0, errors.New("fetched no datapoints")
+	}
+
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	for _, dp := range datapoints {
+		if dp.Timestamp.Before(latest) {
+			continue
+		}
+
+		latest = dp.Timestamp
+		switch statType {
+		case Sum:
+			latestVal = dp.Sum
+		case Maximum:
+			latestVal = dp.Maximum
+		}
+	}
+
+	return latestVal, nil
+}
+
+func (p NATGatewayPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	d := &cloudwatch.Dimension{
+		Name:  "NatGatewayId",
+		Value: p.NatGatewayId,
+	}
+
+	v, err := p.GetLastPoint(d, "ActiveConnectionCount", Maximum)
+	if err == nil {
+		stat["ActiveConnectionCount"] = v
+	} else {
+		common.LogFetchError("ActiveConnectionCount", err)
+	}
+
+	// SNAT port exhaustion
+	v, err = p.GetLastPoint(d, "ErrorPortAllocation", Sum)
+	if err == nil {
+		stat["ErrorPortAllocation"] = v
+	} else {
+		common.LogFetchError("ErrorPortAllocation", err)
+	}
+
+	// sums of 1 min period, converted to per second
+	for _, met := range [...]string{
+		"ConnectionEstablishedCount", "BytesInFromSource", "BytesOutToDestination", "PacketsDropCount",
+	} {
+		v, err := p.GetLastPoint(d, met, Sum)
+		if err == nil {
+			stat[met] = v / 60
+		} else {
+			common.LogFetchError(met, err)
+		}
+	}
+
+	return stat, nil
+}
+
+func (p NATGatewayPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optNatGatewayId := flag.String("nat-gateway-id", "", "NAT Gateway ID")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var natgateway NATGatewayPlugin
+
+	if *optNatGatewayId == "" {
+		log.Fatalln("-nat-gateway-id is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	natgateway.Region = region
+
+	natgateway.AccessKeyId = *optAccessKeyId
+	natgateway.SecretAccessKey = *optSecretAccessKey
+	natgateway.SessionToken = common.AWSSessionToken(*optSessionToken)
+	natgateway.NatGatewayId = *optNatGatewayId
+
+	err = natgateway.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(natgateway))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-natgateway-" + *optNatGatewayId
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,256 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+	"github.com/ziutek/mymysql/mysql"
+	_ "github.com/ziutek/mymysql/native"
+)
+
+const namespace = "AWS/RDS"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"rds-binlog.disk_usage": mp.Graphs{
+		Label: "RDS Binlog Disk Usage",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "BinLogDiskUsage", Label: "Usage"},
+		},
+	},
+}
+
+// defined only with the access to the external replica
+var replicaGraphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"rds-binlog.replica_lag": mp.Graphs{
+		Label: "RDS External Replica Lag (sec)",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "Seconds_Behind_Master", Label: "Seconds Behind Master"},
+		},
+	},
+}
+
+type StatType int
+
+const (
+	Average StatType = iota
+)
+
+func (s StatType) String() string {
+	switch s {
+	case Average:
+		return "Average"
+	}
+	return ""
+}
+
+type RDSBinlogPlugin struct {
+	Region               string
+	AccessKeyId          string
+	SecretAccessKey      string
+	SessionToken         string
+	DBInstanceIdentifier string
+	ReplicaTarget        string
+	ReplicaUsername      string
+	ReplicaPassword      string
+	CloudWatch           *cloudwatch.CloudWatch
+}
+
+func (p *RDSBinlogPlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p RDSBinlogPlugin) GetLastPoint(dimension *cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: []cloudwatch.Dimension{*dimension},
+		StartTime:  now.Add(time.Duration(180) * time.Second * -1), // 3 min (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: metricName,
+		Period:     60,
+		Statistics: []string{statType.String()},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := response.GetMetricStatisticsResult.Datapoints
+	if len(datapoints) == 0 {
+		return 0, errors.New("fetched no datapoints")
+	}
+
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	for _, dp := range datapoints {
+		if dp.Timestamp.Before(latest) {
+			continue
+		}
+
+		latest = dp.Timestamp
+		switch statType {
+		case Average:
+			latestVal = dp.Average
+		}
+	}
+
+	return latestVal, nil
+}
+
+// secondsBehindMaster parses Seconds_Behind_Master, which is NULL when the replication SQL thread is not running
+func secondsBehindMaster(value interface{}) (float64, error) {
+	if value == nil {
+		return 0, errors.New("Seconds_Behind_Master is NULL. is the replication running?")
+	}
+	var s string
+	switch v := value.(type) {
+	case []byte:
+		s = string(v)
+	case string:
+		s = v
+	default:
+		s = fmt.Sprint(v)
+	}
+	return strconv.ParseFloat(s, 64)
+}
+
+func (p RDSBinlogPlugin) fetchReplicaLag() (float64, error) {
+	db := mysql.New("tcp", "", p.ReplicaTarget, p.ReplicaUsername, p.ReplicaPassword, "")
+	db.SetTimeout(10 * time.Second)
+	if err := db.Connect(); err != nil {
+		return 0, err
+	}
+	defer db.Close()
+
+	rows, res, err := db.Query("show slave status")
+	if err != nil {
+		return 0, err
+	}
+	if len(rows) == 0 {
+		return 0, errors.New("not a replica")
+	}
+	return secondsBehindMaster(rows[0][res.Map("Seconds_Behind_Master")])
+}
+
+func (p RDSBinlogPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	d := &cloudwatch.Dimension{
+		Name:  "DBInstanceIdentifier",
+		Value: p.DBInstanceIdentifier,
+	}
+
+	// grows when binlogs are retained for replicas behind (binlog retention hours)
+	v, err := p.GetLastPoint(d, "BinLogDiskUsage", Average)
+	if err == nil {
+		stat["BinLogDiskUsage"] = v
+	} else {
+		common.LogFetchError("BinLogDiskUsage", err)
+	}
+
+	if p.ReplicaTarget != "" {
+		v, err := p.fetchReplicaLag()
+		if err == nil {
+			stat["Seconds_Behind_Master"] = v
+		} else {
+			common.LogFetchError(p.ReplicaTarget, err)
+		}
+	}
+
+	return stat, nil
+}
+
+func (p RDSBinlogPlugin) GraphDefinition() map[string](mp.Graphs) {
+	if p.ReplicaTarget == "" {
+		return graphdef
+	}
+
+	graphs := make(map[string](mp.Graphs), len(graphdef)+len(replicaGraphdef))
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	for k, v := range replicaGraphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optIdentifier := flag.String("identifier", "", "DB Instance Identifier")
+	optHost := flag.String("host", "", "Hostname of the external replica (default: not fetch the replica lag)")
+	optPort := flag.String("port", "3306", "Port of the external replica")
+	optUser := flag.String("user", "root", "Username of the external replica")
+	optPass := flag.String("password", "", "Password of the external replica")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var rds RDSBinlogPlugin
+
+	if *optIdentifier == "" {
+		log.Fatalln("-identifier is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	rds.Region = region
+
+	rds.AccessKeyId = *optAccessKeyId
+	rds.SecretAccessKey = *optSecretAccessKey
+	rds.SessionToken = common.AWSSessionToken(*optSessionToken)
+	rds.DBInstanceIdentifier = *optIdentifier
+	if *optHost != "" {
+		rds.ReplicaTarget = common.HostPort(*optHost, *optPort)
+		rds.ReplicaUsername = *optUser
+		rds.ReplicaPassword = *optPass
+	}
+
+	err = rds.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(rds))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-rds-binlog-" + *optIdentifier
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
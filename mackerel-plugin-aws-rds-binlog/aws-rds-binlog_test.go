@@ -0,0 +1,27 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSecondsBehindMaster(t *testing.T) {
+	v, err := secondsBehindMaster([]byte("42"))
+	assert.Nil(t, err)
+	assert.Equal(t, v, 42.0)
+
+	_, err = secondsBehindMaster(nil)
+	assert.NotNil(t, err)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var rds RDSBinlogPlugin
+	_, ok := rds.GraphDefinition()["rds-binlog.replica_lag"]
+	assert.False(t, ok)
+
+	rds.ReplicaTarget = "replica.example.com:3306"
+	graphs := rds.GraphDefinition()
+	assert.Equal(t, len(graphs), 2)
+	assert.Equal(t, graphs["rds-binlog.replica_lag"].Metrics[0].Name, "Seconds_Behind_Master")
+}
@@ -0,0 +1,318 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatchlogs"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// Enhanced Monitoring writes OS metrics into this log group,
+// a log stream named by the resource ID (DbiResourceId) for each DB instance
+const logGroupName = "RDSOSMetrics"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"rds_enhanced.cpu": mp.Graphs{
+		Label: "RDS OS CPU Utilization",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cpu_user", Label: "User", Stacked: true},
+			mp.Metrics{Name: "cpu_system", Label: "System", Stacked: true},
+			mp.Metrics{Name: "cpu_nice", Label: "Nice", Stacked: true},
+			mp.Metrics{Name: "cpu_wait", Label: "Wait", Stacked: true},
+			mp.Metrics{Name: "cpu_irq", Label: "IRQ", Stacked: true},
+			mp.Metrics{Name: "cpu_guest", Label: "Guest", Stacked: true},
+			mp.Metrics{Name: "cpu_steal", Label: "Steal", Stacked: true},
+			mp.Metrics{Name: "cpu_idle", Label: "Idle", Stacked: true},
+		},
+	},
+	"rds_enhanced.loadavg": mp.Graphs{
+		Label: "RDS OS Load Average",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "loadavg1", Label: "1 min"},
+			mp.Metrics{Name: "loadavg5", Label: "5 min"},
+			mp.Metrics{Name: "loadavg15", Label: "15 min"},
+		},
+	},
+	"rds_enhanced.memory": mp.Graphs{
+		Label: "RDS OS Memory",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "memory_free", Label: "Free"},
+			mp.Metrics{Name: "memory_cached", Label: "Cached"},
+			mp.Metrics{Name: "memory_buffers", Label: "Buffers"},
+			mp.Metrics{Name: "memory_total", Label: "Total"},
+		},
+	},
+
+	// "rds_enhanced.disk_iops", "rds_enhanced.disk_throughput", "rds_enhanced.network" will be generated dynamically
+}
+
+// https://docs.aws.amazon.com/AmazonRDS/latest/UserGuide/USER_Monitoring.OS.html
+// memory is in KB, and IO of disks and networks are per second
+type OSMetrics struct {
+	CPUUtilization struct {
+		Guest  float64 `json:"guest"`
+		Idle   float64 `json:"idle"`
+		Irq    float64 `json:"irq"`
+		Nice   float64 `json:"nice"`
+		Steal  float64 `json:"steal"`
+		System float64 `json:"system"`
+		User   float64 `json:"user"`
+		Wait   float64 `json:"wait"`
+	} `json:"cpuUtilization"`
+	LoadAverageMinute struct {
+		One     float64 `json:"one"`
+		Five    float64 `json:"five"`
+		Fifteen float64 `json:"fifteen"`
+	} `json:"loadAverageMinute"`
+	Memory struct {
+		Free    float64 `json:"free"`
+		Cached  float64 `json:"cached"`
+		Buffers float64 `json:"buffers"`
+		Total   float64 `json:"total"`
+	} `json:"memory"`
+	DiskIO []struct {
+		Device     string  `json:"device"`
+		ReadIOsPS  float64 `json:"readIOsPS"`
+		WriteIOsPS float64 `json:"writeIOsPS"`
+		ReadKbPS   float64 `json:"readKbPS"`
+		WriteKbPS  float64 `json:"writeKbPS"`
+	} `json:"diskIO"`
+	Network []struct {
+		Interface string  `json:"interface"`
+		Rx        float64 `json:"rx"`
+		Tx        float64 `json:"tx"`
+	} `json:"network"`
+}
+
+type RDSEnhancedPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	ResourceId      string
+	Disks           []string
+	Interfaces      []string
+	CloudWatchLogs  *cloudwatchlogs.CloudWatchLogs
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// diskName returns the name of i-th disk. Aurora reports no device names.
+func diskName(device string, i int) string {
+	if device == "" {
+		return "disk" + strconv.Itoa(i)
+	}
+	return metricName(device)
+}
+
+func parseOSMetrics(message string, stat map[string]float64) ([]string, []string, error) {
+	var m OSMetrics
+	if err := json.Unmarshal([]byte(message), &m); err != nil {
+		return nil, nil, err
+	}
+
+	stat["cpu_guest"] = m.CPUUtilization.Guest
+	stat["cpu_idle"] = m.CPUUtilization.Idle
+	stat["cpu_irq"] = m.CPUUtilization.Irq
+	stat["cpu_nice"] = m.CPUUtilization.Nice
+	stat["cpu_steal"] = m.CPUUtilization.Steal
+	stat["cpu_system"] = m.CPUUtilization.System
+	stat["cpu_user"] = m.CPUUtilization.User
+	stat["cpu_wait"] = m.CPUUtilization.Wait
+
+	stat["loadavg1"] = m.LoadAverageMinute.One
+	stat["loadavg5"] = m.LoadAverageMinute.Five
+	stat["loadavg15"] = m.LoadAverageMinute.Fifteen
+
+	stat["memory_free"] = m.Memory.Free * 1024
+	stat["memory_cached"] = m.Memory.Cached * 1024
+	stat["memory_buffers"] = m.Memory.Buffers * 1024
+	stat["memory_total"] = m.Memory.Total * 1024
+
+	disks := make([]string, 0, len(m.DiskIO))
+	for i, d := range m.DiskIO {
+		name := diskName(d.Device, i)
+		disks = append(disks, name)
+		stat["disk_"+name+"_read_iops"] = d.ReadIOsPS
+		stat["disk_"+name+"_write_iops"] = d.WriteIOsPS
+		stat["disk_"+name+"_read_bytes"] = d.ReadKbPS * 1024
+		stat["disk_"+name+"_write_bytes"] = d.WriteKbPS * 1024
+	}
+
+	interfaces := make([]string, 0, len(m.Network))
+	for _, n := range m.Network {
+		name := metricName(n.Interface)
+		interfaces = append(interfaces, name)
+		stat["network_"+name+"_rx"] = n.Rx
+		stat["network_"+name+"_tx"] = n.Tx
+	}
+
+	return disks, interfaces, nil
+}
+
+func (p *RDSEnhancedPlugin) Prepare() error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	config := aws.NewConfig().WithRegion(p.Region)
+	if p.AccessKeyId != "" && p.SecretAccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyId, p.SecretAccessKey, p.SessionToken))
+	}
+
+	p.CloudWatchLogs = cloudwatchlogs.New(sess, config)
+
+	message, err := p.latestMessage()
+	if err != nil {
+		return err
+	}
+	p.Disks, p.Interfaces, err = parseOSMetrics(message, make(map[string]float64))
+	return err
+}
+
+// latestMessage returns the most recent log event, which Enhanced Monitoring writes every granularity seconds
+func (p RDSEnhancedPlugin) latestMessage() (string, error) {
+	out, err := p.CloudWatchLogs.GetLogEvents(&cloudwatchlogs.GetLogEventsInput{
+		LogGroupName:  aws.String(logGroupName),
+		LogStreamName: aws.String(p.ResourceId),
+		StartFromHead: aws.Bool(false),
+		Limit:         aws.Int64(1),
+	})
+	if err != nil {
+		return "", err
+	}
+	if len(out.Events) == 0 {
+		return "", errors.New("fetched no log events. is Enhanced Monitoring enabled?")
+	}
+	return aws.StringValue(out.Events[len(out.Events)-1].Message), nil
+}
+
+func (p RDSEnhancedPlugin) FetchMetrics() (map[string]float64, error) {
+	message, err := p.latestMessage()
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	if _, _, err := parseOSMetrics(message, stat); err != nil {
+		return nil, err
+	}
+
+	return stat, nil
+}
+
+func (p RDSEnhancedPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), len(graphdef)+3)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	var iops, throughput, network [](mp.Metrics)
+	for _, d := range p.Disks {
+		iops = append(iops,
+			mp.Metrics{Name: "disk_" + d + "_read_iops", Label: d + " Read"},
+			mp.Metrics{Name: "disk_" + d + "_write_iops", Label: d + " Write"},
+		)
+		throughput = append(throughput,
+			mp.Metrics{Name: "disk_" + d + "_read_bytes", Label: d + " Read"},
+			mp.Metrics{Name: "disk_" + d + "_write_bytes", Label: d + " Write"},
+		)
+	}
+	for _, n := range p.Interfaces {
+		network = append(network,
+			mp.Metrics{Name: "network_" + n + "_rx", Label: n + " RX"},
+			mp.Metrics{Name: "network_" + n + "_tx", Label: n + " TX"},
+		)
+	}
+
+	if len(iops) > 0 {
+		graphs["rds_enhanced.disk_iops"] = mp.Graphs{
+			Label:   "RDS OS Disk IOPS",
+			Unit:    "iops",
+			Metrics: iops,
+		}
+		graphs["rds_enhanced.disk_throughput"] = mp.Graphs{
+			Label:   "RDS OS Disk Throughput",
+			Unit:    "bytes/sec",
+			Metrics: throughput,
+		}
+	}
+	if len(network) > 0 {
+		graphs["rds_enhanced.network"] = mp.Graphs{
+			Label:   "RDS OS Network",
+			Unit:    "bytes/sec",
+			Metrics: network,
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optResourceId := flag.String("resource-id", "", "Resource ID (DbiResourceId) of the DB instance")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var rds RDSEnhancedPlugin
+
+	if *optResourceId == "" {
+		log.Fatalln("-resource-id is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	rds.Region = region
+
+	rds.AccessKeyId = *optAccessKeyId
+	rds.SecretAccessKey = *optSecretAccessKey
+	rds.SessionToken = common.AWSSessionToken(*optSessionToken)
+	rds.ResourceId = *optResourceId
+
+	err = rds.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(rds))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-rds-enhanced-" + *optResourceId
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseOSMetrics(t *testing.T) {
+	stub := `{"engine":"MYSQL","instanceID":"mydb","instanceResourceID":"db-ABCDEFGHIJKLMNOPQRSTUVWXYZ","timestamp":"2015-01-01T00:00:00Z","version":1,"uptime":"10 days, 1:00:00","numVCPUs":2,
+"cpuUtilization":{"guest":0.0,"irq":0.02,"system":1.5,"wait":0.4,"idle":90.0,"user":6.0,"total":10.0,"steal":2.08,"nice":0.0},
+"loadAverageMinute":{"fifteen":0.15,"five":0.5,"one":1.25},
+"memory":{"writeback":0,"hugePagesFree":0,"hugePagesRsvd":0,"hugePagesSurp":0,"cached":1024,"hugePagesSize":2048,"free":2048,"hugePagesTotal":0,"inactive":100,"pageTables":10,"dirty":1,"mapped":100,"active":500,"total":4096,"slab":50,"buffers":512},
+"diskIO":[{"writeKbPS":16.0,"readIOsPS":1.5,"await":0.5,"readKbPS":8.0,"rrqmPS":0,"util":1.2,"avgQueueLen":0.01,"tps":5,"readKb":100,"device":"rdsdev","writeKb":200,"avgReqSz":8,"wrqmPS":0,"writeIOsPS":3.5}],
+"network":[{"interface":"eth0","rx":1000.5,"tx":2000.5}]}`
+
+	stat := make(map[string]float64)
+	disks, interfaces, err := parseOSMetrics(stub, stat)
+	assert.Nil(t, err)
+	assert.Equal(t, disks, []string{"rdsdev"})
+	assert.Equal(t, interfaces, []string{"eth0"})
+	assert.Equal(t, stat["cpu_steal"], 2.08)
+	assert.Equal(t, stat["cpu_idle"], 90.0)
+	assert.Equal(t, stat["loadavg1"], 1.25)
+	assert.Equal(t, stat["memory_free"], 2048.0*1024)
+	assert.Equal(t, stat["memory_buffers"], 512.0*1024)
+	assert.Equal(t, stat["disk_rdsdev_read_iops"], 1.5)
+	assert.Equal(t, stat["disk_rdsdev_write_bytes"], 16.0*1024)
+	assert.Equal(t, stat["network_eth0_tx"], 2000.5)
+}
+
+func TestParseOSMetricsAurora(t *testing.T) {
+	// Aurora reports no device names of disks
+	stub := `{"engine":"Aurora","cpuUtilization":{"idle":99.0},"diskIO":[{"readIOsPS":1.0,"writeIOsPS":2.0},{"readIOsPS":3.0,"writeIOsPS":4.0}],"network":[]}`
+
+	stat := make(map[string]float64)
+	disks, interfaces, err := parseOSMetrics(stub, stat)
+	assert.Nil(t, err)
+	assert.Equal(t, disks, []string{"disk0", "disk1"})
+	assert.Equal(t, len(interfaces), 0)
+	assert.Equal(t, stat["disk_disk1_write_iops"], 4.0)
+}
+
+func TestGraphDefinitionWithoutDisks(t *testing.T) {
+	var rds RDSEnhancedPlugin
+
+	graphs := rds.GraphDefinition()
+	for name, graph := range graphs {
+		assert.NotEmpty(t, graph.Metrics, name)
+	}
+}
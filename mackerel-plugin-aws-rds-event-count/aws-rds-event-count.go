@@ -0,0 +1,166 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/rds"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// event categories counted separately. the others (e.g. configuration change) are counted as other
+var categories = []string{"failover", "failure", "maintenance", "notification", "backup"}
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"rds.events": mp.Graphs{
+		Label: "RDS Events",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "events_failover", Label: "Failover"},
+			mp.Metrics{Name: "events_failure", Label: "Failure"},
+			mp.Metrics{Name: "events_maintenance", Label: "Maintenance"},
+			mp.Metrics{Name: "events_notification", Label: "Notification"},
+			mp.Metrics{Name: "events_backup", Label: "Backup"},
+			mp.Metrics{Name: "events_other", Label: "Other"},
+		},
+	},
+	"rds.events_total": mp.Graphs{
+		Label: "RDS Events Total",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "events_total", Label: "Total"},
+		},
+	},
+}
+
+type RDSEventCountPlugin struct {
+	Region           string
+	AccessKeyId      string
+	SecretAccessKey  string
+	SessionToken     string
+	SourceIdentifier string
+	SourceType       string
+	Window           int64
+	RDS              *rds.RDS
+}
+
+func (p *RDSEventCountPlugin) Prepare() error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	config := aws.NewConfig().WithRegion(p.Region)
+	if p.AccessKeyId != "" && p.SecretAccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyId, p.SecretAccessKey, p.SessionToken))
+	}
+
+	p.RDS = rds.New(sess, config)
+	return nil
+}
+
+// countEvents counts the events by category. an event of several categories
+// is counted in each of them, and once in the total
+func countEvents(events []*rds.Event) map[string]float64 {
+	known := make(map[string]bool)
+	stat := map[string]float64{"events_other": 0, "events_total": float64(len(events))}
+	for _, c := range categories {
+		known[c] = true
+		stat["events_"+c] = 0
+	}
+
+	for _, e := range events {
+		other := len(e.EventCategories) == 0
+		for _, c := range e.EventCategories {
+			if known[aws.StringValue(c)] {
+				stat["events_"+aws.StringValue(c)]++
+			} else {
+				other = true
+			}
+		}
+		if other {
+			stat["events_other"]++
+		}
+	}
+	return stat
+}
+
+func (p RDSEventCountPlugin) FetchMetrics() (map[string]float64, error) {
+	var events []*rds.Event
+	err := p.RDS.DescribeEventsPages(&rds.DescribeEventsInput{
+		SourceIdentifier: aws.String(p.SourceIdentifier),
+		SourceType:       aws.String(p.SourceType),
+		Duration:         aws.Int64(p.Window),
+	}, func(page *rds.DescribeEventsOutput, lastPage bool) bool {
+		events = append(events, page.Events...)
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return countEvents(events), nil
+}
+
+func (p RDSEventCountPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optSourceIdentifier := flag.String("source-identifier", "", "DB Instance (or DB Cluster) Identifier")
+	optSourceType := flag.String("source-type", rds.SourceTypeDbInstance, "Source type of the events (db-instance or db-cluster)")
+	optWindow := flag.Int64("window", 5, "Window (min) to count the events in")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var events RDSEventCountPlugin
+
+	if *optSourceIdentifier == "" {
+		log.Fatalln("-source-identifier is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	events.Region = region
+
+	events.AccessKeyId = *optAccessKeyId
+	events.SecretAccessKey = *optSecretAccessKey
+	events.SessionToken = common.AWSSessionToken(*optSessionToken)
+	events.SourceIdentifier = *optSourceIdentifier
+	events.SourceType = *optSourceType
+	events.Window = *optWindow
+
+	err = events.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(events))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-aws-rds-event-count-" + *optSourceIdentifier
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
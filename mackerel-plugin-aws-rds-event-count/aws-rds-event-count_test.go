@@ -0,0 +1,30 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/rds"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountEvents(t *testing.T) {
+	events := []*rds.Event{
+		&rds.Event{EventCategories: []*string{aws.String("failover")}, Message: aws.String("Multi-AZ instance failover started.")},
+		&rds.Event{EventCategories: []*string{aws.String("failover"), aws.String("availability")}, Message: aws.String("Multi-AZ instance failover completed.")},
+		&rds.Event{EventCategories: []*string{aws.String("backup")}, Message: aws.String("Backing up DB instance")},
+		&rds.Event{EventCategories: []*string{aws.String("configuration change")}, Message: aws.String("Applying modification to database instance class")},
+		&rds.Event{Message: aws.String("no categories")},
+	}
+
+	stat := countEvents(events)
+	assert.EqualValues(t, stat["events_total"], 5)
+	assert.EqualValues(t, stat["events_failover"], 2)
+	assert.EqualValues(t, stat["events_backup"], 1)
+	assert.EqualValues(t, stat["events_maintenance"], 0)
+	assert.EqualValues(t, stat["events_other"], 3)
+
+	stat = countEvents(nil)
+	assert.EqualValues(t, stat["events_total"], 0)
+	assert.EqualValues(t, stat["events_failover"], 0)
+}
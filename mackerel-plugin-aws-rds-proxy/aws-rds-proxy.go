@@ -0,0 +1,205 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/RDS"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"rds-proxy.connections": mp.Graphs{
+		Label: "RDS Proxy Connections",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "ClientConnections", Label: "Client"},
+			mp.Metrics{Name: "DatabaseConnections", Label: "Database"},
+			mp.Metrics{Name: "DatabaseConnectionsCurrentlyBorrowed", Label: "Currently Borrowed"},
+		},
+	},
+	"rds-proxy.latency": mp.Graphs{
+		Label: "RDS Proxy Latency (usec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "DatabaseConnectionsBorrowLatency", Label: "Borrow"},
+			mp.Metrics{Name: "QueryDatabaseResponseLatency", Label: "Query Response"},
+		},
+	},
+}
+
+type StatType int
+
+const (
+	Average StatType = iota
+	Sum
+)
+
+func (s StatType) String() string {
+	switch s {
+	case Average:
+		return "Average"
+	case Sum:
+		return "Sum"
+	}
+	return ""
+}
+
+type RDSProxyPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	DBProxyName     string
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+func (p *RDSProxyPlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (p RDSProxyPlugin) GetLastPoint(dimension *cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: []cloudwatch.Dimension{*dimension},
+		StartTime:  now.Add(time.Duration(180) * time.Second * -1), // 3 min (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: metricName,
+		Period:     60,
+		Statistics: []string{statType.String()},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := response.GetMetricStatisticsResult.Datapoints
+	if len(datapoints) == 0 {
+		return 0, errors.New("fetched no datapoints")
+	}
+
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	for _, dp := range datapoints {
+		if dp.Timestamp.Before(latest) {
+			continue
+		}
+
+		latest = dp.Timestamp
+		switch statType {
+		case Average:
+			latestVal = dp.Average
+		case Sum:
+			latestVal = dp.Sum
+		}
+	}
+
+	return latestVal, nil
+}
+
+func (p RDSProxyPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	d := &cloudwatch.Dimension{
+		Name:  "ProxyName",
+		Value: p.DBProxyName,
+	}
+
+	// connection counts are reported once a minute
+	for _, met := range [...]string{
+		"ClientConnections", "DatabaseConnections", "DatabaseConnectionsCurrentlyBorrowed",
+	} {
+		v, err := p.GetLastPoint(d, met, Sum)
+		if err == nil {
+			stat[met] = v
+		} else {
+			common.LogFetchError(met, err)
+		}
+	}
+
+	// borrow latency grows when the connection pool is exhausted
+	for _, met := range [...]string{
+		"DatabaseConnectionsBorrowLatency", "QueryDatabaseResponseLatency",
+	} {
+		v, err := p.GetLastPoint(d, met, Average)
+		if err == nil {
+			stat[met] = v
+		} else {
+			common.LogFetchError(met, err)
+		}
+	}
+
+	return stat, nil
+}
+
+func (p RDSProxyPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optDBProxyName := flag.String("db-proxy-name", "", "DB Proxy Name")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var proxy RDSProxyPlugin
+
+	if *optDBProxyName == "" {
+		log.Fatalln("-db-proxy-name is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	proxy.Region = region
+
+	proxy.AccessKeyId = *optAccessKeyId
+	proxy.SecretAccessKey = *optSecretAccessKey
+	proxy.SessionToken = common.AWSSessionToken(*optSessionToken)
+	proxy.DBProxyName = *optDBProxyName
+
+	err = proxy.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(proxy))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-rds-proxy-" + *optDBProxyName
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
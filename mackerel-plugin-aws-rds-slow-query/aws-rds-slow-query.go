@@ -0,0 +1,255 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/pi"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// db.load.avg is the average active sessions (AAS) of the DB instance
+const (
+	loadMetric  = "db.load.avg"
+	vcpusMetric = "os.general.numVCPUs.avg"
+
+	waitEventTypeGroup = "db.wait_event_type"
+	waitEventTypeName  = "db.wait_event_type.name"
+	sqlTokenizedGroup  = "db.sql_tokenized"
+	sqlTokenizedId     = "db.sql_tokenized.id"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"rds_pi.load": mp.Graphs{
+		Label: "RDS DB Load (AAS)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "load_total", Label: "Total"},
+			mp.Metrics{Name: "vcpus", Label: "vCPUs"},
+		},
+	},
+	"rds_pi.top_sql": mp.Graphs{
+		Label: "RDS DB Load of Top SQL (AAS)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "top_sql_load", Label: "Top SQL"},
+		},
+	},
+
+	// "rds_pi.wait_event_type" will be generated dynamically
+}
+
+type RDSPerformanceInsightsPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	ResourceId      string
+	WaitEventTypes  []string
+	PI              *pi.PI
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// latestValue returns the most recent value. the datapoints without values are
+// the periods in which no sessions were sampled.
+func latestValue(datapoints []*pi.DataPoint) (float64, bool) {
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	var found bool
+	for _, dp := range datapoints {
+		if dp.Value == nil || aws.TimeValue(dp.Timestamp).Before(latest) {
+			continue
+		}
+		latest = aws.TimeValue(dp.Timestamp)
+		latestVal = aws.Float64Value(dp.Value)
+		found = true
+	}
+	return latestVal, found
+}
+
+// parseResourceMetrics sets the values of the response, and returns the wait event types found.
+// each grouped query returns the total (without dimensions) and the values of the groups.
+func parseResourceMetrics(out *pi.GetResourceMetricsOutput, stat map[string]float64) []string {
+	var waitEventTypes []string
+	for _, m := range out.MetricList {
+		if m.Key == nil {
+			continue
+		}
+		v, ok := latestValue(m.DataPoints)
+
+		switch aws.StringValue(m.Key.Metric) {
+		case vcpusMetric:
+			if ok {
+				stat["vcpus"] = v
+			}
+		case loadMetric:
+			if t, isWait := m.Key.Dimensions[waitEventTypeName]; isWait {
+				name := metricName(aws.StringValue(t))
+				waitEventTypes = append(waitEventTypes, name)
+				stat["load_wait_"+name] = v
+			} else if _, isSQL := m.Key.Dimensions[sqlTokenizedId]; isSQL {
+				// the groups are sorted by the load, and limited to the top one
+				if v > stat["top_sql_load"] {
+					stat["top_sql_load"] = v
+				}
+			} else {
+				stat["load_total"] = v
+			}
+		}
+	}
+	return waitEventTypes
+}
+
+func (p RDSPerformanceInsightsPlugin) getResourceMetrics(window time.Duration, period int64) (*pi.GetResourceMetricsOutput, error) {
+	now := time.Now()
+
+	return p.PI.GetResourceMetrics(&pi.GetResourceMetricsInput{
+		ServiceType:     aws.String(pi.ServiceTypeRds),
+		Identifier:      aws.String(p.ResourceId),
+		StartTime:       aws.Time(now.Add(window * -1)),
+		EndTime:         aws.Time(now),
+		PeriodInSeconds: aws.Int64(period),
+		MetricQueries: []*pi.MetricQuery{
+			&pi.MetricQuery{
+				Metric:  aws.String(loadMetric),
+				GroupBy: &pi.DimensionGroup{Group: aws.String(waitEventTypeGroup), Limit: aws.Int64(25)},
+			},
+			&pi.MetricQuery{
+				Metric:  aws.String(loadMetric),
+				GroupBy: &pi.DimensionGroup{Group: aws.String(sqlTokenizedGroup), Limit: aws.Int64(1)},
+			},
+			// available only with Enhanced Monitoring
+			&pi.MetricQuery{
+				Metric: aws.String(vcpusMetric),
+			},
+		},
+	})
+}
+
+func (p *RDSPerformanceInsightsPlugin) Prepare() error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	config := aws.NewConfig().WithRegion(p.Region)
+	if p.AccessKeyId != "" && p.SecretAccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyId, p.SecretAccessKey, p.SessionToken))
+	}
+
+	p.PI = pi.New(sess, config)
+
+	// wait event types differ by the engine, discover ones seen in the last hour
+	out, err := p.getResourceMetrics(time.Hour, 300)
+	if err != nil {
+		return err
+	}
+	p.WaitEventTypes = parseResourceMetrics(out, make(map[string]float64))
+
+	return nil
+}
+
+func (p RDSPerformanceInsightsPlugin) FetchMetrics() (map[string]float64, error) {
+	out, err := p.getResourceMetrics(time.Duration(300)*time.Second, 60)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	// the wait event types without sessions are not returned
+	for _, name := range p.WaitEventTypes {
+		stat["load_wait_"+name] = 0
+	}
+	stat["top_sql_load"] = 0
+	parseResourceMetrics(out, stat)
+
+	if _, ok := stat["load_total"]; !ok {
+		log.Printf("%s: fetched no datapoints", loadMetric)
+	}
+
+	return stat, nil
+}
+
+func (p RDSPerformanceInsightsPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), len(graphdef)+1)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	var metrics [](mp.Metrics)
+	for _, name := range p.WaitEventTypes {
+		metrics = append(metrics, mp.Metrics{Name: "load_wait_" + name, Label: name, Stacked: true})
+	}
+	if len(metrics) > 0 {
+		graphs["rds_pi.wait_event_type"] = mp.Graphs{
+			Label:   "RDS DB Load by Wait Event Type (AAS)",
+			Unit:    "float",
+			Metrics: metrics,
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optResourceId := flag.String("resource-id", "", "Resource ID (DbiResourceId) of the DB instance")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var rds RDSPerformanceInsightsPlugin
+
+	if *optResourceId == "" {
+		log.Fatalln("-resource-id is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	rds.Region = region
+
+	rds.AccessKeyId = *optAccessKeyId
+	rds.SecretAccessKey = *optSecretAccessKey
+	rds.SessionToken = common.AWSSessionToken(*optSessionToken)
+	rds.ResourceId = *optResourceId
+
+	err = rds.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(rds))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-rds-slow-query-" + *optResourceId
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/pi"
+	"github.com/stretchr/testify/assert"
+)
+
+func metricKeyDataPoints(metric string, dims map[string]string, values ...float64) *pi.MetricKeyDataPoints {
+	key := &pi.ResponseResourceMetricKey{Metric: aws.String(metric)}
+	if dims != nil {
+		key.Dimensions = make(map[string]*string)
+		for k, v := range dims {
+			key.Dimensions[k] = aws.String(v)
+		}
+	}
+	var dps []*pi.DataPoint
+	t := time.Unix(1420070400, 0)
+	for _, v := range values {
+		dps = append(dps, &pi.DataPoint{Timestamp: aws.Time(t), Value: aws.Float64(v)})
+		t = t.Add(time.Minute)
+	}
+	// the last period without samples
+	dps = append(dps, &pi.DataPoint{Timestamp: aws.Time(t)})
+	return &pi.MetricKeyDataPoints{Key: key, DataPoints: dps}
+}
+
+func TestParseResourceMetrics(t *testing.T) {
+	out := &pi.GetResourceMetricsOutput{
+		MetricList: []*pi.MetricKeyDataPoints{
+			metricKeyDataPoints("db.load.avg", nil, 2.5, 3.0),
+			metricKeyDataPoints("db.load.avg", map[string]string{"db.wait_event_type.name": "CPU"}, 2.0, 2.25),
+			metricKeyDataPoints("db.load.avg", map[string]string{"db.wait_event_type.name": "IO"}, 0.5, 0.75),
+			metricKeyDataPoints("db.load.avg", nil, 2.5, 3.0),
+			metricKeyDataPoints("db.load.avg", map[string]string{"db.sql_tokenized.id": "AKIAI", "db.sql_tokenized.statement": "SELECT ?"}, 1.0, 1.5),
+			metricKeyDataPoints("os.general.numVCPUs.avg", nil, 2.0),
+		},
+	}
+
+	stat := make(map[string]float64)
+	waitEventTypes := parseResourceMetrics(out, stat)
+	assert.Equal(t, waitEventTypes, []string{"CPU", "IO"})
+	assert.Equal(t, stat["load_total"], 3.0)
+	assert.Equal(t, stat["load_wait_CPU"], 2.25)
+	assert.Equal(t, stat["load_wait_IO"], 0.75)
+	assert.Equal(t, stat["top_sql_load"], 1.5)
+	assert.Equal(t, stat["vcpus"], 2.0)
+}
+
+func TestParseResourceMetricsWithoutVCPUs(t *testing.T) {
+	out := &pi.GetResourceMetricsOutput{
+		MetricList: []*pi.MetricKeyDataPoints{
+			metricKeyDataPoints("db.load.avg", nil, 0.5),
+			metricKeyDataPoints("os.general.numVCPUs.avg", nil),
+		},
+	}
+
+	stat := make(map[string]float64)
+	parseResourceMetrics(out, stat)
+	assert.Equal(t, stat["load_total"], 0.5)
+	_, ok := stat["vcpus"]
+	assert.False(t, ok)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var rds RDSPerformanceInsightsPlugin
+	_, ok := rds.GraphDefinition()["rds_pi.wait_event_type"]
+	assert.False(t, ok)
+
+	rds.WaitEventTypes = []string{"CPU", "Lock"}
+	graphs := rds.GraphDefinition()
+	assert.Equal(t, graphs["rds_pi.wait_event_type"].Metrics[1].Name, "load_wait_Lock")
+	assert.Equal(t, len(graphs["rds_pi.load"].Metrics), 2)
+}
@@ -0,0 +1,281 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// the days reported when the free space is not decreasing, so that the metric has no gaps for alerting
+const maxDaysUntilFull = 365
+
+var errNoDatapoints = errors.New("fetched no datapoints")
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"rds_storage.free": mp.Graphs{
+		Label: "RDS Free Storage Space",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "FreeStorageSpace", Label: "Free"},
+		},
+	},
+}
+
+// graphs of the forecast, with -forecast
+var forecastGraphs map[string](mp.Graphs) = map[string](mp.Graphs){
+	"rds_storage.days_until_full": mp.Graphs{
+		Label: "RDS Days until Storage Full",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "DaysUntilFull", Label: "Days until Full"},
+		},
+	},
+	"rds_storage.change": mp.Graphs{
+		Label: "RDS Free Storage Space Change per Day",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "FreeStorageSpaceChangePerDay", Label: "Change per Day"},
+		},
+	},
+}
+
+type StorageForecastPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Identifier      string
+	Forecast        bool
+	Lookback        time.Duration
+	Period          int64
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+type datapoint struct {
+	t time.Time
+	v float64
+}
+
+type byTime []datapoint
+
+func (d byTime) Len() int           { return len(d) }
+func (d byTime) Swap(i, j int)      { d[i], d[j] = d[j], d[i] }
+func (d byTime) Less(i, j int) bool { return d[i].t.Before(d[j].t) }
+
+func (p *StorageForecastPlugin) Prepare() error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	config := aws.NewConfig().WithRegion(p.Region)
+	if p.AccessKeyId != "" && p.SecretAccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyId, p.SecretAccessKey, p.SessionToken))
+	}
+
+	p.CloudWatch = cloudwatch.New(sess, config)
+	return nil
+}
+
+// datapoints returns the datapoints of the results in the order of time
+func datapoints(results []*cloudwatch.MetricDataResult) []datapoint {
+	var points []datapoint
+	for _, r := range results {
+		for i, ts := range r.Timestamps {
+			if i >= len(r.Values) || ts == nil || r.Values[i] == nil {
+				continue
+			}
+			points = append(points, datapoint{*ts, *r.Values[i]})
+		}
+	}
+	sort.Sort(byTime(points))
+	return points
+}
+
+// linearTrend returns the slope (per sec) of the values by the least squares.
+// It is not defined (false) with less than 2 datapoints or without the time span
+func linearTrend(points []datapoint) (float64, bool) {
+	if len(points) < 2 {
+		return 0, false
+	}
+	n := float64(len(points))
+	var sx, sy, sxx, sxy float64
+	for _, p := range points {
+		x := p.t.Sub(points[0].t).Seconds()
+		sx += x
+		sy += p.v
+		sxx += x * x
+		sxy += x * p.v
+	}
+	d := n*sxx - sx*sx
+	if d == 0 {
+		return 0, false
+	}
+	return (n*sxy - sx*sy) / d, true
+}
+
+// daysUntilFull returns the days until the free space runs out at the slope (per sec).
+// It is maxDaysUntilFull when the free space is not decreasing, or further than that
+func daysUntilFull(free, slope float64) float64 {
+	if slope >= 0 {
+		return maxDaysUntilFull
+	}
+	days := free / -slope / 86400
+	if days > maxDaysUntilFull {
+		return maxDaysUntilFull
+	}
+	if days < 0 {
+		return 0
+	}
+	return days
+}
+
+func (p StorageForecastPlugin) fetchDatapoints(lookback time.Duration) ([]datapoint, error) {
+	now := time.Now()
+	input := &cloudwatch.GetMetricDataInput{
+		StartTime: aws.Time(now.Add(-lookback)),
+		EndTime:   aws.Time(now),
+		MetricDataQueries: []*cloudwatch.MetricDataQuery{
+			&cloudwatch.MetricDataQuery{
+				Id: aws.String("m1"),
+				MetricStat: &cloudwatch.MetricStat{
+					Metric: &cloudwatch.Metric{
+						Namespace:  aws.String("AWS/RDS"),
+						MetricName: aws.String("FreeStorageSpace"),
+						Dimensions: []*cloudwatch.Dimension{
+							&cloudwatch.Dimension{Name: aws.String("DBInstanceIdentifier"), Value: aws.String(p.Identifier)},
+						},
+					},
+					Period: aws.Int64(p.Period),
+					Stat:   aws.String("Average"),
+				},
+				ReturnData: aws.Bool(true),
+			},
+		},
+	}
+
+	var results []*cloudwatch.MetricDataResult
+	for {
+		ret, err := p.CloudWatch.GetMetricData(input)
+		if err != nil {
+			return nil, err
+		}
+		results = append(results, ret.MetricDataResults...)
+		if ret.NextToken == nil {
+			break
+		}
+		input.NextToken = ret.NextToken
+	}
+
+	points := datapoints(results)
+	if len(points) == 0 {
+		return nil, errNoDatapoints
+	}
+	return points, nil
+}
+
+func (p StorageForecastPlugin) FetchMetrics() (map[string]float64, error) {
+	// a few periods for the gauge only
+	lookback := time.Duration(3*p.Period) * time.Second
+	if p.Forecast {
+		lookback = p.Lookback
+	}
+	points, err := p.fetchDatapoints(lookback)
+	if err != nil {
+		return nil, err
+	}
+
+	free := points[len(points)-1].v
+	stat := map[string]float64{"FreeStorageSpace": free}
+	if !p.Forecast {
+		return stat, nil
+	}
+
+	// a sudden change (e.g. deleted data or extended storage) is averaged into the trend over the lookback
+	if slope, ok := linearTrend(points); ok {
+		stat["FreeStorageSpaceChangePerDay"] = slope * 86400
+		stat["DaysUntilFull"] = daysUntilFull(free, slope)
+	} else {
+		log.Printf("too few datapoints (%d) in the lookback to forecast", len(points))
+	}
+	return stat, nil
+}
+
+func (p StorageForecastPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs))
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	if p.Forecast {
+		for k, v := range forecastGraphs {
+			graphs[k] = v
+		}
+	}
+	return graphs
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optIdentifier := flag.String("identifier", "", "DB Instance Identifier")
+	optForecast := flag.Bool("forecast", false, "Forecast the days until the storage is full by the trend of FreeStorageSpace")
+	optLookback := flag.Int("lookback", 24, "Hours of FreeStorageSpace history to forecast by")
+	optPeriod := flag.Int64("period", 300, "Period (sec) of the datapoints")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var forecast StorageForecastPlugin
+
+	if *optIdentifier == "" {
+		log.Fatalln("-identifier is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	forecast.Region = region
+
+	forecast.AccessKeyId = *optAccessKeyId
+	forecast.SecretAccessKey = *optSecretAccessKey
+	forecast.SessionToken = common.AWSSessionToken(*optSessionToken)
+	forecast.Identifier = *optIdentifier
+	forecast.Forecast = *optForecast
+	forecast.Lookback = time.Duration(*optLookback) * time.Hour
+	forecast.Period = *optPeriod
+
+	err = forecast.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(forecast))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-rds-storage-forecast-" + *optIdentifier
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
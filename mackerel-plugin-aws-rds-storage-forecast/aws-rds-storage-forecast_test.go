@@ -0,0 +1,57 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDatapoints(t *testing.T) {
+	now := time.Now().Truncate(time.Minute)
+	results := []*cloudwatch.MetricDataResult{
+		&cloudwatch.MetricDataResult{
+			Id:         aws.String("m1"),
+			Timestamps: []*time.Time{aws.Time(now), aws.Time(now.Add(-10 * time.Minute))},
+			Values:     []*float64{aws.Float64(100), aws.Float64(120)},
+		},
+		// a page continued by NextToken
+		&cloudwatch.MetricDataResult{
+			Id:         aws.String("m1"),
+			Timestamps: []*time.Time{aws.Time(now.Add(-5 * time.Minute))},
+			Values:     []*float64{aws.Float64(110)},
+		},
+	}
+
+	points := datapoints(results)
+	assert.Equal(t, len(points), 3)
+	assert.Equal(t, points[0].v, 120.0)
+	assert.Equal(t, points[2].v, 100.0)
+}
+
+func TestLinearTrend(t *testing.T) {
+	now := time.Now()
+	points := []datapoint{
+		{now, 1000},
+		{now.Add(100 * time.Second), 900},
+		{now.Add(200 * time.Second), 800},
+	}
+	slope, ok := linearTrend(points)
+	assert.True(t, ok)
+	assert.InDelta(t, slope, -1, 0.0001)
+
+	_, ok = linearTrend(points[:1])
+	assert.False(t, ok)
+	_, ok = linearTrend([]datapoint{{now, 1}, {now, 2}})
+	assert.False(t, ok, "no time span")
+}
+
+func TestDaysUntilFull(t *testing.T) {
+	// 10 GB free, decreasing 1 GB per day
+	assert.InDelta(t, daysUntilFull(10e9, -1e9/86400), 10, 0.0001)
+	assert.Equal(t, daysUntilFull(10e9, 0), float64(maxDaysUntilFull))
+	assert.Equal(t, daysUntilFull(10e9, 1), float64(maxDaysUntilFull))
+	assert.Equal(t, daysUntilFull(10e9, -1), float64(maxDaysUntilFull), "further than the max")
+}
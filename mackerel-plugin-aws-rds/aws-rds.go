@@ -6,6 +6,7 @@ import (
 	"github.com/crowdmob/goamz/aws"
 	"github.com/crowdmob/goamz/cloudwatch"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"log"
 	"os"
 	"time"
@@ -76,6 +77,7 @@ type RDSPlugin struct {
 	Region          string
 	AccessKeyId     string
 	SecretAccessKey string
+	SessionToken    string
 	Identifier      string
 }
 
@@ -115,7 +117,7 @@ func GetLastPoint(cloudWatch *cloudwatch.CloudWatch, dimension *cloudwatch.Dimen
 }
 
 func (p RDSPlugin) FetchMetrics() (map[string]float64, error) {
-	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, "", time.Now())
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
 	if err != nil {
 		return nil, err
 	}
@@ -141,7 +143,7 @@ func (p RDSPlugin) FetchMetrics() (map[string]float64, error) {
 		if err == nil {
 			stat[met] = v
 		} else {
-			log.Printf("%s: %s", met, err)
+			common.LogFetchError(met, err)
 		}
 	}
 
@@ -154,34 +156,41 @@ func (p RDSPlugin) GraphDefinition() map[string](mp.Graphs) {
 
 func main() {
 	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
 	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
 	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
 	optIdentifier := flag.String("identifier", "", "DB Instance Identifier")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	var rds RDSPlugin
 
-	if *optRegion == "" {
-		rds.Region = aws.InstanceRegion()
-	} else {
-		rds.Region = *optRegion
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
 	}
+	rds.Region = region
 
 	rds.Identifier = *optIdentifier
 	rds.AccessKeyId = *optAccessKeyId
 	rds.SecretAccessKey = *optSecretAccessKey
+	rds.SessionToken = common.AWSSessionToken(*optSessionToken)
 
-	helper := mp.NewMackerelPlugin(rds)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(rds))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
-		helper.Tempfile = "/tmp/mackerel-plugin-rds"
+		helper.Tempfile = common.Tempfile("mackerel-plugin-rds", "region", "identifier")
 	}
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
@@ -0,0 +1,226 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/S3"
+
+// the storage metrics are published once a day
+const (
+	period   = 86400
+	lookback = 48 * time.Hour
+)
+
+// the StorageType of NumberOfObjects, which counts the objects of all the storage classes
+const allStorageTypes = "AllStorageTypes"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"s3_bucket.objects": mp.Graphs{
+		Label: "S3 Bucket Objects",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "NumberOfObjects", Label: "Objects"},
+		},
+	},
+
+	// the graph of the size per storage class will be generated dynamically
+}
+
+// the size of each storage type, e.g. StandardStorage, StandardIAStorage and GlacierStorage
+var storageTypeMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "BucketSizeBytes_", Unit: "bytes", Stacked: true,
+		Graph: "size", GraphLabel: "S3 Bucket Size per Storage Type"},
+}
+
+type S3BucketSizePlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	BucketName      string
+	StorageTypes    []string
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+// storageTypes returns the storage types which BucketSizeBytes of the bucket is reported for
+func storageTypes(metrics []cloudwatch.Metric) []string {
+	var types []string
+	seen := make(map[string]bool)
+	for _, met := range metrics {
+		for _, d := range met.Dimensions {
+			if d.Name != "StorageType" || d.Value == "" || seen[d.Value] {
+				continue
+			}
+			seen[d.Value] = true
+			types = append(types, d.Value)
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+func (p *S3BucketSizePlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	var metrics []cloudwatch.Metric
+	req := &cloudwatch.ListMetricsRequest{
+		Namespace: namespace,
+		Dimensions: []cloudwatch.Dimension{
+			cloudwatch.Dimension{
+				Name:  "BucketName",
+				Value: p.BucketName,
+			},
+		},
+		MetricName: "BucketSizeBytes",
+	}
+	for {
+		ret, err := p.CloudWatch.ListMetrics(req)
+		if err != nil {
+			return err
+		}
+		metrics = append(metrics, ret.ListMetricsResult.Metrics...)
+		if ret.ListMetricsResult.NextToken == "" {
+			break
+		}
+		req.NextToken = ret.ListMetricsResult.NextToken
+	}
+
+	p.StorageTypes = storageTypes(metrics)
+	return nil
+}
+
+// latestAverage returns Average of the newest datapoint
+func latestAverage(datapoints []cloudwatch.Datapoint) (float64, error) {
+	if len(datapoints) == 0 {
+		return 0, errors.New("fetched no datapoints")
+	}
+
+	latest := datapoints[0]
+	for _, dp := range datapoints[1:] {
+		if dp.Timestamp.After(latest.Timestamp) {
+			latest = dp
+		}
+	}
+	return latest.Average, nil
+}
+
+func (p S3BucketSizePlugin) GetLastPoint(storageType string, metricName string) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: []cloudwatch.Dimension{
+			cloudwatch.Dimension{Name: "BucketName", Value: p.BucketName},
+			cloudwatch.Dimension{Name: "StorageType", Value: storageType},
+		},
+		StartTime:  now.Add(-lookback), // 2 days (to fetch at least 1 data-point of a day)
+		EndTime:    now,
+		MetricName: metricName,
+		Period:     period,
+		Statistics: []string{"Average"},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return latestAverage(response.GetMetricStatisticsResult.Datapoints)
+}
+
+func (p S3BucketSizePlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	for _, st := range p.StorageTypes {
+		v, err := p.GetLastPoint(st, "BucketSizeBytes")
+		if err == nil {
+			stat["BucketSizeBytes_"+st] = v
+		} else {
+			common.LogFetchError("BucketSizeBytes "+st, err)
+		}
+	}
+
+	v, err := p.GetLastPoint(allStorageTypes, "NumberOfObjects")
+	if err == nil {
+		stat["NumberOfObjects"] = v
+	} else {
+		common.LogFetchError("NumberOfObjects", err)
+	}
+
+	return stat, nil
+}
+
+func (p S3BucketSizePlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := common.DimensionGraphs("s3_bucket", storageTypeMetrics, p.StorageTypes, false)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region of the bucket")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optBucketName := flag.String("bucket-name", "", "S3 Bucket Name")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var s3 S3BucketSizePlugin
+
+	if *optBucketName == "" {
+		log.Fatalln("-bucket-name is required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	s3.Region = region
+
+	s3.AccessKeyId = *optAccessKeyId
+	s3.SecretAccessKey = *optSecretAccessKey
+	s3.SessionToken = common.AWSSessionToken(*optSessionToken)
+	s3.BucketName = *optBucketName
+
+	err = s3.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(s3))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-s3-bucket-size-" + *optBucketName
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
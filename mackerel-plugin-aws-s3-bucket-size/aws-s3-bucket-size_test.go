@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/crowdmob/goamz/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStorageTypes(t *testing.T) {
+	bucket := cloudwatch.Dimension{Name: "BucketName", Value: "logs"}
+	metrics := []cloudwatch.Metric{
+		cloudwatch.Metric{Dimensions: []cloudwatch.Dimension{bucket, cloudwatch.Dimension{Name: "StorageType", Value: "StandardStorage"}}},
+		cloudwatch.Metric{Dimensions: []cloudwatch.Dimension{bucket, cloudwatch.Dimension{Name: "StorageType", Value: "GlacierStorage"}}},
+		cloudwatch.Metric{Dimensions: []cloudwatch.Dimension{cloudwatch.Dimension{Name: "StorageType", Value: "StandardIAStorage"}, bucket}},
+		cloudwatch.Metric{Dimensions: []cloudwatch.Dimension{bucket, cloudwatch.Dimension{Name: "StorageType", Value: "StandardStorage"}}},
+	}
+	assert.Equal(t, storageTypes(metrics), []string{"GlacierStorage", "StandardIAStorage", "StandardStorage"})
+}
+
+func TestLatestAverage(t *testing.T) {
+	now := time.Now()
+	v, err := latestAverage([]cloudwatch.Datapoint{
+		cloudwatch.Datapoint{Timestamp: now.Add(-48 * time.Hour), Average: 100},
+		cloudwatch.Datapoint{Timestamp: now.Add(-24 * time.Hour), Average: 120},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, v, 120.0)
+
+	_, err = latestAverage(nil)
+	assert.NotNil(t, err)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	s3 := S3BucketSizePlugin{StorageTypes: []string{"StandardStorage", "GlacierStorage"}}
+	graphs := s3.GraphDefinition()
+	assert.Equal(t, len(graphs["s3_bucket.size"].Metrics), 2)
+	assert.Equal(t, graphs["s3_bucket.size"].Metrics[0].Name, "BucketSizeBytes_StandardStorage")
+	_, ok := graphs["s3_bucket.objects"]
+	assert.True(t, ok)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/DDoSProtection"
+
+// the metrics of global resources (CloudFront, Route 53, Global Accelerator) are in us-east-1
+const globalRegion = "us-east-1"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"shield.ddos_detected": mp.Graphs{
+		Label: "Shield DDoS Detected",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "DDoSDetected", Label: "Detected"},
+		},
+	},
+
+	// "shield.attack_bits", "shield.attack_packets" will be generated dynamically
+}
+
+// errNoDatapoints means that no attacks are going on, because Shield reports the metrics only when detected
+var errNoDatapoints = errors.New("fetched no datapoints")
+
+type StatType int
+
+const (
+	Maximum StatType = iota
+)
+
+func (s StatType) String() string {
+	switch s {
+	case Maximum:
+		return "Maximum"
+	}
+	return ""
+}
+
+type ShieldDDoSPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	ResourceArn     string
+	AttackVectors   []string
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+// regionOfARN returns the region in which the metrics of the resource are.
+// arn:aws:elasticloadbalancing:ap-northeast-1:123456789012:loadbalancer/app/my-alb/0123456789abcdef
+// arn:aws:cloudfront::123456789012:distribution/EDFDVBD6EXAMPLE
+func regionOfARN(arn string) string {
+	fields := strings.SplitN(arn, ":", 6)
+	if len(fields) < 6 || fields[3] == "" {
+		return globalRegion
+	}
+	return fields[3]
+}
+
+func (p *ShieldDDoSPlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	// attack vectors appear only after they have been detected
+	ret, err := p.CloudWatch.ListMetrics(&cloudwatch.ListMetricsRequest{
+		Namespace: namespace,
+		Dimensions: []cloudwatch.Dimension{
+			cloudwatch.Dimension{
+				Name:  "ResourceArn",
+				Value: p.ResourceArn,
+			},
+		},
+		MetricName: "DDoSAttackBitsPerSecond",
+	})
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, met := range ret.ListMetricsResult.Metrics {
+		for _, d := range met.Dimensions {
+			if d.Name == "AttackVector" && !seen[d.Value] {
+				seen[d.Value] = true
+				p.AttackVectors = append(p.AttackVectors, d.Value)
+				break
+			}
+		}
+	}
+
+	return nil
+}
+
+func (p ShieldDDoSPlugin) GetLastPoint(dimensions []cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: dimensions,
+		StartTime:  now.Add(time.Duration(180) * time.Second * -1), // 3 min (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: metricName,
+		Period:     60,
+		Statistics: []string{statType.String()},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := response.GetMetricStatisticsResult.Datapoints
+	if len(datapoints) == 0 {
+		return 0, errNoDatapoints
+	}
+
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	for _, dp := range datapoints {
+		if dp.Timestamp.Before(latest) {
+			continue
+		}
+
+		latest = dp.Timestamp
+		switch statType {
+		case Maximum:
+			latestVal = dp.Maximum
+		}
+	}
+
+	return latestVal, nil
+}
+
+func (p ShieldDDoSPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	resource := cloudwatch.Dimension{
+		Name:  "ResourceArn",
+		Value: p.ResourceArn,
+	}
+
+	v, err := p.GetLastPoint([]cloudwatch.Dimension{resource}, "DDoSDetected", Maximum)
+	if err == nil || err == errNoDatapoints {
+		stat["DDoSDetected"] = v
+	} else {
+		common.LogFetchError("DDoSDetected", err)
+	}
+
+	for _, vector := range p.AttackVectors {
+		d := []cloudwatch.Dimension{
+			resource,
+			cloudwatch.Dimension{
+				Name:  "AttackVector",
+				Value: vector,
+			},
+		}
+		for _, met := range []string{"DDoSAttackBitsPerSecond", "DDoSAttackPacketsPerSecond"} {
+			v, err := p.GetLastPoint(d, met, Maximum)
+			if err == nil || err == errNoDatapoints {
+				stat[met+"_"+vector] = v
+			} else {
+				common.LogFetchError(met, err)
+			}
+		}
+	}
+
+	return stat, nil
+}
+
+func (p ShieldDDoSPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), len(graphdef)+2)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	for _, grp := range [...]string{"shield.attack_bits", "shield.attack_packets"} {
+		var name_pre string
+		var label string
+		switch grp {
+		case "shield.attack_bits":
+			name_pre = "DDoSAttackBitsPerSecond_"
+			label = "Shield DDoS Attack Bits per sec"
+		case "shield.attack_packets":
+			name_pre = "DDoSAttackPacketsPerSecond_"
+			label = "Shield DDoS Attack Packets per sec"
+		}
+
+		var metrics [](mp.Metrics)
+		for _, vector := range p.AttackVectors {
+			metrics = append(metrics, mp.Metrics{Name: name_pre + vector, Label: vector, Stacked: true})
+		}
+		// no attacks have been detected yet
+		if len(metrics) == 0 {
+			continue
+		}
+		graphs[grp] = mp.Graphs{
+			Label:   label,
+			Unit:    "float",
+			Metrics: metrics,
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region (default: the region of -resource-arn, or us-east-1 for global resources)")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optResourceArn := flag.String("resource-arn", "", "ARN of the resource protected by Shield Advanced")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var shield ShieldDDoSPlugin
+
+	if *optResourceArn == "" {
+		log.Fatalln("-resource-arn is required")
+	}
+
+	if *optRegion == "" {
+		shield.Region = regionOfARN(*optResourceArn)
+	} else {
+		shield.Region = *optRegion
+	}
+
+	shield.AccessKeyId = *optAccessKeyId
+	shield.SecretAccessKey = *optSecretAccessKey
+	shield.SessionToken = common.AWSSessionToken(*optSessionToken)
+	shield.ResourceArn = *optResourceArn
+
+	err := shield.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(shield))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-shield-ddos-" + invalidChars.ReplaceAllString(*optResourceArn, "_")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
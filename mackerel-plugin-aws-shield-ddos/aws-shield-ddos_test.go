@@ -0,0 +1,28 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegionOfARN(t *testing.T) {
+	assert.Equal(t, regionOfARN("arn:aws:elasticloadbalancing:ap-northeast-1:123456789012:loadbalancer/app/my-alb/0123456789abcdef"), "ap-northeast-1")
+	assert.Equal(t, regionOfARN("arn:aws:ec2:eu-west-1:123456789012:eip-allocation/eipalloc-0123456789abcdef0"), "eu-west-1")
+	assert.Equal(t, regionOfARN("arn:aws:cloudfront::123456789012:distribution/EDFDVBD6EXAMPLE"), "us-east-1")
+	assert.Equal(t, regionOfARN("arn:aws:route53:::hostedzone/Z1D633PJN98FT9"), "us-east-1")
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var shield ShieldDDoSPlugin
+
+	graphs := shield.GraphDefinition()
+	_, ok := graphs["shield.attack_bits"]
+	assert.False(t, ok)
+	assert.Equal(t, len(graphs["shield.ddos_detected"].Metrics), 1)
+
+	shield.AttackVectors = []string{"UDP_REFLECTION", "SYN_FLOOD"}
+	graphs = shield.GraphDefinition()
+	assert.Equal(t, len(graphs["shield.attack_bits"].Metrics), 2)
+	assert.Equal(t, graphs["shield.attack_packets"].Metrics[1].Name, "DDoSAttackPacketsPerSecond_SYN_FLOOD")
+}
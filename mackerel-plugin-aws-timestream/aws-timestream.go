@@ -0,0 +1,337 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	"github.com/crowdmob/goamz/aws"
+	"github.com/crowdmob/goamz/cloudwatch"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/Timestream"
+
+var errNoDatapoints = errors.New("fetched no datapoints")
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"timestream.magnetic_store_rejected": mp.Graphs{
+		Label: "Timestream Magnetic Store Rejected Records",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "MagneticStoreRejectedRecordCount", Label: "Rejected Records"},
+		},
+	},
+	"timestream.storage": mp.Graphs{
+		Label: "Timestream Table Storage",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "MemoryCumulativeBytesMetered", Label: "Memory Store"},
+			mp.Metrics{Name: "MagneticCumulativeBytesMetered", Label: "Magnetic Store"},
+		},
+	},
+
+	// the graphs of operationMetrics will be generated dynamically
+}
+
+// metrics per API operation (e.g. WriteRecords and Query)
+var operationMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "SuccessfulRequestLatency_", Unit: "float",
+		Graph: "latency", GraphLabel: "Timestream Successful Request Latency (ms)"},
+	common.DimensionMetric{Prefix: "UserErrors_", Unit: "integer",
+		Graph: "user_errors", GraphLabel: "Timestream User Errors"},
+	common.DimensionMetric{Prefix: "SystemErrors_", Unit: "integer",
+		Graph: "system_errors", GraphLabel: "Timestream System Errors"},
+}
+
+// a CloudWatch metric and how to fetch it
+type timestreamMetric struct {
+	Name      string
+	Statistic string
+	Period    int
+}
+
+var apiMetricDefs = []timestreamMetric{
+	timestreamMetric{Name: "SuccessfulRequestLatency", Statistic: "Average", Period: 60},
+	timestreamMetric{Name: "UserErrors", Statistic: "Sum", Period: 60},
+	timestreamMetric{Name: "SystemErrors", Statistic: "Sum", Period: 60},
+}
+
+// the metrics of the table. the storage is metered hourly
+var tableMetricDefs = []timestreamMetric{
+	timestreamMetric{Name: "MagneticStoreRejectedRecordCount", Statistic: "Sum", Period: 60},
+	timestreamMetric{Name: "MemoryCumulativeBytesMetered", Statistic: "Average", Period: 3600},
+	timestreamMetric{Name: "MagneticCumulativeBytesMetered", Statistic: "Average", Period: 3600},
+}
+
+// apiMetric is a metric of an operation with the dimensions it is published with
+type apiMetric struct {
+	Name       string
+	Operation  string
+	Dimensions []cloudwatch.Dimension
+}
+
+type TimestreamPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	DatabaseName    string
+	TableName       string
+	Operation       string
+	APIMetrics      []apiMetric
+	Operations      []string
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+// scope returns how specific the dimensions of an operation are to the table: 3 for the table,
+// 2 for the database and 1 for the account (e.g. Query, which is not of a table), or 0 for the others
+func scope(dims map[string]string, database, table string) int {
+	switch len(dims) {
+	case 1:
+		return 1
+	case 2:
+		if dims["DatabaseName"] == database {
+			return 2
+		}
+	case 3:
+		if dims["DatabaseName"] == database && dims["TableName"] == table {
+			return 3
+		}
+	}
+	return 0
+}
+
+// apiMetrics returns the metrics of each operation of the table (or the operation given),
+// with the dimensions most specific to the table
+func apiMetrics(metrics []cloudwatch.Metric, database, table, operation string) []apiMetric {
+	type key struct{ name, operation string }
+	best := make(map[key]int)
+	found := make(map[key]apiMetric)
+
+	for _, met := range metrics {
+		dims := make(map[string]string)
+		for _, d := range met.Dimensions {
+			dims[d.Name] = d.Value
+		}
+		op := dims["Operation"]
+		if op == "" || (operation != "" && op != operation) {
+			continue
+		}
+		s := scope(dims, database, table)
+		k := key{met.MetricName, op}
+		if s == 0 || s <= best[k] {
+			continue
+		}
+		best[k] = s
+		found[k] = apiMetric{Name: met.MetricName, Operation: op, Dimensions: met.Dimensions}
+	}
+
+	ret := make([]apiMetric, 0, len(found))
+	for _, m := range found {
+		ret = append(ret, m)
+	}
+	sort.Sort(byNameOperation(ret))
+	return ret
+}
+
+type byNameOperation []apiMetric
+
+func (m byNameOperation) Len() int      { return len(m) }
+func (m byNameOperation) Swap(i, j int) { m[i], m[j] = m[j], m[i] }
+func (m byNameOperation) Less(i, j int) bool {
+	if m[i].Name != m[j].Name {
+		return m[i].Name < m[j].Name
+	}
+	return m[i].Operation < m[j].Operation
+}
+
+// operations returns the operations of the metrics, sorted
+func operations(metrics []apiMetric) []string {
+	var ops []string
+	seen := make(map[string]bool)
+	for _, m := range metrics {
+		if seen[m.Operation] {
+			continue
+		}
+		seen[m.Operation] = true
+		ops = append(ops, m.Operation)
+	}
+	sort.Strings(ops)
+	return ops
+}
+
+func (p *TimestreamPlugin) Prepare() error {
+	auth, err := aws.GetAuth(p.AccessKeyId, p.SecretAccessKey, p.SessionToken, time.Now())
+	if err != nil {
+		return err
+	}
+
+	p.CloudWatch, err = cloudwatch.NewCloudWatch(auth, aws.Regions[p.Region].CloudWatchServicepoint)
+	if err != nil {
+		return err
+	}
+
+	var metrics []cloudwatch.Metric
+	for _, def := range apiMetricDefs {
+		req := &cloudwatch.ListMetricsRequest{
+			Namespace:  namespace,
+			MetricName: def.Name,
+		}
+		for {
+			ret, err := p.CloudWatch.ListMetrics(req)
+			if err != nil {
+				return err
+			}
+			metrics = append(metrics, ret.ListMetricsResult.Metrics...)
+			if ret.ListMetricsResult.NextToken == "" {
+				break
+			}
+			req.NextToken = ret.ListMetricsResult.NextToken
+		}
+	}
+
+	p.APIMetrics = apiMetrics(metrics, p.DatabaseName, p.TableName, p.Operation)
+	p.Operations = operations(p.APIMetrics)
+	return nil
+}
+
+// latestValue returns the statistic of the newest datapoint
+func latestValue(datapoints []cloudwatch.Datapoint, statistic string) (float64, error) {
+	if len(datapoints) == 0 {
+		return 0, errNoDatapoints
+	}
+
+	latest := datapoints[0]
+	for _, dp := range datapoints[1:] {
+		if dp.Timestamp.After(latest.Timestamp) {
+			latest = dp
+		}
+	}
+	if statistic == "Sum" {
+		return latest.Sum, nil
+	}
+	return latest.Average, nil
+}
+
+func (p TimestreamPlugin) GetLastPoint(dimensions []cloudwatch.Dimension, def timestreamMetric) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsRequest{
+		Dimensions: dimensions,
+		StartTime:  now.Add(time.Duration(3*def.Period) * time.Second * -1), // 3 periods (to fetch at least 1 data-point)
+		EndTime:    now,
+		MetricName: def.Name,
+		Period:     def.Period,
+		Statistics: []string{def.Statistic},
+		Namespace:  namespace,
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	v, err := latestValue(response.GetMetricStatisticsResult.Datapoints, def.Statistic)
+	// no datapoints of the counts mean nothing happened
+	if err == errNoDatapoints && def.Statistic == "Sum" {
+		return 0, nil
+	}
+	return v, err
+}
+
+func (p TimestreamPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	defs := make(map[string]timestreamMetric)
+	for _, def := range apiMetricDefs {
+		defs[def.Name] = def
+	}
+	for _, m := range p.APIMetrics {
+		v, err := p.GetLastPoint(m.Dimensions, defs[m.Name])
+		if err == nil {
+			stat[m.Name+"_"+m.Operation] = v
+		} else if err != errNoDatapoints {
+			common.LogFetchError(m.Name+" "+m.Operation, err)
+		}
+	}
+
+	perTable := []cloudwatch.Dimension{
+		cloudwatch.Dimension{Name: "DatabaseName", Value: p.DatabaseName},
+		cloudwatch.Dimension{Name: "TableName", Value: p.TableName},
+	}
+	for _, def := range tableMetricDefs {
+		v, err := p.GetLastPoint(perTable, def)
+		if err == nil {
+			stat[def.Name] = v
+		} else {
+			common.LogFetchError(def.Name, err)
+		}
+	}
+
+	return stat, nil
+}
+
+func (p TimestreamPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := common.DimensionGraphs("timestream", operationMetrics, p.Operations, false)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optDatabaseName := flag.String("database-name", "", "Timestream Database Name")
+	optTableName := flag.String("table-name", "", "Timestream Table Name")
+	optOperation := flag.String("operation", "", "API Operation (e.g. WriteRecords). all the operations by default")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var timestream TimestreamPlugin
+
+	if *optDatabaseName == "" || *optTableName == "" {
+		log.Fatalln("-database-name and -table-name are required")
+	}
+
+	region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+	if err != nil {
+		log.Fatalln(err)
+	}
+	timestream.Region = region
+
+	timestream.AccessKeyId = *optAccessKeyId
+	timestream.SecretAccessKey = *optSecretAccessKey
+	timestream.SessionToken = common.AWSSessionToken(*optSessionToken)
+	timestream.DatabaseName = *optDatabaseName
+	timestream.TableName = *optTableName
+	timestream.Operation = *optOperation
+
+	err = timestream.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(timestream))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-timestream", "region", "database-name", "table-name", "operation")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
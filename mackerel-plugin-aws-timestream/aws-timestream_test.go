@@ -0,0 +1,64 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/crowdmob/goamz/cloudwatch"
+	"github.com/stretchr/testify/assert"
+)
+
+func dims(kv ...string) []cloudwatch.Dimension {
+	var d []cloudwatch.Dimension
+	for i := 0; i+1 < len(kv); i += 2 {
+		d = append(d, cloudwatch.Dimension{Name: kv[i], Value: kv[i+1]})
+	}
+	return d
+}
+
+func TestAPIMetrics(t *testing.T) {
+	metrics := []cloudwatch.Metric{
+		cloudwatch.Metric{MetricName: "SuccessfulRequestLatency", Dimensions: dims("Operation", "WriteRecords", "DatabaseName", "iot", "TableName", "sensors")},
+		cloudwatch.Metric{MetricName: "SuccessfulRequestLatency", Dimensions: dims("Operation", "WriteRecords", "DatabaseName", "iot", "TableName", "devices")},
+		cloudwatch.Metric{MetricName: "SuccessfulRequestLatency", Dimensions: dims("Operation", "WriteRecords", "DatabaseName", "iot")},
+		cloudwatch.Metric{MetricName: "SuccessfulRequestLatency", Dimensions: dims("Operation", "Query")},
+		cloudwatch.Metric{MetricName: "SystemErrors", Dimensions: dims("Operation", "WriteRecords", "DatabaseName", "iot", "TableName", "sensors")},
+		cloudwatch.Metric{MetricName: "UserErrors", Dimensions: dims("Operation", "Query")},
+		cloudwatch.Metric{MetricName: "UserErrors", Dimensions: dims("Operation", "WriteRecords", "DatabaseName", "logs", "TableName", "sensors")},
+	}
+
+	ret := apiMetrics(metrics, "iot", "sensors", "")
+	assert.Equal(t, len(ret), 4)
+	assert.Equal(t, ret[0].Name, "SuccessfulRequestLatency")
+	assert.Equal(t, ret[0].Operation, "Query")
+	assert.Equal(t, ret[1].Operation, "WriteRecords")
+	// the dimensions of the table rather than the database
+	assert.Equal(t, ret[1].Dimensions, dims("Operation", "WriteRecords", "DatabaseName", "iot", "TableName", "sensors"))
+	assert.Equal(t, ret[2].Name, "SystemErrors")
+	assert.Equal(t, ret[3].Name, "UserErrors")
+	assert.Equal(t, ret[3].Operation, "Query")
+	assert.Equal(t, operations(ret), []string{"Query", "WriteRecords"})
+
+	ret = apiMetrics(metrics, "iot", "sensors", "WriteRecords")
+	assert.Equal(t, len(ret), 2)
+	assert.Equal(t, operations(ret), []string{"WriteRecords"})
+}
+
+func TestLatestValue(t *testing.T) {
+	now := time.Now()
+	datapoints := []cloudwatch.Datapoint{
+		cloudwatch.Datapoint{Timestamp: now.Add(-2 * time.Minute), Average: 12.5, Sum: 3},
+		cloudwatch.Datapoint{Timestamp: now.Add(-1 * time.Minute), Average: 10, Sum: 5},
+	}
+
+	v, err := latestValue(datapoints, "Average")
+	assert.Nil(t, err)
+	assert.Equal(t, v, 10.0)
+
+	v, err = latestValue(datapoints, "Sum")
+	assert.Nil(t, err)
+	assert.Equal(t, v, 5.0)
+
+	_, err = latestValue(nil, "Sum")
+	assert.Equal(t, err, errNoDatapoints)
+}
@@ -0,0 +1,331 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/cloudwatch"
+	"github.com/aws/aws-sdk-go/service/wafv2"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const namespace = "AWS/WAFV2"
+
+// web ACLs for CloudFront are managed, and their metrics are reported, in us-east-1
+const cloudfrontRegion = "us-east-1"
+
+var errNoDatapoints = errors.New("fetched no datapoints")
+
+type StatType int
+
+const (
+	Sum StatType = iota
+)
+
+func (s StatType) String() string {
+	switch s {
+	case Sum:
+		return "Sum"
+	}
+	return ""
+}
+
+// rateBasedRule is a rule of the web ACL with a rate-based statement
+type rateBasedRule struct {
+	Name       string
+	MetricName string
+	Limit      int64
+}
+
+type WAFV2RateBasedPlugin struct {
+	Region          string
+	AccessKeyId     string
+	SecretAccessKey string
+	SessionToken    string
+	Scope           string
+	WebACLName      string
+	WebACLId        string
+	WebACLMetric    string
+	Rules           []rateBasedRule
+	WAFV2           *wafv2.WAFV2
+	CloudWatch      *cloudwatch.CloudWatch
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// rateBasedRules returns the rules with rate-based statements.
+// CloudWatch metrics are named by the metric names in the visibility configs, not by the rule names.
+func rateBasedRules(acl *wafv2.WebACL) []rateBasedRule {
+	var rules []rateBasedRule
+	for _, r := range acl.Rules {
+		if r.Statement == nil || r.Statement.RateBasedStatement == nil {
+			continue
+		}
+		rule := rateBasedRule{
+			Name:  aws.StringValue(r.Name),
+			Limit: aws.Int64Value(r.Statement.RateBasedStatement.Limit),
+		}
+		if r.VisibilityConfig != nil {
+			rule.MetricName = aws.StringValue(r.VisibilityConfig.MetricName)
+		}
+		rules = append(rules, rule)
+	}
+	return rules
+}
+
+func (p *WAFV2RateBasedPlugin) Prepare() error {
+	sess, err := session.NewSession()
+	if err != nil {
+		return err
+	}
+
+	config := aws.NewConfig().WithRegion(p.Region)
+	if p.AccessKeyId != "" && p.SecretAccessKey != "" {
+		config = config.WithCredentials(credentials.NewStaticCredentials(p.AccessKeyId, p.SecretAccessKey, p.SessionToken))
+	}
+
+	p.WAFV2 = wafv2.New(sess, config)
+	p.CloudWatch = cloudwatch.New(sess, config)
+
+	input := &wafv2.ListWebACLsInput{Scope: aws.String(p.Scope)}
+	for {
+		ret, err := p.WAFV2.ListWebACLs(input)
+		if err != nil {
+			return err
+		}
+		for _, acl := range ret.WebACLs {
+			if aws.StringValue(acl.Name) == p.WebACLName {
+				p.WebACLId = aws.StringValue(acl.Id)
+				break
+			}
+		}
+		if p.WebACLId != "" || ret.NextMarker == nil {
+			break
+		}
+		input.NextMarker = ret.NextMarker
+	}
+	if p.WebACLId == "" {
+		return errors.New("web ACL not found: " + p.WebACLName)
+	}
+
+	ret, err := p.WAFV2.GetWebACL(&wafv2.GetWebACLInput{
+		Name:  aws.String(p.WebACLName),
+		Id:    aws.String(p.WebACLId),
+		Scope: aws.String(p.Scope),
+	})
+	if err != nil {
+		return err
+	}
+	if ret.WebACL.VisibilityConfig != nil {
+		p.WebACLMetric = aws.StringValue(ret.WebACL.VisibilityConfig.MetricName)
+	}
+	p.Rules = rateBasedRules(ret.WebACL)
+	if len(p.Rules) == 0 {
+		return errors.New("no rate-based rules in " + p.WebACLName)
+	}
+
+	return nil
+}
+
+func (p WAFV2RateBasedPlugin) GetLastPoint(dimensions []*cloudwatch.Dimension, metricName string, statType StatType) (float64, error) {
+	now := time.Now()
+
+	response, err := p.CloudWatch.GetMetricStatistics(&cloudwatch.GetMetricStatisticsInput{
+		Dimensions: dimensions,
+		StartTime:  aws.Time(now.Add(time.Duration(180) * time.Second * -1)), // 3 min (to fetch at least 1 data-point)
+		EndTime:    aws.Time(now),
+		MetricName: aws.String(metricName),
+		Period:     aws.Int64(60),
+		Statistics: []*string{aws.String(statType.String())},
+		Namespace:  aws.String(namespace),
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	datapoints := response.Datapoints
+	if len(datapoints) == 0 {
+		return 0, errNoDatapoints
+	}
+
+	latest := time.Unix(0, 0)
+	var latestVal float64
+	for _, dp := range datapoints {
+		if aws.TimeValue(dp.Timestamp).Before(latest) {
+			continue
+		}
+
+		latest = aws.TimeValue(dp.Timestamp)
+		switch statType {
+		case Sum:
+			latestVal = aws.Float64Value(dp.Sum)
+		}
+	}
+
+	return latestVal, nil
+}
+
+func (p WAFV2RateBasedPlugin) dimensions(rule rateBasedRule) []*cloudwatch.Dimension {
+	dims := []*cloudwatch.Dimension{
+		&cloudwatch.Dimension{Name: aws.String("WebACL"), Value: aws.String(p.WebACLMetric)},
+		&cloudwatch.Dimension{Name: aws.String("Rule"), Value: aws.String(rule.MetricName)},
+	}
+	// metrics of CloudFront have no Region dimension
+	if p.Scope == wafv2.ScopeRegional {
+		dims = append(dims, &cloudwatch.Dimension{Name: aws.String("Region"), Value: aws.String(p.Region)})
+	}
+	return dims
+}
+
+func (p WAFV2RateBasedPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	for _, rule := range p.Rules {
+		name := metricName(rule.Name)
+
+		// requests are reported only when the rule matches, so no datapoints mean 0
+		for _, met := range []string{"BlockedRequests", "CountedRequests"} {
+			v, err := p.GetLastPoint(p.dimensions(rule), met, Sum)
+			if err == nil || err == errNoDatapoints {
+				stat[met+"_"+name] = v
+			} else {
+				common.LogFetchError(met, err)
+			}
+		}
+
+		stat["Limit_"+name] = float64(rule.Limit)
+
+		// the IP addresses currently over the limit. WAF doesn't tell how close the others are
+		keys, err := p.WAFV2.GetRateBasedStatementManagedKeys(&wafv2.GetRateBasedStatementManagedKeysInput{
+			Scope:      aws.String(p.Scope),
+			WebACLName: aws.String(p.WebACLName),
+			WebACLId:   aws.String(p.WebACLId),
+			RuleName:   aws.String(rule.Name),
+		})
+		if err != nil {
+			common.LogFetchError(rule.Name, err)
+			continue
+		}
+		var addrs int
+		for _, set := range []*wafv2.RateBasedStatementManagedKeysIPSet{keys.ManagedKeysIPV4, keys.ManagedKeysIPV6} {
+			if set != nil {
+				addrs += len(set.Addresses)
+			}
+		}
+		stat["RateLimitedAddresses_"+name] = float64(addrs)
+	}
+
+	return stat, nil
+}
+
+func (p WAFV2RateBasedPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), 4)
+
+	for _, grp := range [...]string{"wafv2_rate.blocked", "wafv2_rate.counted", "wafv2_rate.limited_addresses", "wafv2_rate.limit"} {
+		var name_pre string
+		var label string
+		stacked := true
+		switch grp {
+		case "wafv2_rate.blocked":
+			name_pre = "BlockedRequests_"
+			label = "WAF Requests Blocked by Rate-based Rules"
+		case "wafv2_rate.counted":
+			name_pre = "CountedRequests_"
+			label = "WAF Requests Counted by Rate-based Rules"
+		case "wafv2_rate.limited_addresses":
+			name_pre = "RateLimitedAddresses_"
+			label = "WAF Rate Limited IP Addresses"
+		case "wafv2_rate.limit":
+			name_pre = "Limit_"
+			label = "WAF Rate Limit (requests per 5 min)"
+			stacked = false
+		}
+
+		var metrics [](mp.Metrics)
+		for _, rule := range p.Rules {
+			metrics = append(metrics, mp.Metrics{Name: name_pre + metricName(rule.Name), Label: rule.Name, Stacked: stacked})
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		graphs[grp] = mp.Graphs{
+			Label:   label,
+			Unit:    "integer",
+			Metrics: metrics,
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optRegion := flag.String("region", "", "AWS Region")
+	optPreferInstanceRegion := flag.Bool("prefer-instance-region", false, "Use the region of the running instance rather than -region")
+	optAccessKeyId := flag.String("access-key-id", "", "AWS Access Key ID")
+	optSecretAccessKey := flag.String("secret-access-key", "", "AWS Secret Access Key")
+	optSessionToken := flag.String("session-token", "", "AWS Session Token (default: $AWS_SESSION_TOKEN)")
+	optWebACLName := flag.String("web-acl-name", "", "Web ACL name")
+	optScope := flag.String("scope", wafv2.ScopeRegional, "Scope of the web ACL (REGIONAL or CLOUDFRONT)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var waf WAFV2RateBasedPlugin
+
+	if *optWebACLName == "" {
+		log.Fatalln("-web-acl-name is required")
+	}
+	if *optScope != wafv2.ScopeRegional && *optScope != wafv2.ScopeCloudfront {
+		log.Fatalln("-scope should be REGIONAL or CLOUDFRONT")
+	}
+
+	if *optScope == wafv2.ScopeCloudfront {
+		waf.Region = cloudfrontRegion
+	} else {
+		region, err := common.Region(*optRegion, *optPreferInstanceRegion)
+		if err != nil {
+			log.Fatalln(err)
+		}
+		waf.Region = region
+	}
+
+	waf.AccessKeyId = *optAccessKeyId
+	waf.SecretAccessKey = *optSecretAccessKey
+	waf.SessionToken = common.AWSSessionToken(*optSessionToken)
+	waf.Scope = *optScope
+	waf.WebACLName = *optWebACLName
+
+	err := waf.Prepare()
+	if err != nil {
+		log.Fatalln(err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(waf))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-wafv2-rate-based-" + metricName(*optWebACLName)
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/wafv2"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRateBasedRules(t *testing.T) {
+	acl := &wafv2.WebACL{
+		Rules: []*wafv2.Rule{
+			&wafv2.Rule{
+				Name: aws.String("rate-limit-per-ip"),
+				Statement: &wafv2.Statement{
+					RateBasedStatement: &wafv2.RateBasedStatement{Limit: aws.Int64(2000), AggregateKeyType: aws.String("IP")},
+				},
+				VisibilityConfig: &wafv2.VisibilityConfig{MetricName: aws.String("RateLimitPerIP")},
+			},
+			&wafv2.Rule{
+				Name:             aws.String("AWS-AWSManagedRulesCommonRuleSet"),
+				Statement:        &wafv2.Statement{},
+				VisibilityConfig: &wafv2.VisibilityConfig{MetricName: aws.String("CommonRuleSet")},
+			},
+		},
+	}
+
+	rules := rateBasedRules(acl)
+	assert.Equal(t, len(rules), 1)
+	assert.Equal(t, rules[0].Name, "rate-limit-per-ip")
+	assert.Equal(t, rules[0].MetricName, "RateLimitPerIP")
+	assert.Equal(t, rules[0].Limit, int64(2000))
+}
+
+func TestDimensions(t *testing.T) {
+	waf := WAFV2RateBasedPlugin{Region: "ap-northeast-1", Scope: wafv2.ScopeRegional, WebACLMetric: "MyACL"}
+	rule := rateBasedRule{Name: "rate-limit-per-ip", MetricName: "RateLimitPerIP"}
+
+	dims := waf.dimensions(rule)
+	assert.Equal(t, len(dims), 3)
+	assert.Equal(t, aws.StringValue(dims[0].Value), "MyACL")
+	assert.Equal(t, aws.StringValue(dims[1].Value), "RateLimitPerIP")
+	assert.Equal(t, aws.StringValue(dims[2].Value), "ap-northeast-1")
+
+	waf.Scope = wafv2.ScopeCloudfront
+	assert.Equal(t, len(waf.dimensions(rule)), 2)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var waf WAFV2RateBasedPlugin
+	assert.Equal(t, len(waf.GraphDefinition()), 0)
+
+	waf.Rules = []rateBasedRule{rateBasedRule{Name: "rate-limit-per-ip"}, rateBasedRule{Name: "login.rate"}}
+	graphs := waf.GraphDefinition()
+	assert.Equal(t, len(graphs), 4)
+	assert.Equal(t, graphs["wafv2_rate.blocked"].Metrics[1].Name, "BlockedRequests_login_rate")
+	assert.False(t, graphs["wafv2_rate.limit"].Metrics[0].Stacked)
+}
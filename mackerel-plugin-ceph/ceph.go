@@ -0,0 +1,302 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"ceph.health": mp.Graphs{
+		Label: "Ceph Health (0:OK, 1:WARN, 2:ERR)",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "health", Label: "Health"},
+		},
+	},
+	"ceph.osds": mp.Graphs{
+		Label: "Ceph OSDs",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "osds", Label: "Total"},
+			mp.Metrics{Name: "osds_up", Label: "Up"},
+			mp.Metrics{Name: "osds_in", Label: "In"},
+		},
+	},
+	"ceph.pgs": mp.Graphs{
+		Label: "Ceph Placement Groups",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "pgs", Label: "Total"},
+		},
+	},
+	"ceph.capacity": mp.Graphs{
+		Label: "Ceph Cluster Capacity",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "used_bytes", Label: "Used", Stacked: true},
+			mp.Metrics{Name: "avail_bytes", Label: "Available", Stacked: true},
+		},
+	},
+	"ceph.client_io": mp.Graphs{
+		Label: "Ceph Client IO",
+		Unit:  "bytes/sec",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "read_bytes_sec", Label: "Read"},
+			mp.Metrics{Name: "write_bytes_sec", Label: "Write"},
+		},
+	},
+	"ceph.client_ops": mp.Graphs{
+		Label: "Ceph Client Operations",
+		Unit:  "iops",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "read_op_per_sec", Label: "Read"},
+			mp.Metrics{Name: "write_op_per_sec", Label: "Write"},
+		},
+	},
+
+	// "ceph.pg_states" will be generated dynamically
+}
+
+var healthStatus map[string]float64 = map[string]float64{
+	"HEALTH_OK":   0,
+	"HEALTH_WARN": 1,
+	"HEALTH_ERR":  2,
+}
+
+// a PG state is a combination of these (e.g. "active+undersized+degraded"), and PGs are counted by each of them.
+// those not listed here are added when they are found at the time the plugin starts
+var pgStates = []string{
+	"active", "clean", "degraded", "undersized", "peering", "recovering", "recovery_wait",
+	"backfilling", "backfill_wait", "remapped", "stale", "down", "incomplete", "inconsistent",
+}
+
+type CephPlugin struct {
+	Ceph     string
+	Cluster  string
+	PGStates []string
+}
+
+// ceph status -f json. the IO rates are omitted when they are 0.
+// osdmap is nested in itself before Octopus
+type cephStatus struct {
+	Health struct {
+		Status string `json:"status"`
+	} `json:"health"`
+	OSDMap json.RawMessage `json:"osdmap"`
+	PGMap  struct {
+		PGsByState []struct {
+			StateName string  `json:"state_name"`
+			Count     float64 `json:"count"`
+		} `json:"pgs_by_state"`
+		NumPGs        float64 `json:"num_pgs"`
+		ReadBytesSec  float64 `json:"read_bytes_sec"`
+		WriteBytesSec float64 `json:"write_bytes_sec"`
+		ReadOpPerSec  float64 `json:"read_op_per_sec"`
+		WriteOpPerSec float64 `json:"write_op_per_sec"`
+	} `json:"pgmap"`
+}
+
+type osdMap struct {
+	NumOSDs   *float64 `json:"num_osds"`
+	NumUpOSDs float64  `json:"num_up_osds"`
+	NumInOSDs float64  `json:"num_in_osds"`
+	OSDMap    *osdMap  `json:"osdmap"`
+}
+
+// ceph df -f json
+type cephDF struct {
+	Stats struct {
+		TotalBytes      float64 `json:"total_bytes"`
+		TotalUsedBytes  float64 `json:"total_used_bytes"`
+		TotalAvailBytes float64 `json:"total_avail_bytes"`
+	} `json:"stats"`
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// parseStatus sets the values of ceph status, and returns the PG states found
+func parseStatus(r io.Reader, stat map[string]float64) ([]string, error) {
+	var s cephStatus
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	if v, ok := healthStatus[s.Health.Status]; ok {
+		stat["health"] = v
+	}
+
+	var m osdMap
+	if len(s.OSDMap) > 0 {
+		if err := json.Unmarshal(s.OSDMap, &m); err != nil {
+			return nil, err
+		}
+	}
+	if m.NumOSDs == nil && m.OSDMap != nil {
+		m = *m.OSDMap
+	}
+	if m.NumOSDs != nil {
+		stat["osds"] = *m.NumOSDs
+		stat["osds_up"] = m.NumUpOSDs
+		stat["osds_in"] = m.NumInOSDs
+	}
+
+	stat["pgs"] = s.PGMap.NumPGs
+	var states []string
+	for _, pg := range s.PGMap.PGsByState {
+		for _, state := range strings.Split(pg.StateName, "+") {
+			name := metricName(state)
+			if _, ok := stat["pg_"+name]; !ok {
+				states = append(states, name)
+			}
+			stat["pg_"+name] += pg.Count
+		}
+	}
+
+	stat["read_bytes_sec"] = s.PGMap.ReadBytesSec
+	stat["write_bytes_sec"] = s.PGMap.WriteBytesSec
+	stat["read_op_per_sec"] = s.PGMap.ReadOpPerSec
+	stat["write_op_per_sec"] = s.PGMap.WriteOpPerSec
+
+	return states, nil
+}
+
+func parseDF(r io.Reader, stat map[string]float64) error {
+	var df cephDF
+	if err := json.NewDecoder(r).Decode(&df); err != nil {
+		return err
+	}
+
+	stat["used_bytes"] = df.Stats.TotalUsedBytes
+	stat["avail_bytes"] = df.Stats.TotalAvailBytes
+	return nil
+}
+
+func (p CephPlugin) command(args ...string) (io.Reader, error) {
+	args = append([]string{"--cluster", p.Cluster}, args...)
+	args = append(args, "-f", "json")
+
+	out, err := exec.Command(p.Ceph, args...).Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s %s: %s", p.Ceph, strings.Join(args, " "), err))
+	}
+	return strings.NewReader(string(out)), nil
+}
+
+func (p *CephPlugin) Prepare() error {
+	r, err := p.command("status")
+	if err != nil {
+		return err
+	}
+	found, err := parseStatus(r, make(map[string]float64))
+	if err != nil {
+		return err
+	}
+
+	p.PGStates = append(p.PGStates, pgStates...)
+	known := make(map[string]bool)
+	for _, state := range pgStates {
+		known[state] = true
+	}
+	for _, state := range found {
+		if !known[state] {
+			p.PGStates = append(p.PGStates, state)
+		}
+	}
+
+	return nil
+}
+
+func (p CephPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+	// the states without PGs are not reported
+	for _, state := range p.PGStates {
+		stat["pg_"+state] = 0
+	}
+
+	r, err := p.command("status")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseStatus(r, stat); err != nil {
+		return nil, err
+	}
+
+	r, err = p.command("df")
+	if err != nil {
+		return nil, err
+	}
+	if err := parseDF(r, stat); err != nil {
+		return nil, err
+	}
+
+	return stat, nil
+}
+
+func (p CephPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), len(graphdef)+1)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	var metrics [](mp.Metrics)
+	for _, state := range p.PGStates {
+		metrics = append(metrics, mp.Metrics{Name: "pg_" + state, Label: state})
+	}
+	if len(metrics) > 0 {
+		graphs["ceph.pg_states"] = mp.Graphs{
+			Label:   "Ceph Placement Groups by State",
+			Unit:    "integer",
+			Metrics: metrics,
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optCeph := flag.String("ceph", "ceph", "Path of ceph command")
+	optCluster := flag.String("cluster", "ceph", "Cluster name")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var ceph CephPlugin
+	ceph.Ceph = *optCeph
+	ceph.Cluster = *optCluster
+
+	err := ceph.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(ceph))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-ceph-" + metricName(*optCluster)
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var statusJSON = `{"fsid":"1d3b8c2e-0000-0000-0000-000000000000","health":{"status":"HEALTH_WARN","checks":{}},
+"osdmap":{"epoch":120,"num_osds":6,"num_up_osds":5,"osd_up_since":0,"num_in_osds":6,"osd_in_since":0,"num_remapped_pgs":0},
+"pgmap":{"pgs_by_state":[{"state_name":"active+clean","count":120},{"state_name":"active+undersized+degraded","count":8},{"state_name":"active+clean+scrubbing+deep","count":1}],
+"num_pools":3,"num_objects":1024,"data_bytes":1073741824,"bytes_used":3221225472,"bytes_avail":96636764160,"bytes_total":99857989632,
+"num_pgs":129,"read_bytes_sec":4096,"write_bytes_sec":65536,"read_op_per_sec":2,"write_op_per_sec":15}}`
+
+// before Octopus, without client IO
+var statusJSONLuminous = `{"health":{"checks":{},"status":"HEALTH_OK"},
+"osdmap":{"osdmap":{"epoch":42,"num_osds":3,"num_up_osds":3,"num_in_osds":3,"full":false,"nearfull":false,"num_remapped_pgs":0}},
+"pgmap":{"pgs_by_state":[{"state_name":"active+clean","count":64}],"num_pgs":64,"num_pools":1,"num_objects":0,"data_bytes":0,"bytes_used":3221225472,"bytes_avail":96636764160,"bytes_total":99857989632}}`
+
+var dfJSON = `{"stats":{"total_bytes":99857989632,"total_avail_bytes":96636764160,"total_used_bytes":3221225472,"total_used_raw_bytes":3221225472,"total_used_raw_ratio":0.032},
+"pools":[{"name":"rbd","id":1,"stats":{"stored":1073741824,"objects":1024,"kb_used":3145728,"bytes_used":3221225472,"percent_used":0.01,"max_avail":30601641984}}]}`
+
+func TestParseStatus(t *testing.T) {
+	stat := make(map[string]float64)
+	states, err := parseStatus(strings.NewReader(statusJSON), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, states, []string{"active", "clean", "undersized", "degraded", "scrubbing", "deep"})
+	assert.Equal(t, stat["health"], 1.0)
+	assert.Equal(t, stat["osds"], 6.0)
+	assert.Equal(t, stat["osds_up"], 5.0)
+	assert.Equal(t, stat["pgs"], 129.0)
+	assert.Equal(t, stat["pg_active"], 129.0)
+	assert.Equal(t, stat["pg_clean"], 121.0)
+	assert.Equal(t, stat["pg_degraded"], 8.0)
+	assert.Equal(t, stat["write_bytes_sec"], 65536.0)
+	assert.Equal(t, stat["write_op_per_sec"], 15.0)
+}
+
+func TestParseStatusLuminous(t *testing.T) {
+	stat := make(map[string]float64)
+	_, err := parseStatus(strings.NewReader(statusJSONLuminous), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["health"], 0.0)
+	assert.Equal(t, stat["osds_in"], 3.0)
+	assert.Equal(t, stat["pg_clean"], 64.0)
+	assert.Equal(t, stat["read_bytes_sec"], 0.0)
+}
+
+func TestParseDF(t *testing.T) {
+	stat := make(map[string]float64)
+	err := parseDF(strings.NewReader(dfJSON), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["used_bytes"], 3221225472.0)
+	assert.Equal(t, stat["avail_bytes"], 96636764160.0)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var ceph CephPlugin
+	ceph.PGStates = []string{"active", "clean", "scrubbing"}
+
+	graphs := ceph.GraphDefinition()
+	assert.Equal(t, len(graphs["ceph.pg_states"].Metrics), 3)
+	assert.Equal(t, graphs["ceph.pg_states"].Metrics[2].Name, "pg_scrubbing")
+}
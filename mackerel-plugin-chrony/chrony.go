@@ -0,0 +1,303 @@
+package main
+
+import (
+	"encoding/csv"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"chrony.offset": mp.Graphs{
+		Label: "Chrony Offset (msec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "system_time", Label: "System Time"},
+			mp.Metrics{Name: "last_offset", Label: "Last Offset"},
+			mp.Metrics{Name: "rms_offset", Label: "RMS Offset"},
+		},
+	},
+	"chrony.frequency": mp.Graphs{
+		Label: "Chrony Frequency (ppm)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "frequency", Label: "Frequency"},
+			mp.Metrics{Name: "residual_freq", Label: "Residual Frequency"},
+			mp.Metrics{Name: "skew", Label: "Skew"},
+		},
+	},
+	"chrony.root": mp.Graphs{
+		Label: "Chrony Root Delay and Dispersion (msec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "root_delay", Label: "Root Delay"},
+			mp.Metrics{Name: "root_dispersion", Label: "Root Dispersion"},
+		},
+	},
+	"chrony.stratum": mp.Graphs{
+		Label: "Chrony Stratum",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "stratum", Label: "Stratum"},
+		},
+	},
+	"chrony.leap_status": mp.Graphs{
+		Label: "Chrony Leap Status (0:Normal, 3:Not synchronised)",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "leap_status", Label: "Leap Status"},
+		},
+	},
+	"chrony.sources": mp.Graphs{
+		Label: "Chrony Sources",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "sources", Label: "Total"},
+			mp.Metrics{Name: "sources_reachable", Label: "Reachable"},
+			mp.Metrics{Name: "sources_selected", Label: "Selected"},
+			mp.Metrics{Name: "sources_combined", Label: "Combined"},
+		},
+	},
+}
+
+// metrics of each source, which are drawn in graphs for each source
+var sourceMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "source_offset_", Label: "Offset", Unit: "float",
+		Graph: "source_offset", GraphLabel: "Chrony Source Offset (msec)", Group: "offset", GroupLabel: "Chrony Source Offset and Jitter (msec)"},
+	common.DimensionMetric{Prefix: "source_std_dev_", Label: "Jitter (Std Dev)", Unit: "float",
+		Graph: "source_std_dev", GraphLabel: "Chrony Source Jitter (msec)", Group: "offset", GroupLabel: "Chrony Source Offset and Jitter (msec)"},
+	common.DimensionMetric{Prefix: "source_reach_", Label: "Reached", Unit: "integer",
+		Graph: "source_reach", GraphLabel: "Chrony Source Reachability", Group: "reach", GroupLabel: "Chrony Source Reachability (of Last 8 Polls)"},
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+var leapStatus map[string]float64 = map[string]float64{
+	"Normal":           0,
+	"Insert second":    1,
+	"Delete second":    2,
+	"Not synchronised": 3,
+}
+
+type ChronyPlugin struct {
+	Chronyc string
+	Sources []string
+}
+
+func readCSV(r io.Reader) ([][]string, error) {
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
+	return reader.ReadAll()
+}
+
+// % chronyc -c tracking
+// A9FEA97B,169.254.169.123,4,1594610749.772894081,-0.000001447,0.000001330,0.000003984,-20.364,-0.000,0.013,0.000431382,0.000229882,64.2,Normal
+//
+// Reference ID, Name, Stratum, Ref time, System time, Last offset, RMS offset, Frequency, Residual freq, Skew,
+// Root delay, Root dispersion, Update interval, Leap status. times are in seconds
+func parseTracking(r io.Reader, stat map[string]float64) error {
+	records, err := readCSV(r)
+	if err != nil {
+		return err
+	}
+	if len(records) == 0 || len(records[0]) < 14 {
+		return errors.New("cannot parse the output of chronyc tracking")
+	}
+	fields := records[0]
+
+	for i, name := range map[int]string{
+		2: "stratum", 4: "system_time", 5: "last_offset", 6: "rms_offset",
+		7: "frequency", 8: "residual_freq", 9: "skew", 10: "root_delay", 11: "root_dispersion",
+	} {
+		v, err := strconv.ParseFloat(fields[i], 64)
+		if err != nil {
+			return err
+		}
+		stat[name] = v
+	}
+
+	// in milliseconds
+	for _, name := range []string{"system_time", "last_offset", "rms_offset", "root_delay", "root_dispersion"} {
+		stat[name] *= 1000
+	}
+
+	if v, ok := leapStatus[fields[13]]; ok {
+		stat["leap_status"] = v
+	}
+
+	return nil
+}
+
+// % chronyc -c sources
+// ^,*,169.254.169.123,3,4,377,13,0.000000374,0.000000374,0.000228
+// ^,?,ntp.example.com,0,6,0,-,0.000000000,0.000000000,0.000000
+//
+// Mode, State, Name, Stratum, Poll, Reach (octal), LastRx, Last offset, Offset, Error.
+// the names of the sources are returned, made valid in metric names
+func parseSources(r io.Reader, stat map[string]float64) ([]string, error) {
+	records, err := readCSV(r)
+	if err != nil {
+		return nil, err
+	}
+
+	stat["sources"] = 0
+	stat["sources_reachable"] = 0
+	stat["sources_selected"] = 0
+	stat["sources_combined"] = 0
+	var names []string
+	for _, fields := range records {
+		if len(fields) < 6 {
+			continue
+		}
+		stat["sources"]++
+		name := metricName(fields[2])
+		names = append(names, name)
+
+		// the bits of the last 8 polls, set if succeeded
+		if reach, err := strconv.ParseUint(fields[5], 8, 8); err == nil {
+			if reach != 0 {
+				stat["sources_reachable"]++
+			}
+			var reached float64
+			for ; reach != 0; reach >>= 1 {
+				reached += float64(reach & 1)
+			}
+			stat["source_reach_"+name] = reached
+		}
+
+		switch fields[1] {
+		case "*":
+			stat["sources_selected"]++
+		case "+":
+			stat["sources_combined"]++
+		}
+	}
+
+	return names, nil
+}
+
+// % chronyc -c sourcestats
+// 169.254.169.123,12,7,710,-0.000,0.003,-0.000000011,0.000000397
+//
+// Name, NP, NR, Span, Frequency, Freq Skew, Offset, Std Dev. the offset and the std dev are in seconds
+func parseSourcestats(r io.Reader, stat map[string]float64) error {
+	records, err := readCSV(r)
+	if err != nil {
+		return err
+	}
+
+	for _, fields := range records {
+		if len(fields) < 8 {
+			continue
+		}
+		name := metricName(fields[0])
+		if v, err := strconv.ParseFloat(fields[6], 64); err == nil {
+			stat["source_offset_"+name] = v * 1000
+		}
+		if v, err := strconv.ParseFloat(fields[7], 64); err == nil {
+			stat["source_std_dev_"+name] = v * 1000
+		}
+	}
+
+	return nil
+}
+
+func (p ChronyPlugin) chronyc(args ...string) (io.Reader, error) {
+	args = append([]string{"-c"}, args...)
+	out, err := exec.Command(p.Chronyc, args...).Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s %s: %s", p.Chronyc, strings.Join(args, " "), err))
+	}
+	return strings.NewReader(string(out)), nil
+}
+
+func (p ChronyPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	r, err := p.chronyc("tracking")
+	if err != nil {
+		return nil, err
+	}
+	if err := parseTracking(r, stat); err != nil {
+		return nil, err
+	}
+
+	r, err = p.chronyc("sources")
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseSources(r, stat); err != nil {
+		return nil, err
+	}
+
+	r, err = p.chronyc("sourcestats")
+	if err != nil {
+		return nil, err
+	}
+	if err := parseSourcestats(r, stat); err != nil {
+		return nil, err
+	}
+
+	return stat, nil
+}
+
+// Prepare lists the sources for the graphs of each source
+func (p *ChronyPlugin) Prepare() error {
+	r, err := p.chronyc("sources")
+	if err != nil {
+		return err
+	}
+	p.Sources, err = parseSources(r, make(map[string]float64))
+	return err
+}
+
+func (p ChronyPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := common.DimensionGraphs("chrony", sourceMetrics, p.Sources, true)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optChronyc := flag.String("chronyc", "chronyc", "Path of chronyc")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var chrony ChronyPlugin
+	chrony.Chronyc = *optChronyc
+	if err := chrony.Prepare(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(chrony))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-chrony"
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseTracking(t *testing.T) {
+	stub := "A9FEA97B,169.254.169.123,4,1594610749.772894081,-0.000001447,0.000001330,0.000003984,-20.364,-0.000,0.013,0.000431382,0.000229882,64.2,Normal\n"
+
+	stat := make(map[string]float64)
+	err := parseTracking(strings.NewReader(stub), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["stratum"], 4.0)
+	assert.InDelta(t, stat["system_time"], -0.001447, 1e-9)
+	assert.InDelta(t, stat["rms_offset"], 0.003984, 1e-9)
+	assert.Equal(t, stat["frequency"], -20.364)
+	assert.Equal(t, stat["skew"], 0.013)
+	assert.InDelta(t, stat["root_delay"], 0.431382, 1e-9)
+	assert.Equal(t, stat["leap_status"], 0.0)
+
+	err = parseTracking(strings.NewReader("506 Cannot talk to daemon\n"), make(map[string]float64))
+	assert.NotNil(t, err)
+}
+
+func TestParseSources(t *testing.T) {
+	stub := `^,*,169.254.169.123,3,4,377,13,0.000000374,0.000000374,0.000228
+^,+,ntp1.example.com,2,6,377,35,-0.000120000,-0.000118000,0.012000
+^,-,ntp2.example.com,2,6,17,40,0.000300000,0.000310000,0.020000
+^,?,ntp3.example.com,0,6,0,-,0.000000000,0.000000000,0.000000
+`
+
+	stat := make(map[string]float64)
+	names, err := parseSources(strings.NewReader(stub), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, names, []string{"169_254_169_123", "ntp1_example_com", "ntp2_example_com", "ntp3_example_com"})
+	assert.Equal(t, stat["sources"], 4.0)
+	assert.Equal(t, stat["sources_reachable"], 3.0)
+	assert.Equal(t, stat["sources_selected"], 1.0)
+	assert.Equal(t, stat["sources_combined"], 1.0)
+	assert.Equal(t, stat["source_reach_169_254_169_123"], 8.0)
+	assert.Equal(t, stat["source_reach_ntp2_example_com"], 4.0)
+	assert.Equal(t, stat["source_reach_ntp3_example_com"], 0.0)
+}
+
+func TestParseSourcestats(t *testing.T) {
+	stub := `169.254.169.123,12,7,710,-0.000,0.003,-0.000000011,0.000000397
+ntp1.example.com,6,3,323,0.512,4.231,-0.000118000,0.000654000
+ntp3.example.com,0,0,0,+0.000,2000.000,+0.000000000,4000.000000000
+`
+
+	stat := make(map[string]float64)
+	err := parseSourcestats(strings.NewReader(stub), stat)
+	assert.Nil(t, err)
+	assert.InDelta(t, stat["source_offset_169_254_169_123"], -0.000011, 1e-9)
+	assert.InDelta(t, stat["source_std_dev_169_254_169_123"], 0.000397, 1e-9)
+	assert.InDelta(t, stat["source_offset_ntp1_example_com"], -0.118, 1e-9)
+	assert.InDelta(t, stat["source_std_dev_ntp1_example_com"], 0.654, 1e-9)
+}
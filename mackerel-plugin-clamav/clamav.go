@@ -0,0 +1,278 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// databases loaded by clamd / clamscan, updated by freshclam
+var databases = []string{"main", "daily", "bytecode"}
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"clamav.database_age": mp.Graphs{
+		Label: "ClamAV Database Age (hours)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "main_age", Label: "main"},
+			mp.Metrics{Name: "daily_age", Label: "daily"},
+			mp.Metrics{Name: "bytecode_age", Label: "bytecode"},
+		},
+	},
+	"clamav.database_version": mp.Graphs{
+		Label: "ClamAV Database Version",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "main_version", Label: "main"},
+			mp.Metrics{Name: "daily_version", Label: "daily"},
+			mp.Metrics{Name: "bytecode_version", Label: "bytecode"},
+		},
+	},
+	"clamav.clamd_queue": mp.Graphs{
+		Label: "ClamAV clamd Queue",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "queue", Label: "Queue Length"},
+		},
+	},
+	"clamav.clamd_threads": mp.Graphs{
+		Label: "ClamAV clamd Threads",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "threads_live", Label: "Live", Stacked: true},
+			mp.Metrics{Name: "threads_idle", Label: "Idle", Stacked: true},
+			mp.Metrics{Name: "threads_max", Label: "Max"},
+		},
+	},
+}
+
+type ClamAVPlugin struct {
+	DBDir string
+	Clamd string
+}
+
+type dbHeader struct {
+	Version   float64
+	BuildTime time.Time
+}
+
+// the first 512 bytes of main.cvd / daily.cld are the header like below
+// ClamAV-VDB:16 Sep 2021 08-32 -0400:62:6647427:90:<md5>:<dsig>:sigmgr:1631795567
+// (build time, version, number of signatures, functionality level, md5, signature, builder, build time in unix time)
+func parseDBHeader(str string) (dbHeader, error) {
+	var h dbHeader
+
+	fields := strings.Split(strings.TrimRight(str, "\x00 \n"), ":")
+	if len(fields) < 3 || fields[0] != "ClamAV-VDB" {
+		return h, errors.New("invalid database header")
+	}
+
+	v, err := strconv.ParseFloat(fields[2], 64)
+	if err != nil {
+		return h, err
+	}
+	h.Version = v
+
+	if len(fields) >= 9 {
+		if sec, err := strconv.ParseInt(strings.TrimSpace(fields[8]), 10, 64); err == nil {
+			h.BuildTime = time.Unix(sec, 0)
+			return h, nil
+		}
+	}
+
+	// older databases have no unix time field
+	h.BuildTime, err = time.Parse("02 Jan 2006 15-04 -0700", fields[1])
+	if err != nil {
+		return h, err
+	}
+
+	return h, nil
+}
+
+func readDBHeader(path string) (dbHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return dbHeader{}, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, 512)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return dbHeader{}, err
+	}
+
+	return parseDBHeader(string(buf[:n]))
+}
+
+// freshclam keeps either <name>.cvd (downloaded as is) or <name>.cld (updated by diffs)
+func (p ClamAVPlugin) findDB(name string) (string, error) {
+	var found string
+	var modTime time.Time
+	for _, ext := range []string{".cvd", ".cld"} {
+		path := filepath.Join(p.DBDir, name+ext)
+		fi, err := os.Stat(path)
+		if err != nil {
+			continue
+		}
+		if found == "" || fi.ModTime().After(modTime) {
+			found = path
+			modTime = fi.ModTime()
+		}
+	}
+	if found == "" {
+		return "", errors.New(fmt.Sprintf("%s.cvd or %s.cld not found in %s", name, name, p.DBDir))
+	}
+	return found, nil
+}
+
+// % echo STATS | nc 127.0.0.1 3310
+// POOLS: 1
+//
+// STATE: VALID PRIMARY
+// THREADS: live 1  idle 0 max 12 idle-timeout 30
+// QUEUE: 0 items
+// ...
+// END
+func parseClamdStats(r io.Reader, stat map[string]float64) error {
+	found := false
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case strings.HasPrefix(line, "THREADS:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "THREADS:"))
+			for i := 0; i+1 < len(fields); i += 2 {
+				switch fields[i] {
+				case "live", "idle", "max":
+					v, err := strconv.ParseFloat(fields[i+1], 64)
+					if err != nil {
+						return err
+					}
+					stat["threads_"+fields[i]] = v
+				}
+			}
+			found = true
+		case strings.HasPrefix(line, "QUEUE:"):
+			fields := strings.Fields(strings.TrimPrefix(line, "QUEUE:"))
+			if len(fields) == 0 {
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[0], 64)
+			if err != nil {
+				return err
+			}
+			stat["queue"] = v
+			found = true
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if !found {
+		return errors.New("unexpected STATS response")
+	}
+	return nil
+}
+
+func (p ClamAVPlugin) fetchClamdStats(stat map[string]float64) error {
+	network := "tcp"
+	if strings.HasPrefix(p.Clamd, "/") {
+		network = "unix"
+	}
+
+	conn, err := net.DialTimeout(network, p.Clamd, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	// the "n" prefix makes clamd reply with newline delimited output
+	if _, err := conn.Write([]byte("nSTATS\n")); err != nil {
+		return err
+	}
+
+	return parseClamdStats(conn, stat)
+}
+
+func (p ClamAVPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	now := time.Now()
+
+	for _, name := range databases {
+		path, err := p.findDB(name)
+		if err != nil {
+			// bytecode database is optional
+			if name != "bytecode" {
+				log.Println(err)
+			}
+			continue
+		}
+		h, err := readDBHeader(path)
+		if err != nil {
+			common.LogFetchError(path, err)
+			continue
+		}
+		stat[name+"_version"] = h.Version
+		stat[name+"_age"] = now.Sub(h.BuildTime).Hours()
+	}
+
+	if len(stat) == 0 {
+		return nil, errors.New("cannot read any database in " + p.DBDir)
+	}
+
+	// clamd is not necessarily running (e.g. only clamscan is used)
+	if p.Clamd != "" {
+		if err := p.fetchClamdStats(stat); err != nil {
+			log.Printf("clamd: %s", err)
+		}
+	}
+
+	return stat, nil
+}
+
+func (p ClamAVPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optDBDir := flag.String("db-dir", "/var/lib/clamav", "ClamAV database directory")
+	optClamd := flag.String("clamd", "/var/run/clamav/clamd.ctl", "clamd socket path or host:port (empty to disable)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var clamav ClamAVPlugin
+	clamav.DBDir = *optDBDir
+	clamav.Clamd = *optClamd
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(clamav))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-clamav"
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseDBHeader(t *testing.T) {
+	stub := "ClamAV-VDB:16 Sep 2021 08-32 -0400:26294:3982603:90:2a3b1e00a4b1c7d12b7c8f1d2e5f0a11:sig:raynman:1631795567" + strings.Repeat(" ", 100)
+	h, err := parseDBHeader(stub)
+	assert.Nil(t, err)
+	assert.Equal(t, h.Version, 26294.0)
+	assert.Equal(t, h.BuildTime.Unix(), int64(1631795567))
+
+	// without unix time
+	stub = "ClamAV-VDB:16 Sep 2021 08-32 -0400:62:6647427:90"
+	h, err = parseDBHeader(stub)
+	assert.Nil(t, err)
+	assert.Equal(t, h.Version, 62.0)
+	assert.Equal(t, h.BuildTime.Equal(time.Date(2021, 9, 16, 12, 32, 0, 0, time.UTC)), true)
+
+	_, err = parseDBHeader("\x1f\x8b\x08\x00")
+	assert.NotNil(t, err)
+}
+
+func TestParseClamdStats(t *testing.T) {
+	stub := "POOLS: 1\n\nSTATE: VALID PRIMARY\n" +
+		"THREADS: live 3  idle 2 max 12 idle-timeout 30\n" +
+		"QUEUE: 4 items\n" +
+		"\tSTATS 0.000094\n\n" +
+		"MEMSTATS: heap 3.656M mmap 0.129M used 3.294M free 0.363M releasable 0.125M pools 1 pools_used 565.584M pools_total 565.613M\n" +
+		"END\n"
+
+	stat := make(map[string]float64)
+	err := parseClamdStats(strings.NewReader(stub), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["threads_live"], 3.0)
+	assert.Equal(t, stat["threads_idle"], 2.0)
+	assert.Equal(t, stat["threads_max"], 12.0)
+	assert.Equal(t, stat["queue"], 4.0)
+
+	err = parseClamdStats(strings.NewReader("UNKNOWN COMMAND\n"), make(map[string]float64))
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"consul.leader": mp.Graphs{
+		Label: "Consul Raft Leader",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "leader", Label: "Leader Elected"},
+		},
+	},
+	"consul.checks": mp.Graphs{
+		Label: "Consul Health Checks",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "checks_passing", Label: "Passing", Stacked: true},
+			mp.Metrics{Name: "checks_warning", Label: "Warning", Stacked: true},
+			mp.Metrics{Name: "checks_critical", Label: "Critical", Stacked: true},
+		},
+	},
+	"consul.catalog": mp.Graphs{
+		Label: "Consul Catalog",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "services", Label: "Services"},
+			mp.Metrics{Name: "nodes", Label: "Nodes"},
+		},
+	},
+
+	// the graphs of serviceMetrics will be generated dynamically
+}
+
+// metrics per service
+var serviceMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "instances_", Unit: "integer",
+		Graph: "service_instances", GraphLabel: "Consul Service Instances"},
+	common.DimensionMetric{Prefix: "passing_instances_", Unit: "integer",
+		Graph: "service_passing_instances", GraphLabel: "Consul Service Passing Instances"},
+}
+
+var checkStatuses = []string{"passing", "warning", "critical"}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+type ConsulPlugin struct {
+	URL         string
+	Token       string
+	Concurrency int
+	Services    []string
+}
+
+// a health check of /v1/health/state/any, or of the instances of /v1/health/service/<service>
+type consulCheck struct {
+	Node        string `json:"Node"`
+	CheckID     string `json:"CheckID"`
+	Status      string `json:"Status"`
+	ServiceName string `json:"ServiceName"`
+}
+
+// an instance of /v1/health/service/<service>, with the checks of the node and the instance
+type serviceEntry struct {
+	Checks []consulCheck `json:"Checks"`
+}
+
+func (p ConsulPlugin) get(path string, v interface{}) error {
+	req, err := http.NewRequest("GET", p.URL+path, nil)
+	if err != nil {
+		return err
+	}
+	if p.Token != "" {
+		req.Header.Set("X-Consul-Token", p.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("%s: HTTP status error: %d", path, resp.StatusCode))
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// countChecks returns the number of the checks by status
+func countChecks(checks []consulCheck) map[string]float64 {
+	counts := make(map[string]float64)
+	for _, s := range checkStatuses {
+		counts[s] = 0
+	}
+	for _, c := range checks {
+		if _, ok := counts[c.Status]; ok {
+			counts[c.Status]++
+		}
+	}
+	return counts
+}
+
+// countInstances returns the number of the instances of a service,
+// and the ones whose checks are all passing
+func countInstances(entries []serviceEntry) (float64, float64) {
+	var passing float64
+	for _, e := range entries {
+		ok := true
+		for _, c := range e.Checks {
+			if c.Status != "passing" {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			passing++
+		}
+	}
+	return float64(len(entries)), passing
+}
+
+// serviceNames returns the names of the services in the catalog, sorted
+func serviceNames(services map[string][]string) []string {
+	names := make([]string, 0, len(services))
+	for name := range services {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p ConsulPlugin) fetchServices() ([]string, error) {
+	var services map[string][]string
+	if err := p.get("/v1/catalog/services", &services); err != nil {
+		return nil, err
+	}
+	return serviceNames(services), nil
+}
+
+// Prepare lists the services for the graphs
+func (p *ConsulPlugin) Prepare() error {
+	services, err := p.fetchServices()
+	if err != nil {
+		return err
+	}
+
+	p.Services = make([]string, 0, len(services))
+	for _, s := range services {
+		if name := metricName(s); name != "" {
+			p.Services = append(p.Services, name)
+		}
+	}
+	return nil
+}
+
+func (p ConsulPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	// the address of the leader, which is empty while no leader is elected
+	var leader string
+	if err := p.get("/v1/status/leader", &leader); err != nil {
+		return nil, err
+	}
+	if leader == "" {
+		stat["leader"] = 0
+		// the catalog is not served without the leader
+		return stat, nil
+	}
+	stat["leader"] = 1
+
+	var checks []consulCheck
+	if err := p.get("/v1/health/state/any", &checks); err != nil {
+		return nil, err
+	}
+	for status, n := range countChecks(checks) {
+		stat["checks_"+status] = n
+	}
+
+	var nodes []json.RawMessage
+	if err := p.get("/v1/catalog/nodes", &nodes); err != nil {
+		return nil, err
+	}
+	stat["nodes"] = float64(len(nodes))
+
+	services, err := p.fetchServices()
+	if err != nil {
+		return nil, err
+	}
+	stat["services"] = float64(len(services))
+
+	entries := make([][]serviceEntry, len(services))
+	errs := make([]error, len(services))
+	common.FetchMany(len(services), p.Concurrency, func(i int) {
+		errs[i] = p.get("/v1/health/service/"+url.PathEscape(services[i]), &entries[i])
+	})
+	for i, s := range services {
+		name := metricName(s)
+		if name == "" {
+			continue
+		}
+		if errs[i] != nil {
+			common.LogFetchError(s, errs[i])
+			continue
+		}
+		stat["instances_"+name], stat["passing_instances_"+name] = countInstances(entries[i])
+	}
+
+	return stat, nil
+}
+
+func (p ConsulPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := common.DimensionGraphs("consul", serviceMetrics, p.Services, false)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optURL := flag.String("url", "http://127.0.0.1:8500", "URL of the Consul agent HTTP API")
+	optToken := flag.String("token", "", "ACL token (default: $CONSUL_HTTP_TOKEN)")
+	optConcurrency := flag.Int("concurrency", common.DefaultConcurrency, "Maximum number of services fetched at once")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var consul ConsulPlugin
+	consul.URL = strings.TrimRight(*optURL, "/")
+	consul.Token = *optToken
+	if consul.Token == "" {
+		consul.Token = os.Getenv("CONSUL_HTTP_TOKEN")
+	}
+	consul.Concurrency = *optConcurrency
+
+	// the catalog is not served without the leader, whose loss should still be reported
+	if err := consul.Prepare(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(consul))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-consul", "url")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCountChecks(t *testing.T) {
+	stub := `[
+{"Node": "web1", "CheckID": "serfHealth", "Name": "Serf Health Status", "Status": "passing", "ServiceID": "", "ServiceName": ""},
+{"Node": "web1", "CheckID": "service:web", "Name": "Service 'web' check", "Status": "critical", "ServiceID": "web", "ServiceName": "web"},
+{"Node": "web2", "CheckID": "service:web", "Name": "Service 'web' check", "Status": "passing", "ServiceID": "web", "ServiceName": "web"},
+{"Node": "db1", "CheckID": "service:db", "Name": "Service 'db' check", "Status": "warning", "ServiceID": "db", "ServiceName": "db"}
+]`
+	var checks []consulCheck
+	assert.Nil(t, json.Unmarshal([]byte(stub), &checks))
+
+	counts := countChecks(checks)
+	assert.Equal(t, counts["passing"], 2.0)
+	assert.Equal(t, counts["warning"], 1.0)
+	assert.Equal(t, counts["critical"], 1.0)
+
+	counts = countChecks(nil)
+	assert.Equal(t, counts["critical"], 0.0)
+}
+
+func TestCountInstances(t *testing.T) {
+	stub := `[
+{"Node": {"Node": "web1"}, "Service": {"ID": "web", "Service": "web"}, "Checks": [
+  {"Node": "web1", "CheckID": "serfHealth", "Status": "passing"},
+  {"Node": "web1", "CheckID": "service:web", "Status": "critical", "ServiceName": "web"}]},
+{"Node": {"Node": "web2"}, "Service": {"ID": "web", "Service": "web"}, "Checks": [
+  {"Node": "web2", "CheckID": "serfHealth", "Status": "passing"},
+  {"Node": "web2", "CheckID": "service:web", "Status": "passing", "ServiceName": "web"}]},
+{"Node": {"Node": "web3"}, "Service": {"ID": "web", "Service": "web"}, "Checks": []}
+]`
+	var entries []serviceEntry
+	assert.Nil(t, json.Unmarshal([]byte(stub), &entries))
+
+	instances, passing := countInstances(entries)
+	assert.Equal(t, instances, 3.0)
+	assert.Equal(t, passing, 2.0)
+}
+
+func TestServiceNames(t *testing.T) {
+	services := map[string][]string{"web": []string{"v1"}, "consul": []string{}, "db": nil}
+	assert.Equal(t, serviceNames(services), []string{"consul", "db", "web"})
+}
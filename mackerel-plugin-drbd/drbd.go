@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// connection states, so that "not Connected" is simply "> 0"
+const (
+	stateConnected    = 0 // connected and in sync
+	stateSyncing      = 1 // connected, but resyncing, verifying or waiting for it
+	stateConnecting   = 2
+	stateDisconnected = 3
+)
+
+var connectionStates map[string]float64 = map[string]float64{
+	"Connected":      stateConnected,
+	"Established":    stateConnected,
+	"StartingSyncS":  stateSyncing,
+	"StartingSyncT":  stateSyncing,
+	"WFBitMapS":      stateSyncing,
+	"WFBitMapT":      stateSyncing,
+	"WFSyncUUID":     stateSyncing,
+	"SyncSource":     stateSyncing,
+	"SyncTarget":     stateSyncing,
+	"PausedSyncS":    stateSyncing,
+	"PausedSyncT":    stateSyncing,
+	"VerifyS":        stateSyncing,
+	"VerifyT":        stateSyncing,
+	"Ahead":          stateSyncing,
+	"Behind":         stateSyncing,
+	"WFConnection":   stateConnecting,
+	"WFReportParams": stateConnecting,
+	"Connecting":     stateConnecting,
+}
+
+// disk states, UpToDate is 0 and the larger the worse
+var diskStates map[string]float64 = map[string]float64{
+	"UpToDate":     0,
+	"Consistent":   1,
+	"Outdated":     2,
+	"Inconsistent": 3,
+	"Attaching":    4,
+	"Negotiating":  4,
+	"Detaching":    5,
+	"DUnknown":     5,
+	"Diskless":     6,
+	"Failed":       7,
+}
+
+func connectionState(s string) float64 {
+	if v, ok := connectionStates[s]; ok {
+		return v
+	}
+	return stateDisconnected
+}
+
+func diskState(s string) float64 {
+	if v, ok := diskStates[s]; ok {
+		return v
+	}
+	return diskStates["DUnknown"]
+}
+
+type drbdResource struct {
+	Name            string
+	ConnectionState float64
+	DiskState       float64
+	PeerDiskState   float64
+	Primary         float64
+	// in bytes
+	NetSend    float64
+	NetReceive float64
+	DiskWrite  float64
+	DiskRead   float64
+	OutOfSync  float64
+}
+
+// % cat /proc/drbd (8.x)
+// version: 8.4.11-1 (api:1/proto:86-101)
+// GIT-hash: 66145a308421e9c124ec391a7848ac20203bb03c build by mockbuild@, 2018-11-03 01:26:55
+// ...0: cs:Connected ro:Primary/Secondary ds:UpToDate/UpToDate C r-----
+// ......ns:1048576 nr:0 dw:1048576 dr:2048 al:16 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:0
+// ...1: cs:Unconfigured
+func parseProcDRBD(r io.Reader) ([]drbdResource, error) {
+	var resources []drbdResource
+	var cur *drbdResource
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		if strings.HasSuffix(fields[0], ":") && !strings.Contains(fields[0][:len(fields[0])-1], ":") {
+			minor := strings.TrimSuffix(fields[0], ":")
+			if _, err := strconv.Atoi(minor); err != nil {
+				// version: or GIT-hash:
+				cur = nil
+				continue
+			}
+			if len(fields) < 2 || fields[1] == "cs:Unconfigured" {
+				cur = nil
+				continue
+			}
+			resources = append(resources, drbdResource{Name: "drbd" + minor})
+			cur = &resources[len(resources)-1]
+		}
+		if cur == nil {
+			continue
+		}
+
+		for _, field := range fields {
+			kv := strings.SplitN(field, ":", 2)
+			if len(kv) != 2 {
+				continue
+			}
+			switch kv[0] {
+			case "cs":
+				cur.ConnectionState = connectionState(kv[1])
+			case "ro":
+				if strings.HasPrefix(kv[1], "Primary/") {
+					cur.Primary = 1
+				}
+			case "ds":
+				disks := strings.SplitN(kv[1], "/", 2)
+				cur.DiskState = diskState(disks[0])
+				if len(disks) == 2 {
+					cur.PeerDiskState = diskState(disks[1])
+				}
+			case "ns", "nr", "dw", "dr", "oos":
+				// KiB
+				v, err := strconv.ParseFloat(kv[1], 64)
+				if err != nil {
+					continue
+				}
+				v *= 1024
+				switch kv[0] {
+				case "ns":
+					cur.NetSend = v
+				case "nr":
+					cur.NetReceive = v
+				case "dw":
+					cur.DiskWrite = v
+				case "dr":
+					cur.DiskRead = v
+				case "oos":
+					cur.OutOfSync = v
+				}
+			}
+		}
+	}
+
+	return resources, scanner.Err()
+}
+
+// % drbdsetup status --json (9.x)
+// [{"name":"r0","role":"Primary","devices":[{"volume":0,"minor":0,"disk-state":"UpToDate","read":2048,"written":1048576,...}],
+// "connections":[{"name":"node2","connection-state":"Connected","peer-role":"Secondary","peer_devices":[{"volume":0,
+// "replication-state":"Established","peer-disk-state":"UpToDate","received":0,"sent":1048576,"out-of-sync":0,...}]}]}]
+type drbdStatus struct {
+	Name    string `json:"name"`
+	Role    string `json:"role"`
+	Devices []struct {
+		DiskState string  `json:"disk-state"`
+		Read      float64 `json:"read"`
+		Written   float64 `json:"written"`
+	} `json:"devices"`
+	Connections []struct {
+		ConnectionState string `json:"connection-state"`
+		PeerDevices     []struct {
+			ReplicationState string  `json:"replication-state"`
+			PeerDiskState    string  `json:"peer-disk-state"`
+			Received         float64 `json:"received"`
+			Sent             float64 `json:"sent"`
+			OutOfSync        float64 `json:"out-of-sync"`
+		} `json:"peer_devices"`
+	} `json:"connections"`
+}
+
+// parseStatusJSON summarizes each resource with the worst states of its volumes and peers,
+// and the sums of their counters.
+func parseStatusJSON(r io.Reader) ([]drbdResource, error) {
+	var statuses []drbdStatus
+	if err := json.NewDecoder(r).Decode(&statuses); err != nil {
+		return nil, err
+	}
+
+	resources := make([]drbdResource, 0, len(statuses))
+	for _, s := range statuses {
+		res := drbdResource{Name: s.Name}
+		if s.Role == "Primary" {
+			res.Primary = 1
+		}
+
+		for _, d := range s.Devices {
+			if v := diskState(d.DiskState); v > res.DiskState {
+				res.DiskState = v
+			}
+			res.DiskRead += d.Read * 1024
+			res.DiskWrite += d.Written * 1024
+		}
+
+		// a resource without peers is not replicated at all
+		if len(s.Connections) == 0 {
+			res.ConnectionState = stateDisconnected
+		}
+		for _, c := range s.Connections {
+			if v := connectionState(c.ConnectionState); v > res.ConnectionState {
+				res.ConnectionState = v
+			}
+			for _, pd := range c.PeerDevices {
+				if c.ConnectionState == "Connected" {
+					if v := connectionState(pd.ReplicationState); v > res.ConnectionState {
+						res.ConnectionState = v
+					}
+				}
+				if v := diskState(pd.PeerDiskState); v > res.PeerDiskState {
+					res.PeerDiskState = v
+				}
+				res.NetReceive += pd.Received * 1024
+				res.NetSend += pd.Sent * 1024
+				res.OutOfSync += pd.OutOfSync * 1024
+			}
+		}
+
+		resources = append(resources, res)
+	}
+
+	return resources, nil
+}
+
+type DRBDPlugin struct {
+	ProcPath      string
+	DrbdsetupPath string
+	Resources     []string
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// fetchResources reads /proc/drbd of 8.x, which no longer has the resources since 9.0
+func (p DRBDPlugin) fetchResources() ([]drbdResource, error) {
+	proc, err := ioutil.ReadFile(p.ProcPath)
+	if err != nil {
+		return nil, err
+	}
+	if strings.HasPrefix(string(proc), "version: 8.") {
+		return parseProcDRBD(strings.NewReader(string(proc)))
+	}
+
+	out, err := exec.Command(p.DrbdsetupPath, "status", "--json").Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s status --json: %s", p.DrbdsetupPath, err))
+	}
+	return parseStatusJSON(strings.NewReader(string(out)))
+}
+
+func (p *DRBDPlugin) Prepare() error {
+	resources, err := p.fetchResources()
+	if err != nil {
+		return err
+	}
+	for _, res := range resources {
+		p.Resources = append(p.Resources, res.Name)
+	}
+	if len(p.Resources) == 0 {
+		return errors.New("no drbd resources found")
+	}
+	return nil
+}
+
+func (p DRBDPlugin) FetchMetrics() (map[string]float64, error) {
+	resources, err := p.fetchResources()
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	for _, res := range resources {
+		prefix := metricName(res.Name) + "_"
+		stat[prefix+"connection_state"] = res.ConnectionState
+		stat[prefix+"disk_state"] = res.DiskState
+		stat[prefix+"peer_disk_state"] = res.PeerDiskState
+		stat[prefix+"primary"] = res.Primary
+		stat[prefix+"net_send"] = res.NetSend
+		stat[prefix+"net_receive"] = res.NetReceive
+		stat[prefix+"disk_write"] = res.DiskWrite
+		stat[prefix+"disk_read"] = res.DiskRead
+		stat[prefix+"out_of_sync"] = res.OutOfSync
+	}
+
+	return stat, nil
+}
+
+func (p DRBDPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphdef := make(map[string](mp.Graphs))
+
+	for _, name := range p.Resources {
+		prefix := metricName(name)
+
+		graphdef["drbd."+prefix+".state"] = mp.Graphs{
+			Label: "DRBD " + name + " State",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_connection_state", Label: "Connection (0:Connected)"},
+				mp.Metrics{Name: prefix + "_disk_state", Label: "Disk (0:UpToDate)"},
+				mp.Metrics{Name: prefix + "_peer_disk_state", Label: "Peer Disk (0:UpToDate)"},
+				mp.Metrics{Name: prefix + "_primary", Label: "Primary"},
+			},
+		}
+		graphdef["drbd."+prefix+".network"] = mp.Graphs{
+			Label: "DRBD " + name + " Network",
+			Unit:  "bytes/sec",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_net_send", Label: "Send", Diff: true},
+				mp.Metrics{Name: prefix + "_net_receive", Label: "Receive", Diff: true},
+			},
+		}
+		graphdef["drbd."+prefix+".disk"] = mp.Graphs{
+			Label: "DRBD " + name + " Disk",
+			Unit:  "bytes/sec",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_disk_write", Label: "Write", Diff: true},
+				mp.Metrics{Name: prefix + "_disk_read", Label: "Read", Diff: true},
+			},
+		}
+		graphdef["drbd."+prefix+".out_of_sync"] = mp.Graphs{
+			Label: "DRBD " + name + " Out of Sync",
+			Unit:  "bytes",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_out_of_sync", Label: "Out of Sync"},
+			},
+		}
+	}
+
+	return graphdef
+}
+
+func main() {
+	optProcPath := flag.String("proc", "/proc/drbd", "/proc/drbd path")
+	optDrbdsetupPath := flag.String("drbdsetup", "drbdsetup", "drbdsetup command path (for DRBD 9)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var drbd DRBDPlugin
+	drbd.ProcPath = *optProcPath
+	drbd.DrbdsetupPath = *optDrbdsetupPath
+
+	err := drbd.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(drbd))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-drbd"
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseProcDRBD(t *testing.T) {
+	stub := `version: 8.4.11-1 (api:1/proto:86-101)
+GIT-hash: 66145a308421e9c124ec391a7848ac20203bb03c build by mockbuild@, 2018-11-03 01:26:55
+ 0: cs:Connected ro:Primary/Secondary ds:UpToDate/UpToDate C r-----
+    ns:1048576 nr:0 dw:1048576 dr:2048 al:16 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:0
+ 1: cs:WFConnection ro:Secondary/Unknown ds:UpToDate/DUnknown C r-----
+    ns:0 nr:512 dw:512 dr:0 al:0 bm:0 lo:0 pe:0 ua:0 ap:0 ep:1 wo:f oos:64
+ 2: cs:Unconfigured
+`
+	resources, err := parseProcDRBD(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(resources), 2)
+
+	r := resources[0]
+	assert.Equal(t, r.Name, "drbd0")
+	assert.Equal(t, r.ConnectionState, 0.0)
+	assert.Equal(t, r.Primary, 1.0)
+	assert.Equal(t, r.DiskState, 0.0)
+	assert.Equal(t, r.PeerDiskState, 0.0)
+	assert.Equal(t, r.NetSend, 1073741824.0)
+	assert.Equal(t, r.DiskRead, 2097152.0)
+
+	r = resources[1]
+	assert.Equal(t, r.Name, "drbd1")
+	assert.Equal(t, r.ConnectionState, 2.0)
+	assert.Equal(t, r.Primary, 0.0)
+	assert.Equal(t, r.PeerDiskState, 5.0)
+	assert.Equal(t, r.NetReceive, 524288.0)
+	assert.Equal(t, r.OutOfSync, 65536.0)
+}
+
+func TestParseStatusJSON(t *testing.T) {
+	stub := `[{"name":"r0","node-id":0,"role":"Primary","suspended":false,
+  "devices":[{"volume":0,"minor":0,"disk-state":"UpToDate","client":false,"size":1048576,"read":2048,"written":1048576,"al-writes":16,"bm-writes":0}],
+  "connections":[
+    {"peer-node-id":1,"name":"node2","connection-state":"Connected","peer-role":"Secondary",
+     "peer_devices":[{"volume":0,"replication-state":"SyncSource","peer-disk-state":"Inconsistent","received":0,"sent":1024,"out-of-sync":4096}]},
+    {"peer-node-id":2,"name":"node3","connection-state":"Connected","peer-role":"Secondary",
+     "peer_devices":[{"volume":0,"replication-state":"Established","peer-disk-state":"UpToDate","received":0,"sent":2048,"out-of-sync":0}]}]},
+ {"name":"r1","node-id":0,"role":"Secondary",
+  "devices":[{"volume":0,"minor":1,"disk-state":"Outdated","read":0,"written":0}],
+  "connections":[{"peer-node-id":1,"name":"node2","connection-state":"StandAlone","peer-role":"Unknown","peer_devices":[]}]}]`
+
+	resources, err := parseStatusJSON(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(resources), 2)
+
+	r := resources[0]
+	assert.Equal(t, r.Name, "r0")
+	assert.Equal(t, r.Primary, 1.0)
+	// the worst of the peers
+	assert.Equal(t, r.ConnectionState, 1.0)
+	assert.Equal(t, r.PeerDiskState, 3.0)
+	assert.Equal(t, r.NetSend, 3145728.0)
+	assert.Equal(t, r.OutOfSync, 4194304.0)
+	assert.Equal(t, r.DiskWrite, 1073741824.0)
+
+	r = resources[1]
+	assert.Equal(t, r.ConnectionState, 3.0)
+	assert.Equal(t, r.DiskState, 2.0)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var drbd DRBDPlugin
+	drbd.Resources = []string{"r0", "drbd1"}
+
+	graphs := drbd.GraphDefinition()
+	assert.Equal(t, len(graphs), 8)
+	assert.Equal(t, graphs["drbd.r0.state"].Metrics[0].Name, "r0_connection_state")
+	assert.True(t, graphs["drbd.drbd1.network"].Metrics[0].Diff)
+}
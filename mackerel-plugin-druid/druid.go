@@ -0,0 +1,313 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var heapGraph mp.Graphs = mp.Graphs{
+	Label: "Druid JVM Heap",
+	Unit:  "bytes",
+	Metrics: [](mp.Metrics){
+		mp.Metrics{Name: "heap_used", Label: "Used"},
+		mp.Metrics{Name: "heap_committed", Label: "Committed"},
+		mp.Metrics{Name: "heap_max", Label: "Max"},
+	},
+}
+
+var queryGraphs map[string](mp.Graphs) = map[string](mp.Graphs){
+	"queries": mp.Graphs{
+		Label: "Queries",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "query_count", Label: "Queries", Diff: true},
+		},
+	},
+	"query_time": mp.Graphs{
+		Label: "Average Query Time (msec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "query_time", Label: "Query Time"},
+		},
+	},
+	"cache": mp.Graphs{
+		Label: "Cache Hits/Misses",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cache_hits", Label: "Hits", Diff: true},
+			mp.Metrics{Name: "cache_misses", Label: "Misses", Diff: true},
+		},
+	},
+	"cache_hit_ratio": mp.Graphs{
+		Label: "Cache Hit Ratio",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cache_hit_ratio", Label: "Hit Ratio"},
+		},
+	},
+}
+
+// graphs specific to each node role, in addition to the JVM heap
+var roleGraphs map[string](map[string](mp.Graphs)) = map[string](map[string](mp.Graphs)){
+	"broker": queryGraphs,
+	"historical": merge(queryGraphs, map[string](mp.Graphs){
+		"segment_scan_pending": mp.Graphs{
+			Label: "Segments Waiting to be Scanned",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: "segment_scan_pending", Label: "Pending"},
+			},
+		},
+	}),
+	"coordinator": map[string](mp.Graphs){
+		"segments": mp.Graphs{
+			Label: "Segments",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: "segment_unavailable", Label: "Unavailable"},
+				mp.Metrics{Name: "segment_under_replicated", Label: "Under Replicated"},
+			},
+		},
+	},
+}
+
+func merge(graphs ...map[string](mp.Graphs)) map[string](mp.Graphs) {
+	merged := make(map[string](mp.Graphs))
+	for _, g := range graphs {
+		for k, v := range g {
+			merged[k] = v
+		}
+	}
+	return merged
+}
+
+// metrics of the prometheus-emitter extension (with the default namespace "druid") mapped to metric names.
+// samples of the same metric with different dimensions (e.g. dataSource) are summed up
+var promMetrics map[string]string = map[string]string{
+	"druid_query_time_sum":                "query_time_sum",
+	"druid_query_time_count":              "query_time_count",
+	"druid_query_count":                   "query_count",
+	"druid_query_count_total":             "query_count",
+	"druid_query_cache_total_hits":        "cache_hits",
+	"druid_query_cache_total_misses":      "cache_misses",
+	"druid_segment_scan_pending":          "segment_scan_pending",
+	"druid_segment_unavailable_count":     "segment_unavailable",
+	"druid_segment_underReplicated_count": "segment_under_replicated",
+}
+
+type DruidPlugin struct {
+	Uri        string
+	MetricsUri string
+	Role       string
+	Tempfile   string
+}
+
+// % curl http://localhost:8082/status
+// {"version":"0.22.1","modules":[...],"memory":{"maxMemory":8589934592,"totalMemory":8589934592,"freeMemory":7516192768,"usedMemory":1073741824,"directMemory":4294967296}}
+type DruidStatus struct {
+	Memory struct {
+		MaxMemory   float64 `json:"maxMemory"`
+		TotalMemory float64 `json:"totalMemory"`
+		UsedMemory  float64 `json:"usedMemory"`
+	} `json:"memory"`
+}
+
+func parseStatus(r io.Reader, stat map[string]float64) error {
+	var s DruidStatus
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return err
+	}
+	stat["heap_used"] = s.Memory.UsedMemory
+	stat["heap_committed"] = s.Memory.TotalMemory
+	stat["heap_max"] = s.Memory.MaxMemory
+	return nil
+}
+
+// parse samples of the prometheus text format, like
+// druid_query_time_sum{dataSource="wikipedia",type="timeseries"} 12.5
+// druid_query_time_count{dataSource="wikipedia",type="timeseries"} 120
+func parsePrometheus(r io.Reader, stat map[string]float64) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if i := strings.Index(line, "{"); i >= 0 {
+			j := strings.LastIndex(line, "}")
+			if j < i {
+				continue
+			}
+			line = line[:i] + line[j+1:]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		key, ok := promMetrics[fields[0]]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+		stat[key] += v
+	}
+	return scanner.Err()
+}
+
+// queryTime returns the average time (msec) of the queries completed since the last run.
+// query/time is converted to seconds by the prometheus-emitter.
+func queryTime(stat, last map[string]float64) (float64, bool) {
+	sum, ok1 := last["query_time_sum"]
+	count, ok2 := last["query_time_count"]
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+
+	sum = stat["query_time_sum"] - sum
+	count = stat["query_time_count"] - count
+	// no queries or restarted
+	if count <= 0 || sum < 0 {
+		return 0, false
+	}
+	return sum / count * 1000, true
+}
+
+// cacheHitRatio returns the hit ratio of the cache since the last run.
+func cacheHitRatio(stat, last map[string]float64) (float64, bool) {
+	return common.HitRatioSince(stat, last, "cache_hits", "cache_misses")
+}
+
+func httpGet(url string) (io.ReadCloser, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("HTTP status error: %d", resp.StatusCode))
+	}
+	return resp.Body, nil
+}
+
+func (p DruidPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	body, err := httpGet(p.Uri + "/status")
+	if err != nil {
+		return nil, err
+	}
+	err = parseStatus(body, stat)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.MetricsUri == "" {
+		return stat, nil
+	}
+
+	body, err = httpGet(p.MetricsUri)
+	if err != nil {
+		return nil, err
+	}
+	err = parsePrometheus(body, stat)
+	body.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	if last := common.LastValues(p.Tempfile); last != nil {
+		if v, ok := queryTime(stat, last); ok {
+			stat["query_time"] = v
+		}
+		if v, ok := cacheHitRatio(stat, last); ok {
+			stat["cache_hit_ratio"] = v
+		}
+	}
+
+	return stat, nil
+}
+
+func (p DruidPlugin) GraphDefinition() map[string](mp.Graphs) {
+	prefix := "druid." + p.Role + "."
+	label := "Druid " + strings.Title(p.Role) + " "
+
+	graphs := map[string](mp.Graphs){
+		prefix + "heap": mp.Graphs{Label: label + "JVM Heap", Unit: heapGraph.Unit, Metrics: heapGraph.Metrics},
+	}
+	if p.MetricsUri == "" {
+		return graphs
+	}
+	for k, v := range roleGraphs[p.Role] {
+		graphs[prefix+k] = mp.Graphs{Label: label + v.Label, Unit: v.Unit, Metrics: v.Metrics}
+	}
+	return graphs
+}
+
+// default ports of the quickstart configurations
+var defaultPorts map[string]string = map[string]string{
+	"broker":      "8082",
+	"historical":  "8083",
+	"coordinator": "8081",
+}
+
+func main() {
+	optHost := flag.String("host", "localhost", "Hostname")
+	optPort := flag.String("port", "", "Port (default: 8082 for broker, 8083 for historical, 8081 for coordinator)")
+	optRole := flag.String("role", "broker", "Node role (broker, historical or coordinator)")
+	optMetricsPort := flag.String("metrics-port", "", "Port of the prometheus-emitter (druid.emitter.prometheus.port)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	if _, ok := roleGraphs[*optRole]; !ok {
+		fmt.Fprintln(os.Stderr, "unknown role: "+*optRole)
+		os.Exit(1)
+	}
+	port := *optPort
+	if port == "" {
+		port = defaultPorts[*optRole]
+	}
+
+	var druid DruidPlugin
+	druid.Role = *optRole
+	druid.Uri = "http://" + common.HostPort(*optHost, port)
+	if *optMetricsPort != "" {
+		druid.MetricsUri = "http://" + common.HostPort(*optHost, *optMetricsPort) + "/metrics"
+	}
+	if *optTempfile != "" {
+		druid.Tempfile = *optTempfile
+	} else {
+		druid.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-druid-%s-%s-%s", *optRole, *optHost, port)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(druid))
+	helper.Tempfile = druid.Tempfile
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
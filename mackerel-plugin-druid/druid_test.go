@@ -0,0 +1,90 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatus(t *testing.T) {
+	stub := `{"version":"0.22.1","modules":[],"memory":{"maxMemory":8589934592,"totalMemory":4294967296,"freeMemory":3221225472,"usedMemory":1073741824,"directMemory":4294967296}}`
+
+	stat := make(map[string]float64)
+	err := parseStatus(strings.NewReader(stub), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["heap_used"], 1073741824.0)
+	assert.Equal(t, stat["heap_committed"], 4294967296.0)
+	assert.Equal(t, stat["heap_max"], 8589934592.0)
+}
+
+func TestParsePrometheus(t *testing.T) {
+	stub := `# HELP druid_query_time Seconds taken to complete a query.
+# TYPE druid_query_time histogram
+druid_query_time_bucket{dataSource="wikipedia",type="timeseries",le="0.1"} 100.0
+druid_query_time_count{dataSource="wikipedia",type="timeseries"} 120.0
+druid_query_time_sum{dataSource="wikipedia",type="timeseries"} 12.0
+druid_query_time_count{dataSource="metrics",type="groupBy"} 30.0
+druid_query_time_sum{dataSource="metrics",type="groupBy"} 6.0
+druid_query_count_total 150.0
+druid_query_cache_total_hits 800.0
+druid_query_cache_total_misses 200.0
+druid_segment_scan_pending 3.0
+druid_segment_unavailable_count{dataSource="wikipedia"} NaN
+`
+	stat := make(map[string]float64)
+	err := parsePrometheus(strings.NewReader(stub), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["query_time_count"], 150.0)
+	assert.Equal(t, stat["query_time_sum"], 18.0)
+	assert.Equal(t, stat["query_count"], 150.0)
+	assert.Equal(t, stat["cache_hits"], 800.0)
+	assert.Equal(t, stat["cache_misses"], 200.0)
+	assert.Equal(t, stat["segment_scan_pending"], 3.0)
+	_, ok := stat["segment_unavailable"]
+	assert.False(t, ok)
+}
+
+func TestQueryTime(t *testing.T) {
+	last := map[string]float64{"query_time_sum": 10.0, "query_time_count": 100}
+	stat := map[string]float64{"query_time_sum": 12.5, "query_time_count": 150}
+	v, ok := queryTime(stat, last)
+	assert.True(t, ok)
+	assert.Equal(t, v, 50.0)
+
+	// no queries since the last run
+	_, ok = queryTime(last, last)
+	assert.False(t, ok)
+	_, ok = queryTime(stat, map[string]float64{})
+	assert.False(t, ok)
+}
+
+func TestCacheHitRatio(t *testing.T) {
+	last := map[string]float64{"cache_hits": 800, "cache_misses": 200}
+	stat := map[string]float64{"cache_hits": 890, "cache_misses": 210}
+	v, ok := cacheHitRatio(stat, last)
+	assert.True(t, ok)
+	assert.Equal(t, v, 90.0)
+
+	// restarted
+	_, ok = cacheHitRatio(map[string]float64{"cache_hits": 10, "cache_misses": 0}, last)
+	assert.False(t, ok)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	druid := DruidPlugin{Role: "historical"}
+	graphs := druid.GraphDefinition()
+	assert.Equal(t, len(graphs), 1)
+	assert.Equal(t, graphs["druid.historical.heap"].Label, "Druid Historical JVM Heap")
+
+	druid.MetricsUri = "http://localhost:9091/metrics"
+	graphs = druid.GraphDefinition()
+	assert.Equal(t, len(graphs), 6)
+	assert.Equal(t, graphs["druid.historical.segment_scan_pending"].Metrics[0].Name, "segment_scan_pending")
+
+	druid.Role = "coordinator"
+	graphs = druid.GraphDefinition()
+	_, ok := graphs["druid.coordinator.queries"]
+	assert.False(t, ok)
+	assert.Equal(t, len(graphs["druid.coordinator.segments"].Metrics), 2)
+}
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"github.com/mackerelio/mackerel-agent/logging"
 	"net/http"
 	"os"
@@ -208,12 +209,17 @@ func main() {
 	optHost := flag.String("host", "localhost", "Host")
 	optPort := flag.String("port", "9200", "Port")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
+	httpOpts.Setup()
 
 	var elasticsearch ElasticsearchPlugin
-	elasticsearch.Uri = fmt.Sprintf("http://%s:%s", *optHost, *optPort)
+	elasticsearch.Uri = "http://" + common.HostPort(*optHost, *optPort)
 
-	helper := mp.NewMackerelPlugin(elasticsearch)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(elasticsearch))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
@@ -223,6 +229,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
@@ -0,0 +1,153 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"exim.queue": mp.Graphs{
+		Label: "Exim Queue",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "queue", Label: "Messages"},
+			mp.Metrics{Name: "frozen", Label: "Frozen"},
+			mp.Metrics{Name: "old", Label: "Older than Threshold"},
+		},
+	},
+	"exim.queue_size": mp.Graphs{
+		Label: "Exim Queue Size",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "queue_bytes", Label: "Size"},
+		},
+	},
+	"exim.queue_age": mp.Graphs{
+		Label: "Exim Oldest Message Age (sec)",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "oldest_age", Label: "Oldest"},
+		},
+	},
+}
+
+type EximPlugin struct {
+	EximPath     string
+	OldThreshold float64
+}
+
+// % exim -bp
+// 25m  2.9K 1hH4bG-0003Ml-7P <alice@example.com>
+// bob@example.com
+//
+// 4d   12M 1hGxyz-0001Ab-2Q <> *** frozen ***
+// D carol@example.com
+var messageLine = regexp.MustCompile(`^\s*(\d+)([smhdw])\s+([\d.]+)([KMG]?)\s+\S+-\S+-\S+\s`)
+
+var ageUnits = map[string]float64{"s": 1, "m": 60, "h": 3600, "d": 86400, "w": 604800}
+var sizeUnits = map[string]float64{"": 1, "K": 1024, "M": 1024 * 1024, "G": 1024 * 1024 * 1024}
+
+// parseQueue parses the output of exim -bp, where each message begins with the line of
+// the age, the size, the id and the sender, followed by the lines of the recipients
+func parseQueue(r io.Reader, oldThreshold float64) (map[string]float64, error) {
+	stat := map[string]float64{"frozen": 0, "queue_bytes": 0, "old": 0, "oldest_age": 0}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		m := messageLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		age, _ := strconv.ParseFloat(m[1], 64)
+		age *= ageUnits[m[2]]
+		size, _ := strconv.ParseFloat(m[3], 64)
+		size *= sizeUnits[m[4]]
+
+		stat["queue_bytes"] += size
+		if strings.Contains(line, "*** frozen ***") {
+			stat["frozen"]++
+		}
+		if age > oldThreshold {
+			stat["old"]++
+		}
+		if age > stat["oldest_age"] {
+			stat["oldest_age"] = age
+		}
+	}
+	return stat, scanner.Err()
+}
+
+func (p EximPlugin) exim(arg string) (string, error) {
+	out, err := exec.Command(p.EximPath, arg).Output()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("%s %s: %s", p.EximPath, arg, err))
+	}
+	return string(out), nil
+}
+
+func (p EximPlugin) FetchMetrics() (map[string]float64, error) {
+	out, err := p.exim("-bpc")
+	if err != nil {
+		return nil, err
+	}
+	count, err := strconv.ParseFloat(strings.TrimSpace(out), 64)
+	if err != nil {
+		return nil, err
+	}
+
+	// an empty queue is no output, which results in zeros
+	out, err = p.exim("-bp")
+	if err != nil {
+		return nil, err
+	}
+	stat, err := parseQueue(strings.NewReader(out), p.OldThreshold)
+	if err != nil {
+		return nil, err
+	}
+	stat["queue"] = count
+	return stat, nil
+}
+
+func (p EximPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optEximPath := flag.String("exim-path", "exim", "Path of exim")
+	optOldThreshold := flag.Int("old-threshold", 3600, "Age (sec) of the messages to count as old")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var exim EximPlugin
+	exim.EximPath = *optEximPath
+	exim.OldThreshold = float64(*optOldThreshold)
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(exim))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-exim"
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQueue(t *testing.T) {
+	stub := `25m  2.9K 1hH4bG-0003Ml-7P <alice@example.com>
+          bob@example.com
+
+ 4d   12M 1hGxyz-0001Ab-2Q <> *** frozen ***
+        D carol@example.com
+          dave@example.com
+
+ 2h   512 1hH1aa-0002Cd-3R <root@example.com>
+          eve@example.com
+
+`
+
+	stat, err := parseQueue(strings.NewReader(stub), 3600)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["frozen"], 1)
+	assert.EqualValues(t, stat["old"], 2)
+	assert.EqualValues(t, stat["oldest_age"], 4*86400)
+	assert.InDelta(t, stat["queue_bytes"], 2.9*1024+12*1024*1024+512, 0.1)
+}
+
+func TestParseEmptyQueue(t *testing.T) {
+	stat, err := parseQueue(strings.NewReader(""), 3600)
+	assert.Nil(t, err)
+	assert.EqualValues(t, stat["frozen"], 0)
+	assert.EqualValues(t, stat["old"], 0)
+	assert.EqualValues(t, stat["queue_bytes"], 0)
+	assert.EqualValues(t, stat["oldest_age"], 0)
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+type Fail2banPlugin struct {
+	ClientPath string
+	Jails      []string
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+func (p Fail2banPlugin) client(args ...string) (string, error) {
+	out, err := exec.Command(p.ClientPath, args...).CombinedOutput()
+	if err != nil {
+		msg := strings.TrimSpace(string(out))
+		if strings.Contains(msg, "Is it running?") {
+			return "", errors.New(fmt.Sprintf("fail2ban server is not running: %s", msg))
+		}
+		return "", errors.New(fmt.Sprintf("%s: %s", err, msg))
+	}
+	return string(out), nil
+}
+
+// % fail2ban-client status
+// Status
+// |- Number of jail:	2
+// `- Jail list:	sshd, nginx-http-auth
+func parseJailList(str string) []string {
+	for _, line := range strings.Split(str, "\n") {
+		i := strings.Index(line, "Jail list:")
+		if i < 0 {
+			continue
+		}
+
+		var jails []string
+		for _, jail := range strings.Split(line[i+len("Jail list:"):], ",") {
+			if jail = strings.TrimSpace(jail); jail != "" {
+				jails = append(jails, jail)
+			}
+		}
+		return jails
+	}
+	return nil
+}
+
+// items of `fail2ban-client status <jail>` mapped to metric names
+var statusItems map[string]string = map[string]string{
+	"Currently failed": "currently_failed",
+	"Total failed":     "total_failed",
+	"Currently banned": "currently_banned",
+	"Total banned":     "total_banned",
+}
+
+// % fail2ban-client status sshd
+// Status for the jail: sshd
+// |- Filter
+// |  |- Currently failed:	3
+// |  |- Total failed:	120
+// |  `- File list:	/var/log/auth.log
+// `- Actions
+// ...|- Currently banned:	1
+// ...|- Total banned:	10
+// ...`- Banned IP list:	192.0.2.1
+func parseJailStatus(str string, jail string, stat map[string]float64) {
+	prefix := metricName(jail)
+	for _, line := range strings.Split(str, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimLeft(kv[0], " |`-")
+		name, ok := statusItems[key]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			continue
+		}
+		stat[prefix+"_"+name] = v
+	}
+}
+
+func (p *Fail2banPlugin) Prepare() error {
+	out, err := p.client("status")
+	if err != nil {
+		return err
+	}
+	p.Jails = parseJailList(out)
+	if len(p.Jails) == 0 {
+		return errors.New("no jails found")
+	}
+	return nil
+}
+
+func (p Fail2banPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	for _, jail := range p.Jails {
+		out, err := p.client("status", jail)
+		if err != nil {
+			return nil, err
+		}
+		parseJailStatus(out, jail, stat)
+	}
+
+	return stat, nil
+}
+
+func (p Fail2banPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphdef := make(map[string](mp.Graphs))
+
+	for _, jail := range p.Jails {
+		prefix := metricName(jail)
+
+		graphdef["fail2ban."+prefix+".banned"] = mp.Graphs{
+			Label: "fail2ban " + jail + " Banned",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_currently_banned", Label: "Currently Banned"},
+				mp.Metrics{Name: prefix + "_total_banned", Label: "New Bans", Diff: true},
+			},
+		}
+		graphdef["fail2ban."+prefix+".failed"] = mp.Graphs{
+			Label: "fail2ban " + jail + " Failed",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_currently_failed", Label: "Currently Failed"},
+				mp.Metrics{Name: prefix + "_total_failed", Label: "New Failures", Diff: true},
+			},
+		}
+	}
+
+	return graphdef
+}
+
+func main() {
+	optClientPath := flag.String("fail2ban-client", "fail2ban-client", "fail2ban-client command path")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var fail2ban Fail2banPlugin
+	fail2ban.ClientPath = *optClientPath
+
+	err := fail2ban.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(fail2ban))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-fail2ban"
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
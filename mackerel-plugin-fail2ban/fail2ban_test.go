@@ -0,0 +1,35 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseJailList(t *testing.T) {
+	stub := "Status\n|- Number of jail:\t2\n`- Jail list:\tsshd, nginx-http-auth\n"
+	assert.Equal(t, parseJailList(stub), []string{"sshd", "nginx-http-auth"})
+
+	stub = "Status\n|- Number of jail:\t0\n`- Jail list:\t\n"
+	assert.Nil(t, parseJailList(stub))
+}
+
+func TestParseJailStatus(t *testing.T) {
+	stub := "Status for the jail: sshd\n" +
+		"|- Filter\n" +
+		"|  |- Currently failed:\t3\n" +
+		"|  |- Total failed:\t120\n" +
+		"|  `- File list:\t/var/log/auth.log\n" +
+		"`- Actions\n" +
+		"   |- Currently banned:\t1\n" +
+		"   |- Total banned:\t10\n" +
+		"   `- Banned IP list:\t192.0.2.1\n"
+
+	stat := make(map[string]float64)
+	parseJailStatus(stub, "sshd", stat)
+	assert.Equal(t, len(stat), 4)
+	assert.Equal(t, stat["sshd_currently_failed"], 3.0)
+	assert.Equal(t, stat["sshd_total_failed"], 120.0)
+	assert.Equal(t, stat["sshd_currently_banned"], 1.0)
+	assert.Equal(t, stat["sshd_total_banned"], 10.0)
+}
@@ -0,0 +1,349 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"gitlab_runner.jobs": mp.Graphs{
+		Label: "GitLab Runner Jobs",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "jobs_active", Label: "Active", Stacked: true},
+			mp.Metrics{Name: "jobs_idle", Label: "Idle", Stacked: true},
+		},
+	},
+}
+
+// graphs of the concurrency, which only the metrics endpoint of the runner reports
+var concurrencyGraphs map[string](mp.Graphs) = map[string](mp.Graphs){
+	"gitlab_runner.utilization": mp.Graphs{
+		Label: "GitLab Runner Concurrency Utilization",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "utilization", Label: "Active / Concurrent"},
+		},
+	},
+	"gitlab_runner.saturation": mp.Graphs{
+		Label: "GitLab Runner Saturation",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "saturated", Label: "Saturated"},
+			mp.Metrics{Name: "concurrency_exceeded", Label: "Requests Exceeding Concurrency", Diff: true},
+		},
+	},
+}
+
+// metrics of each runner
+var runnerMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "jobs_", Unit: "integer", Stacked: true,
+		Graph: "jobs_per_runner", GraphLabel: "GitLab Runner Active Jobs per Runner"},
+	common.DimensionMetric{Prefix: "success_", Unit: "integer", Diff: true,
+		Graph: "success", GraphLabel: "GitLab Runner Succeeded Jobs"},
+	common.DimensionMetric{Prefix: "failed_", Unit: "integer", Diff: true,
+		Graph: "failed", GraphLabel: "GitLab Runner Failed Jobs"},
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+type GitLabCIPlugin struct {
+	URL     string
+	APIURL  string
+	Token   string
+	Runners []string
+}
+
+type runnerStatus struct {
+	jobs     float64
+	total    float64
+	failed   float64
+	hasTotal bool
+}
+
+type runnerMetricsStatus struct {
+	runners    map[string]*runnerStatus
+	concurrent float64
+	exceeded   float64
+}
+
+var labelPattern = regexp.MustCompile(`(\w+)="((?:[^"\\]|\\.)*)"`)
+
+func parseLabels(s string) map[string]string {
+	labels := make(map[string]string)
+	for _, m := range labelPattern.FindAllStringSubmatch(s, -1) {
+		labels[m[1]] = m[2]
+	}
+	return labels
+}
+
+// parse samples of the prometheus text format of the runner, like
+// gitlab_runner_jobs{executor_stage="build",runner="a1b2c3d4",stage="build_script",state="running"} 2
+// gitlab_runner_failed_jobs_total{failure_reason="script_failure",runner="a1b2c3d4"} 5
+func parseRunnerMetrics(r io.Reader) (runnerMetricsStatus, error) {
+	st := runnerMetricsStatus{runners: make(map[string]*runnerStatus)}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		labels := map[string]string{}
+		if i := strings.Index(line, "{"); i >= 0 {
+			j := strings.LastIndex(line, "}")
+			if j < i {
+				continue
+			}
+			labels = parseLabels(line[i+1 : j])
+			line = line[:i] + line[j+1:]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name := fields[0]
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+
+		switch name {
+		case "gitlab_runner_concurrent":
+			st.concurrent = v
+			continue
+		case "gitlab_runner_request_concurrency_exceeded_total":
+			st.exceeded += v
+			continue
+		}
+
+		runner := metricName(labels["runner"])
+		if runner == "" {
+			continue
+		}
+		rs, ok := st.runners[runner]
+		if !ok {
+			rs = &runnerStatus{}
+		}
+		switch name {
+		case "gitlab_runner_jobs":
+			rs.jobs += v
+		case "gitlab_runner_jobs_total":
+			rs.total += v
+			rs.hasTotal = true
+		case "gitlab_runner_failed_jobs_total":
+			rs.failed += v
+		default:
+			continue
+		}
+		st.runners[runner] = rs
+	}
+	return st, scanner.Err()
+}
+
+// runnerStat returns the metrics of the runners, and the derived ones from the concurrency.
+// succeeded jobs are the ones not failed, as the runner counts the total and the failed
+func runnerStat(st runnerMetricsStatus) map[string]float64 {
+	stat := map[string]float64{"jobs_active": 0}
+	for name, rs := range st.runners {
+		stat["jobs_"+name] = rs.jobs
+		stat["failed_"+name] = rs.failed
+		if rs.hasTotal {
+			stat["success_"+name] = rs.total - rs.failed
+		}
+		stat["jobs_active"] += rs.jobs
+	}
+
+	stat["concurrency_exceeded"] = st.exceeded
+	if st.concurrent > 0 {
+		stat["jobs_idle"] = math.Max(st.concurrent-stat["jobs_active"], 0)
+		stat["utilization"] = stat["jobs_active"] / st.concurrent * 100
+		if stat["jobs_active"] >= st.concurrent {
+			stat["saturated"] = 1
+		} else {
+			stat["saturated"] = 0
+		}
+	}
+	return stat
+}
+
+func (p GitLabCIPlugin) get(url string) (*http.Response, error) {
+	req, err := http.NewRequest("GET", url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.Token != "" {
+		req.Header.Set("PRIVATE-TOKEN", p.Token)
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("%s: HTTP status error: %d", url, resp.StatusCode))
+	}
+	return resp, nil
+}
+
+func (p GitLabCIPlugin) fetchRunnerMetrics() (runnerMetricsStatus, error) {
+	resp, err := p.get(p.URL)
+	if err != nil {
+		return runnerMetricsStatus{}, err
+	}
+	defer resp.Body.Close()
+	return parseRunnerMetrics(resp.Body)
+}
+
+// countJobs returns the number of the jobs of the runner in the status, by X-Total of the API
+func (p GitLabCIPlugin) countJobs(runner, status string) (float64, error) {
+	resp, err := p.get(fmt.Sprintf("%s/api/v4/runners/%s/jobs?status=%s&per_page=1", p.APIURL, runner, status))
+	if err != nil {
+		return 0, err
+	}
+	resp.Body.Close()
+	return strconv.ParseFloat(resp.Header.Get("X-Total"), 64)
+}
+
+// Prepare lists the runners for the graphs
+func (p *GitLabCIPlugin) Prepare() error {
+	if p.APIURL == "" {
+		st, err := p.fetchRunnerMetrics()
+		if err != nil {
+			return err
+		}
+		for name := range st.runners {
+			p.Runners = append(p.Runners, name)
+		}
+		sort.Strings(p.Runners)
+		return nil
+	}
+
+	if len(p.Runners) > 0 {
+		return nil
+	}
+	// the runners available to the user of the token
+	resp, err := p.get(p.APIURL + "/api/v4/runners?per_page=100")
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	var runners []struct {
+		ID int `json:"id"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&runners); err != nil {
+		return err
+	}
+	for _, r := range runners {
+		p.Runners = append(p.Runners, strconv.Itoa(r.ID))
+	}
+	return nil
+}
+
+func (p GitLabCIPlugin) FetchMetrics() (map[string]float64, error) {
+	if p.APIURL == "" {
+		st, err := p.fetchRunnerMetrics()
+		if err != nil {
+			return nil, err
+		}
+		return runnerStat(st), nil
+	}
+
+	stat := map[string]float64{"jobs_active": 0}
+	for _, runner := range p.Runners {
+		for _, status := range []string{"running", "success", "failed"} {
+			v, err := p.countJobs(runner, status)
+			if err != nil {
+				common.LogFetchError(runner+" "+status, err)
+				continue
+			}
+			switch status {
+			case "running":
+				stat["jobs_"+runner] = v
+				stat["jobs_active"] += v
+			default:
+				stat[status+"_"+runner] = v
+			}
+		}
+	}
+	return stat, nil
+}
+
+func (p GitLabCIPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := common.DimensionGraphs("gitlab_runner", runnerMetrics, p.Runners, false)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	if p.APIURL == "" {
+		for k, v := range concurrencyGraphs {
+			graphs[k] = v
+		}
+	}
+	return graphs
+}
+
+func main() {
+	optURL := flag.String("url", "http://localhost:9252/metrics", "URL of the metrics endpoint of GitLab Runner")
+	optAPIURL := flag.String("api-url", "", "URL of GitLab (e.g. https://gitlab.example.com) to fetch the jobs of the runners by the API instead")
+	optToken := flag.String("token", "", "Access token of GitLab API (default: $GITLAB_TOKEN)")
+	optRunners := flag.String("runners", "", "IDs of the runners to fetch by the API, comma separated (default: the ones available to the token)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var gitlab GitLabCIPlugin
+	gitlab.URL = *optURL
+	gitlab.APIURL = strings.TrimRight(*optAPIURL, "/")
+	gitlab.Token = *optToken
+	if gitlab.Token == "" {
+		gitlab.Token = os.Getenv("GITLAB_TOKEN")
+	}
+	for _, r := range strings.Split(*optRunners, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			gitlab.Runners = append(gitlab.Runners, metricName(r))
+		}
+	}
+
+	if err := gitlab.Prepare(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(gitlab))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-gitlab-ci", "url", "api-url", "runners")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseRunnerMetrics(t *testing.T) {
+	stub := `# HELP gitlab_runner_concurrent The current value of concurrent setting
+# TYPE gitlab_runner_concurrent gauge
+gitlab_runner_concurrent 4
+# HELP gitlab_runner_jobs The current number of running builds.
+# TYPE gitlab_runner_jobs gauge
+gitlab_runner_jobs{executor_stage="build",runner="a1b2c3d4",stage="build_script",state="running"} 2
+gitlab_runner_jobs{executor_stage="prepare",runner="a1b2c3d4",stage="",state="running"} 1
+gitlab_runner_jobs{executor_stage="build",runner="e5f6g7h8",stage="build_script",state="running"} 1
+# TYPE gitlab_runner_jobs_total counter
+gitlab_runner_jobs_total{runner="a1b2c3d4"} 120
+gitlab_runner_jobs_total{runner="e5f6g7h8"} 30
+# TYPE gitlab_runner_failed_jobs_total counter
+gitlab_runner_failed_jobs_total{failure_reason="script_failure",runner="a1b2c3d4"} 5
+gitlab_runner_failed_jobs_total{failure_reason="runner_system_failure",runner="a1b2c3d4"} 1
+# TYPE gitlab_runner_request_concurrency_exceeded_total counter
+gitlab_runner_request_concurrency_exceeded_total{runner="a1b2c3d4"} 7
+# TYPE gitlab_runner_limit gauge
+gitlab_runner_limit{runner="a1b2c3d4"} 0
+`
+
+	st, err := parseRunnerMetrics(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(st.runners), 2)
+	assert.EqualValues(t, st.concurrent, 4)
+	assert.EqualValues(t, st.exceeded, 7)
+
+	stat := runnerStat(st)
+	assert.EqualValues(t, stat["jobs_a1b2c3d4"], 3)
+	assert.EqualValues(t, stat["failed_a1b2c3d4"], 6)
+	assert.EqualValues(t, stat["success_a1b2c3d4"], 114)
+	assert.EqualValues(t, stat["success_e5f6g7h8"], 30)
+	assert.EqualValues(t, stat["jobs_active"], 4)
+	assert.EqualValues(t, stat["jobs_idle"], 0)
+	assert.EqualValues(t, stat["utilization"], 100)
+	assert.EqualValues(t, stat["saturated"], 1)
+}
+
+func TestRunnerStatWithoutJobs(t *testing.T) {
+	st, err := parseRunnerMetrics(strings.NewReader("gitlab_runner_concurrent 2\n"))
+	assert.Nil(t, err)
+
+	stat := runnerStat(st)
+	assert.EqualValues(t, stat["jobs_active"], 0)
+	assert.EqualValues(t, stat["jobs_idle"], 2)
+	assert.EqualValues(t, stat["utilization"], 0)
+	assert.EqualValues(t, stat["saturated"], 0)
+}
@@ -0,0 +1,451 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+type GlusterBrick struct {
+	Name   string
+	Online bool
+	Free   float64
+	Total  float64
+}
+
+type GlusterVolume struct {
+	Name   string
+	Type   string
+	Bricks []GlusterBrick
+}
+
+// healable returns whether the volume has replicas to heal, which `gluster volume heal` supports
+func (vol GlusterVolume) healable() bool {
+	return strings.Contains(vol.Type, "Replicate") || strings.Contains(vol.Type, "Disperse")
+}
+
+type GlusterFSPlugin struct {
+	GlusterPath string
+	Volume      string
+	Volumes     []GlusterVolume
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+func (p GlusterFSPlugin) gluster(args ...string) (string, error) {
+	out, err := exec.Command(p.GlusterPath, args...).CombinedOutput()
+	if err != nil {
+		return "", errors.New(fmt.Sprintf("%s: %s", err, strings.TrimSpace(string(out))))
+	}
+	return string(out), nil
+}
+
+// % gluster volume status gv0
+// Status of volume: gv0
+// Gluster process                             TCP Port  RDMA Port  Online  Pid
+// ------------------------------------------------------------------------------
+// Brick server1:/data/brick1/gv0              49152     0          Y       1234
+// Brick server2:/data/brick1/gv0              N/A       N/A        N       N/A
+// Self-heal Daemon on localhost               N/A       N/A        Y       1240
+func parseVolumeStatus(str string) []GlusterBrick {
+	bricks := []GlusterBrick{}
+	for _, line := range strings.Split(str, "\n") {
+		if !strings.HasPrefix(line, "Brick ") {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 4 {
+			continue
+		}
+		bricks = append(bricks, GlusterBrick{
+			Name:   fields[1],
+			Online: fields[len(fields)-2] == "Y",
+		})
+	}
+	return bricks
+}
+
+// % gluster volume status gv0 detail
+// Status of volume: gv0
+// ------------------------------------------------------------------------------
+// Brick                : Brick server1:/data/brick1/gv0
+// TCP Port             : 49152
+// Online               : Y
+// Disk Space Free      : 9.5GB
+// Total Disk Space     : 10.0GB
+// ------------------------------------------------------------------------------
+// Brick                : Brick server2:/data/brick1/gv0
+// ...
+func parseVolumeStatusDetail(str string) []GlusterBrick {
+	bricks := []GlusterBrick{}
+	for _, line := range strings.Split(str, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key := strings.TrimSpace(kv[0])
+		value := strings.TrimSpace(kv[1])
+		if key == "Brick" {
+			bricks = append(bricks, GlusterBrick{Name: strings.TrimPrefix(value, "Brick ")})
+			continue
+		}
+		if len(bricks) == 0 {
+			continue
+		}
+
+		b := &bricks[len(bricks)-1]
+		switch key {
+		case "Online":
+			b.Online = value == "Y"
+		case "Disk Space Free":
+			if v, err := parseSize(value); err == nil {
+				b.Free = v
+			}
+		case "Total Disk Space":
+			if v, err := parseSize(value); err == nil {
+				b.Total = v
+			}
+		}
+	}
+	return bricks
+}
+
+var sizeUnits = map[string]float64{
+	"Bytes": 1,
+	"B":     1,
+	"KB":    1 << 10,
+	"MB":    1 << 20,
+	"GB":    1 << 30,
+	"TB":    1 << 40,
+	"PB":    1 << 50,
+}
+
+var sizePattern = regexp.MustCompile(`^([0-9.]+)\s*([A-Za-z]+)$`)
+
+// parseSize parses the human readable sizes of gluster, e.g. 9.5GB and 512Bytes
+func parseSize(s string) (float64, error) {
+	m := sizePattern.FindStringSubmatch(s)
+	if m == nil {
+		return 0, errors.New("invalid size: " + s)
+	}
+	unit, ok := sizeUnits[m[2]]
+	if !ok {
+		return 0, errors.New("invalid unit of size: " + s)
+	}
+	v, err := strconv.ParseFloat(m[1], 64)
+	if err != nil {
+		return 0, err
+	}
+	return v * unit, nil
+}
+
+// % gluster volume info gv0
+// Volume Name: gv0
+// Type: Distributed-Replicate
+// ...
+func parseVolumeType(str string) string {
+	for _, line := range strings.Split(str, "\n") {
+		if strings.HasPrefix(line, "Type:") {
+			return strings.TrimSpace(strings.TrimPrefix(line, "Type:"))
+		}
+	}
+	return ""
+}
+
+// the entries to heal, mapped to metric names
+var healColumns = map[string]string{
+	"Number of entries in heal pending":  "heal_pending",
+	"Number of entries in split-brain":   "split_brain",
+	"Number of entries possibly healing": "possibly_healing",
+}
+
+// % gluster volume heal gv0 info summary
+// Brick server1:/data/brick1/gv0
+// Status: Connected
+// Total Number of entries: 3
+// Number of entries in heal pending: 2
+// Number of entries in split-brain: 1
+// Number of entries possibly healing: 0
+//
+// Brick server2:/data/brick1/gv0
+// Status: Transport endpoint is not connected
+// Total Number of entries: -
+// ...
+func parseHealSummary(str string, volume string, stat map[string]float64) {
+	for _, name := range healColumns {
+		stat[volume+"_"+name] = 0
+	}
+	for _, line := range strings.Split(str, "\n") {
+		kv := strings.SplitN(line, ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		name, ok := healColumns[strings.TrimSpace(kv[0])]
+		if !ok {
+			continue
+		}
+		// the bricks not connected are "-"
+		if v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64); err == nil {
+			stat[volume+"_"+name] += v
+		}
+	}
+}
+
+var fopLine = regexp.MustCompile(`^\s*[0-9.]+\s+([0-9.]+) us\s+[0-9.]+ us\s+[0-9.]+ us\s+([0-9]+)\s+(\w+)\s*$`)
+
+// % gluster volume profile gv0 info
+// Brick: server1:/data/brick1/gv0
+// -------------------------------
+// Cumulative Stats:
+// %-latency   Avg-latency   Min-Latency   Max-Latency   No. of calls         Fop
+// ---------   -----------   -----------   -----------   ------------        ----
+//     10.00     100.00 us      50.00 us     200.00 us            100      WRITE
+//     20.00     150.00 us      50.00 us     300.00 us             50       READ
+//
+// Interval 0 Stats:
+// ...
+
+func parseVolumeProfile(str string, volume string, stat map[string]float64) {
+	var brick string
+	cumulative := false
+	for _, line := range strings.Split(str, "\n") {
+		if strings.HasPrefix(line, "Brick: ") {
+			brick = metricName(strings.TrimPrefix(line, "Brick: "))
+			cumulative = false
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "Cumulative Stats:") {
+			cumulative = true
+			continue
+		}
+		if strings.HasPrefix(strings.TrimSpace(line), "Interval ") {
+			cumulative = false
+			continue
+		}
+		if brick == "" || !cumulative {
+			continue
+		}
+
+		m := fopLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+
+		var op string
+		switch m[3] {
+		case "READ":
+			op = "read"
+		case "WRITE":
+			op = "write"
+		default:
+			continue
+		}
+
+		prefix := volume + "_" + brick + "_" + op
+		if latency, err := strconv.ParseFloat(m[1], 64); err == nil {
+			stat[prefix+"_latency"] = latency
+		}
+		if calls, err := strconv.ParseFloat(m[2], 64); err == nil {
+			stat[prefix+"_ops"] = calls
+		}
+	}
+}
+
+func (p *GlusterFSPlugin) Prepare() error {
+	var names []string
+	if p.Volume != "" {
+		names = []string{p.Volume}
+	} else {
+		out, err := p.gluster("volume", "list")
+		if err != nil {
+			return err
+		}
+		names = strings.Fields(out)
+	}
+	if len(names) == 0 {
+		return errors.New("no volumes found")
+	}
+
+	p.Volumes = make([]GlusterVolume, 0, len(names))
+	for _, name := range names {
+		out, err := p.gluster("volume", "status", name)
+		if err != nil {
+			return err
+		}
+		vol := GlusterVolume{Name: name, Bricks: parseVolumeStatus(out)}
+
+		out, err = p.gluster("volume", "info", name)
+		if err != nil {
+			return err
+		}
+		vol.Type = parseVolumeType(out)
+		p.Volumes = append(p.Volumes, vol)
+	}
+
+	return nil
+}
+
+func (p GlusterFSPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	for _, vol := range p.Volumes {
+		name := metricName(vol.Name)
+
+		// the bricks going offline after the plugin started are counted as well
+		out, err := p.gluster("volume", "status", vol.Name, "detail")
+		if err != nil {
+			return nil, err
+		}
+		bricks := parseVolumeStatusDetail(out)
+		online := 0
+		for _, b := range bricks {
+			if b.Online {
+				online++
+			}
+			if b.Total > 0 {
+				prefix := name + "_" + metricName(b.Name)
+				stat[prefix+"_free"] = b.Free
+				stat[prefix+"_free_percentage"] = b.Free / b.Total * 100
+			}
+		}
+		stat[name+"_bricks_online"] = float64(online)
+		stat[name+"_bricks_total"] = float64(len(bricks))
+
+		if vol.healable() {
+			out, err := p.gluster("volume", "heal", vol.Name, "info", "summary")
+			if err == nil {
+				parseHealSummary(out, name, stat)
+			} else {
+				common.LogFetchError(vol.Name+" heal", err)
+			}
+		}
+
+		// the health of the volume is reported without profiling
+		out, err = p.gluster("volume", "profile", vol.Name, "info")
+		if err != nil {
+			common.LogFetchError(vol.Name+" profile", errors.New(fmt.Sprintf("profiling must be enabled by `gluster volume profile %s start`: %s", vol.Name, err)))
+			continue
+		}
+		if strings.Contains(out, "not started") {
+			common.LogFetchError(vol.Name+" profile", errors.New(fmt.Sprintf("profiling is not enabled. run `gluster volume profile %s start`", vol.Name)))
+			continue
+		}
+		parseVolumeProfile(out, name, stat)
+	}
+
+	return stat, nil
+}
+
+func (p GlusterFSPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphdef := make(map[string](mp.Graphs))
+
+	for _, vol := range p.Volumes {
+		name := metricName(vol.Name)
+
+		var ops, latency, free, freePercentage [](mp.Metrics)
+		for _, b := range vol.Bricks {
+			prefix := name + "_" + metricName(b.Name)
+			free = append(free, mp.Metrics{Name: prefix + "_free", Label: b.Name})
+			freePercentage = append(freePercentage, mp.Metrics{Name: prefix + "_free_percentage", Label: b.Name})
+			ops = append(ops,
+				mp.Metrics{Name: prefix + "_read_ops", Label: b.Name + " Read", Diff: true},
+				mp.Metrics{Name: prefix + "_write_ops", Label: b.Name + " Write", Diff: true},
+			)
+			latency = append(latency,
+				mp.Metrics{Name: prefix + "_read_latency", Label: b.Name + " Read"},
+				mp.Metrics{Name: prefix + "_write_latency", Label: b.Name + " Write"},
+			)
+		}
+
+		graphdef["glusterfs."+name+".bricks"] = mp.Graphs{
+			Label: "GlusterFS " + vol.Name + " Bricks",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: name + "_bricks_online", Label: "Online"},
+				mp.Metrics{Name: name + "_bricks_total", Label: "Total"},
+			},
+		}
+		// Mackerel rejects graphs without metrics, e.g. of a volume being created
+		if len(vol.Bricks) > 0 {
+			graphdef["glusterfs."+name+".ops"] = mp.Graphs{
+				Label:   "GlusterFS " + vol.Name + " Brick Operations",
+				Unit:    "integer",
+				Metrics: ops,
+			}
+			graphdef["glusterfs."+name+".latency"] = mp.Graphs{
+				Label:   "GlusterFS " + vol.Name + " Brick Average Latency (us)",
+				Unit:    "float",
+				Metrics: latency,
+			}
+			graphdef["glusterfs."+name+".brick_free"] = mp.Graphs{
+				Label:   "GlusterFS " + vol.Name + " Brick Free Space",
+				Unit:    "bytes",
+				Metrics: free,
+			}
+			graphdef["glusterfs."+name+".brick_free_percentage"] = mp.Graphs{
+				Label:   "GlusterFS " + vol.Name + " Brick Free Space Percentage",
+				Unit:    "percentage",
+				Metrics: freePercentage,
+			}
+		}
+		if vol.healable() {
+			graphdef["glusterfs."+name+".heal"] = mp.Graphs{
+				Label: "GlusterFS " + vol.Name + " Entries to Heal",
+				Unit:  "integer",
+				Metrics: [](mp.Metrics){
+					mp.Metrics{Name: name + "_heal_pending", Label: "Heal Pending"},
+					mp.Metrics{Name: name + "_split_brain", Label: "Split-brain"},
+					mp.Metrics{Name: name + "_possibly_healing", Label: "Possibly Healing"},
+				},
+			}
+		}
+	}
+
+	return graphdef
+}
+
+func main() {
+	optGlusterPath := flag.String("gluster", "/usr/sbin/gluster", "gluster command path")
+	optVolume := flag.String("volume", "", "Volume name (default: all volumes)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var glusterfs GlusterFSPlugin
+	glusterfs.GlusterPath = *optGlusterPath
+	glusterfs.Volume = *optVolume
+
+	err := glusterfs.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(glusterfs))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-glusterfs", "volume")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
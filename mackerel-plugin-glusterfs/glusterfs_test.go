@@ -0,0 +1,168 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseVolumeStatus(t *testing.T) {
+	stub := `Status of volume: gv0
+Gluster process                             TCP Port  RDMA Port  Online  Pid
+------------------------------------------------------------------------------
+Brick server1:/data/brick1/gv0              49152     0          Y       1234
+Brick server2:/data/brick1/gv0              N/A       N/A        N       N/A
+Self-heal Daemon on localhost               N/A       N/A        Y       1240
+
+Task Status of Volume gv0
+------------------------------------------------------------------------------
+There are no active volume tasks
+`
+	bricks := parseVolumeStatus(stub)
+	assert.Equal(t, len(bricks), 2)
+	assert.Equal(t, bricks[0].Name, "server1:/data/brick1/gv0")
+	assert.True(t, bricks[0].Online)
+	assert.Equal(t, bricks[1].Name, "server2:/data/brick1/gv0")
+	assert.False(t, bricks[1].Online)
+}
+
+func TestParseVolumeProfile(t *testing.T) {
+	stub := `Brick: server1:/data/brick1/gv0
+-------------------------------
+Cumulative Stats:
+   Block Size:               4096b+
+ No. of Reads:                    0
+No. of Writes:                  100
+ %-latency   Avg-latency   Min-Latency   Max-Latency   No. of calls         Fop
+ ---------   -----------   -----------   -----------   ------------        ----
+      0.00       0.00 us       0.00 us       0.00 us             10     FORGET
+     10.00     100.00 us      50.00 us     200.00 us            100      WRITE
+     20.00     150.50 us      50.00 us     300.00 us             50       READ
+
+    Duration: 1000 seconds
+   Data Read: 0 bytes
+Data Written: 409600 bytes
+
+Interval 0 Stats:
+ %-latency   Avg-latency   Min-Latency   Max-Latency   No. of calls         Fop
+ ---------   -----------   -----------   -----------   ------------        ----
+     10.00      99.00 us      50.00 us     200.00 us              3      WRITE
+`
+	stat := make(map[string]float64)
+	parseVolumeProfile(stub, "gv0", stat)
+
+	assert.Equal(t, stat["gv0_server1_data_brick1_gv0_write_ops"], 100.0)
+	assert.Equal(t, stat["gv0_server1_data_brick1_gv0_write_latency"], 100.0)
+	assert.Equal(t, stat["gv0_server1_data_brick1_gv0_read_ops"], 50.0)
+	assert.Equal(t, stat["gv0_server1_data_brick1_gv0_read_latency"], 150.5)
+	assert.Equal(t, len(stat), 4)
+}
+
+func TestParseVolumeStatusDetail(t *testing.T) {
+	stub := `Status of volume: gv0
+------------------------------------------------------------------------------
+Brick                : Brick server1:/data/brick1/gv0
+TCP Port             : 49152
+RDMA Port            : 0
+Online               : Y
+Pid                  : 1234
+File System          : xfs
+Device               : /dev/sdb1
+Mount Options        : rw,relatime,attr2,inode64,noquota
+Inode Size           : 512
+Disk Space Free      : 9.5GB
+Total Disk Space     : 10.0GB
+Inode Count          : 5242880
+Free Inodes          : 5242000
+------------------------------------------------------------------------------
+Brick                : Brick server2:/data/brick1/gv0
+TCP Port             : N/A
+RDMA Port            : N/A
+Online               : N
+Pid                  : N/A
+File System          : N/A
+Device               : N/A
+Mount Options        : N/A
+Inode Size           : N/A
+Disk Space Free      : N/A
+Total Disk Space     : N/A
+Inode Count          : N/A
+Free Inodes          : N/A
+`
+	bricks := parseVolumeStatusDetail(stub)
+	assert.Equal(t, len(bricks), 2)
+	assert.Equal(t, bricks[0].Name, "server1:/data/brick1/gv0")
+	assert.True(t, bricks[0].Online)
+	assert.Equal(t, bricks[0].Free, 9.5*(1<<30))
+	assert.Equal(t, bricks[0].Total, 10.0*(1<<30))
+	assert.Equal(t, bricks[1].Name, "server2:/data/brick1/gv0")
+	assert.False(t, bricks[1].Online)
+	assert.Equal(t, bricks[1].Total, 0.0)
+}
+
+func TestParseSize(t *testing.T) {
+	v, err := parseSize("512Bytes")
+	assert.Nil(t, err)
+	assert.Equal(t, v, 512.0)
+	v, err = parseSize("1.5TB")
+	assert.Nil(t, err)
+	assert.Equal(t, v, 1.5*(1<<40))
+
+	_, err = parseSize("N/A")
+	assert.NotNil(t, err)
+	_, err = parseSize("10XB")
+	assert.NotNil(t, err)
+}
+
+func TestParseVolumeType(t *testing.T) {
+	stub := `
+Volume Name: gv0
+Type: Distributed-Replicate
+Volume ID: 8c6b9a1e-0000-0000-0000-000000000000
+Status: Started
+Number of Bricks: 2 x 2 = 4
+`
+	assert.Equal(t, parseVolumeType(stub), "Distributed-Replicate")
+	assert.True(t, GlusterVolume{Type: "Distributed-Replicate"}.healable())
+	assert.True(t, GlusterVolume{Type: "Disperse"}.healable())
+	assert.False(t, GlusterVolume{Type: "Distribute"}.healable())
+}
+
+func TestParseHealSummary(t *testing.T) {
+	stub := `Brick server1:/data/brick1/gv0
+Status: Connected
+Total Number of entries: 3
+Number of entries in heal pending: 2
+Number of entries in split-brain: 1
+Number of entries possibly healing: 0
+
+Brick server2:/data/brick1/gv0
+Status: Connected
+Total Number of entries: 4
+Number of entries in heal pending: 4
+Number of entries in split-brain: 0
+Number of entries possibly healing: 0
+
+Brick server3:/data/brick1/gv0
+Status: Transport endpoint is not connected
+Total Number of entries: -
+Number of entries in heal pending: -
+Number of entries in split-brain: -
+Number of entries possibly healing: -
+`
+	stat := make(map[string]float64)
+	parseHealSummary(stub, "gv0", stat)
+	assert.Equal(t, stat, map[string]float64{"gv0_heal_pending": 6, "gv0_split_brain": 1, "gv0_possibly_healing": 0})
+}
+
+func TestGraphDefinitionWithoutBricks(t *testing.T) {
+	glusterfs := GlusterFSPlugin{Volumes: []GlusterVolume{GlusterVolume{Name: "gv0", Type: "Replicate"}}}
+	graphs := glusterfs.GraphDefinition()
+	for _, key := range []string{"glusterfs.gv0.ops", "glusterfs.gv0.latency", "glusterfs.gv0.brick_free"} {
+		_, ok := graphs[key]
+		assert.False(t, ok)
+	}
+	for key, g := range graphs {
+		assert.NotEmpty(t, g.Metrics, key)
+	}
+}
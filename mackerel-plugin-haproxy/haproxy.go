@@ -6,10 +6,13 @@ import (
 	"flag"
 	"fmt"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"io"
+	"net"
 	"net/http"
 	"os"
 	"strconv"
+	"time"
 )
 
 var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
@@ -38,18 +41,45 @@ var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
 }
 
 type HAProxyPlugin struct {
-	Uri string
+	Uri    string
+	Socket string
 }
 
 func (p HAProxyPlugin) FetchMetrics() (map[string]float64, error) {
+	if p.Socket != "" {
+		return p.fetchSocket()
+	}
+
 	resp, err := http.Get(p.Uri + ";csv;norefresh")
 	if err != nil {
 		return nil, err
 	}
 	defer resp.Body.Close()
 
+	return parseStats(resp.Body)
+}
+
+// fetchSocket sends "show stat" to the stats socket, which responds the same CSV as the stats page.
+// without "prompt", haproxy closes the connection after the response
+func (p HAProxyPlugin) fetchSocket() (map[string]float64, error) {
+	conn, err := net.DialTimeout("unix", p.Socket, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.Write([]byte("show stat\n")); err != nil {
+		return nil, err
+	}
+
+	return parseStats(conn)
+}
+
+func parseStats(r io.Reader) (map[string]float64, error) {
 	stat := make(map[string]float64)
-	reader := csv.NewReader(resp.Body)
+	reader := csv.NewReader(r)
+	reader.FieldsPerRecord = -1
 
 	for {
 		columns, err := reader.Read()
@@ -57,8 +87,11 @@ func (p HAProxyPlugin) FetchMetrics() (map[string]float64, error) {
 		if err == io.EOF {
 			break
 		}
+		if err != nil {
+			return nil, err
+		}
 
-		if columns[1] != "BACKEND" {
+		if len(columns) < 14 || columns[1] != "BACKEND" {
 			continue
 		}
 
@@ -102,26 +135,34 @@ func main() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "80", "Port")
 	optPath := flag.String("path", "/", "Path")
+	optSocket := flag.String("socket", "", "Stats socket (e.g. /var/run/haproxy.sock), used instead of the stats page")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
+	httpOpts.Setup()
 
 	var haproxy HAProxyPlugin
 	if *optUri != "" {
 		haproxy.Uri = *optUri
 	} else {
-		haproxy.Uri = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
+		haproxy.Uri = fmt.Sprintf("%s://%s%s", *optScheme, common.HostPort(*optHost, *optPort), *optPath)
 	}
+	haproxy.Socket = *optSocket
 
-	helper := mp.NewMackerelPlugin(haproxy)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(haproxy))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
-		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-haproxy")
+		helper.Tempfile = common.Tempfile("mackerel-plugin-haproxy", "uri", "host", "port", "path", "socket")
 	}
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
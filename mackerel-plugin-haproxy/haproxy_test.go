@@ -0,0 +1,25 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var statsCSV = `# pxname,svname,qcur,qmax,scur,smax,slim,stot,bin,bout,dreq,dresp,ereq,econ,eresp,wretr,wredis,status,weight,act,bck,chkfail,chkdown,lastchg,downtime,qlimit,pid,iid,sid,throttle,lbtot,tracked,type,rate,rate_lim,rate_max,
+http-in,FRONTEND,,,1,10,2000,500,102400,204800,0,0,3,,,,,OPEN,,,,,,,,,1,1,0,,,,0,1,0,5,
+app,web1,0,0,0,3,,200,51200,102400,,0,,1,0,0,0,UP,1,1,0,0,0,3600,0,,1,2,1,,200,,2,0,,3,
+app,BACKEND,0,0,0,5,200,300,61440,122880,0,0,,2,0,0,0,UP,1,1,0,,0,3600,0,,1,2,0,,300,,1,0,,5,
+static,BACKEND,0,0,0,2,200,100,10240,20480,0,0,,1,0,0,0,UP,1,1,0,,0,3600,0,,1,3,0,,100,,1,0,,2,
+
+`
+
+func TestParseStats(t *testing.T) {
+	stat, err := parseStats(strings.NewReader(statsCSV))
+	assert.Nil(t, err)
+	assert.Equal(t, stat["sessions"], 400.0)
+	assert.Equal(t, stat["bytes_in"], 71680.0)
+	assert.Equal(t, stat["bytes_out"], 143360.0)
+	assert.Equal(t, stat["connection_errors"], 3.0)
+}
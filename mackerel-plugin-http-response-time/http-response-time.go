@@ -0,0 +1,295 @@
+package main
+
+import (
+	"crypto/tls"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptrace"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"http_response_time.time": mp.Graphs{
+		Label: "HTTP Response Time (ms)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "dns", Label: "DNS Lookup"},
+			mp.Metrics{Name: "connect", Label: "TCP Connect"},
+			mp.Metrics{Name: "tls", Label: "TLS Handshake"},
+			mp.Metrics{Name: "ttfb", Label: "Time to First Byte"},
+			mp.Metrics{Name: "total", Label: "Total"},
+		},
+	},
+	"http_response_time.status": mp.Graphs{
+		Label: "HTTP Response Status",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "status_code", Label: "Status Code"},
+		},
+	},
+	"http_response_time.expected": mp.Graphs{
+		Label: "HTTP Response Expected",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "expected", Label: "Expected"},
+		},
+	},
+	"http_response_time.certificate": mp.Graphs{
+		Label: "HTTPS Certificate Days until Expiry",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cert_days_left", Label: "Days Left"},
+		},
+	},
+}
+
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+type HTTPResponseTimePlugin struct {
+	URL          string
+	Method       string
+	Headers      http.Header
+	ExpectStatus int
+	Timeout      time.Duration
+}
+
+// parse 'Host: example.com'
+func parseHeaders(specs []string) (http.Header, error) {
+	header := make(http.Header)
+	for _, spec := range specs {
+		kv := strings.SplitN(spec, ":", 2)
+		if len(kv) != 2 || strings.TrimSpace(kv[0]) == "" {
+			return nil, errors.New("invalid header (must be <name>: <value>): " + spec)
+		}
+		header.Add(strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1]))
+	}
+	return header, nil
+}
+
+func msec(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+// certDaysLeft returns the days until the earliest expiry of the peer certificates
+func certDaysLeft(state *tls.ConnectionState, now time.Time) (float64, bool) {
+	if state == nil || len(state.PeerCertificates) == 0 {
+		return 0, false
+	}
+	notAfter := state.PeerCertificates[0].NotAfter
+	for _, cert := range state.PeerCertificates[1:] {
+		if cert.NotAfter.Before(notAfter) {
+			notAfter = cert.NotAfter
+		}
+	}
+	return notAfter.Sub(now).Hours() / 24, true
+}
+
+// isExpected returns whether the status is the expected one, or 2xx/3xx if expected is 0
+func isExpected(status, expected int) bool {
+	if expected == 0 {
+		return status >= 200 && status < 400
+	}
+	return status == expected
+}
+
+// requestTimings records the durations of the phases of a request from the callbacks of httptrace,
+// which can be called concurrently (e.g. the dials to both of IPv4 and IPv6), even after the request.
+// only the first successful one of each phase is recorded, which is of the connection used
+type requestTimings struct {
+	mu       sync.Mutex
+	starts   map[string]time.Time
+	recorded map[string]time.Duration
+}
+
+func newRequestTimings() *requestTimings {
+	return &requestTimings{
+		starts:   make(map[string]time.Time),
+		recorded: make(map[string]time.Duration),
+	}
+}
+
+// start marks the start of the phase, for each addr of the dials
+func (t *requestTimings) start(phase, addr string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.starts[phase+" "+addr] = time.Now()
+}
+
+// done records the duration of the phase since its start, unless it failed
+func (t *requestTimings) done(phase, addr string, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	started, ok := t.starts[phase+" "+addr]
+	if err != nil || !ok {
+		return
+	}
+	if _, ok := t.recorded[phase]; !ok {
+		t.recorded[phase] = time.Since(started)
+	}
+}
+
+func (t *requestTimings) record(phase string, d time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.recorded[phase]; !ok {
+		t.recorded[phase] = d
+	}
+}
+
+// durations returns a copy of the recorded durations, as the callbacks can still be called
+func (t *requestTimings) durations() map[string]time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	durations := make(map[string]time.Duration, len(t.recorded))
+	for phase, d := range t.recorded {
+		durations[phase] = d
+	}
+	return durations
+}
+
+func (p HTTPResponseTimePlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	req, err := http.NewRequest(p.Method, p.URL, nil)
+	if err != nil {
+		return nil, err
+	}
+	for k, vs := range p.Headers {
+		for _, v := range vs {
+			req.Header.Add(k, v)
+		}
+	}
+	// Host header is not sent from req.Header
+	if host := p.Headers.Get("Host"); host != "" {
+		req.Host = host
+	}
+
+	var start time.Time
+	timings := newRequestTimings()
+	trace := &httptrace.ClientTrace{
+		DNSStart:             func(httptrace.DNSStartInfo) { timings.start("dns", "") },
+		DNSDone:              func(httptrace.DNSDoneInfo) { timings.done("dns", "", nil) },
+		ConnectStart:         func(network, addr string) { timings.start("connect", addr) },
+		ConnectDone:          func(network, addr string, err error) { timings.done("connect", addr, err) },
+		TLSHandshakeStart:    func() { timings.start("tls", "") },
+		TLSHandshakeDone:     func(state tls.ConnectionState, err error) { timings.done("tls", "", err) },
+		GotFirstResponseByte: func() { timings.record("ttfb", time.Since(start)) },
+	}
+	req = req.WithContext(httptrace.WithClientTrace(req.Context(), trace))
+
+	client := &http.Client{
+		Timeout: p.Timeout,
+		// the status of the url itself is checked
+		CheckRedirect: func(req *http.Request, via []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+
+	start = time.Now()
+	resp, err := client.Do(req)
+	if err != nil {
+		// the endpoint is down, which should be seen in the graph rather than as a missing value
+		log.Printf("%s %s: %s", p.Method, p.URL, err)
+		return map[string]float64{"expected": 0}, nil
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+	stat["total"] = msec(time.Since(start))
+	for name, d := range timings.durations() {
+		stat[name] = msec(d)
+	}
+
+	stat["status_code"] = float64(resp.StatusCode)
+	if isExpected(resp.StatusCode, p.ExpectStatus) {
+		stat["expected"] = 1
+	} else {
+		stat["expected"] = 0
+	}
+
+	if days, ok := certDaysLeft(resp.TLS, time.Now()); ok {
+		stat["cert_days_left"] = days
+	}
+
+	return stat, nil
+}
+
+func (p HTTPResponseTimePlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func main() {
+	optURL := flag.String("url", "", "URL to request")
+	optMethod := flag.String("method", "GET", "HTTP method (GET or HEAD)")
+	var optHeaders stringSlice
+	flag.Var(&optHeaders, "header", "Request header (<name>: <value>), can be specified multiple times")
+	optExpectStatus := flag.Int("expect-status", 0, "Expected status code (default: any of 2xx and 3xx)")
+	optTimeout := flag.Duration("timeout", 10*time.Second, "Timeout of the request")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	if *optURL == "" {
+		fmt.Fprintln(os.Stderr, "-url is required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	method := strings.ToUpper(*optMethod)
+	if method != "GET" && method != "HEAD" {
+		fmt.Fprintln(os.Stderr, "-method must be GET or HEAD")
+		os.Exit(1)
+	}
+
+	headers, err := parseHeaders(optHeaders)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var check HTTPResponseTimePlugin
+	check.URL = *optURL
+	check.Method = method
+	check.Headers = headers
+	check.ExpectStatus = *optExpectStatus
+	check.Timeout = *optTimeout
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(check))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-http-response-time-" + strings.Trim(invalidChars.ReplaceAllString(*optURL, "_"), "_")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
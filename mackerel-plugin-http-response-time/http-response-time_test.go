@@ -0,0 +1,84 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseHeaders(t *testing.T) {
+	header, err := parseHeaders([]string{"Host: example.com", "X-Check: a:b"})
+	assert.Nil(t, err)
+	assert.Equal(t, header.Get("Host"), "example.com")
+	assert.Equal(t, header.Get("X-Check"), "a:b")
+
+	_, err = parseHeaders([]string{"invalid"})
+	assert.NotNil(t, err)
+}
+
+func TestIsExpected(t *testing.T) {
+	assert.True(t, isExpected(200, 0))
+	assert.True(t, isExpected(301, 0))
+	assert.False(t, isExpected(404, 0))
+	assert.False(t, isExpected(503, 0))
+	assert.True(t, isExpected(401, 401))
+	assert.False(t, isExpected(200, 401))
+}
+
+func TestCertDaysLeft(t *testing.T) {
+	now := time.Now()
+	state := &tls.ConnectionState{
+		PeerCertificates: []*x509.Certificate{
+			&x509.Certificate{NotAfter: now.Add(30 * 24 * time.Hour)},
+			// intermediate certificate
+			&x509.Certificate{NotAfter: now.Add(10 * 24 * time.Hour)},
+		},
+	}
+	days, ok := certDaysLeft(state, now)
+	assert.True(t, ok)
+	assert.Equal(t, days, 10.0)
+
+	_, ok = certDaysLeft(nil, now)
+	assert.False(t, ok)
+}
+
+func TestRequestTimings(t *testing.T) {
+	timings := newRequestTimings()
+	// the dial which lost the race is not recorded
+	timings.start("connect", "[2001:db8::1]:443")
+	timings.start("connect", "192.0.2.1:443")
+	timings.done("connect", "[2001:db8::1]:443", errors.New("connect: network is unreachable"))
+	timings.done("connect", "192.0.2.1:443", nil)
+	durations := timings.durations()
+	_, ok := durations["connect"]
+	assert.True(t, ok)
+
+	// only the first one is recorded
+	timings.record("ttfb", 100*time.Millisecond)
+	timings.record("ttfb", 200*time.Millisecond)
+	assert.Equal(t, timings.durations()["ttfb"], 100*time.Millisecond)
+
+	// phases without the start
+	timings.done("tls", "", nil)
+	_, ok = timings.durations()["tls"]
+	assert.False(t, ok)
+
+	// the callbacks are called concurrently
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			timings.start("dns", "")
+			timings.done("dns", "", nil)
+		}()
+	}
+	wg.Wait()
+	_, ok = timings.durations()["dns"]
+	assert.True(t, ok)
+}
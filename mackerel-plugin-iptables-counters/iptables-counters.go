@@ -0,0 +1,240 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// counters of each rule, named by the comment of the rule
+var ruleMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "packets_", Unit: "integer", Diff: true,
+		Graph: "packets", GraphLabel: "Packets Matched by Rules"},
+	common.DimensionMetric{Prefix: "bytes_", Unit: "bytes", Diff: true,
+		Graph: "bytes", GraphLabel: "Bytes Matched by Rules"},
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+type counter struct {
+	packets float64
+	bytes   float64
+}
+
+type IptablesCountersPlugin struct {
+	Backend  string
+	Iptables string
+	Nft      string
+	Table    string
+	Pattern  *regexp.Regexp
+	Rules    []string
+}
+
+// "/* block-ssh */" put at the end of a rule with -m comment --comment block-ssh
+var iptablesComment = regexp.MustCompile(`/\* (.*?) \*/`)
+
+// % iptables -t filter -nvxL
+// Chain INPUT (policy ACCEPT 1234 packets, 567890 bytes)
+// pkts      bytes target     prot opt in     out     source               destination
+// 120     7200 DROP       tcp  --  *      *       0.0.0.0/0            0.0.0.0/0            tcp dpt:22 /* block-ssh */
+//
+// Rules with the same comment are summed up.
+func parseIptables(r io.Reader, pattern *regexp.Regexp) (map[string]counter, error) {
+	counters := make(map[string]counter)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		m := iptablesComment.FindStringSubmatch(line)
+		if m == nil || !pattern.MatchString(m[1]) {
+			continue
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		packets, err := strconv.ParseFloat(fields[0], 64)
+		if err != nil {
+			continue
+		}
+		bytes, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil {
+			continue
+		}
+
+		name := metricName(m[1])
+		if name == "" {
+			continue
+		}
+		c := counters[name]
+		c.packets += packets
+		c.bytes += bytes
+		counters[name] = c
+	}
+
+	return counters, scanner.Err()
+}
+
+// % nft -j list ruleset
+// {"nftables": [{"metainfo": {...}}, {"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 4,
+// "comment": "block-ssh", "expr": [{"match": {...}}, {"counter": {"packets": 120, "bytes": 7200}}, {"drop": null}]}}]}
+type nftRuleset struct {
+	Nftables []struct {
+		Rule *struct {
+			Comment string `json:"comment"`
+			Expr    []struct {
+				Counter *struct {
+					Packets float64 `json:"packets"`
+					Bytes   float64 `json:"bytes"`
+				} `json:"counter"`
+			} `json:"expr"`
+		} `json:"rule"`
+	} `json:"nftables"`
+}
+
+// parseNft returns the counters of the rules with counter statements.
+// Rules with the same comment are summed up.
+func parseNft(r io.Reader, pattern *regexp.Regexp) (map[string]counter, error) {
+	var ruleset nftRuleset
+	if err := json.NewDecoder(r).Decode(&ruleset); err != nil {
+		return nil, err
+	}
+
+	counters := make(map[string]counter)
+	for _, obj := range ruleset.Nftables {
+		rule := obj.Rule
+		if rule == nil || rule.Comment == "" || !pattern.MatchString(rule.Comment) {
+			continue
+		}
+		name := metricName(rule.Comment)
+		if name == "" {
+			continue
+		}
+		for _, expr := range rule.Expr {
+			if expr.Counter == nil {
+				continue
+			}
+			c := counters[name]
+			c.packets += expr.Counter.Packets
+			c.bytes += expr.Counter.Bytes
+			counters[name] = c
+		}
+	}
+
+	return counters, nil
+}
+
+func (p IptablesCountersPlugin) fetchCounters() (map[string]counter, error) {
+	var cmd *exec.Cmd
+	switch p.Backend {
+	case "iptables":
+		cmd = exec.Command(p.Iptables, "-t", p.Table, "-nvxL")
+	case "nft":
+		cmd = exec.Command(p.Nft, "-j", "list", "ruleset")
+	default:
+		return nil, errors.New("unknown backend: " + p.Backend)
+	}
+
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s: %s", strings.Join(cmd.Args, " "), err))
+	}
+
+	if p.Backend == "nft" {
+		return parseNft(strings.NewReader(string(out)), p.Pattern)
+	}
+	return parseIptables(strings.NewReader(string(out)), p.Pattern)
+}
+
+// Prepare lists the rules matching the pattern for the graphs
+func (p *IptablesCountersPlugin) Prepare() error {
+	counters, err := p.fetchCounters()
+	if err != nil {
+		return err
+	}
+
+	p.Rules = make([]string, 0, len(counters))
+	for name := range counters {
+		p.Rules = append(p.Rules, name)
+	}
+	sort.Strings(p.Rules)
+	return nil
+}
+
+func (p IptablesCountersPlugin) FetchMetrics() (map[string]float64, error) {
+	counters, err := p.fetchCounters()
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	for name, c := range counters {
+		stat["packets_"+name] = c.packets
+		stat["bytes_"+name] = c.bytes
+	}
+	return stat, nil
+}
+
+func (p IptablesCountersPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return common.DimensionGraphs("iptables", ruleMetrics, p.Rules, false)
+}
+
+func main() {
+	optBackend := flag.String("backend", "iptables", "Backend to read the counters from (iptables or nft)")
+	optIptables := flag.String("iptables", "iptables", "Path of iptables (or ip6tables)")
+	optNft := flag.String("nft", "nft", "Path of nft")
+	optTable := flag.String("table", "filter", "Table of iptables")
+	optPattern := flag.String("pattern", "", "Regular expression of the comments of the rules")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var iptables IptablesCountersPlugin
+	iptables.Backend = *optBackend
+	iptables.Iptables = *optIptables
+	iptables.Nft = *optNft
+	iptables.Table = *optTable
+
+	var err error
+	iptables.Pattern, err = regexp.Compile(*optPattern)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	if err := iptables.Prepare(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(iptables))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile(fmt.Sprintf("mackerel-plugin-iptables-counters-%s-%s", *optBackend, *optTable), "pattern", "iptables", "nft")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
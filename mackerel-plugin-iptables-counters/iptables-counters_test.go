@@ -0,0 +1,53 @@
+package main
+
+import (
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseIptables(t *testing.T) {
+	stub := `Chain INPUT (policy ACCEPT 1234 packets, 567890 bytes)
+    pkts      bytes target     prot opt in     out     source               destination
+     120     7200 DROP       tcp  --  *      *       0.0.0.0/0            0.0.0.0/0            tcp dpt:22 /* block-ssh */
+      30     1800 DROP       tcp  --  *      *       ::/0                 ::/0                 tcp dpt:23 /* block-ssh */
+    5000   300000 ACCEPT     tcp  --  *      *       0.0.0.0/0            0.0.0.0/0            tcp dpt:80 /* rate limit: http */
+      10      600 ACCEPT     all  --  lo     *       0.0.0.0/0            0.0.0.0/0
+
+Chain FORWARD (policy DROP 0 packets, 0 bytes)
+    pkts      bytes target     prot opt in     out     source               destination
+       7      420 REJECT     all  --  *      *       10.0.0.0/8           0.0.0.0/0            /* other */ reject-with icmp-port-unreachable
+`
+
+	counters, err := parseIptables(strings.NewReader(stub), regexp.MustCompile(""))
+	assert.Nil(t, err)
+	assert.Equal(t, len(counters), 3)
+	assert.Equal(t, counters["block-ssh"].packets, 150.0)
+	assert.Equal(t, counters["block-ssh"].bytes, 9000.0)
+	assert.Equal(t, counters["rate_limit_http"].packets, 5000.0)
+	assert.Equal(t, counters["other"].bytes, 420.0)
+
+	counters, err = parseIptables(strings.NewReader(stub), regexp.MustCompile("^block-"))
+	assert.Nil(t, err)
+	assert.Equal(t, len(counters), 1)
+}
+
+func TestParseNft(t *testing.T) {
+	stub := `{"nftables": [{"metainfo": {"version": "0.9.3", "release_name": "Topsy", "json_schema_version": 1}},
+{"table": {"family": "inet", "name": "filter", "handle": 1}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 4, "comment": "block-ssh",
+ "expr": [{"match": {"op": "==", "left": {"payload": {"protocol": "tcp", "field": "dport"}}, "right": 22}},
+ {"counter": {"packets": 120, "bytes": 7200}}, {"drop": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 5, "comment": "no-counter",
+ "expr": [{"accept": null}]}},
+{"rule": {"family": "inet", "table": "filter", "chain": "input", "handle": 6,
+ "expr": [{"counter": {"packets": 1, "bytes": 60}}, {"accept": null}]}}]}`
+
+	counters, err := parseNft(strings.NewReader(stub), regexp.MustCompile(""))
+	assert.Nil(t, err)
+	assert.Equal(t, len(counters), 1)
+	assert.Equal(t, counters["block-ssh"].packets, 120.0)
+	assert.Equal(t, counters["block-ssh"].bytes, 7200.0)
+}
@@ -0,0 +1,197 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// syslog priorities, 0 (emerg) to 7 (debug)
+var priorities = []string{"emerg", "alert", "crit", "err", "warning", "notice", "info", "debug"}
+
+// priorities up to this one are counted as errors
+const errPriority = 3
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"journald.entries": mp.Graphs{
+		Label: "Journal Entries per sec",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "entries_emerg", Label: "Emerg", Stacked: true},
+			mp.Metrics{Name: "entries_alert", Label: "Alert", Stacked: true},
+			mp.Metrics{Name: "entries_crit", Label: "Crit", Stacked: true},
+			mp.Metrics{Name: "entries_err", Label: "Err", Stacked: true},
+			mp.Metrics{Name: "entries_warning", Label: "Warning", Stacked: true},
+			mp.Metrics{Name: "entries_notice", Label: "Notice", Stacked: true},
+			mp.Metrics{Name: "entries_info", Label: "Info", Stacked: true},
+			mp.Metrics{Name: "entries_debug", Label: "Debug", Stacked: true},
+		},
+	},
+	"journald.errors": mp.Graphs{
+		Label: "Journal Error Entries per sec",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "errors", Label: "Err and Above"},
+		},
+	},
+}
+
+// the position in the journal read up to by the last run
+type journalState struct {
+	Cursor string `json:"cursor"`
+	Time   int64  `json:"time"`
+}
+
+type JournaldPlugin struct {
+	Journalctl string
+	Unit       string
+	StateFile  string
+	Interval   time.Duration
+}
+
+// % journalctl -o json --after-cursor=<cursor>
+// {"__CURSOR": "s=739ad463348b4ceca5a9e69c95a3c93f;i=4ece7;b=6c7c6013a8ba4fa5e9f4b6c2e7e6cf5b;m=...", "PRIORITY": "6", "MESSAGE": "...", ...}
+type journalEntry struct {
+	Cursor   string `json:"__CURSOR"`
+	Priority string `json:"PRIORITY"`
+}
+
+// parseEntries counts the entries of each priority, and returns the cursor of the last entry
+func parseEntries(r io.Reader) ([]float64, string, error) {
+	counts := make([]float64, len(priorities))
+	var cursor string
+
+	dec := json.NewDecoder(r)
+	for {
+		var entry journalEntry
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, "", err
+		}
+		if entry.Cursor != "" {
+			cursor = entry.Cursor
+		}
+		p, err := strconv.Atoi(entry.Priority)
+		if err != nil || p < 0 || p >= len(priorities) {
+			continue
+		}
+		counts[p]++
+	}
+	return counts, cursor, nil
+}
+
+// entryRates returns the entries per sec of each priority in the elapsed seconds
+func entryRates(counts []float64, elapsed float64) map[string]float64 {
+	stat := make(map[string]float64)
+	var errs float64
+	for p, name := range priorities {
+		stat["entries_"+name] = counts[p] / elapsed
+		if p <= errPriority {
+			errs += counts[p]
+		}
+	}
+	stat["errors"] = errs / elapsed
+	return stat
+}
+
+func (p JournaldPlugin) readJournal(args ...string) ([]float64, string, error) {
+	args = append([]string{"-o", "json", "--no-pager", "-q"}, args...)
+	if p.Unit != "" {
+		args = append(args, "-u", p.Unit)
+	}
+	out, err := exec.Command(p.Journalctl, args...).Output()
+	if err != nil {
+		return nil, "", errors.New(fmt.Sprintf("%s %s: %s", p.Journalctl, strings.Join(args, " "), err))
+	}
+	return parseEntries(strings.NewReader(string(out)))
+}
+
+func (p JournaldPlugin) FetchMetrics() (map[string]float64, error) {
+	now := time.Now()
+
+	// on the first run, or if the state is broken, count the entries of the last interval
+	var state journalState
+	err := common.LoadState(p.StateFile, &state)
+	if err != nil || state.Time <= 0 || state.Time >= now.Unix() {
+		state = journalState{Time: now.Add(-p.Interval).Unix()}
+	}
+	since := fmt.Sprintf("--since=@%d", state.Time)
+
+	var counts []float64
+	var cursor string
+	if state.Cursor != "" {
+		counts, cursor, err = p.readJournal("--after-cursor=" + state.Cursor)
+		if err != nil {
+			// the cursor may be gone by the rotation or the vacuum of the journal
+			common.LogFetchError("cursor", err)
+			counts, cursor, err = p.readJournal(since)
+		}
+	} else {
+		counts, cursor, err = p.readJournal(since)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	// keep the last cursor if no entries are added
+	if cursor == "" {
+		cursor = state.Cursor
+	}
+	if err := common.SaveState(p.StateFile, journalState{Cursor: cursor, Time: now.Unix()}); err != nil {
+		return nil, err
+	}
+
+	return entryRates(counts, float64(now.Unix()-state.Time)), nil
+}
+
+func (p JournaldPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optJournalctl := flag.String("journalctl", "journalctl", "Path of journalctl")
+	optUnit := flag.String("unit", "", "Count the entries of the systemd unit only")
+	optInterval := flag.Int("interval", 60, "Interval (sec) to count the entries of on the first run")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var journald JournaldPlugin
+	journald.Journalctl = *optJournalctl
+	journald.Unit = *optUnit
+	journald.Interval = time.Duration(*optInterval) * time.Second
+
+	tempfile := "/tmp/mackerel-plugin-journald"
+	if *optTempfile != "" {
+		tempfile = *optTempfile
+	} else if *optUnit != "" {
+		tempfile += "-" + *optUnit
+	}
+	// the cursor is kept beside the values of the last run
+	journald.StateFile = tempfile + ".cursor"
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(journald))
+	helper.Tempfile = tempfile
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
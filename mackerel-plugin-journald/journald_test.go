@@ -0,0 +1,38 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseEntries(t *testing.T) {
+	stub := `{"__CURSOR": "s=739a;i=4ec1", "PRIORITY": "6", "MESSAGE": "Started Session 1 of user root.", "_SYSTEMD_UNIT": "init.scope"}
+{"__CURSOR": "s=739a;i=4ec2", "PRIORITY": "3", "MESSAGE": [72, 101, 108, 108, 111]}
+{"__CURSOR": "s=739a;i=4ec3", "PRIORITY": "2", "MESSAGE": "kernel: Out of memory"}
+{"__CURSOR": "s=739a;i=4ec4", "MESSAGE": "no priority"}
+{"__CURSOR": "s=739a;i=4ec5", "PRIORITY": "6", "MESSAGE": "Stopped Session 1 of user root."}
+`
+
+	counts, cursor, err := parseEntries(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, "s=739a;i=4ec5")
+	assert.EqualValues(t, counts[6], 2)
+	assert.EqualValues(t, counts[3], 1)
+	assert.EqualValues(t, counts[2], 1)
+
+	counts, cursor, err = parseEntries(strings.NewReader(""))
+	assert.Nil(t, err)
+	assert.Equal(t, cursor, "")
+	assert.EqualValues(t, counts[6], 0)
+}
+
+func TestEntryRates(t *testing.T) {
+	counts := []float64{0, 0, 6, 12, 30, 0, 120, 0}
+	stat := entryRates(counts, 60)
+	assert.InDelta(t, stat["entries_info"], 2.0, 0.0001)
+	assert.InDelta(t, stat["entries_err"], 0.2, 0.0001)
+	assert.InDelta(t, stat["errors"], 0.3, 0.0001)
+	assert.InDelta(t, stat["entries_debug"], 0.0, 0.0001)
+}
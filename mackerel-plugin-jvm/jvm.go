@@ -11,6 +11,7 @@ import (
 	"strings"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"github.com/mackerelio/mackerel-agent/logging"
 )
 
@@ -183,10 +184,13 @@ func main() {
 	optJavaName := flag.String("javaname", "", "Java app name")
 	optPidFile := flag.String("pidfile", "", "pidfile path")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	var jvm JVMPlugin
-	jvm.Target = fmt.Sprintf("%s:%s", *optHost, *optPort)
+	jvm.Target = common.HostPort(*optHost, *optPort)
 	jvm.JstatPath = *optJstatPath
 
 	if *optJavaName == "" {
@@ -215,7 +219,7 @@ func main() {
 
 	jvm.JavaName = *optJavaName
 
-	helper := mp.NewMackerelPlugin(jvm)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(jvm))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
@@ -225,6 +229,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
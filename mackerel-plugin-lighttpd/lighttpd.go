@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"lighttpd.requests": mp.Graphs{
+		Label: "Lighttpd Requests",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "requests", Label: "Requests", Diff: true},
+		},
+	},
+	"lighttpd.bytes": mp.Graphs{
+		Label: "Lighttpd Bytes",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "bytes_sent", Label: "Bytes Sent", Diff: true},
+		},
+	},
+	"lighttpd.servers": mp.Graphs{
+		Label: "Lighttpd Servers",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "busy_servers", Label: "Busy Servers", Stacked: true},
+			mp.Metrics{Name: "idle_servers", Label: "Idle Servers", Stacked: true},
+		},
+	},
+	"lighttpd.scoreboard": mp.Graphs{
+		Label: "Lighttpd Scoreboard",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "score_connect", Label: "Connect", Stacked: true},
+			mp.Metrics{Name: "score_request_start", Label: "Request start", Stacked: true},
+			mp.Metrics{Name: "score_read", Label: "Reading request", Stacked: true},
+			mp.Metrics{Name: "score_read_post", Label: "Reading POST", Stacked: true},
+			mp.Metrics{Name: "score_request_end", Label: "Request end", Stacked: true},
+			mp.Metrics{Name: "score_handle_request", Label: "Handling request", Stacked: true},
+			mp.Metrics{Name: "score_response_start", Label: "Response start", Stacked: true},
+			mp.Metrics{Name: "score_write", Label: "Writing response", Stacked: true},
+			mp.Metrics{Name: "score_response_end", Label: "Response end", Stacked: true},
+			mp.Metrics{Name: "score_keepalive", Label: "Keepalive", Stacked: true},
+			mp.Metrics{Name: "score_error", Label: "Error", Stacked: true},
+			mp.Metrics{Name: "score_close", Label: "Closing", Stacked: true},
+			mp.Metrics{Name: "score_free", Label: "Free slot", Stacked: true},
+			mp.Metrics{Name: "score_other", Label: "Other", Stacked: true},
+		},
+	},
+}
+
+// the fields of server-status?auto
+var statusFields = map[string]string{
+	"Total Accesses": "requests",
+	"Total kBytes":   "bytes_sent",
+	"BusyServers":    "busy_servers",
+	"IdleServers":    "idle_servers",
+}
+
+// the states of the connections in the scoreboard, which differ from the ones of Apache
+var scoreboardStates = map[rune]string{
+	'.': "score_connect",
+	'q': "score_request_start",
+	'r': "score_read",
+	'R': "score_read_post",
+	'Q': "score_request_end",
+	'h': "score_handle_request",
+	's': "score_response_start",
+	'W': "score_write",
+	'S': "score_response_end",
+	'k': "score_keepalive",
+	'E': "score_error",
+	'C': "score_close",
+	'_': "score_free",
+}
+
+type LighttpdPlugin struct {
+	Uri string
+}
+
+// % wget -qO- 'http://localhost/server-status?auto'
+// Total Accesses: 1257
+// Total kBytes: 4396
+// Uptime: 3288
+// BusyServers: 2
+// IdleServers: 126
+// Scoreboard: hW______________________...
+func parseStatus(r io.Reader) (map[string]float64, error) {
+	stat := make(map[string]float64)
+	var scoreboard bool
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+
+		if key == "Scoreboard" {
+			for _, state := range scoreboardStates {
+				stat[state] = 0
+			}
+			stat["score_other"] = 0
+			for _, c := range value {
+				if name, ok := scoreboardStates[c]; ok {
+					stat[name]++
+				} else {
+					stat["score_other"]++
+				}
+			}
+			scoreboard = true
+			continue
+		}
+
+		name, ok := statusFields[key]
+		if !ok {
+			continue
+		}
+		v, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return nil, errors.New(fmt.Sprintf("invalid value of %s: %s", key, value))
+		}
+		stat[name] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if len(stat) == 0 {
+		return nil, errors.New("status not found. the status URL of mod_status should be given with ?auto")
+	}
+	if !scoreboard {
+		return nil, errors.New("scoreboard not found")
+	}
+	if v, ok := stat["bytes_sent"]; ok {
+		stat["bytes_sent"] = v * 1024
+	}
+	return stat, nil
+}
+
+func (l LighttpdPlugin) FetchMetrics() (map[string]float64, error) {
+	resp, err := http.Get(l.Uri)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound {
+		return nil, errors.New(fmt.Sprintf("%s is not found. mod_status may not be enabled, or status.status-url differs", l.Uri))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("HTTP status error: %d", resp.StatusCode))
+	}
+
+	return parseStatus(resp.Body)
+}
+
+func (l LighttpdPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optUri := flag.String("uri", "", "URI")
+	optScheme := flag.String("scheme", "http", "Scheme")
+	optHost := flag.String("host", "localhost", "Hostname")
+	optPort := flag.String("port", "80", "Port")
+	optPath := flag.String("path", "/server-status?auto", "Path")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var lighttpd LighttpdPlugin
+	if *optUri != "" {
+		lighttpd.Uri = *optUri
+	} else {
+		lighttpd.Uri = fmt.Sprintf("%s://%s%s", *optScheme, common.HostPort(*optHost, *optPort), *optPath)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(lighttpd))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-lighttpd", "uri", "host", "port", "path")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatus(t *testing.T) {
+	stub := `Total Accesses: 1257
+Total kBytes: 4396
+Uptime: 3288
+BusyServers: 3
+IdleServers: 5
+Scoreboard: hWk_____
+`
+
+	stat, err := parseStatus(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, stat["requests"], 1257.0)
+	assert.Equal(t, stat["bytes_sent"], 4396.0*1024)
+	assert.Equal(t, stat["busy_servers"], 3.0)
+	assert.Equal(t, stat["idle_servers"], 5.0)
+	assert.Equal(t, stat["score_handle_request"], 1.0)
+	assert.Equal(t, stat["score_write"], 1.0)
+	assert.Equal(t, stat["score_keepalive"], 1.0)
+	assert.Equal(t, stat["score_free"], 5.0)
+	assert.Equal(t, stat["score_read"], 0.0)
+	assert.Equal(t, stat["score_other"], 0.0)
+	_, ok := stat["Uptime"]
+	assert.False(t, ok)
+}
+
+func TestParseStatusNotAuto(t *testing.T) {
+	// the HTML page without ?auto
+	stub := `<!DOCTYPE html>
+<html><head><title>Status</title></head>
+<body><h1>Server-Status (lighttpd/1.4.59)</h1></body></html>
+`
+	_, err := parseStatus(strings.NewReader(stub))
+	assert.NotNil(t, err)
+}
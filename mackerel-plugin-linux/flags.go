@@ -7,6 +7,12 @@ import (
 var Flags = []cli.Flag{
 	cliTempFile,
 	cliType,
+	cliStatsd,
+	cliStatsdPrefix,
+	cliStatsdOnly,
+	cliSelfMetrics,
+	cliPostProcess,
+	cliPostProcessTimeout,
 }
 
 var cliTempFile = cli.StringFlag{
@@ -22,3 +28,43 @@ var cliType = cli.StringFlag{
 	Usage:  "Select metrics: all, swap, netstat, diskstats, proc_stat, users",
 	EnvVar: "ENVVAR_TYPE",
 }
+
+var cliStatsd = cli.StringFlag{
+	Name:   "statsd",
+	Value:  "",
+	Usage:  "Set StatsD address (host:port) to send the metrics to as gauges.",
+	EnvVar: "ENVVAR_STATSD",
+}
+
+var cliStatsdPrefix = cli.StringFlag{
+	Name:   "statsd_prefix",
+	Value:  "mackerel",
+	Usage:  "Set prefix of the stat names sent to StatsD.",
+	EnvVar: "ENVVAR_STATSD_PREFIX",
+}
+
+var cliStatsdOnly = cli.BoolFlag{
+	Name:   "statsd_only",
+	Usage:  "Send the metrics only to StatsD, without printing them for mackerel-agent.",
+	EnvVar: "ENVVAR_STATSD_ONLY",
+}
+
+var cliSelfMetrics = cli.BoolFlag{
+	Name:   "self_metrics",
+	Usage:  "Output the time of the collection and the number of the metrics failed to fetch.",
+	EnvVar: "ENVVAR_SELF_METRICS",
+}
+
+var cliPostProcess = cli.StringFlag{
+	Name:   "post_process",
+	Value:  "",
+	Usage:  "Set command to pipe the metric lines through before the output.",
+	EnvVar: "ENVVAR_POST_PROCESS",
+}
+
+var cliPostProcessTimeout = cli.IntFlag{
+	Name:   "post_process_timeout",
+	Value:  10,
+	Usage:  "Set timeout (sec) of the post-process command.",
+	EnvVar: "ENVVAR_POST_PROCESS_TIMEOUT",
+}
@@ -11,6 +11,7 @@ import (
 
 	"github.com/codegangsta/cli"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 )
 
 const PathVmstat = "/proc/vmstat"
@@ -76,13 +77,21 @@ func doMain(c *cli.Context) {
 	var linux LinuxPlugin
 
 	linux.Type = c.String("type")
-	helper := mp.NewMackerelPlugin(linux)
+	helper := mp.NewMackerelPlugin((&common.SelfMetrics{Enabled: c.Bool("self_metrics")}).Wrap(linux))
 	helper.Tempfile = c.String("tempfile")
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, &common.Statsd{
+			Addr:   c.String("statsd"),
+			Prefix: c.String("statsd_prefix"),
+			Only:   c.Bool("statsd_only"),
+		}, &common.PostProcess{
+			Command: c.String("post_process"),
+			Timeout: c.Int("post_process_timeout"),
+		})
 	}
 }
 
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"io/ioutil"
+	"os"
+	"runtime"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+const PathLoadavg = "/proc/loadavg"
+const PathUptime = "/proc/uptime"
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"loadavg.load": mp.Graphs{
+		Label: "Load Average",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "load1", Label: "1 min", Diff: false},
+			mp.Metrics{Name: "load5", Label: "5 min", Diff: false},
+			mp.Metrics{Name: "load15", Label: "15 min", Diff: false},
+		},
+	},
+	"loadavg.normalized": mp.Graphs{
+		Label: "Load Average per CPU Core",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "normalized_load1", Label: "1 min", Diff: false},
+			mp.Metrics{Name: "normalized_load5", Label: "5 min", Diff: false},
+			mp.Metrics{Name: "normalized_load15", Label: "15 min", Diff: false},
+		},
+	},
+	"loadavg.procs": mp.Graphs{
+		Label: "Processes",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "procs_running", Label: "Running", Diff: false},
+			mp.Metrics{Name: "procs_total", Label: "Total", Diff: false},
+		},
+	},
+	"loadavg.last_pid": mp.Graphs{
+		Label: "Last PID",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "last_pid", Label: "Last PID", Diff: false},
+		},
+	},
+	"loadavg.uptime": mp.Graphs{
+		Label: "Uptime",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "uptime", Label: "Uptime (sec)", Diff: false},
+		},
+	},
+}
+
+type LoadavgPlugin struct {
+	NumCPU int
+}
+
+// % cat /proc/loadavg
+// 0.20 0.18 0.12 1/80 11206
+func parseLoadavg(str string, stat map[string]float64) error {
+	fields := strings.Fields(str)
+	if len(fields) != 5 {
+		return errors.New("unexpected format of loadavg: " + str)
+	}
+
+	procs := strings.SplitN(fields[3], "/", 2)
+	if len(procs) != 2 {
+		return errors.New("unexpected format of loadavg: " + str)
+	}
+
+	values := map[string]string{
+		"load1":         fields[0],
+		"load5":         fields[1],
+		"load15":        fields[2],
+		"procs_running": procs[0],
+		"procs_total":   procs[1],
+		"last_pid":      fields[4],
+	}
+	for k, v := range values {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return err
+		}
+		stat[k] = f
+	}
+
+	return nil
+}
+
+// % cat /proc/uptime
+// 350735.47 234388.90
+func parseUptime(str string, stat map[string]float64) error {
+	fields := strings.Fields(str)
+	if len(fields) < 1 {
+		return errors.New("unexpected format of uptime: " + str)
+	}
+
+	uptime, err := strconv.ParseFloat(fields[0], 64)
+	if err != nil {
+		return err
+	}
+	stat["uptime"] = uptime
+
+	return nil
+}
+
+func (p LoadavgPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	loadavg, err := ioutil.ReadFile(PathLoadavg)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseLoadavg(string(loadavg), stat); err != nil {
+		return nil, err
+	}
+
+	uptime, err := ioutil.ReadFile(PathUptime)
+	if err != nil {
+		return nil, err
+	}
+	if err := parseUptime(string(uptime), stat); err != nil {
+		return nil, err
+	}
+
+	// raw load averages are hard to compare across hosts with different number of cores
+	for _, k := range []string{"load1", "load5", "load15"} {
+		stat["normalized_"+k] = stat[k] / float64(p.NumCPU)
+	}
+
+	return stat, nil
+}
+
+func (p LoadavgPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var loadavg LoadavgPlugin
+	loadavg.NumCPU = runtime.NumCPU()
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(loadavg))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-loadavg"
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLoadavg(t *testing.T) {
+	stub := "0.20 0.18 0.12 1/80 11206\n"
+	stat := make(map[string]float64)
+
+	err := parseLoadavg(stub, stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["load1"], 0.20)
+	assert.Equal(t, stat["load5"], 0.18)
+	assert.Equal(t, stat["load15"], 0.12)
+	assert.Equal(t, stat["procs_running"], 1.0)
+	assert.Equal(t, stat["procs_total"], 80.0)
+	assert.Equal(t, stat["last_pid"], 11206.0)
+}
+
+func TestParseLoadavgInvalid(t *testing.T) {
+	stat := make(map[string]float64)
+
+	assert.NotNil(t, parseLoadavg("0.20 0.18 0.12", stat))
+	assert.NotNil(t, parseLoadavg("0.20 0.18 0.12 80 11206", stat))
+}
+
+func TestParseUptime(t *testing.T) {
+	stub := "350735.47 234388.90\n"
+	stat := make(map[string]float64)
+
+	err := parseUptime(stub, stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["uptime"], 350735.47)
+}
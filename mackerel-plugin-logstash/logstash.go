@@ -0,0 +1,346 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"logstash.events": mp.Graphs{
+		Label: "Logstash Events",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "events_in", Label: "In", Diff: true},
+			mp.Metrics{Name: "events_filtered", Label: "Filtered", Diff: true},
+			mp.Metrics{Name: "events_out", Label: "Out", Diff: true},
+		},
+	},
+	"logstash.drop_ratio": mp.Graphs{
+		Label: "Logstash Dropped Events Ratio",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "events_drop_ratio", Label: "Drop Ratio"},
+		},
+	},
+	"logstash.jvm.heap": mp.Graphs{
+		Label: "Logstash JVM Heap Mem",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "heap_used", Label: "Used"},
+			mp.Metrics{Name: "heap_max", Label: "Max"},
+		},
+	},
+
+	// "logstash.pipeline.<pipeline>.*" will be generated dynamically
+}
+
+type LogstashEvents struct {
+	In       float64 `json:"in"`
+	Filtered float64 `json:"filtered"`
+	Out      float64 `json:"out"`
+}
+
+type LogstashPluginStats struct {
+	ID     string          `json:"id"`
+	Name   string          `json:"name"`
+	Events *LogstashEvents `json:"events"`
+}
+
+// queue stats of persisted queues are
+// {"type":"persisted","events":0,"capacity":{"queue_size_in_bytes":1024,...}} (6.x) or
+// {"type":"persisted","events_count":0,"queue_size_in_bytes":1024,...} (7.x),
+// and only the type is reported for memory queues
+type LogstashQueue struct {
+	Type             string  `json:"type"`
+	Events           float64 `json:"events"`
+	EventsCount      float64 `json:"events_count"`
+	QueueSizeInBytes float64 `json:"queue_size_in_bytes"`
+	Capacity         struct {
+		QueueSizeInBytes float64 `json:"queue_size_in_bytes"`
+	} `json:"capacity"`
+}
+
+type LogstashPipeline struct {
+	Events  *LogstashEvents `json:"events"`
+	Plugins struct {
+		Inputs  []LogstashPluginStats `json:"inputs"`
+		Filters []LogstashPluginStats `json:"filters"`
+		Outputs []LogstashPluginStats `json:"outputs"`
+	} `json:"plugins"`
+	Queue LogstashQueue `json:"queue"`
+}
+
+// % curl http://localhost:9600/_node/stats
+// Logstash 5.x has a single "pipeline", and 6.x or later have "pipelines" keyed by pipeline ids.
+type LogstashNodeStats struct {
+	Events *LogstashEvents `json:"events"`
+	JVM    struct {
+		Mem struct {
+			HeapUsedInBytes float64 `json:"heap_used_in_bytes"`
+			HeapMaxInBytes  float64 `json:"heap_max_in_bytes"`
+		} `json:"mem"`
+	} `json:"jvm"`
+	Pipeline  *LogstashPipeline            `json:"pipeline"`
+	Pipelines map[string]*LogstashPipeline `json:"pipelines"`
+}
+
+type LogstashPlugin struct {
+	Uri       string
+	Tempfile  string
+	Pipelines map[string]*LogstashPipeline
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+func parseNodeStats(r io.Reader) (*LogstashNodeStats, error) {
+	var s LogstashNodeStats
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	if s.Pipelines == nil {
+		s.Pipelines = make(map[string]*LogstashPipeline)
+		if s.Pipeline != nil {
+			s.Pipelines["main"] = s.Pipeline
+		}
+	}
+
+	// Logstash 5.x before 5.4 has no node-wide events
+	if s.Events == nil {
+		s.Events = &LogstashEvents{}
+		for _, pl := range s.Pipelines {
+			if pl.Events != nil {
+				s.Events.In += pl.Events.In
+				s.Events.Filtered += pl.Events.Filtered
+				s.Events.Out += pl.Events.Out
+			}
+		}
+	}
+
+	return &s, nil
+}
+
+func pluginsOf(pl *LogstashPipeline) []LogstashPluginStats {
+	var plugins []LogstashPluginStats
+	plugins = append(plugins, pl.Plugins.Inputs...)
+	plugins = append(plugins, pl.Plugins.Filters...)
+	plugins = append(plugins, pl.Plugins.Outputs...)
+	return plugins
+}
+
+func setNodeStats(s *LogstashNodeStats, stat map[string]float64) {
+	stat["events_in"] = s.Events.In
+	stat["events_filtered"] = s.Events.Filtered
+	stat["events_out"] = s.Events.Out
+	stat["heap_used"] = s.JVM.Mem.HeapUsedInBytes
+	stat["heap_max"] = s.JVM.Mem.HeapMaxInBytes
+
+	for id, pl := range s.Pipelines {
+		prefix := metricName(id)
+		if pl.Events != nil {
+			stat[prefix+"_events_in"] = pl.Events.In
+			stat[prefix+"_events_filtered"] = pl.Events.Filtered
+			stat[prefix+"_events_out"] = pl.Events.Out
+		}
+
+		q := pl.Queue
+		stat[prefix+"_queue_events"] = q.Events + q.EventsCount
+		stat[prefix+"_queue_size"] = q.QueueSizeInBytes + q.Capacity.QueueSizeInBytes
+
+		for _, plugin := range pluginsOf(pl) {
+			if plugin.Events != nil {
+				stat[prefix+"_plugin_"+metricName(plugin.ID)] = plugin.Events.Out
+			}
+		}
+	}
+}
+
+// dropRatio returns the ratio of events which came in but did not go out since the last run.
+func dropRatio(stat, last map[string]float64, prefix string) (float64, bool) {
+	in, ok1 := last[prefix+"events_in"]
+	out, ok2 := last[prefix+"events_out"]
+	if !ok1 || !ok2 {
+		return 0, false
+	}
+
+	in = stat[prefix+"events_in"] - in
+	out = stat[prefix+"events_out"] - out
+	// no events or restarted
+	if in <= 0 || out < 0 {
+		return 0, false
+	}
+
+	ratio := (in - out) / in * 100
+	if ratio < 0 {
+		// the events which were in flight at the last run
+		ratio = 0
+	}
+	return ratio, true
+}
+
+func (p LogstashPlugin) fetchNodeStats() (*LogstashNodeStats, error) {
+	resp, err := http.Get(p.Uri + "/_node/stats")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("HTTP status error: %d", resp.StatusCode))
+	}
+
+	return parseNodeStats(resp.Body)
+}
+
+func (p *LogstashPlugin) Prepare() error {
+	s, err := p.fetchNodeStats()
+	if err != nil {
+		return err
+	}
+	p.Pipelines = s.Pipelines
+	return nil
+}
+
+func (p LogstashPlugin) FetchMetrics() (map[string]float64, error) {
+	s, err := p.fetchNodeStats()
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	setNodeStats(s, stat)
+
+	if last := common.LastValues(p.Tempfile); last != nil {
+		if v, ok := dropRatio(stat, last, ""); ok {
+			stat["events_drop_ratio"] = v
+		}
+		for id := range s.Pipelines {
+			prefix := metricName(id) + "_"
+			if v, ok := dropRatio(stat, last, prefix); ok {
+				stat[prefix+"events_drop_ratio"] = v
+			}
+		}
+	}
+
+	return stat, nil
+}
+
+func (p LogstashPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), len(graphdef)+len(p.Pipelines)*5)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	for id, pl := range p.Pipelines {
+		prefix := metricName(id)
+		label := "Logstash Pipeline " + id
+
+		graphs["logstash.pipeline."+prefix+".events"] = mp.Graphs{
+			Label: label + " Events",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_events_in", Label: "In", Diff: true},
+				mp.Metrics{Name: prefix + "_events_filtered", Label: "Filtered", Diff: true},
+				mp.Metrics{Name: prefix + "_events_out", Label: "Out", Diff: true},
+			},
+		}
+		graphs["logstash.pipeline."+prefix+".drop_ratio"] = mp.Graphs{
+			Label: label + " Dropped Events Ratio",
+			Unit:  "percentage",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_events_drop_ratio", Label: "Drop Ratio"},
+			},
+		}
+		graphs["logstash.pipeline."+prefix+".queue"] = mp.Graphs{
+			Label: label + " Queued Events",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_queue_events", Label: "Events"},
+			},
+		}
+		graphs["logstash.pipeline."+prefix+".queue_size"] = mp.Graphs{
+			Label: label + " Queue Size",
+			Unit:  "bytes",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_queue_size", Label: "Size"},
+			},
+		}
+
+		var metrics [](mp.Metrics)
+		for _, plugin := range pluginsOf(pl) {
+			label := plugin.Name
+			if label == "" {
+				label = plugin.ID
+			}
+			metrics = append(metrics, mp.Metrics{Name: prefix + "_plugin_" + metricName(plugin.ID), Label: label + " (" + plugin.ID + ")", Diff: true})
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		sort.Sort(byName(metrics))
+		graphs["logstash.pipeline."+prefix+".plugins"] = mp.Graphs{
+			Label:   label + " Plugin Events Out",
+			Unit:    "integer",
+			Metrics: metrics,
+		}
+	}
+
+	return graphs
+}
+
+type byName [](mp.Metrics)
+
+func (m byName) Len() int           { return len(m) }
+func (m byName) Swap(i, j int)      { m[i], m[j] = m[j], m[i] }
+func (m byName) Less(i, j int) bool { return m[i].Name < m[j].Name }
+
+func main() {
+	optHost := flag.String("host", "localhost", "Host")
+	optPort := flag.String("port", "9600", "Port of the monitoring API")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var logstash LogstashPlugin
+	logstash.Uri = "http://" + common.HostPort(*optHost, *optPort)
+	if *optTempfile != "" {
+		logstash.Tempfile = *optTempfile
+	} else {
+		logstash.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-logstash-%s-%s", *optHost, *optPort)
+	}
+
+	err := logstash.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(logstash))
+	helper.Tempfile = logstash.Tempfile
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseNodeStats5(t *testing.T) {
+	stub := `{"host":"logstash1","version":"5.6.0","jvm":{"threads":{"count":30},"mem":{"heap_used_percent":20,"heap_committed_in_bytes":1037959168,"heap_max_in_bytes":1037959168,"heap_used_in_bytes":207887608}},
+"pipeline":{"events":{"duration_in_millis":1000,"in":100,"filtered":90,"out":90,"queue_push_duration_in_millis":10},
+"plugins":{"inputs":[{"id":"input-beats","events":{"out":100,"queue_push_duration_in_millis":10},"name":"beats"}],
+"filters":[{"id":"3f4d9a","events":{"duration_in_millis":5,"in":100,"out":90},"name":"drop"}],
+"outputs":[{"id":"output-es","events":{"duration_in_millis":20,"in":90,"out":90},"name":"elasticsearch"}]},
+"queue":{"type":"memory"}}}`
+
+	s, err := parseNodeStats(strings.NewReader(stub))
+	assert.Nil(t, err)
+
+	stat := make(map[string]float64)
+	setNodeStats(s, stat)
+	assert.Equal(t, stat["events_in"], 100.0)
+	assert.Equal(t, stat["events_out"], 90.0)
+	assert.Equal(t, stat["heap_used"], 207887608.0)
+	assert.Equal(t, stat["main_events_filtered"], 90.0)
+	assert.Equal(t, stat["main_queue_events"], 0.0)
+	assert.Equal(t, stat["main_plugin_input-beats"], 100.0)
+	assert.Equal(t, stat["main_plugin_3f4d9a"], 90.0)
+}
+
+func TestParseNodeStats7(t *testing.T) {
+	stub := `{"host":"logstash1","version":"7.6.0","events":{"in":300,"filtered":300,"out":280},
+"jvm":{"mem":{"heap_used_in_bytes":300000000,"heap_max_in_bytes":1000000000}},
+"pipelines":{
+"main":{"events":{"in":200,"filtered":200,"out":190},"plugins":{"inputs":[],"filters":[],"outputs":[{"id":"es","name":"elasticsearch","events":{"in":190,"out":190}}]},
+"queue":{"type":"persisted","events_count":12,"queue_size_in_bytes":4096,"max_queue_size_in_bytes":1073741824}},
+".monitoring-logstash":{"events":{"in":100,"filtered":100,"out":90},"plugins":{"inputs":[],"filters":[],"outputs":[]},"queue":{"type":"memory"}}}}`
+
+	s, err := parseNodeStats(strings.NewReader(stub))
+	assert.Nil(t, err)
+
+	stat := make(map[string]float64)
+	setNodeStats(s, stat)
+	assert.Equal(t, stat["events_in"], 300.0)
+	assert.Equal(t, stat["main_queue_events"], 12.0)
+	assert.Equal(t, stat["main_queue_size"], 4096.0)
+	assert.Equal(t, stat["main_plugin_es"], 190.0)
+	assert.Equal(t, stat["monitoring-logstash_events_out"], 90.0)
+}
+
+func TestParseNodeStats6Queue(t *testing.T) {
+	stub := `{"events":{"in":1,"filtered":1,"out":1},"pipelines":{"main":{"events":{"in":1,"filtered":1,"out":1},
+"queue":{"type":"persisted","events":5,"capacity":{"page_capacity_in_bytes":67108864,"max_queue_size_in_bytes":1073741824,"queue_size_in_bytes":2048}}}}}`
+
+	s, err := parseNodeStats(strings.NewReader(stub))
+	assert.Nil(t, err)
+
+	stat := make(map[string]float64)
+	setNodeStats(s, stat)
+	assert.Equal(t, stat["main_queue_events"], 5.0)
+	assert.Equal(t, stat["main_queue_size"], 2048.0)
+}
+
+func TestDropRatio(t *testing.T) {
+	last := map[string]float64{"events_in": 100, "events_out": 90}
+
+	v, ok := dropRatio(map[string]float64{"events_in": 200, "events_out": 170}, last, "")
+	assert.True(t, ok)
+	assert.Equal(t, v, 20.0)
+
+	// no events since the last run
+	_, ok = dropRatio(map[string]float64{"events_in": 100, "events_out": 90}, last, "")
+	assert.False(t, ok)
+
+	// restarted
+	_, ok = dropRatio(map[string]float64{"events_in": 10, "events_out": 5}, last, "")
+	assert.False(t, ok)
+
+	_, ok = dropRatio(map[string]float64{"events_in": 10, "events_out": 5}, map[string]float64{}, "")
+	assert.False(t, ok)
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// array states, so that "not healthy" is simply "< 1"
+const (
+	stateHealthy  = 1   // active and in sync (including checking)
+	stateSyncing  = 0.5 // resyncing, recovering or reshaping
+	stateDegraded = 0   // degraded without recovering, failed or inactive
+)
+
+type mdArray struct {
+	Name    string
+	Active  bool
+	Level   string
+	Total   float64
+	Working float64
+	Failed  float64
+	Spare   float64
+	// resync, recovery, reshape or check, which is "" when not syncing
+	Sync     string
+	Progress float64
+	// in KB/sec
+	Speed float64
+}
+
+var arrayLine = regexp.MustCompile(`^(md\S*) : (\S+)(.*)$`)
+
+var devicesStatus = regexp.MustCompile(`\[(\d+)/(\d+)\]`)
+
+var syncStatus = regexp.MustCompile(`(resync|recovery|reshape|check)\s*=\s*(?:([\d.]+)%)?`)
+
+var syncSpeed = regexp.MustCompile(`speed=(\d+)K/sec`)
+
+var devicePattern = regexp.MustCompile(`^\S+\[\d+\](\([A-Z]\))?$`)
+
+// % cat /proc/mdstat
+// Personalities : [raid1] [raid6] [raid5] [raid4]
+// md0 : active raid1 sdb1[1] sda1[0]
+// ......1953382464 blocks super 1.2 [2/2] [UU]
+// ......bitmap: 0/15 pages [0KB], 65536KB chunk
+// md1 : active raid5 sdc1[3](F) sdd1[1] sde1[0]
+// ......3906764800 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/2] [UU_]
+// ......[=>...................]  recovery =  8.5% (166398208/1953382400) finish=146.2min speed=203450K/sec
+// unused devices: <none>
+func parseMdstat(r io.Reader) ([]mdArray, error) {
+	var arrays []mdArray
+	var cur *mdArray
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			cur = nil
+			continue
+		}
+
+		if m := arrayLine.FindStringSubmatch(line); m != nil {
+			arrays = append(arrays, mdArray{Name: m[1], Active: m[2] == "active"})
+			cur = &arrays[len(arrays)-1]
+
+			for _, field := range strings.Fields(m[3]) {
+				dm := devicePattern.FindStringSubmatch(field)
+				if dm == nil {
+					// the level, or "(read-only)"
+					if !strings.HasPrefix(field, "(") && cur.Level == "" {
+						cur.Level = field
+					}
+					continue
+				}
+				switch dm[1] {
+				case "(F)":
+					cur.Failed++
+				case "(S)":
+					cur.Spare++
+				default:
+					cur.Working++
+				}
+			}
+			// raid0 and linear have no [n/m] status below, whose devices are all needed
+			cur.Total = cur.Working + cur.Failed
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+
+		if m := devicesStatus.FindStringSubmatch(line); m != nil {
+			cur.Total, _ = strconv.ParseFloat(m[1], 64)
+			cur.Working, _ = strconv.ParseFloat(m[2], 64)
+		}
+		if m := syncStatus.FindStringSubmatch(line); m != nil {
+			// "resync=DELAYED" and "resync=PENDING" have no progress yet
+			cur.Sync = m[1]
+			cur.Progress, _ = strconv.ParseFloat(m[2], 64)
+			if sm := syncSpeed.FindStringSubmatch(line); sm != nil {
+				cur.Speed, _ = strconv.ParseFloat(sm[1], 64)
+			}
+		}
+	}
+
+	return arrays, scanner.Err()
+}
+
+// arrayState returns the state of the array by /proc/mdstat
+func arrayState(a mdArray) float64 {
+	switch {
+	case !a.Active:
+		return stateDegraded
+	case a.Sync == "resync" || a.Sync == "recovery" || a.Sync == "reshape":
+		return stateSyncing
+	case a.Working < a.Total:
+		return stateDegraded
+	}
+	return stateHealthy
+}
+
+type mdDetail struct {
+	State  string
+	Failed float64
+}
+
+// % mdadm --detail /dev/md1
+// /dev/md1:
+// ........Raid Level : raid5
+// .............State : clean, degraded, recovering
+// ....Failed Devices : 1
+func parseDetail(r io.Reader) (mdDetail, error) {
+	var d mdDetail
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), " : ", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch strings.TrimSpace(kv[0]) {
+		case "State":
+			d.State = strings.TrimSpace(kv[1])
+		case "Failed Devices":
+			d.Failed, _ = strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		}
+	}
+	if d.State == "" {
+		return d, errors.New("no State in mdadm --detail")
+	}
+	return d, scanner.Err()
+}
+
+// detailState returns the state of the array by the State of mdadm --detail,
+// e.g. "clean", "active, degraded, recovering" or "clean, FAILED"
+func detailState(state string) float64 {
+	flags := make(map[string]bool)
+	for _, s := range strings.Split(state, ",") {
+		flags[strings.TrimSpace(s)] = true
+	}
+	switch {
+	case flags["FAILED"] || flags["inactive"]:
+		return stateDegraded
+	case flags["resyncing"] || flags["recovering"] || flags["reshaping"]:
+		return stateSyncing
+	case flags["degraded"]:
+		return stateDegraded
+	}
+	return stateHealthy
+}
+
+type MdadmPlugin struct {
+	MdstatPath string
+	MdadmPath  string
+	Detail     bool
+	Arrays     []string
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+func (p MdadmPlugin) fetchArrays() ([]mdArray, error) {
+	f, err := os.Open(p.MdstatPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return parseMdstat(f)
+}
+
+func (p MdadmPlugin) fetchDetail(name string) (mdDetail, error) {
+	out, err := exec.Command(p.MdadmPath, "--detail", "/dev/"+name).Output()
+	if err != nil {
+		return mdDetail{}, errors.New(fmt.Sprintf("%s --detail /dev/%s: %s", p.MdadmPath, name, err))
+	}
+	return parseDetail(strings.NewReader(string(out)))
+}
+
+func (p *MdadmPlugin) Prepare() error {
+	arrays, err := p.fetchArrays()
+	if err != nil {
+		return err
+	}
+	for _, a := range arrays {
+		p.Arrays = append(p.Arrays, a.Name)
+	}
+	if len(p.Arrays) == 0 {
+		return errors.New("no md arrays found")
+	}
+	return nil
+}
+
+func (p MdadmPlugin) FetchMetrics() (map[string]float64, error) {
+	arrays, err := p.fetchArrays()
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	for _, a := range arrays {
+		state := arrayState(a)
+		failed := a.Failed
+		if p.Detail {
+			// the worse of both, as mdstat no longer lists the failed devices once removed
+			if d, err := p.fetchDetail(a.Name); err != nil {
+				common.LogFetchError(a.Name, err)
+			} else {
+				if v := detailState(d.State); v < state {
+					state = v
+				}
+				if d.Failed > failed {
+					failed = d.Failed
+				}
+			}
+		}
+
+		prefix := metricName(a.Name) + "_"
+		stat[prefix+"state"] = state
+		stat[prefix+"devices_total"] = a.Total
+		stat[prefix+"devices_active"] = a.Working
+		stat[prefix+"devices_failed"] = failed
+		stat[prefix+"devices_spare"] = a.Spare
+		if a.Sync != "" {
+			stat[prefix+"sync_progress"] = a.Progress
+		} else {
+			stat[prefix+"sync_progress"] = 100
+		}
+		stat[prefix+"sync_speed"] = a.Speed * 1024
+	}
+
+	return stat, nil
+}
+
+func (p MdadmPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphdef := make(map[string](mp.Graphs))
+
+	for _, name := range p.Arrays {
+		prefix := metricName(name)
+
+		graphdef["mdadm."+prefix+".state"] = mp.Graphs{
+			Label: "mdadm " + name + " State",
+			Unit:  "float",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_state", Label: "State (1:Healthy)"},
+			},
+		}
+		graphdef["mdadm."+prefix+".devices"] = mp.Graphs{
+			Label: "mdadm " + name + " Devices",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_devices_total", Label: "Total"},
+				mp.Metrics{Name: prefix + "_devices_active", Label: "Active"},
+				mp.Metrics{Name: prefix + "_devices_failed", Label: "Failed"},
+				mp.Metrics{Name: prefix + "_devices_spare", Label: "Spare"},
+			},
+		}
+		graphdef["mdadm."+prefix+".sync_progress"] = mp.Graphs{
+			Label: "mdadm " + name + " Resync/Recovery Progress",
+			Unit:  "percentage",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_sync_progress", Label: "Progress"},
+			},
+		}
+		graphdef["mdadm."+prefix+".sync_speed"] = mp.Graphs{
+			Label: "mdadm " + name + " Resync/Recovery Speed",
+			Unit:  "bytes/sec",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_sync_speed", Label: "Speed"},
+			},
+		}
+	}
+
+	return graphdef
+}
+
+func main() {
+	optMdstatPath := flag.String("mdstat", "/proc/mdstat", "/proc/mdstat path")
+	optMdadmPath := flag.String("mdadm", "mdadm", "mdadm command path")
+	optDetail := flag.Bool("detail", false, "Check the state by mdadm --detail as well (requires root)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var mdadm MdadmPlugin
+	mdadm.MdstatPath = *optMdstatPath
+	mdadm.MdadmPath = *optMdadmPath
+	mdadm.Detail = *optDetail
+
+	err := mdadm.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(mdadm))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-mdadm"
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
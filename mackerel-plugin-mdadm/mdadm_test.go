@@ -0,0 +1,106 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseMdstat(t *testing.T) {
+	stub := `Personalities : [raid0] [raid1] [raid6] [raid5] [raid4]
+md0 : active raid1 sdb1[1] sda1[0]
+      1953382464 blocks super 1.2 [2/2] [UU]
+      bitmap: 0/15 pages [0KB], 65536KB chunk
+
+md1 : active raid5 sdc1[3](F) sdf1[4](S) sdd1[1] sde1[0]
+      3906764800 blocks super 1.2 level 5, 512k chunk, algorithm 2 [3/2] [UU_]
+      [=>...................]  recovery =  8.5% (166398208/1953382400) finish=146.2min speed=203450K/sec
+
+md2 : active raid0 sdg1[1] sdh1[0]
+      3906764800 blocks super 1.2 512k chunks
+
+md3 : active (auto-read-only) raid1 sdi1[1] sdj1[0]
+      976630336 blocks super 1.2 [2/2] [UU]
+        resync=PENDING
+
+md127 : inactive sdk[0](S)
+      976630336 blocks super 1.2
+
+unused devices: <none>
+`
+	arrays, err := parseMdstat(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(arrays), 5)
+
+	a := arrays[0]
+	assert.Equal(t, a.Name, "md0")
+	assert.Equal(t, a.Level, "raid1")
+	assert.Equal(t, a.Total, 2.0)
+	assert.Equal(t, a.Working, 2.0)
+	assert.Equal(t, a.Sync, "")
+	assert.Equal(t, arrayState(a), 1.0)
+
+	a = arrays[1]
+	assert.Equal(t, a.Level, "raid5")
+	assert.Equal(t, a.Total, 3.0)
+	assert.Equal(t, a.Working, 2.0)
+	assert.Equal(t, a.Failed, 1.0)
+	assert.Equal(t, a.Spare, 1.0)
+	assert.Equal(t, a.Sync, "recovery")
+	assert.InDelta(t, a.Progress, 8.5, 0.001)
+	assert.Equal(t, a.Speed, 203450.0)
+	assert.Equal(t, arrayState(a), 0.5)
+
+	a = arrays[2]
+	assert.Equal(t, a.Level, "raid0")
+	assert.Equal(t, a.Total, 2.0)
+	assert.Equal(t, a.Working, 2.0)
+	assert.Equal(t, arrayState(a), 1.0)
+
+	a = arrays[3]
+	assert.Equal(t, a.Level, "raid1")
+	assert.Equal(t, a.Sync, "resync")
+	assert.Equal(t, a.Progress, 0.0)
+
+	a = arrays[4]
+	assert.Equal(t, a.Name, "md127")
+	assert.False(t, a.Active)
+	assert.Equal(t, a.Spare, 1.0)
+	assert.Equal(t, arrayState(a), 0.0)
+}
+
+func TestArrayState(t *testing.T) {
+	assert.Equal(t, arrayState(mdArray{Active: true, Total: 2, Working: 1}), 0.0)
+	assert.Equal(t, arrayState(mdArray{Active: true, Total: 2, Working: 2, Sync: "check"}), 1.0)
+	assert.Equal(t, arrayState(mdArray{Active: true, Total: 2, Working: 2, Sync: "resync"}), 0.5)
+}
+
+func TestParseDetail(t *testing.T) {
+	stub := `/dev/md1:
+           Version : 1.2
+        Raid Level : raid5
+      Raid Devices : 3
+             State : clean, degraded, recovering
+    Active Devices : 2
+   Working Devices : 3
+    Failed Devices : 1
+     Spare Devices : 1
+`
+	d, err := parseDetail(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, d.State, "clean, degraded, recovering")
+	assert.Equal(t, d.Failed, 1.0)
+
+	_, err = parseDetail(strings.NewReader("mdadm: cannot open /dev/md9: No such file or directory\n"))
+	assert.NotNil(t, err)
+}
+
+func TestDetailState(t *testing.T) {
+	assert.Equal(t, detailState("clean"), 1.0)
+	assert.Equal(t, detailState("active, checking"), 1.0)
+	assert.Equal(t, detailState("clean, degraded, recovering"), 0.5)
+	assert.Equal(t, detailState("active, resyncing"), 0.5)
+	assert.Equal(t, detailState("clean, degraded"), 0.0)
+	assert.Equal(t, detailState("clean, FAILED"), 0.0)
+}
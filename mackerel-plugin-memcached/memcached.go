@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"log"
 	"net"
 	"os"
@@ -81,11 +82,12 @@ var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
 
 type MemcachedPlugin struct {
 	Target   string
+	Network  string
 	Tempfile string
 }
 
 func (m MemcachedPlugin) FetchMetrics() (map[string]float64, error) {
-	conn, err := net.Dial("tcp", m.Target)
+	conn, err := net.Dial(m.Network, m.Target)
 	if err != nil {
 		return nil, err
 	}
@@ -121,12 +123,22 @@ func (m MemcachedPlugin) GraphDefinition() map[string](mp.Graphs) {
 func main() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "11211", "Port")
+	optNetwork := common.NetworkFlag()
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
+	if err := common.CheckNetwork(*optNetwork); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	var memcached MemcachedPlugin
-	memcached.Target = fmt.Sprintf("%s:%s", *optHost, *optPort)
-	helper := mp.NewMackerelPlugin(memcached)
+	memcached.Target = common.HostPort(*optHost, *optPort)
+	memcached.Network = *optNetwork
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(memcached))
 
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
@@ -137,6 +149,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
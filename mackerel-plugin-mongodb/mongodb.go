@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"gopkg.in/mgo.v2"
 	"gopkg.in/mgo.v2/bson"
 	"os"
@@ -128,16 +129,19 @@ func main() {
 	optUser := flag.String("username", "", "Username")
 	optPass := flag.String("password", "", "Password")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	var mongodb MongoDBPlugin
 	if *optUser == "" && *optPass == "" {
-		mongodb.Url = fmt.Sprintf("mongodb://%s:%s", *optHost, *optPort)
+		mongodb.Url = "mongodb://" + common.HostPort(*optHost, *optPort)
 	} else {
-		mongodb.Url = fmt.Sprintf("mongodb://%s:%s@%s:%s", *optUser, *optPass, *optHost, *optPort)
+		mongodb.Url = fmt.Sprintf("mongodb://%s:%s@%s", *optUser, *optPass, common.HostPort(*optHost, *optPort))
 	}
 
-	helper := mp.NewMackerelPlugin(mongodb)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(mongodb))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
@@ -147,6 +151,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
@@ -6,6 +6,7 @@ import (
 	"flag"
 	"fmt"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"io"
 	"io/ioutil"
 	"log"
@@ -282,6 +283,9 @@ func main() {
 	optPluginConfDir := flag.String("plugin-conf-d", "", "Munin plugin-conf.d path")
 	optGraphName := flag.String("name", "", "Graph name")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	var munin MuninPlugin
@@ -301,7 +305,7 @@ func main() {
 		log.Fatalln(err)
 	}
 
-	helper := mp.NewMackerelPlugin(munin)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(munin))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
@@ -311,6 +315,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
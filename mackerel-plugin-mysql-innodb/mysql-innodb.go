@@ -0,0 +1,282 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+	"github.com/ziutek/mymysql/mysql"
+	_ "github.com/ziutek/mymysql/native"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"innodb.history_list_length": mp.Graphs{
+		Label: "InnoDB History List Length",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "history_list_length", Label: "Undo Logs not Purged"},
+		},
+	},
+	"innodb.checkpoint_age": mp.Graphs{
+		Label: "InnoDB Checkpoint Age",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "checkpoint_age", Label: "Checkpoint Age"},
+		},
+	},
+	"innodb.log_written": mp.Graphs{
+		Label: "InnoDB Redo Log Written",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "log_sequence_number", Label: "Written", Diff: true},
+		},
+	},
+	"innodb.pending_io": mp.Graphs{
+		Label: "InnoDB Pending I/O",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "pending_aio_reads", Label: "AIO Reads"},
+			mp.Metrics{Name: "pending_aio_writes", Label: "AIO Writes"},
+			mp.Metrics{Name: "pending_log_fsyncs", Label: "Log fsyncs"},
+			mp.Metrics{Name: "pending_buffer_pool_fsyncs", Label: "Buffer Pool fsyncs"},
+			mp.Metrics{Name: "pending_reads", Label: "Buffer Pool Reads"},
+			mp.Metrics{Name: "pending_writes", Label: "Buffer Pool Writes"},
+		},
+	},
+	"innodb.row_lock_waits": mp.Graphs{
+		Label: "InnoDB Transactions Waiting for Row Locks",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "row_lock_waits", Label: "Waiting"},
+		},
+	},
+	"innodb.row_lock_wait_time": mp.Graphs{
+		Label: "InnoDB Row Lock Wait Time (sec)",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "row_lock_wait_time", Label: "Total"},
+		},
+	},
+	"innodb.buffer_pool_pages": mp.Graphs{
+		Label: "InnoDB Buffer Pool Pages",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "buffer_pool_pages_dirty", Label: "Dirty", Stacked: true},
+			mp.Metrics{Name: "buffer_pool_pages_clean", Label: "Clean", Stacked: true},
+			mp.Metrics{Name: "buffer_pool_pages_free", Label: "Free", Stacked: true},
+		},
+	},
+	"innodb.buffer_pool_dirty": mp.Graphs{
+		Label: "InnoDB Buffer Pool Dirty Pages",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "buffer_pool_dirty_percentage", Label: "Dirty"},
+		},
+	},
+}
+
+// lines of SHOW ENGINE INNODB STATUS holding a number (or numbers) mapped to metric names.
+// the spaces between the names and the numbers vary by the versions, e.g.
+// "Log sequence number 2612837" (5.6), "Log sequence number          2612837" (8.0)
+// and "Log sequence number 0 2612837" (5.0, the high and low 32 bits)
+var statusLines = []struct {
+	re  *regexp.Regexp
+	key string
+}{
+	{regexp.MustCompile(`^History list length\s+(\d+)`), "history_list_length"},
+	{regexp.MustCompile(`^Log sequence number\s+(\d+)(?:\s+(\d+))?$`), "log_sequence_number"},
+	{regexp.MustCompile(`^Last checkpoint at\s+(\d+)(?:\s+(\d+))?$`), "last_checkpoint"},
+	{regexp.MustCompile(`^Pending flushes \(fsync\) log:\s*(\d+)`), "pending_log_fsyncs"},
+	{regexp.MustCompile(`^Pending flushes \(fsync\).*buffer pool:\s*(\d+)`), "pending_buffer_pool_fsyncs"},
+	{regexp.MustCompile(`^Pending reads\s+(\d+)`), "pending_reads"},
+	{regexp.MustCompile(`^Buffer pool size\s+(\d+)`), "buffer_pool_pages_total"},
+	{regexp.MustCompile(`^Free buffers\s+(\d+)`), "buffer_pool_pages_free"},
+	{regexp.MustCompile(`^Database pages\s+(\d+)`), "buffer_pool_pages_data"},
+	{regexp.MustCompile(`^Modified db pages\s+(\d+)`), "buffer_pool_pages_dirty"},
+}
+
+// "------- TRX HAS BEEN WAITING 12 SEC FOR THIS LOCK TO BE GRANTED:"
+var lockWaitTime = regexp.MustCompile(`TRX HAS BEEN WAITING (\d+) SEC`)
+
+var numbers = regexp.MustCompile(`\d+`)
+
+type MySQLInnoDBPlugin struct {
+	Target   string
+	Username string
+	Password string
+}
+
+// parseEngineStatus parses the free-text output of SHOW ENGINE INNODB STATUS.
+// only the first occurrence of each line is taken, as the totals of the buffer pools
+// come before the ones of each instance (INDIVIDUAL BUFFER POOL INFO)
+func parseEngineStatus(str string) map[string]float64 {
+	stat := make(map[string]float64)
+	var lockWaits, waitTime float64
+
+	scanner := bufio.NewScanner(strings.NewReader(str))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		if strings.HasPrefix(line, "LOCK WAIT") {
+			lockWaits++
+			continue
+		}
+		if m := lockWaitTime.FindStringSubmatch(line); m != nil {
+			v, _ := strconv.ParseFloat(m[1], 64)
+			waitTime += v
+			continue
+		}
+		if strings.HasPrefix(line, "Pending normal aio reads:") {
+			parsePendingAIO(line, stat)
+			continue
+		}
+		if strings.HasPrefix(line, "Pending writes:") {
+			// "Pending writes: LRU 0, flush list 0, single page 0"
+			if _, ok := stat["pending_writes"]; !ok {
+				stat["pending_writes"] = sumNumbers(line)
+			}
+			continue
+		}
+
+		for _, l := range statusLines {
+			if _, ok := stat[l.key]; ok {
+				continue
+			}
+			m := l.re.FindStringSubmatch(line)
+			if m == nil {
+				continue
+			}
+			v, _ := strconv.ParseFloat(m[1], 64)
+			if len(m) > 2 && m[2] != "" {
+				low, _ := strconv.ParseFloat(m[2], 64)
+				v = v*4294967296 + low
+			}
+			stat[l.key] = v
+		}
+	}
+
+	stat["row_lock_waits"] = lockWaits
+	stat["row_lock_wait_time"] = waitTime
+
+	lsn, ok1 := stat["log_sequence_number"]
+	checkpoint, ok2 := stat["last_checkpoint"]
+	if ok1 && ok2 {
+		stat["checkpoint_age"] = lsn - checkpoint
+	}
+
+	total, ok1 := stat["buffer_pool_pages_total"]
+	dirty, ok2 := stat["buffer_pool_pages_dirty"]
+	if ok1 && ok2 && total > 0 {
+		stat["buffer_pool_dirty_percentage"] = dirty / total * 100
+	}
+	if data, ok := stat["buffer_pool_pages_data"]; ok && ok2 {
+		stat["buffer_pool_pages_clean"] = data - dirty
+	}
+
+	return stat
+}
+
+// parsePendingAIO parses the pending asynchronous I/O of the file I/O section, which is
+// "Pending normal aio reads: 0, aio writes: 0," (5.1),
+// "Pending normal aio reads: 0 [0, 0, 0, 0] , aio writes: 0 [0, 0, 0, 0] ," (5.6) or
+// "Pending normal aio reads: [0, 0, 0, 0] , aio writes: [0, 0, 0, 0] ," (8.0)
+func parsePendingAIO(line string, stat map[string]float64) {
+	line = strings.TrimPrefix(line, "Pending normal aio reads:")
+	parts := strings.SplitN(line, "aio writes:", 2)
+	stat["pending_aio_reads"] = pendingTotal(parts[0])
+	if len(parts) == 2 {
+		stat["pending_aio_writes"] = pendingTotal(parts[1])
+	}
+}
+
+// pendingTotal returns the total before the numbers per I/O thread, or their sum without it
+func pendingTotal(s string) float64 {
+	s = strings.TrimSpace(s)
+	if strings.HasPrefix(s, "[") {
+		if i := strings.Index(s, "]"); i >= 0 {
+			s = s[:i]
+		}
+		return sumNumbers(s)
+	}
+	if n := numbers.FindString(s); n != "" {
+		v, _ := strconv.ParseFloat(n, 64)
+		return v
+	}
+	return 0
+}
+
+func sumNumbers(s string) float64 {
+	var sum float64
+	for _, n := range numbers.FindAllString(s, -1) {
+		v, _ := strconv.ParseFloat(n, 64)
+		sum += v
+	}
+	return sum
+}
+
+func (m MySQLInnoDBPlugin) FetchMetrics() (map[string]float64, error) {
+	db := mysql.New("tcp", "", m.Target, m.Username, m.Password, "")
+	db.SetTimeout(10 * time.Second)
+	if err := db.Connect(); err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	// the columns are Type, Name and Status
+	rows, _, err := db.Query("show engine innodb status")
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 || len(rows[0]) < 3 {
+		return nil, errors.New("cannot get the status of InnoDB")
+	}
+
+	stat := parseEngineStatus(rows[0].Str(2))
+	if _, ok := stat["history_list_length"]; !ok {
+		return nil, errors.New("cannot parse the status of InnoDB")
+	}
+	return stat, nil
+}
+
+func (m MySQLInnoDBPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optHost := flag.String("host", "localhost", "Hostname")
+	optPort := flag.String("port", "3306", "Port")
+	optUser := flag.String("username", "root", "Username")
+	optPass := flag.String("password", "", "Password")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var innodb MySQLInnoDBPlugin
+	innodb.Target = common.HostPort(*optHost, *optPort)
+	innodb.Username = *optUser
+	innodb.Password = *optPass
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(innodb))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-mysql-innodb-%s-%s", *optHost, *optPort)
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
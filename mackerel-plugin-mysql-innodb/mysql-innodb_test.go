@@ -0,0 +1,129 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// parts of the output of MySQL 5.6
+var status56 = `
+=====================================
+2015-06-01 12:00:00 7f1c2c0c4700 INNODB MONITOR OUTPUT
+=====================================
+------------
+TRANSACTIONS
+------------
+Trx id counter 1843
+Purge done for trx's n:o < 1840 undo n:o < 0 state: running but idle
+History list length 572
+LIST OF TRANSACTIONS FOR EACH SESSION:
+---TRANSACTION 1842, ACTIVE 14 sec starting index read
+mysql tables in use 1, locked 1
+LOCK WAIT 2 lock struct(s), heap size 360, 1 row lock(s)
+MySQL thread id 3, OS thread handle 0x7f1c2c0c4700, query id 40 localhost root updating
+------- TRX HAS BEEN WAITING 14 SEC FOR THIS LOCK TO BE GRANTED:
+---TRANSACTION 1841, ACTIVE 5 sec starting index read
+LOCK WAIT 2 lock struct(s), heap size 360, 1 row lock(s)
+------- TRX HAS BEEN WAITING 5 SEC FOR THIS LOCK TO BE GRANTED:
+--------
+FILE I/O
+--------
+Pending normal aio reads: 3 [1, 2, 0, 0] , aio writes: 1 [0, 1, 0, 0] ,
+ ibuf aio reads: 0, log i/o's: 0, sync i/o's: 0
+Pending flushes (fsync) log: 1; buffer pool: 2
+---
+LOG
+---
+Log sequence number 1626842
+Log flushed up to   1626842
+Pages flushed up to 1626000
+Last checkpoint at  1600000
+----------------------
+BUFFER POOL AND MEMORY
+----------------------
+Total memory allocated 137428992; in additional pool allocated 0
+Buffer pool size   8191
+Free buffers       7700
+Database pages     491
+Modified db pages  40
+Pending reads 2
+Pending writes: LRU 0, flush list 1, single page 0
+----------------------
+INDIVIDUAL BUFFER POOL INFO
+----------------------
+---BUFFER POOL 0
+Buffer pool size   4096
+Free buffers       3850
+Database pages     246
+Modified db pages  20
+`
+
+// parts of the output of MySQL 8.0, without transactions waiting for locks
+var status80 = `
+------------
+TRANSACTIONS
+------------
+Trx id counter 10541
+History list length 0
+--------
+FILE I/O
+--------
+Pending normal aio reads: [0, 0, 0, 0] , aio writes: [0, 2, 0, 1] ,
+ ibuf aio reads:, log i/o's:
+Pending flushes (fsync) log: 0; buffer pool: 0
+---
+LOG
+---
+Log sequence number          20966959
+Log buffer assigned up to    20966959
+Last checkpoint at           20966000
+----------------------
+BUFFER POOL AND MEMORY
+----------------------
+Buffer pool size   8192
+Free buffers       7000
+Database pages     1192
+Modified db pages  0
+Pending reads      0
+Pending writes: LRU 0, flush list 0, single page 0
+`
+
+func TestParseEngineStatus(t *testing.T) {
+	stat := parseEngineStatus(status56)
+
+	assert.Equal(t, stat["history_list_length"], 572.0)
+	assert.Equal(t, stat["checkpoint_age"], 26842.0)
+	assert.Equal(t, stat["pending_aio_reads"], 3.0)
+	assert.Equal(t, stat["pending_aio_writes"], 1.0)
+	assert.Equal(t, stat["pending_log_fsyncs"], 1.0)
+	assert.Equal(t, stat["pending_buffer_pool_fsyncs"], 2.0)
+	assert.Equal(t, stat["pending_reads"], 2.0)
+	assert.Equal(t, stat["pending_writes"], 1.0)
+	assert.Equal(t, stat["row_lock_waits"], 2.0)
+	assert.Equal(t, stat["row_lock_wait_time"], 19.0)
+	// the totals, not the ones of BUFFER POOL 0
+	assert.Equal(t, stat["buffer_pool_pages_total"], 8191.0)
+	assert.Equal(t, stat["buffer_pool_pages_dirty"], 40.0)
+	assert.Equal(t, stat["buffer_pool_pages_clean"], 451.0)
+	assert.InDelta(t, stat["buffer_pool_dirty_percentage"], 0.488, 0.001)
+}
+
+func TestParseEngineStatus80(t *testing.T) {
+	stat := parseEngineStatus(status80)
+
+	assert.Equal(t, stat["history_list_length"], 0.0)
+	assert.Equal(t, stat["log_sequence_number"], 20966959.0)
+	assert.Equal(t, stat["checkpoint_age"], 959.0)
+	assert.Equal(t, stat["pending_aio_reads"], 0.0)
+	assert.Equal(t, stat["pending_aio_writes"], 3.0)
+	assert.Equal(t, stat["row_lock_waits"], 0.0)
+	assert.Equal(t, stat["buffer_pool_dirty_percentage"], 0.0)
+}
+
+func TestParseEngineStatus50(t *testing.T) {
+	stat := parseEngineStatus("Log sequence number 1 100\nLast checkpoint at 0 4294967000\n")
+
+	assert.Equal(t, stat["log_sequence_number"], 4294967396.0)
+	assert.Equal(t, stat["checkpoint_age"], 396.0)
+}
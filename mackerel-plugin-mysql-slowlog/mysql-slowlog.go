@@ -0,0 +1,162 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"mysql_slowlog.queries": mp.Graphs{
+		Label: "MySQL Slow Queries per sec",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "slow_queries", Label: "Slow Queries"},
+			mp.Metrics{Name: "long_queries", Label: "Longer than Threshold"},
+		},
+	},
+	"mysql_slowlog.query_time": mp.Graphs{
+		Label: "MySQL Slow Query Time (sec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "query_time_total", Label: "Total per sec"},
+			mp.Metrics{Name: "query_time_max", Label: "Max"},
+		},
+	},
+	"mysql_slowlog.rows_examined": mp.Graphs{
+		Label: "MySQL Slow Query Rows Examined per sec",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "rows_examined", Label: "Rows Examined"},
+		},
+	},
+}
+
+type MySQLSlowlogPlugin struct {
+	Slowlog       string
+	LongThreshold float64
+	StateFile     string
+}
+
+type slowQueries struct {
+	count        float64
+	long         float64
+	queryTime    float64
+	maxQueryTime float64
+	rowsExamined float64
+}
+
+// # Time: 2017-09-18T08:22:00.123456Z
+// # User@Host: app[app] @ web1 [192.168.0.10]  Id:    12
+// # Query_time: 2.000123  Lock_time: 0.000045 Rows_sent: 1  Rows_examined: 123456
+// SET timestamp=1505722920;
+// SELECT ...;
+var queryTimeLine = regexp.MustCompile(`^# Query_time: ([\d.]+)\s+Lock_time: [\d.]+\s+Rows_sent: \d+\s+Rows_examined: (\d+)`)
+
+// parseSlowlog parses the header of each query in the slow log
+func parseSlowlog(r io.Reader, longThreshold float64) (slowQueries, error) {
+	var q slowQueries
+
+	// not bufio.Scanner, as long queries are written in a line
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return q, err
+		}
+		m := queryTimeLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		t, _ := strconv.ParseFloat(m[1], 64)
+		rows, _ := strconv.ParseFloat(m[2], 64)
+
+		q.count++
+		q.queryTime += t
+		q.rowsExamined += rows
+		if t > q.maxQueryTime {
+			q.maxQueryTime = t
+		}
+		if t > longThreshold {
+			q.long++
+		}
+	}
+	return q, nil
+}
+
+// slowlogMetrics returns the rates of the counts in the elapsed seconds, and the max query time as it is
+func slowlogMetrics(q slowQueries, elapsed float64) map[string]float64 {
+	return map[string]float64{
+		"slow_queries":     q.count / elapsed,
+		"long_queries":     q.long / elapsed,
+		"query_time_total": q.queryTime / elapsed,
+		"query_time_max":   q.maxQueryTime,
+		"rows_examined":    q.rowsExamined / elapsed,
+	}
+}
+
+func (p MySQLSlowlogPlugin) FetchMetrics() (map[string]float64, error) {
+	now := time.Now()
+
+	b, elapsed, ok, err := common.ReadNewLog(p.Slowlog, p.StateFile, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// the first run starts from the end of the log
+		return map[string]float64{}, nil
+	}
+
+	q, err := parseSlowlog(bytes.NewReader(b), p.LongThreshold)
+	if err != nil {
+		return nil, err
+	}
+
+	return slowlogMetrics(q, elapsed), nil
+}
+
+func (p MySQLSlowlogPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optSlowlog := flag.String("slowlog", "/var/log/mysql/mysql-slow.log", "Path of the slow query log")
+	optLongThreshold := flag.Float64("long-threshold", 10, "Query time (sec) of the queries to count as long")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var slowlog MySQLSlowlogPlugin
+	slowlog.Slowlog = *optSlowlog
+	slowlog.LongThreshold = *optLongThreshold
+
+	tempfile := common.Tempfile("mackerel-plugin-mysql-slowlog", "slowlog")
+	if *optTempfile != "" {
+		tempfile = *optTempfile
+	}
+	// the position in the log is kept beside the values of the last run
+	slowlog.StateFile = tempfile + ".position"
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(slowlog))
+	helper.Tempfile = tempfile
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
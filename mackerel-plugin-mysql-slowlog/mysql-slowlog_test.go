@@ -0,0 +1,37 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSlowlog(t *testing.T) {
+	stub := `/usr/sbin/mysqld, Version: 5.7.19-log (MySQL Community Server (GPL)). started with:
+Tcp port: 3306  Unix socket: /var/run/mysqld/mysqld.sock
+Time                 Id Command    Argument
+# Time: 2017-09-18T08:22:00.123456Z
+# User@Host: app[app] @ web1 [192.168.0.10]  Id:    12
+# Query_time: 2.500000  Lock_time: 0.000045 Rows_sent: 1  Rows_examined: 100000
+SET timestamp=1505722920;
+SELECT COUNT(*) FROM users WHERE name LIKE '%foo%';
+# Time: 2017-09-18T08:22:30.000000Z
+# User@Host: app[app] @ web2 [192.168.0.11]  Id:    13
+# Query_time: 12.000000  Lock_time: 0.000100 Rows_sent: 10  Rows_examined: 5000000
+SET timestamp=1505722950;
+SELECT * FROM orders ORDER BY created_at;
+`
+
+	q, err := parseSlowlog(strings.NewReader(stub), 10)
+	assert.Nil(t, err)
+	assert.EqualValues(t, q.count, 2)
+	assert.EqualValues(t, q.long, 1)
+	assert.InDelta(t, q.queryTime, 14.5, 0.0001)
+	assert.InDelta(t, q.maxQueryTime, 12.0, 0.0001)
+	assert.EqualValues(t, q.rowsExamined, 5100000)
+
+	stat := slowlogMetrics(q, 60)
+	assert.InDelta(t, stat["slow_queries"], 2.0/60, 0.0001)
+	assert.InDelta(t, stat["query_time_max"], 12.0, 0.0001)
+}
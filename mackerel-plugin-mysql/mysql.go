@@ -8,6 +8,7 @@ import (
 	"strconv"
 
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"github.com/ziutek/mymysql/mysql"
 	_ "github.com/ziutek/mymysql/native"
 )
@@ -165,14 +166,17 @@ func main() {
 	optUser := flag.String("username", "root", "Username")
 	optPass := flag.String("password", "", "Password")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	var mysql MySQLPlugin
 
-	mysql.Target = fmt.Sprintf("%s:%s", *optHost, *optPort)
+	mysql.Target = common.HostPort(*optHost, *optPort)
 	mysql.Username = *optUser
 	mysql.Password = *optPass
-	helper := mp.NewMackerelPlugin(mysql)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(mysql))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
@@ -182,6 +186,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	//"io/ioutil"
 	"errors"
 	"net/http"
@@ -138,25 +139,31 @@ func main() {
 	optPort := flag.String("port", "8080", "Port")
 	optPath := flag.String("path", "/nginx_status", "Path")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
+	httpOpts.Setup()
 
 	var nginx NginxPlugin
 	if *optUri != "" {
 		nginx.Uri = *optUri
 	} else {
-		nginx.Uri = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
+		nginx.Uri = fmt.Sprintf("%s://%s%s", *optScheme, common.HostPort(*optHost, *optPort), *optPath)
 	}
 
-	helper := mp.NewMackerelPlugin(nginx)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(nginx))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
-		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-nginx")
+		helper.Tempfile = common.Tempfile("mackerel-plugin-nginx", "uri", "host", "port", "path")
 	}
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
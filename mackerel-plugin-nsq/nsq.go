@@ -0,0 +1,347 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// % curl http://127.0.0.1:4151/stats?format=json
+// {"version":"0.3.0","health":"OK","start_time":1420070400,"topics":[{"topic_name":"events","channels":[...],"depth":0,...}]}
+//
+// nsqd before 1.0 wraps the response with {"status_code":200,"status_txt":"OK","data":{...}}
+type NSQChannel struct {
+	ChannelName   string            `json:"channel_name"`
+	Depth         float64           `json:"depth"`
+	InFlightCount float64           `json:"in_flight_count"`
+	DeferredCount float64           `json:"deferred_count"`
+	MessageCount  float64           `json:"message_count"`
+	RequeueCount  float64           `json:"requeue_count"`
+	TimeoutCount  float64           `json:"timeout_count"`
+	Clients       []json.RawMessage `json:"clients"`
+}
+
+type NSQTopic struct {
+	TopicName    string       `json:"topic_name"`
+	Depth        float64      `json:"depth"`
+	MessageCount float64      `json:"message_count"`
+	Channels     []NSQChannel `json:"channels"`
+}
+
+type NSQStats struct {
+	Topics []NSQTopic `json:"topics"`
+}
+
+type statsResponse struct {
+	NSQStats
+	Data *NSQStats `json:"data"`
+}
+
+// % curl http://127.0.0.1:4161/nodes
+// {"producers":[{"broadcast_address":"nsqd1","hostname":"nsqd1","http_port":4151,"tcp_port":4150,...}]}
+type NSQProducer struct {
+	BroadcastAddress string `json:"broadcast_address"`
+	HTTPPort         int    `json:"http_port"`
+}
+
+type NSQNodes struct {
+	Producers []NSQProducer `json:"producers"`
+}
+
+type nodesResponse struct {
+	NSQNodes
+	Data *NSQNodes `json:"data"`
+}
+
+type NSQPlugin struct {
+	Nsqd        string
+	Lookupd     string
+	Topic       string
+	Concurrency int
+	Topics      []NSQTopic
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+func parseStats(r io.Reader) (*NSQStats, error) {
+	var res statsResponse
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Data != nil {
+		return res.Data, nil
+	}
+	return &res.NSQStats, nil
+}
+
+func parseNodes(r io.Reader) ([]string, error) {
+	var res nodesResponse
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return nil, err
+	}
+	nodes := &res.NSQNodes
+	if res.Data != nil {
+		nodes = res.Data
+	}
+
+	addrs := make([]string, 0, len(nodes.Producers))
+	for _, p := range nodes.Producers {
+		addrs = append(addrs, fmt.Sprintf("%s:%d", p.BroadcastAddress, p.HTTPPort))
+	}
+	return addrs, nil
+}
+
+func httpGet(url string) (io.ReadCloser, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("%s: HTTP status error: %d", url, resp.StatusCode))
+	}
+	return resp.Body, nil
+}
+
+func (p NSQPlugin) nodes() ([]string, error) {
+	if p.Lookupd == "" {
+		return []string{p.Nsqd}, nil
+	}
+
+	body, err := httpGet("http://" + p.Lookupd + "/nodes")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+
+	addrs, err := parseNodes(body)
+	if err != nil {
+		return nil, err
+	}
+	if len(addrs) == 0 {
+		return nil, errors.New("no nsqd found by nsqlookupd " + p.Lookupd)
+	}
+	return addrs, nil
+}
+
+// mergeTopics sums up the stats of the same topics and channels on every nsqd
+func mergeTopics(dst []NSQTopic, src []NSQTopic, topic string) []NSQTopic {
+	for _, t := range src {
+		if topic != "" && t.TopicName != topic {
+			continue
+		}
+
+		i := 0
+		for ; i < len(dst); i++ {
+			if dst[i].TopicName == t.TopicName {
+				break
+			}
+		}
+		if i == len(dst) {
+			dst = append(dst, NSQTopic{TopicName: t.TopicName})
+		}
+		dt := &dst[i]
+		dt.Depth += t.Depth
+		dt.MessageCount += t.MessageCount
+
+		for _, c := range t.Channels {
+			j := 0
+			for ; j < len(dt.Channels); j++ {
+				if dt.Channels[j].ChannelName == c.ChannelName {
+					break
+				}
+			}
+			if j == len(dt.Channels) {
+				dt.Channels = append(dt.Channels, NSQChannel{ChannelName: c.ChannelName})
+			}
+			dc := &dt.Channels[j]
+			dc.Depth += c.Depth
+			dc.InFlightCount += c.InFlightCount
+			dc.DeferredCount += c.DeferredCount
+			dc.MessageCount += c.MessageCount
+			dc.RequeueCount += c.RequeueCount
+			dc.TimeoutCount += c.TimeoutCount
+			dc.Clients = append(dc.Clients, c.Clients...)
+		}
+	}
+	return dst
+}
+
+func (p NSQPlugin) fetchTopics() ([]NSQTopic, error) {
+	addrs, err := p.nodes()
+	if err != nil {
+		return nil, err
+	}
+
+	stats := make([]*NSQStats, len(addrs))
+	errs := make([]error, len(addrs))
+	common.FetchMany(len(addrs), p.Concurrency, func(i int) {
+		body, err := httpGet("http://" + addrs[i] + "/stats?format=json")
+		if err != nil {
+			errs[i] = err
+			return
+		}
+		defer body.Close()
+		stats[i], errs[i] = parseStats(body)
+	})
+
+	var topics []NSQTopic
+	for i := range addrs {
+		if errs[i] != nil {
+			return nil, errs[i]
+		}
+		topics = mergeTopics(topics, stats[i].Topics, p.Topic)
+	}
+
+	return topics, nil
+}
+
+func (p *NSQPlugin) Prepare() error {
+	var err error
+	p.Topics, err = p.fetchTopics()
+	return err
+}
+
+func setTopicMetrics(topics []NSQTopic, stat map[string]float64) {
+	for _, t := range topics {
+		tn := metricName(t.TopicName)
+		stat[tn+"_depth"] = t.Depth
+		stat[tn+"_message_count"] = t.MessageCount
+
+		for _, c := range t.Channels {
+			prefix := tn + "_" + metricName(c.ChannelName)
+			stat[prefix+"_depth"] = c.Depth
+			stat[prefix+"_in_flight_count"] = c.InFlightCount
+			stat[prefix+"_deferred_count"] = c.DeferredCount
+			stat[prefix+"_message_count"] = c.MessageCount
+			stat[prefix+"_requeue_count"] = c.RequeueCount
+			stat[prefix+"_timeout_count"] = c.TimeoutCount
+			stat[prefix+"_clients"] = float64(len(c.Clients))
+		}
+	}
+}
+
+func (p NSQPlugin) FetchMetrics() (map[string]float64, error) {
+	topics, err := p.fetchTopics()
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	setTopicMetrics(topics, stat)
+
+	return stat, nil
+}
+
+func (p NSQPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphdef := make(map[string](mp.Graphs))
+
+	for _, t := range p.Topics {
+		tn := metricName(t.TopicName)
+
+		graphdef["nsq.topic."+tn+".depth"] = mp.Graphs{
+			Label: "NSQ Topic " + t.TopicName + " Depth",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: tn + "_depth", Label: "Depth"},
+			},
+		}
+		graphdef["nsq.topic."+tn+".messages"] = mp.Graphs{
+			Label: "NSQ Topic " + t.TopicName + " Messages",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: tn + "_message_count", Label: "Messages", Diff: true},
+			},
+		}
+
+		for _, c := range t.Channels {
+			cn := metricName(c.ChannelName)
+			prefix := tn + "_" + cn
+			label := "NSQ Channel " + t.TopicName + "/" + c.ChannelName
+
+			graphdef["nsq.channel."+tn+"."+cn+".depth"] = mp.Graphs{
+				Label: label + " Depth",
+				Unit:  "integer",
+				Metrics: [](mp.Metrics){
+					mp.Metrics{Name: prefix + "_depth", Label: "Depth"},
+					mp.Metrics{Name: prefix + "_in_flight_count", Label: "In-Flight"},
+					mp.Metrics{Name: prefix + "_deferred_count", Label: "Deferred"},
+				},
+			}
+			graphdef["nsq.channel."+tn+"."+cn+".messages"] = mp.Graphs{
+				Label: label + " Messages",
+				Unit:  "integer",
+				Metrics: [](mp.Metrics){
+					mp.Metrics{Name: prefix + "_message_count", Label: "Messages", Diff: true},
+					mp.Metrics{Name: prefix + "_requeue_count", Label: "Requeued", Diff: true},
+					mp.Metrics{Name: prefix + "_timeout_count", Label: "Timed-out", Diff: true},
+				},
+			}
+			graphdef["nsq.channel."+tn+"."+cn+".clients"] = mp.Graphs{
+				Label: label + " Clients",
+				Unit:  "integer",
+				Metrics: [](mp.Metrics){
+					mp.Metrics{Name: prefix + "_clients", Label: "Clients"},
+				},
+			}
+		}
+	}
+
+	return graphdef
+}
+
+func main() {
+	optHost := flag.String("host", "127.0.0.1", "nsqd Host")
+	optPort := flag.String("port", "4151", "nsqd HTTP Port")
+	optLookupd := flag.String("lookupd", "", "nsqlookupd HTTP address (host:port) to discover nsqd nodes")
+	optTopic := flag.String("topic", "", "Topic name (default: all topics)")
+	optConcurrency := flag.Int("concurrency", common.DefaultConcurrency, "Maximum number of nsqd nodes fetched at once")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var nsq NSQPlugin
+	nsq.Nsqd = common.HostPort(*optHost, *optPort)
+	nsq.Lookupd = *optLookupd
+	nsq.Topic = *optTopic
+	nsq.Concurrency = *optConcurrency
+
+	err := nsq.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(nsq))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else if *optLookupd != "" {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-nsq-lookupd-%s-%s", metricName(*optLookupd), metricName(*optTopic))
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-nsq-%s-%s-%s", *optHost, *optPort, metricName(*optTopic))
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
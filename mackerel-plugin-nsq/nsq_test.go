@@ -0,0 +1,60 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var statsStub = `{"version":"0.3.5","health":"OK","start_time":1420070400,"topics":[
+{"topic_name":"events","depth":12,"backend_depth":0,"message_count":1000,"paused":false,"channels":[
+{"channel_name":"archive","depth":3,"backend_depth":0,"in_flight_count":2,"deferred_count":1,"message_count":900,"requeue_count":5,"timeout_count":4,"paused":false,"clients":[{"name":"worker1"},{"name":"worker2"}]},
+{"channel_name":"tail#ephemeral","depth":0,"backend_depth":0,"in_flight_count":0,"deferred_count":0,"message_count":10,"requeue_count":0,"timeout_count":0,"paused":false,"clients":[]}
+]},
+{"topic_name":"logs","depth":0,"backend_depth":0,"message_count":50,"paused":false,"channels":[]}
+]}`
+
+func TestParseStats(t *testing.T) {
+	stats, err := parseStats(strings.NewReader(statsStub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(stats.Topics), 2)
+
+	stat := make(map[string]float64)
+	setTopicMetrics(stats.Topics, stat)
+	assert.Equal(t, stat["events_depth"], 12.0)
+	assert.Equal(t, stat["events_message_count"], 1000.0)
+	assert.Equal(t, stat["events_archive_in_flight_count"], 2.0)
+	assert.Equal(t, stat["events_archive_requeue_count"], 5.0)
+	assert.Equal(t, stat["events_archive_timeout_count"], 4.0)
+	assert.Equal(t, stat["events_archive_clients"], 2.0)
+	assert.Equal(t, stat["events_tail_ephemeral_message_count"], 10.0)
+	assert.Equal(t, stat["logs_message_count"], 50.0)
+}
+
+func TestParseStatsWrapped(t *testing.T) {
+	stats, err := parseStats(strings.NewReader(`{"status_code":200,"status_txt":"OK","data":` + statsStub + `}`))
+	assert.Nil(t, err)
+	assert.Equal(t, len(stats.Topics), 2)
+	assert.Equal(t, stats.Topics[0].TopicName, "events")
+}
+
+func TestParseNodes(t *testing.T) {
+	addrs, err := parseNodes(strings.NewReader(`{"status_code":200,"status_txt":"OK","data":{"producers":[
+{"remote_address":"10.0.0.1:51234","hostname":"nsqd1","broadcast_address":"nsqd1","tcp_port":4150,"http_port":4151,"version":"0.3.5"},
+{"remote_address":"10.0.0.2:51234","hostname":"nsqd2","broadcast_address":"nsqd2","tcp_port":4150,"http_port":4151,"version":"0.3.5"}]}}`))
+	assert.Nil(t, err)
+	assert.Equal(t, addrs, []string{"nsqd1:4151", "nsqd2:4151"})
+}
+
+func TestMergeTopics(t *testing.T) {
+	stats, _ := parseStats(strings.NewReader(statsStub))
+
+	topics := mergeTopics(nil, stats.Topics, "events")
+	topics = mergeTopics(topics, stats.Topics, "events")
+	assert.Equal(t, len(topics), 1)
+	assert.Equal(t, topics[0].Depth, 24.0)
+	assert.Equal(t, len(topics[0].Channels), 2)
+	assert.Equal(t, topics[0].Channels[0].MessageCount, 1800.0)
+	assert.Equal(t, len(topics[0].Channels[0].Clients), 4)
+}
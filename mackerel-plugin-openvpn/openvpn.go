@@ -0,0 +1,401 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"openvpn.clients": mp.Graphs{
+		Label: "OpenVPN Connected Clients",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "clients", Label: "Clients"},
+		},
+	},
+	"openvpn.bytes": mp.Graphs{
+		Label: "OpenVPN Bytes",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "bytes_received", Label: "Received"},
+			mp.Metrics{Name: "bytes_sent", Label: "Sent"},
+		},
+	},
+
+	// the graphs of clientMetrics will be generated dynamically with -per-client
+}
+
+// metrics per client, named by the common name
+var clientMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "bytes_received_", Unit: "bytes",
+		Graph: "client_bytes_received", GraphLabel: "OpenVPN Bytes Received per Client"},
+	common.DimensionMetric{Prefix: "bytes_sent_", Unit: "bytes",
+		Graph: "client_bytes_sent", GraphLabel: "OpenVPN Bytes Sent per Client"},
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+type client struct {
+	name           string
+	connectedSince string
+	bytesReceived  float64
+	bytesSent      float64
+}
+
+// sessionKey identifies a connection of the client, as the bytes are reset by a reconnection
+func (c client) sessionKey() string {
+	return c.name + "\t" + c.connectedSince
+}
+
+// the bytes of a connection at the last run
+type sessionBytes struct {
+	Received float64 `json:"received"`
+	Sent     float64 `json:"sent"`
+}
+
+// the connections at the last run, to calculate the bytes since then
+type sessionState struct {
+	Time     int64                   `json:"time"`
+	Sessions map[string]sessionBytes `json:"sessions"`
+}
+
+type OpenVPNPlugin struct {
+	StatusFile string
+	Management string
+	PerClient  bool
+	Clients    []string
+	StateFile  string
+}
+
+// the versions of the status format (--status-version)
+const (
+	statusV1 = 1 // "OpenVPN CLIENT LIST", the default of the status file and the management interface
+	statusV2 = 2 // "TITLE,...", comma separated
+	statusV3 = 3 // "TITLE\t...", tab separated
+)
+
+// detectVersion returns the version of the status from the first line of it
+func detectVersion(line string) (int, error) {
+	switch {
+	case line == "OpenVPN CLIENT LIST":
+		return statusV1, nil
+	case strings.HasPrefix(line, "TITLE,"):
+		return statusV2, nil
+	case strings.HasPrefix(line, "TITLE\t"):
+		return statusV3, nil
+	case line == "OpenVPN STATISTICS":
+		return 0, errors.New("the status of an OpenVPN client is not supported")
+	}
+	return 0, errors.New("unknown format of the status: " + line)
+}
+
+// % cat /var/log/openvpn/openvpn-status.log (--status-version 1)
+// OpenVPN CLIENT LIST
+// Updated,Thu Jun 18 08:12:15 2015
+// Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since
+// alice,203.0.113.10:50123,123456,654321,Thu Jun 18 04:23:03 2015
+// ROUTING TABLE
+// ...
+// END
+//
+// % cat /var/log/openvpn/openvpn-status.log (--status-version 2, or 3 separated by tabs)
+// TITLE,OpenVPN 2.4.7 x86_64-pc-linux-gnu ...
+// TIME,Thu Jun 18 08:12:15 2015,1434615135
+// HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Virtual IPv6 Address,Bytes Received,Bytes Sent,...
+// CLIENT_LIST,alice,203.0.113.10:50123,10.8.0.6,,123456,654321,Thu Jun 18 04:23:03 2015,1434601383,UNDEF,0,0
+// ...
+// END
+//
+// The columns are located by the header, which differs among the versions of OpenVPN.
+// The lines of the management interface (">INFO:...") are skipped.
+func parseStatus(r io.Reader) ([]client, error) {
+	var version int
+	var sep string
+	var columns map[string]int
+	var inClientList bool
+	var clients []client
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		if line == "" || strings.HasPrefix(line, ">") {
+			continue
+		}
+		if strings.HasPrefix(line, "ERROR:") {
+			return nil, errors.New(line)
+		}
+		if line == "END" {
+			break
+		}
+
+		if version == 0 {
+			var err error
+			version, err = detectVersion(line)
+			if err != nil {
+				return nil, err
+			}
+			sep = ","
+			if version == statusV3 {
+				sep = "\t"
+			}
+			inClientList = version == statusV1
+			continue
+		}
+
+		fields := strings.Split(line, sep)
+		var values []string
+		if version == statusV1 {
+			if !inClientList {
+				continue
+			}
+			if line == "ROUTING TABLE" {
+				inClientList = false
+				continue
+			}
+			if fields[0] == "Common Name" {
+				columns = columnIndex(fields)
+				continue
+			}
+			if columns == nil {
+				// e.g. "Updated,..."
+				continue
+			}
+			values = fields
+		} else {
+			if len(fields) > 2 && fields[0] == "HEADER" && fields[1] == "CLIENT_LIST" {
+				columns = columnIndex(fields[2:])
+				continue
+			}
+			if fields[0] != "CLIENT_LIST" || columns == nil {
+				continue
+			}
+			values = fields[1:]
+		}
+
+		c, err := parseClient(values, columns)
+		if err != nil {
+			return nil, err
+		}
+		clients = append(clients, c)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if version == 0 {
+		return nil, errors.New("empty status")
+	}
+	if columns == nil {
+		return nil, errors.New("client list not found in the status")
+	}
+	return clients, nil
+}
+
+func columnIndex(header []string) map[string]int {
+	columns := make(map[string]int)
+	for i, h := range header {
+		columns[h] = i
+	}
+	return columns
+}
+
+func parseClient(values []string, columns map[string]int) (client, error) {
+	value := func(name string) (string, error) {
+		i, ok := columns[name]
+		if !ok || i >= len(values) {
+			return "", errors.New("no column in the client list: " + name)
+		}
+		return values[i], nil
+	}
+
+	var c client
+	var err error
+	if c.name, err = value("Common Name"); err != nil {
+		return c, err
+	}
+	if c.connectedSince, err = value("Connected Since"); err != nil {
+		return c, err
+	}
+	for name, v := range map[string]*float64{"Bytes Received": &c.bytesReceived, "Bytes Sent": &c.bytesSent} {
+		s, err := value(name)
+		if err != nil {
+			return c, err
+		}
+		if *v, err = strconv.ParseFloat(s, 64); err != nil {
+			return c, errors.New(fmt.Sprintf("invalid %s of %s: %s", name, c.name, s))
+		}
+	}
+	return c, nil
+}
+
+// clientStat returns the metrics of the clients, and the connections to be compared at the next run.
+// the bytes are the ones per minute since the last run, summed up from the differences of each connection,
+// not to lose the bytes of the others when a client disconnects. all the bytes of a new connection are counted.
+// the connections of the same common name are summed up
+func clientStat(clients []client, last sessionState, now int64, perClient bool) (map[string]float64, sessionState) {
+	stat := make(map[string]float64)
+	stat["clients"] = float64(len(clients))
+
+	cur := sessionState{Time: now, Sessions: make(map[string]sessionBytes, len(clients))}
+	for _, c := range clients {
+		cur.Sessions[c.sessionKey()] = sessionBytes{Received: c.bytesReceived, Sent: c.bytesSent}
+	}
+	if last.Time <= 0 || now <= last.Time {
+		// the first run
+		return stat, cur
+	}
+	minutes := float64(now-last.Time) / 60
+
+	stat["bytes_received"] = 0
+	stat["bytes_sent"] = 0
+	for _, c := range clients {
+		received, sent := c.bytesReceived, c.bytesSent
+		if prev, ok := last.Sessions[c.sessionKey()]; ok && received >= prev.Received && sent >= prev.Sent {
+			received -= prev.Received
+			sent -= prev.Sent
+		}
+		stat["bytes_received"] += received / minutes
+		stat["bytes_sent"] += sent / minutes
+
+		name := metricName(c.name)
+		if !perClient || name == "" {
+			continue
+		}
+		stat["bytes_received_"+name] += received / minutes
+		stat["bytes_sent_"+name] += sent / minutes
+	}
+	return stat, cur
+}
+
+func (p OpenVPNPlugin) fetchClients() ([]client, error) {
+	if p.Management == "" {
+		f, err := os.Open(p.StatusFile)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		return parseStatus(f)
+	}
+
+	network := "tcp"
+	if strings.HasPrefix(p.Management, "/") {
+		network = "unix"
+	}
+
+	conn, err := net.DialTimeout(network, p.Management, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	if _, err := conn.Write([]byte("status 2\n")); err != nil {
+		return nil, err
+	}
+	return parseStatus(conn)
+}
+
+// Prepare lists the clients for the graphs with -per-client
+func (p *OpenVPNPlugin) Prepare() error {
+	if !p.PerClient {
+		return nil
+	}
+
+	clients, err := p.fetchClients()
+	if err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range clients {
+		name := metricName(c.name)
+		if name == "" || seen[name] {
+			continue
+		}
+		seen[name] = true
+		p.Clients = append(p.Clients, name)
+	}
+	sort.Strings(p.Clients)
+	return nil
+}
+
+func (p OpenVPNPlugin) FetchMetrics() (map[string]float64, error) {
+	clients, err := p.fetchClients()
+	if err != nil {
+		return nil, err
+	}
+
+	var last sessionState
+	if err := common.LoadState(p.StateFile, &last); err != nil {
+		// the first run
+		last = sessionState{}
+	}
+	stat, cur := clientStat(clients, last, time.Now().Unix(), p.PerClient)
+	if err := common.SaveState(p.StateFile, cur); err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+func (p OpenVPNPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := common.DimensionGraphs("openvpn", clientMetrics, p.Clients, false)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optStatusFile := flag.String("status-file", "/var/log/openvpn/openvpn-status.log", "Path of the status file (--status)")
+	optManagement := flag.String("management", "", "Management interface socket path or host:port, read instead of the status file")
+	optPerClient := flag.Bool("per-client", false, "Report the bytes of each client")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var openvpn OpenVPNPlugin
+	openvpn.StatusFile = *optStatusFile
+	openvpn.Management = *optManagement
+	openvpn.PerClient = *optPerClient
+
+	if err := openvpn.Prepare(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	tempfile := common.Tempfile("mackerel-plugin-openvpn", "status-file", "management")
+	if *optTempfile != "" {
+		tempfile = *optTempfile
+	}
+	// the bytes of the connections are kept beside the values of the last run
+	openvpn.StateFile = tempfile + ".sessions"
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(openvpn))
+	helper.Tempfile = tempfile
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
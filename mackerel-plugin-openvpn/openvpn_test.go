@@ -0,0 +1,123 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStatusV1(t *testing.T) {
+	stub := `OpenVPN CLIENT LIST
+Updated,Thu Jun 18 08:12:15 2015
+Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since
+alice,203.0.113.10:50123,123456,654321,Thu Jun 18 04:23:03 2015
+bob,198.51.100.20:40000,1000,2000,Thu Jun 18 07:00:00 2015
+ROUTING TABLE
+Virtual Address,Common Name,Real Address,Last Ref
+10.8.0.6,alice,203.0.113.10:50123,Thu Jun 18 08:12:09 2015
+GLOBAL STATS
+Max bcast/mcast queue length,0
+END
+`
+	clients, err := parseStatus(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(clients), 2)
+	assert.Equal(t, clients[0].name, "alice")
+	assert.Equal(t, clients[0].bytesReceived, 123456.0)
+	assert.Equal(t, clients[0].bytesSent, 654321.0)
+	assert.Equal(t, clients[1].name, "bob")
+	assert.Equal(t, clients[1].connectedSince, "Thu Jun 18 07:00:00 2015")
+}
+
+func TestParseStatusV2(t *testing.T) {
+	// from the management interface
+	stub := `>INFO:OpenVPN Management Interface Version 1 -- type 'help' for more info
+TITLE,OpenVPN 2.4.7 x86_64-pc-linux-gnu [SSL (OpenSSL)] [LZO] [LZ4] [EPOLL] [PKCS11] [MH/PKTINFO] [AEAD]
+TIME,Thu Jun 18 08:12:15 2015,1434615135
+HEADER,CLIENT_LIST,Common Name,Real Address,Virtual Address,Virtual IPv6 Address,Bytes Received,Bytes Sent,Connected Since,Connected Since (time_t),Username,Client ID,Peer ID
+CLIENT_LIST,alice,203.0.113.10:50123,10.8.0.6,,123456,654321,Thu Jun 18 04:23:03 2015,1434601383,UNDEF,0,0
+HEADER,ROUTING_TABLE,Virtual Address,Common Name,Real Address,Last Ref,Last Ref (time_t)
+ROUTING_TABLE,10.8.0.6,alice,203.0.113.10:50123,Thu Jun 18 08:12:09 2015,1434615129
+GLOBAL_STATS,Max bcast/mcast queue length,0
+END
+`
+	clients, err := parseStatus(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(clients), 1)
+	assert.Equal(t, clients[0].name, "alice")
+	assert.Equal(t, clients[0].bytesReceived, 123456.0)
+	assert.Equal(t, clients[0].bytesSent, 654321.0)
+}
+
+func TestParseStatusV3(t *testing.T) {
+	stub := "TITLE\tOpenVPN 2.3.10 x86_64-pc-linux-gnu\n" +
+		"TIME\tThu Jun 18 08:12:15 2015\t1434615135\n" +
+		"HEADER\tCLIENT_LIST\tCommon Name\tReal Address\tVirtual Address\tBytes Received\tBytes Sent\tConnected Since\tConnected Since (time_t)\tUsername\n" +
+		"CLIENT_LIST\tcarol\t192.0.2.30:1194\t10.8.0.10\t500\t700\tThu Jun 18 06:00:00 2015\t1434607200\tUNDEF\n" +
+		"END\n"
+	clients, err := parseStatus(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(clients), 1)
+	assert.Equal(t, clients[0].name, "carol")
+	assert.Equal(t, clients[0].bytesReceived, 500.0)
+	assert.Equal(t, clients[0].bytesSent, 700.0)
+}
+
+func TestParseStatusNoClients(t *testing.T) {
+	stub := `OpenVPN CLIENT LIST
+Updated,Thu Jun 18 08:12:15 2015
+Common Name,Real Address,Bytes Received,Bytes Sent,Connected Since
+ROUTING TABLE
+Virtual Address,Common Name,Real Address,Last Ref
+GLOBAL STATS
+Max bcast/mcast queue length,0
+END
+`
+	clients, err := parseStatus(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(clients), 0)
+	stat, _ := clientStat(clients, sessionState{}, 1434615135, false)
+	assert.Equal(t, stat["clients"], 0.0)
+}
+
+func TestParseStatusUnknown(t *testing.T) {
+	_, err := parseStatus(strings.NewReader("OpenVPN STATISTICS\nUpdated,Thu Jun 18 08:12:15 2015\nEND\n"))
+	assert.NotNil(t, err)
+
+	_, err = parseStatus(strings.NewReader(""))
+	assert.NotNil(t, err)
+}
+
+func TestClientStat(t *testing.T) {
+	clients := []client{
+		client{name: "alice", connectedSince: "Thu Jun 18 04:23:03 2015", bytesReceived: 100, bytesSent: 200},
+		client{name: "alice", connectedSince: "Thu Jun 18 07:00:00 2015", bytesReceived: 10, bytesSent: 20},
+		client{name: "bob@example.com", connectedSince: "Thu Jun 18 05:00:00 2015", bytesReceived: 1, bytesSent: 2},
+	}
+
+	// the first run
+	stat, last := clientStat(clients, sessionState{}, 1434615000, false)
+	assert.Equal(t, stat["clients"], 3.0)
+	_, ok := stat["bytes_received"]
+	assert.False(t, ok)
+	assert.Equal(t, len(last.Sessions), 3)
+
+	// bob disconnected and reconnected, and carol connected in 2 minutes
+	clients = []client{
+		client{name: "alice", connectedSince: "Thu Jun 18 04:23:03 2015", bytesReceived: 160, bytesSent: 400},
+		client{name: "alice", connectedSince: "Thu Jun 18 07:00:00 2015", bytesReceived: 30, bytesSent: 20},
+		client{name: "bob@example.com", connectedSince: "Thu Jun 18 08:11:00 2015", bytesReceived: 4, bytesSent: 8},
+		client{name: "carol", connectedSince: "Thu Jun 18 08:11:30 2015", bytesReceived: 6, bytesSent: 12},
+	}
+	stat, _ = clientStat(clients, last, 1434615120, false)
+	assert.Equal(t, stat["clients"], 4.0)
+	assert.Equal(t, stat["bytes_received"], (60.0+20+4+6)/2)
+	assert.Equal(t, stat["bytes_sent"], (200.0+0+8+12)/2)
+	_, ok = stat["bytes_received_alice"]
+	assert.False(t, ok)
+
+	stat, _ = clientStat(clients, last, 1434615120, true)
+	assert.Equal(t, stat["bytes_received_alice"], 40.0)
+	assert.Equal(t, stat["bytes_sent_bob_example_com"], 4.0)
+}
@@ -0,0 +1,291 @@
+package main
+
+import (
+	"database/sql"
+	"flag"
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+	"github.com/mackerelio/mackerel-agent/logging"
+)
+
+var logger = logging.GetLogger("metrics.plugin.pgbouncer")
+
+// the admin console database of pgbouncer, which is not a real pool
+const adminDatabase = "pgbouncer"
+
+type PgBouncerPool struct {
+	Database string
+	User     string
+}
+
+type PgBouncerPlugin struct {
+	Host      string
+	Port      string
+	Username  string
+	Password  string
+	SSLmode   string
+	Timeout   int
+	Pools     []PgBouncerPool
+	Databases []string
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+func (pool PgBouncerPool) metricPrefix() string {
+	return metricName(pool.Database) + "_" + metricName(pool.User)
+}
+
+// columns of SHOW POOLS mapped to metric names
+var poolColumns map[string]string = map[string]string{
+	"cl_active":  "cl_active",
+	"cl_waiting": "cl_waiting",
+	"sv_active":  "sv_active",
+	"sv_idle":    "sv_idle",
+	"sv_used":    "sv_used",
+	"maxwait":    "maxwait",
+}
+
+// columns of SHOW STATS mapped to metric names.
+// pgbouncer before 1.8 reports total_requests, which is the number of queries.
+var statsColumns map[string]string = map[string]string{
+	"total_requests":    "queries",
+	"total_query_count": "queries",
+	"total_xact_count":  "transactions",
+}
+
+func (p PgBouncerPlugin) open() (*sql.DB, error) {
+	dsn := fmt.Sprintf("user=%s host=%s port=%s dbname=%s sslmode=%s connect_timeout=%d", p.Username, p.Host, p.Port, adminDatabase, p.SSLmode, p.Timeout)
+	if p.Password != "" {
+		dsn += " password=" + p.Password
+	}
+	return sql.Open("postgres", dsn)
+}
+
+// query runs a SHOW command and returns its rows as maps of column names to values,
+// since the columns differ among pgbouncer versions.
+func query(db *sql.DB, q string) ([]map[string]string, error) {
+	rows, err := db.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	columns, err := rows.Columns()
+	if err != nil {
+		return nil, err
+	}
+
+	var result []map[string]string
+	for rows.Next() {
+		values := make([]sql.NullString, len(columns))
+		dest := make([]interface{}, len(columns))
+		for i := range values {
+			dest[i] = &values[i]
+		}
+		if err := rows.Scan(dest...); err != nil {
+			logger.Warningf("Failed to scan. %s", err)
+			continue
+		}
+
+		row := make(map[string]string, len(columns))
+		for i, c := range columns {
+			row[c] = values[i].String
+		}
+		result = append(result, row)
+	}
+
+	return result, rows.Err()
+}
+
+func parsePools(rows []map[string]string, stat map[string]float64) []PgBouncerPool {
+	var pools []PgBouncerPool
+	for _, row := range rows {
+		pool := PgBouncerPool{Database: row["database"], User: row["user"]}
+		if pool.Database == adminDatabase {
+			continue
+		}
+		pools = append(pools, pool)
+
+		prefix := pool.metricPrefix()
+		for col, name := range poolColumns {
+			v, err := strconv.ParseFloat(row[col], 64)
+			if err != nil {
+				continue
+			}
+			stat[prefix+"_"+name] = v
+		}
+		// pgbouncer 1.8 or later reports the fraction of maxwait in maxwait_us
+		if us, err := strconv.ParseFloat(row["maxwait_us"], 64); err == nil {
+			stat[prefix+"_maxwait"] += us / 1000000
+		}
+	}
+	return pools
+}
+
+func parseStats(rows []map[string]string, stat map[string]float64) []string {
+	var databases []string
+	for _, row := range rows {
+		database := row["database"]
+		if database == adminDatabase {
+			continue
+		}
+		databases = append(databases, database)
+
+		prefix := metricName(database)
+		for col, name := range statsColumns {
+			v, err := strconv.ParseFloat(row[col], 64)
+			if err != nil {
+				continue
+			}
+			stat[prefix+"_"+name] = v
+		}
+	}
+	return databases
+}
+
+func (p PgBouncerPlugin) fetch(stat map[string]float64) ([]PgBouncerPool, []string, error) {
+	db, err := p.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	rows, err := query(db, "SHOW POOLS")
+	if err != nil {
+		logger.Errorf("Failed to show pools. %s", err)
+		return nil, nil, err
+	}
+	pools := parsePools(rows, stat)
+
+	rows, err = query(db, "SHOW STATS")
+	if err != nil {
+		logger.Errorf("Failed to show stats. %s", err)
+		return nil, nil, err
+	}
+	databases := parseStats(rows, stat)
+
+	return pools, databases, nil
+}
+
+func (p *PgBouncerPlugin) Prepare() error {
+	var err error
+	p.Pools, p.Databases, err = p.fetch(make(map[string]float64))
+	return err
+}
+
+func (p PgBouncerPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+	if _, _, err := p.fetch(stat); err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+func (p PgBouncerPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphdef := make(map[string](mp.Graphs))
+
+	for _, pool := range p.Pools {
+		prefix := pool.metricPrefix()
+		label := "PgBouncer Pool " + pool.Database + "/" + pool.User
+
+		graphdef["pgbouncer.pool."+prefix+".clients"] = mp.Graphs{
+			Label: label + " Clients",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_cl_active", Label: "Active", Stacked: true},
+				mp.Metrics{Name: prefix + "_cl_waiting", Label: "Waiting", Stacked: true},
+			},
+		}
+		graphdef["pgbouncer.pool."+prefix+".servers"] = mp.Graphs{
+			Label: label + " Servers",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_sv_active", Label: "Active", Stacked: true},
+				mp.Metrics{Name: prefix + "_sv_idle", Label: "Idle", Stacked: true},
+				mp.Metrics{Name: prefix + "_sv_used", Label: "Used", Stacked: true},
+			},
+		}
+		graphdef["pgbouncer.pool."+prefix+".maxwait"] = mp.Graphs{
+			Label: label + " Max Wait (sec)",
+			Unit:  "float",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_maxwait", Label: "Max Wait"},
+			},
+		}
+	}
+
+	for _, database := range p.Databases {
+		prefix := metricName(database)
+
+		graphdef["pgbouncer.stats."+prefix+".requests"] = mp.Graphs{
+			Label: "PgBouncer Database " + database + " Requests",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_queries", Label: "Queries", Diff: true},
+				mp.Metrics{Name: prefix + "_transactions", Label: "Transactions", Diff: true},
+			},
+		}
+	}
+
+	return graphdef
+}
+
+func main() {
+	optHost := flag.String("hostname", "localhost", "Hostname to login to")
+	optPort := flag.String("port", "6432", "pgbouncer port")
+	optUser := flag.String("user", "", "pgbouncer admin (or stats) user")
+	optPass := flag.String("password", "", "pgbouncer password")
+	optSSLmode := flag.String("sslmode", "disable", "Whether or not to use SSL")
+	optConnectTimeout := flag.Int("connect_timeout", 5, "Maximum wait for connection, in seconds.")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	if *optUser == "" {
+		logger.Warningf("user is required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var pgbouncer PgBouncerPlugin
+	pgbouncer.Host = *optHost
+	pgbouncer.Port = *optPort
+	pgbouncer.Username = *optUser
+	pgbouncer.Password = *optPass
+	pgbouncer.SSLmode = *optSSLmode
+	pgbouncer.Timeout = *optConnectTimeout
+
+	err := pgbouncer.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(pgbouncer))
+
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-pgbouncer-%s-%s", *optHost, *optPort)
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
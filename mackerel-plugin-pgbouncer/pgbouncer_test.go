@@ -0,0 +1,41 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePools(t *testing.T) {
+	rows := []map[string]string{
+		map[string]string{"database": "pgbouncer", "user": "pgbouncer", "cl_active": "1", "cl_waiting": "0", "sv_active": "0", "sv_idle": "0", "sv_used": "0", "maxwait": "0", "pool_mode": "statement"},
+		map[string]string{"database": "app", "user": "web", "cl_active": "10", "cl_waiting": "3", "sv_active": "8", "sv_idle": "2", "sv_used": "1", "maxwait": "2", "maxwait_us": "500000", "pool_mode": "transaction"},
+	}
+
+	stat := make(map[string]float64)
+	pools := parsePools(rows, stat)
+	assert.Equal(t, pools, []PgBouncerPool{PgBouncerPool{Database: "app", User: "web"}})
+	assert.Equal(t, stat["app_web_cl_active"], 10.0)
+	assert.Equal(t, stat["app_web_cl_waiting"], 3.0)
+	assert.Equal(t, stat["app_web_sv_active"], 8.0)
+	assert.Equal(t, stat["app_web_sv_idle"], 2.0)
+	assert.Equal(t, stat["app_web_maxwait"], 2.5)
+	_, ok := stat["pgbouncer_pgbouncer_cl_active"]
+	assert.False(t, ok)
+}
+
+func TestParseStats(t *testing.T) {
+	rows := []map[string]string{
+		// pgbouncer 1.7
+		map[string]string{"database": "app", "total_requests": "1000", "total_received": "2048"},
+		// pgbouncer 1.8 or later
+		map[string]string{"database": "batch", "total_xact_count": "30", "total_query_count": "120"},
+	}
+
+	stat := make(map[string]float64)
+	databases := parseStats(rows, stat)
+	assert.Equal(t, databases, []string{"app", "batch"})
+	assert.Equal(t, stat["app_queries"], 1000.0)
+	assert.Equal(t, stat["batch_queries"], 120.0)
+	assert.Equal(t, stat["batch_transactions"], 30.0)
+}
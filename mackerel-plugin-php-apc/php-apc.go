@@ -11,6 +11,7 @@ import (
 
 	"github.com/codegangsta/cli"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 )
 
 // metric value structure
@@ -52,6 +53,7 @@ var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
 
 // for fetching metrics
 type PhpApcPlugin struct {
+	Scheme   string
 	Host     string
 	Port     uint16
 	Path     string
@@ -66,25 +68,39 @@ func (c PhpApcPlugin) GraphDefinition() map[string](mp.Graphs) {
 // main function
 func doMain(c *cli.Context) {
 
+	(&common.HTTPOptions{
+		Insecure: c.Bool("insecure"),
+		CACert:   c.String("ca_cert"),
+	}).Setup()
+
 	var phpapc PhpApcPlugin
 
+	phpapc.Scheme = c.String("http_scheme")
 	phpapc.Host = c.String("http_host")
 	phpapc.Port = uint16(c.Int("http_port"))
 	phpapc.Path = c.String("status_page")
 
-	helper := mp.NewMackerelPlugin(phpapc)
+	helper := mp.NewMackerelPlugin((&common.SelfMetrics{Enabled: c.Bool("self_metrics")}).Wrap(phpapc))
 	helper.Tempfile = c.String("tempfile")
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, &common.Statsd{
+			Addr:   c.String("statsd"),
+			Prefix: c.String("statsd_prefix"),
+			Only:   c.Bool("statsd_only"),
+		}, &common.PostProcess{
+			Command: c.String("post_process"),
+			Timeout: c.Int("post_process_timeout"),
+		})
 	}
 }
 
 // fetch metrics
 func (c PhpApcPlugin) FetchMetrics() (map[string]float64, error) {
-	data, err := getPhpApcMetrics(c.Host, c.Port, c.Path)
+	data, err := getPhpApcMetrics(c.Scheme, c.Host, c.Port, c.Path)
 	if err != nil {
 		return nil, err
 	}
@@ -120,8 +136,8 @@ func parsePhpApcStatus(str string, p *map[string]float64) error {
 }
 
 // Getting php-apc status from server-status module data.
-func getPhpApcMetrics(host string, port uint16, path string) (string, error) {
-	uri := "http://" + host + ":" + strconv.FormatUint(uint64(port), 10) + path
+func getPhpApcMetrics(scheme string, host string, port uint16, path string) (string, error) {
+	uri := scheme + "://" + host + ":" + strconv.FormatUint(uint64(port), 10) + path
 	resp, err := http.Get(uri)
 	if err != nil {
 		return "", err
@@ -74,7 +74,7 @@ user_cache_full_count:0`
 	port, _ := strconv.Atoi(found[3])
 	path := found[4]
 
-	ret, err := getPhpApcMetrics(host, uint16(port), path)
+	ret, err := getPhpApcMetrics(found[1], host, uint16(port), path)
 	assert.Nil(t, err)
 	assert.NotNil(t, ret)
 	assert.NotEmpty(t, ret)
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+)
+
+// a minimal FastCGI client (responder role), which is enough to run a PHP script by php-fpm
+// http://www.mit.edu/~yandros/doc/specs/fcgi-spec.html
+
+const (
+	fcgiVersion      = 1
+	fcgiBeginRequest = 1
+	fcgiEndRequest   = 3
+	fcgiParams       = 4
+	fcgiStdin        = 5
+	fcgiStdout       = 6
+	fcgiStderr       = 7
+
+	fcgiResponder = 1
+	fcgiRequestId = 1
+)
+
+type fcgiHeader struct {
+	Version       uint8
+	Type          uint8
+	RequestId     uint16
+	ContentLength uint16
+	PaddingLength uint8
+	Reserved      uint8
+}
+
+func writeRecord(w io.Writer, recType uint8, content []byte) error {
+	h := fcgiHeader{
+		Version:       fcgiVersion,
+		Type:          recType,
+		RequestId:     fcgiRequestId,
+		ContentLength: uint16(len(content)),
+	}
+	if err := binary.Write(w, binary.BigEndian, h); err != nil {
+		return err
+	}
+	_, err := w.Write(content)
+	return err
+}
+
+// encodeLength encodes the length of a name or a value of params in 1 byte (< 128) or 4 bytes
+func encodeLength(b *bytes.Buffer, n int) {
+	if n < 128 {
+		b.WriteByte(byte(n))
+		return
+	}
+	binary.Write(b, binary.BigEndian, uint32(n)|1<<31)
+}
+
+func encodeParams(params map[string]string) []byte {
+	var b bytes.Buffer
+	for k, v := range params {
+		encodeLength(&b, len(k))
+		encodeLength(&b, len(v))
+		b.WriteString(k)
+		b.WriteString(v)
+	}
+	return b.Bytes()
+}
+
+// fcgiRequest sends a request without stdin, and returns the body of the response without CGI headers
+func fcgiRequest(rw io.ReadWriter, params map[string]string) ([]byte, error) {
+	begin := []byte{0, fcgiResponder, 0, 0, 0, 0, 0, 0}
+	if err := writeRecord(rw, fcgiBeginRequest, begin); err != nil {
+		return nil, err
+	}
+	// the params are small enough to be sent in a record
+	if err := writeRecord(rw, fcgiParams, encodeParams(params)); err != nil {
+		return nil, err
+	}
+	if err := writeRecord(rw, fcgiParams, nil); err != nil {
+		return nil, err
+	}
+	if err := writeRecord(rw, fcgiStdin, nil); err != nil {
+		return nil, err
+	}
+
+	r := bufio.NewReader(rw)
+	var stdout, stderr bytes.Buffer
+	for {
+		var h fcgiHeader
+		if err := binary.Read(r, binary.BigEndian, &h); err != nil {
+			return nil, err
+		}
+		content := make([]byte, int(h.ContentLength)+int(h.PaddingLength))
+		if _, err := io.ReadFull(r, content); err != nil {
+			return nil, err
+		}
+		content = content[:h.ContentLength]
+
+		switch h.Type {
+		case fcgiStdout:
+			stdout.Write(content)
+		case fcgiStderr:
+			stderr.Write(content)
+		case fcgiEndRequest:
+			return parseCGIResponse(stdout.Bytes(), stderr.String())
+		}
+	}
+}
+
+func parseCGIResponse(out []byte, stderr string) ([]byte, error) {
+	i := bytes.Index(out, []byte("\r\n\r\n"))
+	if i < 0 {
+		if stderr != "" {
+			return nil, errors.New(strings.TrimSpace(stderr))
+		}
+		return nil, errors.New("invalid FastCGI response")
+	}
+
+	header, body := string(out[:i]), out[i+4:]
+	for _, line := range strings.Split(header, "\r\n") {
+		// php-fpm responds e.g. "Status: 404 Not Found" when the script is not found
+		if strings.HasPrefix(line, "Status:") && !strings.HasPrefix(strings.TrimSpace(line[7:]), "200") {
+			return nil, errors.New("FastCGI status error: " + strings.TrimSpace(line[7:]))
+		}
+	}
+	return body, nil
+}
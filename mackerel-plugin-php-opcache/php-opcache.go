@@ -0,0 +1,195 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"php-opcache.memory": mp.Graphs{
+		Label: "PHP OPcache Memory",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "used_memory", Label: "Used", Stacked: true},
+			mp.Metrics{Name: "wasted_memory", Label: "Wasted", Stacked: true},
+			mp.Metrics{Name: "free_memory", Label: "Free", Stacked: true},
+		},
+	},
+	"php-opcache.hit_rate": mp.Graphs{
+		Label: "PHP OPcache Hit Rate",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "hit_rate", Label: "Hit Rate"},
+		},
+	},
+	"php-opcache.scripts": mp.Graphs{
+		Label: "PHP OPcache Cached Scripts",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cached_scripts", Label: "Cached Scripts"},
+			mp.Metrics{Name: "max_cached_keys", Label: "Max Cached Keys"},
+		},
+	},
+	"php-opcache.restarts": mp.Graphs{
+		Label: "PHP OPcache Restarts",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "oom_restarts", Label: "Out of Memory", Diff: true},
+			mp.Metrics{Name: "hash_restarts", Label: "Hash Table Full", Diff: true},
+			mp.Metrics{Name: "manual_restarts", Label: "Manual", Diff: true},
+		},
+	},
+}
+
+type PhpOpcachePlugin struct {
+	Url      string
+	Fcgi     string
+	Script   string
+	Tempfile string
+}
+
+// % curl http://localhost/mackerel/php-opcache.php
+// used_memory:12345678
+// free_memory:...
+func parseStatus(r io.Reader, stat map[string]float64) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		kv := strings.SplitN(scanner.Text(), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v, err := strconv.ParseFloat(strings.TrimSpace(kv[1]), 64)
+		if err != nil {
+			continue
+		}
+		stat[strings.TrimSpace(kv[0])] = v
+	}
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if _, ok := stat["used_memory"]; !ok {
+		return errors.New("cannot get values. is OPcache enabled?")
+	}
+	return nil
+}
+
+// hitRate returns the hit rate since the last run.
+// the one opcache reports is since the start, which hides recent thrashing
+func hitRate(stat, last map[string]float64) (float64, bool) {
+	return common.HitRatioSince(stat, last, "hits", "misses")
+}
+
+func (p PhpOpcachePlugin) fetchHTTP() ([]byte, error) {
+	resp, err := http.Get(p.Url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("HTTP status error: %d", resp.StatusCode))
+	}
+
+	var b bytes.Buffer
+	_, err = b.ReadFrom(resp.Body)
+	return b.Bytes(), err
+}
+
+// fetchFastCGI runs the script by php-fpm directly, which shares OPcache with the pool
+func (p PhpOpcachePlugin) fetchFastCGI() ([]byte, error) {
+	network := "tcp"
+	if strings.HasPrefix(p.Fcgi, "/") {
+		network = "unix"
+	}
+	conn, err := net.DialTimeout(network, p.Fcgi, 10*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(10 * time.Second))
+
+	return fcgiRequest(conn, map[string]string{
+		"GATEWAY_INTERFACE": "CGI/1.1",
+		"SERVER_PROTOCOL":   "HTTP/1.1",
+		"REQUEST_METHOD":    "GET",
+		"SCRIPT_FILENAME":   p.Script,
+		"SCRIPT_NAME":       "/" + path.Base(p.Script),
+		"QUERY_STRING":      "",
+	})
+}
+
+func (p PhpOpcachePlugin) FetchMetrics() (map[string]float64, error) {
+	var body []byte
+	var err error
+	if p.Fcgi != "" {
+		body, err = p.fetchFastCGI()
+	} else {
+		body, err = p.fetchHTTP()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	if err := parseStatus(bytes.NewReader(body), stat); err != nil {
+		return nil, err
+	}
+
+	if v, ok := hitRate(stat, common.LastValues(p.Tempfile)); ok {
+		stat["hit_rate"] = v
+	}
+
+	return stat, nil
+}
+
+func (p PhpOpcachePlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optUrl := flag.String("url", "http://localhost/mackerel/php-opcache.php", "URL of php-opcache.php")
+	optFcgi := flag.String("fcgi", "", "Address (host:port or unix socket path) of php-fpm, used instead of -url")
+	optScript := flag.String("script", "/var/www/mackerel/php-opcache.php", "Path of php-opcache.php on the php-fpm host, with -fcgi")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var opcache PhpOpcachePlugin
+	opcache.Url = *optUrl
+	opcache.Fcgi = *optFcgi
+	opcache.Script = *optScript
+
+	if *optTempfile != "" {
+		opcache.Tempfile = *optTempfile
+	} else {
+		opcache.Tempfile = common.Tempfile("mackerel-plugin-php-opcache", "url", "fcgi", "script")
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(opcache))
+	helper.Tempfile = opcache.Tempfile
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
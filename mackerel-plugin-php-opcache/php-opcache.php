@@ -0,0 +1,25 @@
+<?php
+
+header("Content-Type: text/plain");
+
+$status = opcache_get_status(false);
+
+$mem   = $status['memory_usage'];
+$stats = $status['opcache_statistics'];
+
+$values = array(
+    "used_memory"          => (int)$mem['used_memory'],
+    "free_memory"          => (int)$mem['free_memory'],
+    "wasted_memory"        => (int)$mem['wasted_memory'],
+    "cached_scripts"       => (int)$stats['num_cached_scripts'],
+    "max_cached_keys"      => (int)$stats['max_cached_keys'],
+    "hits"                 => (int)$stats['hits'],
+    "misses"               => (int)$stats['misses'],
+    "oom_restarts"         => (int)$stats['oom_restarts'],
+    "hash_restarts"        => (int)$stats['hash_restarts'],
+    "manual_restarts"      => (int)$stats['manual_restarts'],
+);
+
+foreach( $values as $name => $value ){
+    echo sprintf( "%s:%d\n", $name, $value );
+}
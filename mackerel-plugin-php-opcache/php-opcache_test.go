@@ -0,0 +1,88 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var statusStub = `used_memory:50331648
+free_memory:12582912
+wasted_memory:4194304
+cached_scripts:1500
+max_cached_keys:7963
+hits:98000
+misses:2000
+oom_restarts:3
+hash_restarts:1
+manual_restarts:0
+`
+
+func TestParseStatus(t *testing.T) {
+	stat := make(map[string]float64)
+	err := parseStatus(strings.NewReader(statusStub), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["used_memory"], 50331648.0)
+	assert.Equal(t, stat["wasted_memory"], 4194304.0)
+	assert.Equal(t, stat["max_cached_keys"], 7963.0)
+	assert.Equal(t, stat["oom_restarts"], 3.0)
+
+	err = parseStatus(strings.NewReader("<html>Not Found</html>"), make(map[string]float64))
+	assert.NotNil(t, err)
+}
+
+func TestHitRate(t *testing.T) {
+	stat := map[string]float64{"hits": 1090, "misses": 20}
+
+	_, ok := hitRate(stat, nil)
+	assert.False(t, ok)
+
+	v, ok := hitRate(stat, map[string]float64{"hits": 1000, "misses": 10})
+	assert.True(t, ok)
+	assert.Equal(t, v, 90.0)
+
+	// reset by a restart
+	_, ok = hitRate(stat, map[string]float64{"hits": 5000, "misses": 10})
+	assert.False(t, ok)
+}
+
+type fakeConn struct {
+	io.Reader
+	io.Writer
+}
+
+func TestFcgiRequest(t *testing.T) {
+	var resp bytes.Buffer
+	writeRecord(&resp, fcgiStdout, []byte("X-Powered-By: PHP/7.4\r\nContent-type: text/plain\r\n\r\nused_memory:1\n"))
+	writeRecord(&resp, fcgiStderr, []byte("PHP Notice: something"))
+	writeRecord(&resp, fcgiEndRequest, []byte{0, 0, 0, 0, 0, 0, 0, 0})
+
+	var req bytes.Buffer
+	body, err := fcgiRequest(fakeConn{&resp, &req}, map[string]string{"SCRIPT_FILENAME": "/var/www/php-opcache.php"})
+	assert.Nil(t, err)
+	assert.Equal(t, string(body), "used_memory:1\n")
+
+	// BEGIN_REQUEST, PARAMS, empty PARAMS and empty STDIN
+	sent := req.Bytes()
+	assert.Equal(t, sent[1], byte(fcgiBeginRequest))
+	assert.Equal(t, sent[8+8+1], byte(fcgiParams))
+	assert.True(t, bytes.Contains(sent, []byte("\x0f\x18SCRIPT_FILENAME/var/www/php-opcache.php")))
+}
+
+func TestParseCGIResponse(t *testing.T) {
+	_, err := parseCGIResponse([]byte("Status: 404 Not Found\r\nContent-type: text/html\r\n\r\nFile not found.\n"), "")
+	assert.Equal(t, err.Error(), "FastCGI status error: 404 Not Found")
+
+	_, err = parseCGIResponse(nil, "Primary script unknown\n")
+	assert.Equal(t, err.Error(), "Primary script unknown")
+}
+
+func TestEncodeLength(t *testing.T) {
+	var b bytes.Buffer
+	encodeLength(&b, 127)
+	encodeLength(&b, 300)
+	assert.Equal(t, b.Bytes(), []byte{127, 0x80, 0, 1, 44})
+}
@@ -4,6 +4,7 @@ import (
 	"flag"
 	"fmt"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"errors"
 	"net/http"
 	"os"
@@ -141,25 +142,31 @@ func main() {
 	optPort := flag.String("port", "5000", "Port")
 	optPath := flag.String("path", "/server-status?json", "Path")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
+	httpOpts.Setup()
 
 	var plack PlackPlugin
 	if *optUri != "" {
 		plack.Uri = *optUri
 	} else {
-		plack.Uri = fmt.Sprintf("%s://%s:%s%s", *optScheme, *optHost, *optPort, *optPath)
+		plack.Uri = fmt.Sprintf("%s://%s%s", *optScheme, common.HostPort(*optHost, *optPort), *optPath)
 	}
 
-	helper := mp.NewMackerelPlugin(plack)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(plack))
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
-		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-plack")
+		helper.Tempfile = common.Tempfile("mackerel-plugin-plack", "uri", "host", "port", "path")
 	}
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
@@ -0,0 +1,361 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"math"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	_ "github.com/lib/pq"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+	"github.com/mackerelio/mackerel-agent/logging"
+)
+
+var logger = logging.GetLogger("metrics.plugin.postgres-replication")
+
+// graphs of the standby, which knows what it has received and replayed, but not the position of the primary
+var standbyGraphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"postgres_replication.lag_seconds": mp.Graphs{
+		Label: "PostgreSQL Replication Lag (sec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "lag_seconds", Label: "Since Last Replayed Transaction"},
+		},
+	},
+	"postgres_replication.replay_gap": mp.Graphs{
+		Label: "PostgreSQL Replication Replay Gap",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "replay_gap_bytes", Label: "Received - Replayed"},
+		},
+	},
+}
+
+var primaryGraphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"postgres_replication.replicas": mp.Graphs{
+		Label: "PostgreSQL Replication Replicas",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "replicas", Label: "Connected"},
+		},
+	},
+}
+
+// metrics of each replica connected to the primary
+var replicaMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "lag_bytes_", Unit: "bytes",
+		Graph: "lag_bytes", GraphLabel: "PostgreSQL Replication Lag"},
+	common.DimensionMetric{Prefix: "replay_gap_bytes_", Unit: "bytes",
+		Graph: "replay_gap_per_replica", GraphLabel: "PostgreSQL Replication Replay Gap (Flushed - Replayed)"},
+	common.DimensionMetric{Prefix: "lag_seconds_", Unit: "float",
+		Graph: "lag_seconds_per_replica", GraphLabel: "PostgreSQL Replication Replay Lag (sec)"},
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+type PostgresReplicationPlugin struct {
+	Host     string
+	Port     string
+	Username string
+	Password string
+	Database string
+	SSLmode  string
+	Timeout  int
+	Role     string
+	Replicas []string
+}
+
+// a row of pg_stat_replication on the primary
+type replica struct {
+	Name       string
+	ClientAddr string
+	LagBytes   sql.NullFloat64
+	GapBytes   sql.NullFloat64
+	LagSeconds sql.NullFloat64
+}
+
+// the replication status of the standby
+type standbyStatus struct {
+	InRecovery bool
+	GapBytes   sql.NullFloat64
+	CaughtUp   sql.NullBool
+	LagSeconds sql.NullFloat64
+}
+
+// the functions and the columns were renamed from xlog and location to wal and lsn in PostgreSQL 10
+const walRenamedVersion = 100000
+
+// replicationQuery returns the query of the replicas on the primary.
+// replay_lag is available since PostgreSQL 10
+func replicationQuery(version int) string {
+	if version >= walRenamedVersion {
+		return `SELECT application_name, COALESCE(host(client_addr), ''),
+	pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn),
+	pg_wal_lsn_diff(flush_lsn, replay_lsn),
+	EXTRACT(EPOCH FROM replay_lag)
+FROM pg_stat_replication`
+	}
+	return `SELECT application_name, COALESCE(host(client_addr), ''),
+	pg_xlog_location_diff(pg_current_xlog_location(), replay_location),
+	pg_xlog_location_diff(flush_location, replay_location),
+	NULL
+FROM pg_stat_replication`
+}
+
+// standbyQuery returns the query of the replication status on the standby
+func standbyQuery(version int) string {
+	if version >= walRenamedVersion {
+		return `SELECT pg_is_in_recovery(),
+	pg_wal_lsn_diff(pg_last_wal_receive_lsn(), pg_last_wal_replay_lsn()),
+	pg_last_wal_receive_lsn() = pg_last_wal_replay_lsn(),
+	EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())`
+	}
+	return `SELECT pg_is_in_recovery(),
+	pg_xlog_location_diff(pg_last_xlog_receive_location(), pg_last_xlog_replay_location()),
+	pg_last_xlog_receive_location() = pg_last_xlog_replay_location(),
+	EXTRACT(EPOCH FROM now() - pg_last_xact_replay_timestamp())`
+}
+
+// replicaName returns the name of the replica in the metric names. application_name is
+// the one of primary_conninfo, which is "walreceiver" unless specified, so the address is used then
+func replicaName(r replica) string {
+	name := metricName(r.Name)
+	if name == "" || name == "walreceiver" {
+		name = metricName(r.ClientAddr)
+	}
+	if name == "" {
+		// connected by the unix domain socket
+		name = "local"
+	}
+	return name
+}
+
+// replicaStat returns the metrics of the replicas and their names. The replicas of the same name
+// are reported by the one lagging the most.
+// The lag in seconds is NULL while the replica has caught up and no WAL is written for a while,
+// which is 0 unless it lags in bytes.
+func replicaStat(replicas []replica) (map[string]float64, []string) {
+	stat := map[string]float64{"replicas": float64(len(replicas))}
+	var names []string
+	seen := make(map[string]bool)
+	for _, r := range replicas {
+		name := replicaName(r)
+		if seen[name] {
+			lag, ok := stat["lag_bytes_"+name]
+			if !r.LagBytes.Valid || (ok && r.LagBytes.Float64 <= lag) {
+				continue
+			}
+		} else {
+			seen[name] = true
+			names = append(names, name)
+		}
+
+		delete(stat, "lag_bytes_"+name)
+		delete(stat, "replay_gap_bytes_"+name)
+		delete(stat, "lag_seconds_"+name)
+		if r.LagBytes.Valid {
+			stat["lag_bytes_"+name] = r.LagBytes.Float64
+		}
+		if r.GapBytes.Valid {
+			stat["replay_gap_bytes_"+name] = r.GapBytes.Float64
+		}
+		if r.LagSeconds.Valid {
+			stat["lag_seconds_"+name] = r.LagSeconds.Float64
+		} else if r.LagBytes.Valid && r.LagBytes.Float64 == 0 {
+			stat["lag_seconds_"+name] = 0
+		}
+	}
+	sort.Strings(names)
+	return stat, names
+}
+
+// standbyStat returns the metrics of the standby. The lag in seconds is the time since the last replayed
+// transaction, which grows on an idle primary as well, so it is 0 when all the received WAL has been replayed.
+func standbyStat(s standbyStatus) (map[string]float64, error) {
+	if !s.InRecovery {
+		return nil, errors.New("the server is not a standby (not in recovery)")
+	}
+
+	stat := make(map[string]float64)
+	// NULL without streaming replication (e.g. recovering from the archive)
+	if s.GapBytes.Valid {
+		stat["replay_gap_bytes"] = s.GapBytes.Float64
+	}
+	if s.CaughtUp.Valid && s.CaughtUp.Bool {
+		stat["lag_seconds"] = 0
+	} else if s.LagSeconds.Valid {
+		stat["lag_seconds"] = math.Max(s.LagSeconds.Float64, 0)
+	}
+	return stat, nil
+}
+
+func (p PostgresReplicationPlugin) open() (*sql.DB, error) {
+	dsn := fmt.Sprintf("user=%s host=%s port=%s dbname=%s sslmode=%s connect_timeout=%d", p.Username, p.Host, p.Port, p.Database, p.SSLmode, p.Timeout)
+	if p.Password != "" {
+		dsn += " password=" + p.Password
+	}
+	return sql.Open("postgres", dsn)
+}
+
+func serverVersion(db *sql.DB) (int, error) {
+	var s string
+	if err := db.QueryRow("SHOW server_version_num").Scan(&s); err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(s)
+}
+
+func fetchReplicas(db *sql.DB, version int) ([]replica, error) {
+	rows, err := db.Query(replicationQuery(version))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var replicas []replica
+	for rows.Next() {
+		var r replica
+		if err := rows.Scan(&r.Name, &r.ClientAddr, &r.LagBytes, &r.GapBytes, &r.LagSeconds); err != nil {
+			logger.Warningf("Failed to scan. %s", err)
+			continue
+		}
+		replicas = append(replicas, r)
+	}
+	return replicas, rows.Err()
+}
+
+func fetchStandby(db *sql.DB, version int) (standbyStatus, error) {
+	var s standbyStatus
+	err := db.QueryRow(standbyQuery(version)).Scan(&s.InRecovery, &s.GapBytes, &s.CaughtUp, &s.LagSeconds)
+	return s, err
+}
+
+func (p PostgresReplicationPlugin) fetch() (map[string]float64, []string, error) {
+	db, err := p.open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer db.Close()
+
+	version, err := serverVersion(db)
+	if err != nil {
+		logger.Errorf("Failed to fetch the server version. %s", err)
+		return nil, nil, err
+	}
+
+	if p.Role == "primary" {
+		replicas, err := fetchReplicas(db, version)
+		if err != nil {
+			logger.Errorf("Failed to fetch pg_stat_replication. %s", err)
+			return nil, nil, err
+		}
+		stat, names := replicaStat(replicas)
+		return stat, names, nil
+	}
+
+	s, err := fetchStandby(db, version)
+	if err != nil {
+		logger.Errorf("Failed to fetch the replication status. %s", err)
+		return nil, nil, err
+	}
+	stat, err := standbyStat(s)
+	return stat, nil, err
+}
+
+// Prepare lists the replicas connected to the primary for the graphs
+func (p *PostgresReplicationPlugin) Prepare() error {
+	if p.Role != "primary" {
+		return nil
+	}
+	var err error
+	_, p.Replicas, err = p.fetch()
+	return err
+}
+
+func (p PostgresReplicationPlugin) FetchMetrics() (map[string]float64, error) {
+	stat, _, err := p.fetch()
+	if err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+func (p PostgresReplicationPlugin) GraphDefinition() map[string](mp.Graphs) {
+	if p.Role != "primary" {
+		return standbyGraphdef
+	}
+
+	graphs := common.DimensionGraphs("postgres_replication", replicaMetrics, p.Replicas, false)
+	for k, v := range primaryGraphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optHost := flag.String("hostname", "localhost", "Hostname to login to")
+	optPort := flag.String("port", "5432", "Database port")
+	optUser := flag.String("user", "", "Postgres User")
+	optPass := flag.String("password", "", "Postgres Password")
+	optDatabase := flag.String("database", "postgres", "Database to connect to")
+	optSSLmode := flag.String("sslmode", "disable", "Whether or not to use SSL")
+	optConnectTimeout := flag.Int("connect_timeout", 5, "Maximum wait for connection, in seconds.")
+	optRole := flag.String("role", "standby", "Role of the server to fetch the lag on (standby or primary)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	if *optUser == "" {
+		logger.Warningf("user is required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+	if *optRole != "standby" && *optRole != "primary" {
+		logger.Warningf("role should be standby or primary")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var replication PostgresReplicationPlugin
+	replication.Host = *optHost
+	replication.Port = *optPort
+	replication.Username = *optUser
+	replication.Password = *optPass
+	replication.Database = *optDatabase
+	replication.SSLmode = *optSSLmode
+	replication.Timeout = *optConnectTimeout
+	replication.Role = *optRole
+
+	if err := replication.Prepare(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(replication))
+
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-postgres-replication-%s-%s", *optHost, *optPort)
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"database/sql"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func valid(f float64) sql.NullFloat64 {
+	return sql.NullFloat64{Float64: f, Valid: true}
+}
+
+func TestQueries(t *testing.T) {
+	// PostgreSQL 9.6
+	assert.True(t, strings.Contains(replicationQuery(90605), "pg_xlog_location_diff(pg_current_xlog_location(), replay_location)"))
+	assert.True(t, strings.Contains(standbyQuery(90605), "pg_last_xlog_replay_location()"))
+	assert.False(t, strings.Contains(standbyQuery(90605), "wal"))
+
+	// PostgreSQL 10
+	assert.True(t, strings.Contains(replicationQuery(100004), "pg_wal_lsn_diff(pg_current_wal_lsn(), replay_lsn)"))
+	assert.True(t, strings.Contains(replicationQuery(100004), "replay_lag"))
+	assert.True(t, strings.Contains(standbyQuery(120002), "pg_last_wal_receive_lsn()"))
+	assert.False(t, strings.Contains(standbyQuery(120002), "xlog"))
+}
+
+func TestReplicaName(t *testing.T) {
+	assert.Equal(t, replicaName(replica{Name: "standby1", ClientAddr: "10.0.0.2"}), "standby1")
+	assert.Equal(t, replicaName(replica{Name: "walreceiver", ClientAddr: "10.0.0.2"}), "10_0_0_2")
+	assert.Equal(t, replicaName(replica{Name: "", ClientAddr: "fd00::2"}), "fd00_2")
+	assert.Equal(t, replicaName(replica{Name: "walreceiver"}), "local")
+}
+
+func TestReplicaStat(t *testing.T) {
+	stat, names := replicaStat([]replica{
+		replica{Name: "standby2", LagBytes: valid(1024), GapBytes: valid(512), LagSeconds: valid(1.5)},
+		// caught up and idle
+		replica{Name: "standby1", LagBytes: valid(0), GapBytes: valid(0)},
+		// the same name, which lags more
+		replica{Name: "walreceiver", ClientAddr: "10.0.0.3", LagBytes: valid(10)},
+		replica{Name: "walreceiver", ClientAddr: "10.0.0.3", LagBytes: valid(2048), GapBytes: valid(0), LagSeconds: valid(3)},
+		replica{Name: "walreceiver", ClientAddr: "10.0.0.3", LagBytes: valid(100)},
+	})
+	assert.Equal(t, names, []string{"10_0_0_3", "standby1", "standby2"})
+	assert.Equal(t, stat["replicas"], 5.0)
+	assert.Equal(t, stat["lag_bytes_standby2"], 1024.0)
+	assert.Equal(t, stat["replay_gap_bytes_standby2"], 512.0)
+	assert.Equal(t, stat["lag_seconds_standby2"], 1.5)
+	assert.Equal(t, stat["lag_seconds_standby1"], 0.0)
+	assert.Equal(t, stat["lag_bytes_10_0_0_3"], 2048.0)
+	assert.Equal(t, stat["lag_seconds_10_0_0_3"], 3.0)
+
+	// PostgreSQL 9.6 reports no lag in seconds
+	stat, _ = replicaStat([]replica{replica{Name: "standby1", LagBytes: valid(100), GapBytes: valid(0)}})
+	_, ok := stat["lag_seconds_standby1"]
+	assert.False(t, ok)
+}
+
+func TestStandbyStat(t *testing.T) {
+	stat, err := standbyStat(standbyStatus{InRecovery: true, GapBytes: valid(4096), CaughtUp: sql.NullBool{Bool: false, Valid: true}, LagSeconds: valid(12.5)})
+	assert.Nil(t, err)
+	assert.Equal(t, stat, map[string]float64{"replay_gap_bytes": 4096, "lag_seconds": 12.5})
+
+	// no transactions have been replayed since long before, but all the received WAL is replayed
+	stat, err = standbyStat(standbyStatus{InRecovery: true, GapBytes: valid(0), CaughtUp: sql.NullBool{Bool: true, Valid: true}, LagSeconds: valid(3600)})
+	assert.Nil(t, err)
+	assert.Equal(t, stat["lag_seconds"], 0.0)
+
+	// recovering from the archive, without streaming replication
+	stat, err = standbyStat(standbyStatus{InRecovery: true, LagSeconds: valid(30)})
+	assert.Nil(t, err)
+	assert.Equal(t, stat, map[string]float64{"lag_seconds": 30})
+
+	_, err = standbyStat(standbyStatus{InRecovery: false})
+	assert.NotNil(t, err)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	graphs := PostgresReplicationPlugin{Role: "standby"}.GraphDefinition()
+	assert.Equal(t, len(graphs), 2)
+
+	graphs = PostgresReplicationPlugin{Role: "primary", Replicas: []string{"standby1", "standby2"}}.GraphDefinition()
+	assert.Equal(t, len(graphs), 4)
+	assert.Equal(t, len(graphs["postgres_replication.lag_bytes"].Metrics), 2)
+}
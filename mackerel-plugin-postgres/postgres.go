@@ -8,6 +8,7 @@ import (
 
 	_ "github.com/lib/pq"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"github.com/mackerelio/mackerel-agent/logging"
 )
 
@@ -226,6 +227,9 @@ func main() {
 	optSSLmode := flag.String("sslmode", "disable", "Whether or not to use SSL")
 	optConnectTimeout := flag.Int("connect_timeout", 5, "Maximum wait for connection, in seconds.")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	if *optUser == "" {
@@ -247,7 +251,7 @@ func main() {
 	postgres.SSLmode = *optSSLmode
 	postgres.Timeout = *optConnectTimeout
 
-	helper := mp.NewMackerelPlugin(postgres)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(postgres))
 
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
@@ -258,6 +262,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
@@ -0,0 +1,264 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"path"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"powerdns.queries": mp.Graphs{
+		Label: "PowerDNS Queries",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "udp_queries", Label: "UDP Queries", Diff: true, Stacked: true},
+			mp.Metrics{Name: "tcp_queries", Label: "TCP Queries", Diff: true, Stacked: true},
+		},
+	},
+	"powerdns.answers": mp.Graphs{
+		Label: "PowerDNS Answers",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "udp_answers", Label: "UDP Answers", Diff: true, Stacked: true},
+			mp.Metrics{Name: "tcp_answers", Label: "TCP Answers", Diff: true, Stacked: true},
+			mp.Metrics{Name: "servfail_answers", Label: "SERVFAIL Answers", Diff: true},
+		},
+	},
+	"powerdns.cache": mp.Graphs{
+		Label: "PowerDNS Cache Hits/Misses",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cache_hits", Label: "Hits", Diff: true},
+			mp.Metrics{Name: "cache_misses", Label: "Misses", Diff: true},
+		},
+	},
+	"powerdns.cache_hit_ratio": mp.Graphs{
+		Label: "PowerDNS Cache Hit Ratio",
+		Unit:  "percentage",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cache_hit_ratio", Label: "Hit Ratio", Diff: false},
+		},
+	},
+	"powerdns.cache_entries": mp.Graphs{
+		Label: "PowerDNS Cache Entries",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cache_entries", Label: "Entries", Diff: false},
+		},
+	},
+	"powerdns.latency": mp.Graphs{
+		Label: "PowerDNS Latency (usec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "latency", Label: "Latency", Diff: false},
+		},
+	},
+}
+
+// statistic names of pdns (authoritative) and pdns_recursor for each metric.
+// only the first one reported is used, not to add up the same value reported by both of the names
+var statNames map[string][]string = map[string][]string{
+	"udp_queries":      []string{"udp-queries"},
+	"udp_answers":      []string{"udp-answers"},
+	"tcp_queries":      []string{"tcp-queries"},
+	"tcp_answers":      []string{"tcp-answers"},
+	"cache_hits":       []string{"packetcache-hit", "cache-hits"},
+	"cache_misses":     []string{"packetcache-miss", "cache-misses"},
+	"servfail_answers": []string{"servfail-packets", "servfail-answers"},
+	"cache_entries":    []string{"packetcache-size", "cache-entries"},
+	"latency":          []string{"qa-latency", "latency"},
+}
+
+type PowerDNSPlugin struct {
+	Method      string
+	ApiUrl      string
+	ApiKey      string
+	ControlPath string
+	Tempfile    string
+}
+
+// % curl -H 'X-API-Key: secret' http://127.0.0.1:8081/api/v1/servers/localhost/statistics
+// [{"name": "corrupt-packets", "type": "StatisticItem", "value": "0"}, ...]
+type statisticItem struct {
+	Name  string      `json:"name"`
+	Type  string      `json:"type"`
+	Value interface{} `json:"value"`
+}
+
+func parseApiStatistics(r io.Reader, stat map[string]float64) error {
+	var items []statisticItem
+	if err := json.NewDecoder(r).Decode(&items); err != nil {
+		return err
+	}
+
+	statistics := make(map[string]string)
+	for _, item := range items {
+		// MapStatisticItem / RingStatisticItem have non-scalar values
+		value, ok := item.Value.(string)
+		if !ok {
+			continue
+		}
+		statistics[item.Name] = value
+	}
+
+	setStatistics(stat, statistics)
+	return nil
+}
+
+// % pdns_control show '*'
+// corrupt-packets=0,deferred-cache-inserts=0,latency=412,...,
+//
+// % rec_control get-all
+// all-outqueries	2713
+// answers-slow	36
+func parseControlOutput(str string, stat map[string]float64) error {
+	statistics := make(map[string]string)
+	fields := strings.FieldsFunc(str, func(r rune) bool { return r == ',' || r == '\n' })
+	for _, field := range fields {
+		kv := strings.SplitN(field, "=", 2)
+		if len(kv) != 2 {
+			kv = strings.Fields(field)
+		}
+		if len(kv) != 2 {
+			continue
+		}
+		statistics[strings.TrimSpace(kv[0])] = kv[1]
+	}
+
+	setStatistics(stat, statistics)
+	return nil
+}
+
+// setStatistics sets the metrics from the statistics by the names of statNames
+func setStatistics(stat map[string]float64, statistics map[string]string) {
+	for key, names := range statNames {
+		for _, name := range names {
+			value, ok := statistics[name]
+			if !ok {
+				continue
+			}
+			if v, err := strconv.ParseFloat(strings.TrimSpace(value), 64); err == nil {
+				stat[key] = v
+			}
+			break
+		}
+	}
+}
+
+func (p PowerDNSPlugin) fetchApi(stat map[string]float64) error {
+	req, err := http.NewRequest("GET", p.ApiUrl+"/api/v1/servers/localhost/statistics", nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("X-API-Key", p.ApiKey)
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return errors.New(fmt.Sprintf("HTTP status error: %d", resp.StatusCode))
+	}
+
+	return parseApiStatistics(resp.Body, stat)
+}
+
+func (p PowerDNSPlugin) fetchControl(stat map[string]float64) error {
+	args := []string{"show", "*"}
+	if path.Base(p.ControlPath) == "rec_control" {
+		args = []string{"get-all"}
+	}
+
+	out, err := exec.Command(p.ControlPath, args...).Output()
+	if err != nil {
+		return errors.New(fmt.Sprintf("%s: %s", err, out))
+	}
+
+	return parseControlOutput(string(out), stat)
+}
+
+// cacheHitRatio returns the hit ratio of the cache since the last run.
+// the counters since the server started hide a recent drop of the ratio
+func cacheHitRatio(stat, last map[string]float64) (float64, bool) {
+	return common.HitRatioSince(stat, last, "cache_hits", "cache_misses")
+}
+
+func (p PowerDNSPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	var err error
+	switch p.Method {
+	case "api":
+		err = p.fetchApi(stat)
+	case "control":
+		err = p.fetchControl(stat)
+	default:
+		err = errors.New("unknown method: " + p.Method)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(stat) == 0 {
+		return nil, errors.New("cannot get values")
+	}
+
+	if v, ok := cacheHitRatio(stat, common.LastValues(p.Tempfile)); ok {
+		stat["cache_hit_ratio"] = v
+	}
+
+	return stat, nil
+}
+
+func (p PowerDNSPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optMethod := flag.String("method", "api", "Collection method (api or control)")
+	optApiUrl := flag.String("api-url", "http://127.0.0.1:8081", "PowerDNS webserver URL")
+	optApiKey := flag.String("api-key", "", "PowerDNS API key")
+	optControlPath := flag.String("pdns-control", "pdns_control", "pdns_control (or rec_control) path")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var powerdns PowerDNSPlugin
+	powerdns.Method = *optMethod
+	powerdns.ApiUrl = strings.TrimRight(*optApiUrl, "/")
+	powerdns.ApiKey = *optApiKey
+	powerdns.ControlPath = *optControlPath
+
+	if *optTempfile != "" {
+		powerdns.Tempfile = *optTempfile
+	} else {
+		powerdns.Tempfile = common.Tempfile("mackerel-plugin-powerdns", "api-url", "pdns-control")
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(powerdns))
+	helper.Tempfile = powerdns.Tempfile
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
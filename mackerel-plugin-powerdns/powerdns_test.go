@@ -0,0 +1,81 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseControlOutput(t *testing.T) {
+	stub := "corrupt-packets=0,deferred-cache-inserts=0,latency=412,packetcache-hit=300,packetcache-miss=100,packetcache-size=42,servfail-packets=3,tcp-answers=5,tcp-queries=6,udp-answers=998,udp-queries=1000,\n"
+	stat := make(map[string]float64)
+
+	err := parseControlOutput(stub, stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["udp_queries"], 1000.0)
+	assert.Equal(t, stat["udp_answers"], 998.0)
+	assert.Equal(t, stat["tcp_queries"], 6.0)
+	assert.Equal(t, stat["tcp_answers"], 5.0)
+	assert.Equal(t, stat["cache_hits"], 300.0)
+	assert.Equal(t, stat["cache_misses"], 100.0)
+	assert.Equal(t, stat["cache_entries"], 42.0)
+	assert.Equal(t, stat["servfail_answers"], 3.0)
+	assert.Equal(t, stat["latency"], 412.0)
+	_, ok := stat["corrupt-packets"]
+	assert.False(t, ok)
+}
+
+func TestParseControlOutputRecursor(t *testing.T) {
+	// latency and qa-latency of the same value are not added up
+	stub := `cache-entries	1234
+cache-hits	80
+cache-misses	20
+latency	5000
+qa-latency	5500
+`
+	stat := make(map[string]float64)
+
+	err := parseControlOutput(stub, stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["cache_entries"], 1234.0)
+	assert.Equal(t, stat["cache_hits"], 80.0)
+	assert.Equal(t, stat["cache_misses"], 20.0)
+	assert.Equal(t, stat["latency"], 5500.0)
+}
+
+func TestParseApiStatistics(t *testing.T) {
+	stub := `[
+{"name": "cache-entries", "type": "StatisticItem", "value": "1234"},
+{"name": "cache-hits", "type": "StatisticItem", "value": "80"},
+{"name": "cache-misses", "type": "StatisticItem", "value": "20"},
+{"name": "qa-latency", "type": "StatisticItem", "value": "5500"},
+{"name": "servfail-answers", "type": "StatisticItem", "value": "7"},
+{"name": "response-by-qtype", "type": "MapStatisticItem", "value": [{"name": "A", "value": "10"}]}
+]`
+	stat := make(map[string]float64)
+
+	err := parseApiStatistics(bytes.NewBufferString(stub), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["cache_entries"], 1234.0)
+	assert.Equal(t, stat["cache_hits"], 80.0)
+	assert.Equal(t, stat["cache_misses"], 20.0)
+	assert.Equal(t, stat["latency"], 5500.0)
+	assert.Equal(t, stat["servfail_answers"], 7.0)
+}
+
+func TestCacheHitRatio(t *testing.T) {
+	last := map[string]float64{"cache_hits": 800, "cache_misses": 200}
+	stat := map[string]float64{"cache_hits": 890, "cache_misses": 210}
+	v, ok := cacheHitRatio(stat, last)
+	assert.True(t, ok)
+	assert.Equal(t, v, 90.0)
+
+	// the first run
+	_, ok = cacheHitRatio(stat, nil)
+	assert.False(t, ok)
+
+	// restarted
+	_, ok = cacheHitRatio(map[string]float64{"cache_hits": 10, "cache_misses": 0}, last)
+	assert.False(t, ok)
+}
@@ -0,0 +1,333 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/fzzy/radix/redis"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+	"github.com/mackerelio/mackerel-agent/logging"
+)
+
+var logger = logging.GetLogger("metrics.plugin.redis-cluster")
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"redis_cluster.state": mp.Graphs{
+		Label: "Redis Cluster State",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cluster_state", Label: "OK"},
+		},
+	},
+	"redis_cluster.slots": mp.Graphs{
+		Label: "Redis Cluster Slots",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cluster_slots_assigned", Label: "Assigned"},
+			mp.Metrics{Name: "cluster_slots_ok", Label: "OK"},
+			mp.Metrics{Name: "cluster_slots_pfail", Label: "Possibly Failed"},
+			mp.Metrics{Name: "cluster_slots_fail", Label: "Failed"},
+		},
+	},
+	"redis_cluster.nodes": mp.Graphs{
+		Label: "Redis Cluster Nodes",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cluster_known_nodes", Label: "Known Nodes"},
+			mp.Metrics{Name: "cluster_size", Label: "Masters Serving Slots"},
+			mp.Metrics{Name: "unreachable_masters", Label: "Unreachable Masters"},
+		},
+	},
+	"redis_cluster.memory": mp.Graphs{
+		Label: "Redis Cluster Used Memory",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "used_memory", Label: "Used Memory"},
+		},
+	},
+	"redis_cluster.keyspace": mp.Graphs{
+		Label: "Redis Cluster Keyspace",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "keyspace_hits", Label: "Keyspace Hits", Diff: true},
+			mp.Metrics{Name: "keyspace_misses", Label: "Keyspace Missed", Diff: true},
+		},
+	},
+	"redis_cluster.commands": mp.Graphs{
+		Label: "Redis Cluster Commands",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "total_commands_processed", Label: "Commands", Diff: true},
+		},
+	},
+
+	// "redis_cluster.node_memory", "redis_cluster.node_commands" will be generated dynamically
+}
+
+// INFO fields summed up over the masters, which are also reported per node
+var infoKeys = []string{"used_memory", "keyspace_hits", "keyspace_misses", "total_commands_processed"}
+
+type RedisClusterPlugin struct {
+	Target      string
+	Timeout     int
+	Concurrency int
+	Masters     []string
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+func (m RedisClusterPlugin) command(addr string, cmd string, args ...interface{}) (string, error) {
+	c, err := redis.DialTimeout("tcp", addr, time.Duration(m.Timeout)*time.Second)
+	if err != nil {
+		return "", err
+	}
+	defer c.Close()
+
+	r := c.Cmd(cmd, args...)
+	if r.Err != nil {
+		return "", r.Err
+	}
+	return r.Str()
+}
+
+// % redis-cli cluster nodes
+// 07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected
+// e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001@31001 myself,master - 0 0 1 connected 0-5460
+// (redis before 4.0 doesn't have @<cluster bus port>)
+func parseClusterNodes(str string, seed string) []string {
+	seedHost, _, _ := net.SplitHostPort(seed)
+
+	var masters []string
+	for _, line := range strings.Split(str, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) < 3 {
+			continue
+		}
+
+		isMaster, noAddr := false, false
+		for _, f := range strings.Split(fields[2], ",") {
+			switch f {
+			case "master":
+				isMaster = true
+			case "noaddr", "handshake":
+				noAddr = true
+			}
+		}
+		if !isMaster || noAddr {
+			continue
+		}
+
+		addr := fields[1]
+		if i := strings.IndexAny(addr, "@,"); i >= 0 {
+			addr = addr[:i]
+		}
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil || port == "0" {
+			continue
+		}
+		// a node not yet joined to a cluster doesn't know its own ip
+		if host == "" {
+			host = seedHost
+		}
+		masters = append(masters, net.JoinHostPort(host, port))
+	}
+
+	return masters
+}
+
+// % redis-cli cluster info
+// cluster_state:ok
+// cluster_slots_assigned:16384
+// ...
+func parseClusterInfo(str string, stat map[string]float64) {
+	for _, line := range strings.Split(str, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+
+		if kv[0] == "cluster_state" {
+			if kv[1] == "ok" {
+				stat["cluster_state"] = 1
+			} else {
+				stat["cluster_state"] = 0
+			}
+			continue
+		}
+
+		switch kv[0] {
+		case "cluster_slots_assigned", "cluster_slots_ok", "cluster_slots_pfail", "cluster_slots_fail",
+			"cluster_known_nodes", "cluster_size":
+			v, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				continue
+			}
+			stat[kv[0]] = v
+		}
+	}
+}
+
+func parseInfo(str string) map[string]float64 {
+	info := make(map[string]float64)
+	for _, line := range strings.Split(str, "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		for _, key := range infoKeys {
+			if kv[0] != key {
+				continue
+			}
+			v, err := strconv.ParseFloat(kv[1], 64)
+			if err != nil {
+				break
+			}
+			info[key] = v
+		}
+	}
+	return info
+}
+
+func (m *RedisClusterPlugin) Prepare() error {
+	str, err := m.command(m.Target, "cluster", "nodes")
+	if err != nil {
+		return err
+	}
+	m.Masters = parseClusterNodes(str, m.Target)
+	if len(m.Masters) == 0 {
+		return errors.New("no masters found. is cluster mode enabled?")
+	}
+	return nil
+}
+
+// masterStat sets INFO of each master (nil if unreachable), and the sum of them.
+// the sum is omitted while any master is unreachable, as the partial sum of the counters
+// drops and then jumps back with the master, which looks like a spike of the rate
+func masterStat(masters []string, infos []map[string]float64, stat map[string]float64) {
+	stat["unreachable_masters"] = 0
+	sum := make(map[string]float64, len(infoKeys))
+	for _, key := range infoKeys {
+		sum[key] = 0
+	}
+	for i, info := range infos {
+		if info == nil {
+			stat["unreachable_masters"]++
+			continue
+		}
+		prefix := "node_" + metricName(masters[i]) + "_"
+		for key, v := range info {
+			sum[key] += v
+			stat[prefix+key] = v
+		}
+	}
+
+	if stat["unreachable_masters"] > 0 {
+		return
+	}
+	for key, v := range sum {
+		stat[key] = v
+	}
+}
+
+func (m RedisClusterPlugin) FetchMetrics() (map[string]float64, error) {
+	str, err := m.command(m.Target, "cluster", "info")
+	if err != nil {
+		logger.Errorf("Failed to run cluster info command. %s", err)
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	parseClusterInfo(str, stat)
+
+	// a dead master must not block the others, so each of them has its own timeout
+	infos := make([]map[string]float64, len(m.Masters))
+	common.FetchMany(len(m.Masters), m.Concurrency, func(i int) {
+		str, err := m.command(m.Masters[i], "info")
+		if err != nil {
+			logger.Warningf("Failed to fetch information of %s. %s", m.Masters[i], err)
+			return
+		}
+		infos[i] = parseInfo(str)
+	})
+
+	masterStat(m.Masters, infos, stat)
+
+	return stat, nil
+}
+
+func (m RedisClusterPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), len(graphdef)+2)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	var memory, commands [](mp.Metrics)
+	for _, addr := range m.Masters {
+		prefix := "node_" + metricName(addr) + "_"
+		memory = append(memory, mp.Metrics{Name: prefix + "used_memory", Label: addr, Stacked: true})
+		commands = append(commands, mp.Metrics{Name: prefix + "total_commands_processed", Label: addr, Diff: true, Stacked: true})
+	}
+	if len(m.Masters) > 0 {
+		graphs["redis_cluster.node_memory"] = mp.Graphs{
+			Label:   "Redis Cluster Used Memory per Master",
+			Unit:    "bytes",
+			Metrics: memory,
+		}
+		graphs["redis_cluster.node_commands"] = mp.Graphs{
+			Label:   "Redis Cluster Commands per Master",
+			Unit:    "integer",
+			Metrics: commands,
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optHost := flag.String("host", "localhost", "Hostname of a cluster node")
+	optPort := flag.String("port", "6379", "Port")
+	optTimeout := flag.Int("timeout", 5, "Timeout per node")
+	optConcurrency := flag.Int("concurrency", common.DefaultConcurrency, "Maximum number of masters fetched at once")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var redisCluster RedisClusterPlugin
+	redisCluster.Target = net.JoinHostPort(*optHost, *optPort)
+	redisCluster.Timeout = *optTimeout
+	redisCluster.Concurrency = *optConcurrency
+
+	err := redisCluster.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(redisCluster))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-redis-cluster-%s-%s", *optHost, *optPort)
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseClusterNodes(t *testing.T) {
+	stub := "07c37dfeb235213a872192d90877d0cd55635b91 127.0.0.1:30004@31004 slave e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 0 1426238317239 4 connected\n" +
+		"67ed2db8d677e59ec4a4cefb06858cf2a1a89fa1 127.0.0.1:30002@31002 master - 0 1426238316232 2 connected 5461-10922\n" +
+		"292f8b365bb7edb5e285caf0b7e6ddc7265d2f4f 127.0.0.1:30003@31003 master - 0 1426238318243 3 connected 10923-16383\n" +
+		"e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca :30001@31001 myself,master - 0 0 1 connected 0-5460\n" +
+		"6ec23923021cf3ffec47632106199cb7f496ce01 :0@0 master,fail,noaddr - 1426238317741 1426238316232 5 disconnected\n"
+
+	masters := parseClusterNodes(stub, "10.0.0.1:30001")
+	assert.Equal(t, masters, []string{"127.0.0.1:30002", "127.0.0.1:30003", "10.0.0.1:30001"})
+
+	// redis 3.x
+	stub = "e7d1eecce10fd6bb5eb35b9f99a514335d9ba9ca 127.0.0.1:30001 myself,master - 0 0 1 connected 0-16383\n"
+	assert.Equal(t, parseClusterNodes(stub, "127.0.0.1:30001"), []string{"127.0.0.1:30001"})
+}
+
+func TestParseClusterInfo(t *testing.T) {
+	stub := "cluster_state:fail\r\n" +
+		"cluster_slots_assigned:16384\r\n" +
+		"cluster_slots_ok:10923\r\n" +
+		"cluster_slots_pfail:0\r\n" +
+		"cluster_slots_fail:5461\r\n" +
+		"cluster_known_nodes:6\r\n" +
+		"cluster_size:3\r\n" +
+		"cluster_current_epoch:6\r\n"
+
+	stat := make(map[string]float64)
+	parseClusterInfo(stub, stat)
+	assert.Equal(t, stat["cluster_state"], 0.0)
+	assert.Equal(t, stat["cluster_slots_assigned"], 16384.0)
+	assert.Equal(t, stat["cluster_slots_fail"], 5461.0)
+	assert.Equal(t, stat["cluster_known_nodes"], 6.0)
+	assert.Equal(t, stat["cluster_size"], 3.0)
+	_, ok := stat["cluster_current_epoch"]
+	assert.False(t, ok)
+
+	parseClusterInfo("cluster_state:ok\r\n", stat)
+	assert.Equal(t, stat["cluster_state"], 1.0)
+}
+
+func TestParseInfo(t *testing.T) {
+	stub := "# Memory\r\nused_memory:1048576\r\nused_memory_human:1.00M\r\n" +
+		"# Stats\r\ntotal_commands_processed:1200\r\nkeyspace_hits:300\r\nkeyspace_misses:20\r\n"
+
+	info := parseInfo(stub)
+	assert.Equal(t, len(info), 4)
+	assert.Equal(t, info["used_memory"], 1048576.0)
+	assert.Equal(t, info["total_commands_processed"], 1200.0)
+	assert.Equal(t, info["keyspace_hits"], 300.0)
+	assert.Equal(t, info["keyspace_misses"], 20.0)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var redisCluster RedisClusterPlugin
+	_, ok := redisCluster.GraphDefinition()["redis_cluster.node_memory"]
+	assert.False(t, ok)
+
+	redisCluster.Masters = []string{"127.0.0.1:30001", "127.0.0.1:30002"}
+	graphs := redisCluster.GraphDefinition()
+	assert.Equal(t, len(graphs["redis_cluster.node_memory"].Metrics), 2)
+	assert.Equal(t, graphs["redis_cluster.node_commands"].Metrics[1].Name, "node_127_0_0_1_30002_total_commands_processed")
+}
+
+func TestMasterStat(t *testing.T) {
+	masters := []string{"127.0.0.1:30001", "127.0.0.1:30002"}
+	infos := []map[string]float64{
+		map[string]float64{"used_memory": 100, "total_commands_processed": 1000},
+		map[string]float64{"used_memory": 200, "total_commands_processed": 3000},
+	}
+	stat := make(map[string]float64)
+	masterStat(masters, infos, stat)
+	assert.Equal(t, stat["unreachable_masters"], 0.0)
+	assert.Equal(t, stat["used_memory"], 300.0)
+	assert.Equal(t, stat["total_commands_processed"], 4000.0)
+	assert.Equal(t, stat["keyspace_hits"], 0.0)
+	assert.Equal(t, stat["node_127_0_0_1_30002_used_memory"], 200.0)
+
+	// the partial sum is not reported
+	infos[0] = nil
+	stat = make(map[string]float64)
+	masterStat(masters, infos, stat)
+	assert.Equal(t, stat["unreachable_masters"], 1.0)
+	_, ok := stat["total_commands_processed"]
+	assert.False(t, ok)
+	assert.Equal(t, stat["node_127_0_0_1_30002_total_commands_processed"], 3000.0)
+}
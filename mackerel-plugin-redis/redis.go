@@ -11,6 +11,7 @@ import (
 
 	"github.com/fzzy/radix/redis"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"github.com/mackerelio/mackerel-agent/logging"
 )
 
@@ -71,12 +72,13 @@ var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
 
 type RedisPlugin struct {
 	Target   string
+	Network  string
 	Timeout  int
 	Tempfile string
 }
 
 func (m RedisPlugin) FetchMetrics() (map[string]float64, error) {
-	c, err := redis.DialTimeout("tcp", m.Target, time.Duration(m.Timeout)*time.Second)
+	c, err := redis.DialTimeout(m.Network, m.Target, time.Duration(m.Timeout)*time.Second)
 	defer c.Close()
 
 	r := c.Cmd("info")
@@ -151,14 +153,24 @@ func (m RedisPlugin) GraphDefinition() map[string](mp.Graphs) {
 func main() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "6379", "Port")
+	optNetwork := common.NetworkFlag()
 	optTimeout := flag.Int("timeout", 5, "Timeout")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
+	if err := common.CheckNetwork(*optNetwork); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	var redis RedisPlugin
-	redis.Target = fmt.Sprintf("%s:%s", *optHost, *optPort)
+	redis.Target = common.HostPort(*optHost, *optPort)
+	redis.Network = *optNetwork
 	redis.Timeout = *optTimeout
-	helper := mp.NewMackerelPlugin(redis)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(redis))
 
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
@@ -169,6 +181,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
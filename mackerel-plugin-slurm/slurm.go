@@ -0,0 +1,476 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var jobStates = []string{"running", "pending", "completing", "other"}
+
+var nodeStates = []string{"idle", "allocated", "mixed", "down", "drain", "other"}
+
+var cpuStates = []string{"allocated", "idle", "other", "total"}
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"slurm.jobs": mp.Graphs{
+		Label: "Job Scheduler Jobs",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "jobs_running", Label: "Running", Stacked: true},
+			mp.Metrics{Name: "jobs_pending", Label: "Pending", Stacked: true},
+			mp.Metrics{Name: "jobs_completing", Label: "Completing", Stacked: true},
+			mp.Metrics{Name: "jobs_other", Label: "Other", Stacked: true},
+		},
+	},
+	"slurm.nodes": mp.Graphs{
+		Label: "Job Scheduler Nodes",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "nodes_idle", Label: "Idle", Stacked: true},
+			mp.Metrics{Name: "nodes_allocated", Label: "Allocated", Stacked: true},
+			mp.Metrics{Name: "nodes_mixed", Label: "Mixed", Stacked: true},
+			mp.Metrics{Name: "nodes_down", Label: "Down", Stacked: true},
+			mp.Metrics{Name: "nodes_drain", Label: "Drain", Stacked: true},
+			mp.Metrics{Name: "nodes_other", Label: "Other", Stacked: true},
+		},
+	},
+	"slurm.cpus": mp.Graphs{
+		Label: "Job Scheduler CPUs",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "cpus_allocated", Label: "Allocated"},
+			mp.Metrics{Name: "cpus_idle", Label: "Idle"},
+			mp.Metrics{Name: "cpus_other", Label: "Other (Down, Drain)"},
+			mp.Metrics{Name: "cpus_total", Label: "Total"},
+		},
+	},
+}
+
+// partitionMetrics are the metrics above of each partition (queue of PBS),
+// drawn in graphs for each partition
+func partitionMetrics() []common.DimensionMetric {
+	var metrics []common.DimensionMetric
+	add := func(kind string, states []string, stacked bool, label string) {
+		for _, s := range states {
+			metrics = append(metrics, common.DimensionMetric{Prefix: kind + "_" + s + "_", Label: strings.Title(s), Unit: "integer",
+				Stacked: stacked && s != "total", Group: kind, GroupLabel: "Job Scheduler " + label})
+		}
+	}
+	add("jobs", jobStates, true, "Jobs")
+	add("nodes", nodeStates, true, "Nodes")
+	add("cpus", cpuStates, false, "CPUs")
+	return metrics
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// schedulerStat sums up the metrics for the whole cluster and for each partition.
+// the metrics of a partition are 0 from the first time it is seen
+type schedulerStat struct {
+	stat       map[string]float64
+	partitions map[string]bool
+}
+
+func metricNames() []string {
+	var names []string
+	for _, s := range jobStates {
+		names = append(names, "jobs_"+s)
+	}
+	for _, s := range nodeStates {
+		names = append(names, "nodes_"+s)
+	}
+	for _, s := range cpuStates {
+		names = append(names, "cpus_"+s)
+	}
+	return names
+}
+
+func newSchedulerStat() *schedulerStat {
+	s := &schedulerStat{stat: make(map[string]float64), partitions: make(map[string]bool)}
+	for _, name := range metricNames() {
+		s.stat[name] = 0
+	}
+	return s
+}
+
+func (s *schedulerStat) addTotal(name string, v float64) {
+	s.stat[name] += v
+}
+
+func (s *schedulerStat) addPartition(name, partition string, v float64) {
+	p := metricName(partition)
+	if p == "" {
+		return
+	}
+	if !s.partitions[p] {
+		s.partitions[p] = true
+		for _, n := range metricNames() {
+			s.stat[n+"_"+p] = 0
+		}
+	}
+	s.stat[name+"_"+p] += v
+}
+
+func (s *schedulerStat) add(name, partition string, v float64) {
+	s.addTotal(name, v)
+	s.addPartition(name, partition, v)
+}
+
+// slurmJobState returns the state of the job by %T of squeue, e.g. RUNNING
+func slurmJobState(s string) string {
+	switch s {
+	case "RUNNING":
+		return "running"
+	case "PENDING":
+		return "pending"
+	case "COMPLETING":
+		return "completing"
+	}
+	return "other"
+}
+
+// slurmNodeState returns the state of the node by %T of sinfo, e.g. "idle", "down*" or "drained".
+// the suffixes (e.g. "*" for not responding, "~" for powered off) are ignored
+func slurmNodeState(s string) string {
+	s = strings.TrimRight(s, "*~#!%$@^-+")
+	switch s {
+	case "idle":
+		return "idle"
+	case "allocated", "alloc":
+		return "allocated"
+	case "mixed", "mix":
+		return "mixed"
+	case "down", "fail", "failing":
+		return "down"
+	case "drained", "draining", "drain", "drng":
+		return "drain"
+	}
+	return "other"
+}
+
+// splitFields splits a line of the output by "|", which the commands are given as the separator
+func splitFields(line string, n int) ([]string, bool) {
+	fields := strings.Split(strings.TrimSpace(line), "|")
+	if len(fields) != n {
+		return nil, false
+	}
+	for i := range fields {
+		fields[i] = strings.TrimSpace(fields[i])
+	}
+	return fields, true
+}
+
+// % squeue -h -a -o '%P|%T'
+// batch|RUNNING
+// batch,debug|PENDING
+func parseSqueue(r io.Reader, s *schedulerStat) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields, ok := splitFields(scanner.Text(), 2)
+		if !ok {
+			continue
+		}
+		// a pending job may be submitted to several partitions, and is counted in the first one
+		partition := strings.SplitN(fields[0], ",", 2)[0]
+		s.add("jobs_"+slurmJobState(fields[1]), partition, 1)
+	}
+	return scanner.Err()
+}
+
+// % sinfo -h -N -o '%N|%R|%T|%C'
+// node01|batch|mixed|12/4/0/16
+// node01|debug|mixed|12/4/0/16
+// node02|batch|down*|0/0/16/16
+//
+// a node is listed for each partition it belongs to, and counted once in the whole cluster
+func parseSinfo(r io.Reader, s *schedulerStat) error {
+	seen := make(map[string]bool)
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields, ok := splitFields(scanner.Text(), 4)
+		if !ok {
+			continue
+		}
+		cpus := strings.Split(fields[3], "/")
+		if len(cpus) != len(cpuStates) {
+			continue
+		}
+
+		values := make(map[string]float64)
+		values["nodes_"+slurmNodeState(fields[2])] = 1
+		for i, c := range cpus {
+			v, err := strconv.ParseFloat(c, 64)
+			if err != nil {
+				continue
+			}
+			values["cpus_"+cpuStates[i]] = v
+		}
+
+		for name, v := range values {
+			if !seen[fields[0]] {
+				s.addTotal(name, v)
+			}
+			s.addPartition(name, fields[1], v)
+		}
+		seen[fields[0]] = true
+	}
+	return scanner.Err()
+}
+
+// % qstat -Q
+// Queue              Max   Tot Ena Str   Que   Run   Hld   Wat   Trn   Ext Type
+// ---------------- ----- ----- --- --- ----- ----- ----- ----- ----- ----- ----
+// workq                0     5 yes yes     2     3     0     0     0     0 Exec
+//
+// the columns are found by the header, as they differ between the implementations
+func parseQstatQueues(r io.Reader, s *schedulerStat) error {
+	columns := map[string]string{"Run": "running", "Que": "pending", "Ext": "completing", "Hld": "other", "Wat": "other", "Trn": "other"}
+
+	var header []string
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 0 || strings.HasPrefix(fields[0], "---") {
+			continue
+		}
+		if fields[0] == "Queue" {
+			header = fields
+			continue
+		}
+		if len(header) == 0 || len(fields) != len(header) {
+			continue
+		}
+		for i, col := range header {
+			state, ok := columns[col]
+			if !ok {
+				continue
+			}
+			v, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				continue
+			}
+			s.add("jobs_"+state, fields[0], v)
+		}
+	}
+	if header == nil {
+		return errors.New("no header in qstat -Q")
+	}
+	return scanner.Err()
+}
+
+type pbsNode struct {
+	state    string
+	queue    string
+	ncpus    float64
+	assigned float64
+}
+
+// pbsNodeState returns the state of the node by the state of pbsnodes, e.g. "free" or "down,offline"
+func pbsNodeState(n pbsNode) string {
+	states := make(map[string]bool)
+	for _, st := range strings.Split(n.state, ",") {
+		states[strings.TrimSpace(st)] = true
+	}
+	switch {
+	case states["down"] || states["state-unknown"]:
+		return "down"
+	case states["offline"]:
+		return "drain"
+	case states["job-busy"] || states["job-exclusive"] || states["busy"]:
+		return "allocated"
+	case states["free"] && n.assigned > 0:
+		return "mixed"
+	case states["free"]:
+		return "idle"
+	}
+	return "other"
+}
+
+// % pbsnodes -a
+// node01
+// .....Mom = node01
+// .....state = free
+// .....resources_available.ncpus = 16
+// .....resources_assigned.ncpus = 4
+// .....queue = workq
+//
+// np is the CPUs of Torque. the nodes not assigned to a queue are counted only in the whole cluster
+func parsePbsnodes(r io.Reader, s *schedulerStat) error {
+	var nodes []pbsNode
+	var cur *pbsNode
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if strings.TrimSpace(line) == "" {
+			cur = nil
+			continue
+		}
+		if !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "\t") {
+			nodes = append(nodes, pbsNode{})
+			cur = &nodes[len(nodes)-1]
+			continue
+		}
+		if cur == nil {
+			continue
+		}
+		kv := strings.SplitN(line, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		v := strings.TrimSpace(kv[1])
+		switch strings.TrimSpace(kv[0]) {
+		case "state":
+			cur.state = v
+		case "queue":
+			cur.queue = v
+		case "resources_available.ncpus", "np":
+			cur.ncpus, _ = strconv.ParseFloat(v, 64)
+		case "resources_assigned.ncpus":
+			cur.assigned, _ = strconv.ParseFloat(v, 64)
+		}
+	}
+
+	for _, n := range nodes {
+		state := pbsNodeState(n)
+		if state == "allocated" && n.assigned == 0 {
+			// Torque doesn't report the assigned CPUs of the busy nodes
+			n.assigned = n.ncpus
+		}
+		values := map[string]float64{"nodes_" + state: 1, "cpus_total": n.ncpus}
+		switch state {
+		case "down", "drain", "other":
+			values["cpus_other"] = n.ncpus
+		default:
+			values["cpus_allocated"] = n.assigned
+			values["cpus_idle"] = n.ncpus - n.assigned
+		}
+		for name, v := range values {
+			s.add(name, n.queue, v)
+		}
+	}
+	return scanner.Err()
+}
+
+type SlurmPlugin struct {
+	Scheduler  string
+	Partitions []string
+}
+
+func run(name string, args ...string) (io.Reader, error) {
+	out, err := exec.Command(name, args...).Output()
+	if err != nil {
+		return nil, errors.New(fmt.Sprintf("%s %s: %s", name, strings.Join(args, " "), err))
+	}
+	return strings.NewReader(string(out)), nil
+}
+
+func (p SlurmPlugin) fetch() (*schedulerStat, error) {
+	type command struct {
+		args  []string
+		parse func(io.Reader, *schedulerStat) error
+	}
+	commands := []command{
+		{[]string{"squeue", "-h", "-a", "-o", "%P|%T"}, parseSqueue},
+		{[]string{"sinfo", "-h", "-N", "-o", "%N|%R|%T|%C"}, parseSinfo},
+	}
+	if p.Scheduler == "pbs" {
+		commands = []command{
+			{[]string{"qstat", "-Q"}, parseQstatQueues},
+			{[]string{"pbsnodes", "-a"}, parsePbsnodes},
+		}
+	}
+
+	s := newSchedulerStat()
+	for _, c := range commands {
+		out, err := run(c.args[0], c.args[1:]...)
+		if err != nil {
+			return nil, err
+		}
+		if err := c.parse(out, s); err != nil {
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+// Prepare lists the partitions for the graphs
+func (p *SlurmPlugin) Prepare() error {
+	s, err := p.fetch()
+	if err != nil {
+		return err
+	}
+	for name := range s.partitions {
+		p.Partitions = append(p.Partitions, name)
+	}
+	sort.Strings(p.Partitions)
+	return nil
+}
+
+func (p SlurmPlugin) FetchMetrics() (map[string]float64, error) {
+	s, err := p.fetch()
+	if err != nil {
+		return nil, err
+	}
+	return s.stat, nil
+}
+
+func (p SlurmPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := common.DimensionGraphs("slurm", partitionMetrics(), p.Partitions, true)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optScheduler := flag.String("scheduler", "slurm", "Job scheduler (slurm or pbs)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	if *optScheduler != "slurm" && *optScheduler != "pbs" {
+		fmt.Fprintln(os.Stderr, "-scheduler should be slurm or pbs")
+		os.Exit(1)
+	}
+
+	var slurm SlurmPlugin
+	slurm.Scheduler = *optScheduler
+
+	if err := slurm.Prepare(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(slurm))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = "/tmp/mackerel-plugin-slurm"
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
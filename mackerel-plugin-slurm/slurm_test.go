@@ -0,0 +1,105 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSqueue(t *testing.T) {
+	stub := `batch|RUNNING
+batch|RUNNING
+batch,debug|PENDING
+debug|COMPLETING
+debug|SUSPENDED
+broken line
+`
+	s := newSchedulerStat()
+	assert.Nil(t, parseSqueue(strings.NewReader(stub), s))
+	assert.Equal(t, s.stat["jobs_running"], 2.0)
+	assert.Equal(t, s.stat["jobs_pending"], 1.0)
+	assert.Equal(t, s.stat["jobs_completing"], 1.0)
+	assert.Equal(t, s.stat["jobs_other"], 1.0)
+	assert.Equal(t, s.stat["jobs_pending_batch"], 1.0)
+	assert.Equal(t, s.stat["jobs_pending_debug"], 0.0)
+	assert.Equal(t, s.stat["jobs_completing_debug"], 1.0)
+	assert.True(t, s.partitions["debug"])
+}
+
+func TestParseSinfo(t *testing.T) {
+	stub := `node01|batch|mixed|12/4/0/16
+node01|debug|mixed|12/4/0/16
+node02|batch|down*|0/0/16/16
+node03|batch|drained|0/0/16/16
+node04|batch|idle~|0/16/0/16
+`
+	s := newSchedulerStat()
+	assert.Nil(t, parseSinfo(strings.NewReader(stub), s))
+	assert.Equal(t, s.stat["nodes_mixed"], 1.0, "counted once in the whole cluster")
+	assert.Equal(t, s.stat["nodes_down"], 1.0)
+	assert.Equal(t, s.stat["nodes_drain"], 1.0)
+	assert.Equal(t, s.stat["nodes_idle"], 1.0)
+	assert.Equal(t, s.stat["cpus_allocated"], 12.0)
+	assert.Equal(t, s.stat["cpus_idle"], 20.0)
+	assert.Equal(t, s.stat["cpus_other"], 32.0)
+	assert.Equal(t, s.stat["cpus_total"], 64.0)
+	assert.Equal(t, s.stat["nodes_mixed_debug"], 1.0)
+	assert.Equal(t, s.stat["cpus_total_debug"], 16.0)
+	assert.Equal(t, s.stat["cpus_total_batch"], 64.0)
+}
+
+func TestSlurmNodeState(t *testing.T) {
+	assert.Equal(t, slurmNodeState("alloc"), "allocated")
+	assert.Equal(t, slurmNodeState("draining"), "drain")
+	assert.Equal(t, slurmNodeState("idle*"), "idle")
+	assert.Equal(t, slurmNodeState("maint"), "other")
+}
+
+func TestParseQstatQueues(t *testing.T) {
+	stub := `Queue              Max   Tot Ena Str   Que   Run   Hld   Wat   Trn   Ext Type
+---------------- ----- ----- --- --- ----- ----- ----- ----- ----- ----- ----
+workq                0     5 yes yes     2     3     0     0     0     0 Exec
+long                 0     2 yes yes     0     1     1     0     0     0 Exec
+`
+	s := newSchedulerStat()
+	assert.Nil(t, parseQstatQueues(strings.NewReader(stub), s))
+	assert.Equal(t, s.stat["jobs_running"], 4.0)
+	assert.Equal(t, s.stat["jobs_pending"], 2.0)
+	assert.Equal(t, s.stat["jobs_other"], 1.0)
+	assert.Equal(t, s.stat["jobs_running_workq"], 3.0)
+	assert.Equal(t, s.stat["jobs_other_long"], 1.0)
+
+	assert.NotNil(t, parseQstatQueues(strings.NewReader("qstat: command not found\n"), newSchedulerStat()))
+}
+
+func TestParsePbsnodes(t *testing.T) {
+	stub := `node01
+     Mom = node01
+     state = free
+     resources_available.ncpus = 16
+     resources_assigned.ncpus = 4
+     queue = workq
+
+node02
+     Mom = node02
+     state = down,offline
+     resources_available.ncpus = 16
+     resources_assigned.ncpus = 0
+
+node03
+     state = job-exclusive
+     np = 8
+`
+	s := newSchedulerStat()
+	assert.Nil(t, parsePbsnodes(strings.NewReader(stub), s))
+	assert.Equal(t, s.stat["nodes_mixed"], 1.0)
+	assert.Equal(t, s.stat["nodes_down"], 1.0)
+	assert.Equal(t, s.stat["nodes_allocated"], 1.0)
+	assert.Equal(t, s.stat["cpus_total"], 40.0)
+	assert.Equal(t, s.stat["cpus_other"], 16.0)
+	assert.Equal(t, s.stat["cpus_allocated"], 4.0+8.0)
+	assert.Equal(t, s.stat["cpus_idle"], 12.0)
+	assert.Equal(t, s.stat["nodes_mixed_workq"], 1.0)
+	assert.Equal(t, len(s.partitions), 1)
+}
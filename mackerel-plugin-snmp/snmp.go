@@ -5,6 +5,7 @@ import (
 	"fmt"
 	"github.com/alouca/gosnmp"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"log"
 	"os"
 	"strconv"
@@ -75,6 +76,9 @@ func main() {
 	optCommunity := flag.String("community", "public", "SNMP V2c Community")
 
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	var snmp SNMPPlugin
@@ -102,7 +106,7 @@ func main() {
 	}
 	snmp.SNMPMetricsSlice = sms
 
-	helper := mp.NewMackerelPlugin(snmp)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(snmp))
 
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
@@ -113,6 +117,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
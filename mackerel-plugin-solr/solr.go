@@ -0,0 +1,363 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// caches of the searcher, of which the hit ratios are reported
+var caches = []string{"queryResultCache", "filterCache", "documentCache"}
+
+type SolrPlugin struct {
+	BaseUrl    string
+	APIVersion string
+	Cores      []string
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// older versions of solr return some numbers as strings
+func toFloat(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case string:
+		f, err := strconv.ParseFloat(n, 64)
+		return f, err == nil
+	}
+	return 0, false
+}
+
+// % curl 'http://localhost:8983/solr/admin/cores?action=STATUS&wt=json'
+// {"status":{"collection1":{"name":"collection1","index":{"numDocs":1000,"maxDoc":1020,"sizeInBytes":1048576,"size":"1 MB",...},...}}}
+type SolrCoreStatus struct {
+	Status map[string]struct {
+		Index struct {
+			NumDocs     float64 `json:"numDocs"`
+			SizeInBytes float64 `json:"sizeInBytes"`
+		} `json:"index"`
+	} `json:"status"`
+}
+
+func parseCoreStatus(r io.Reader) (*SolrCoreStatus, error) {
+	var s SolrCoreStatus
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+	return &s, nil
+}
+
+// % curl 'http://localhost:8983/solr/collection1/admin/mbeans?stats=true&wt=json'
+// {"solr-mbeans":["CORE",{...},"QUERYHANDLER",{"/select":{"stats":{"requests":100,"95thPcRequestTime":12.5,...}},...},
+// "UPDATEHANDLER",{"updateHandler":{"stats":{"commits":10,"optimizes":1,...}}},"CACHE",{"filterCache":{"stats":{"hitratio":0.95,...}},...}]}
+// (categories and their beans alternate in the array)
+func parseMBeans(r io.Reader, prefix string, stat map[string]float64) error {
+	var res struct {
+		MBeans []json.RawMessage `json:"solr-mbeans"`
+	}
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return err
+	}
+
+	for i := 0; i+1 < len(res.MBeans); i += 2 {
+		var category string
+		if err := json.Unmarshal(res.MBeans[i], &category); err != nil {
+			return err
+		}
+		var beans map[string]struct {
+			Stats map[string]interface{} `json:"stats"`
+		}
+		if err := json.Unmarshal(res.MBeans[i+1], &beans); err != nil {
+			return err
+		}
+
+		set := func(bean, key, name string, scale float64) {
+			if v, ok := toFloat(beans[bean].Stats[key]); ok {
+				stat[prefix+name] = v * scale
+			}
+		}
+
+		switch category {
+		case "QUERYHANDLER", "QUERY":
+			set("/select", "requests", "query_requests", 1)
+			set("/select", "95thPcRequestTime", "query_p95", 1)
+			set("/update", "requests", "update_requests", 1)
+		case "UPDATEHANDLER", "UPDATE":
+			set("updateHandler", "commits", "commits", 1)
+			set("updateHandler", "optimizes", "optimizes", 1)
+		case "CACHE":
+			for _, cache := range caches {
+				set(cache, "hitratio", cache+"_hitratio", 100)
+			}
+		}
+	}
+	return nil
+}
+
+// % curl 'http://localhost:8983/solr/admin/metrics?group=core&prefix=QUERY./select.requestTimes,...&wt=json'
+// {"metrics":{"solr.core.collection1":{"QUERY./select.requestTimes":{"count":100,"p95_ms":12.5,...},
+// "UPDATE.updateHandler.commits":{"count":10,...},"CACHE.searcher.filterCache":{"hitratio":0.95,...},...}}}
+var metricsPrefixes = []string{
+	"QUERY./select.requestTimes",
+	"UPDATE./update.requestTimes",
+	"UPDATE.updateHandler.commits",
+	"UPDATE.updateHandler.optimizes",
+	"CACHE.searcher.",
+}
+
+// coreRegistry returns the metrics registry of the core, which is "solr.core.<core>" in the standalone mode,
+// and "solr.core.<collection>.<shard>.<replica>" of the core "<collection>_<shard>_<replica>" in SolrCloud
+func coreRegistry(registries map[string]map[string]map[string]interface{}, core string) (map[string]map[string]interface{}, bool) {
+	if metrics, ok := registries["solr.core."+core]; ok {
+		return metrics, true
+	}
+	for name, metrics := range registries {
+		if !strings.HasPrefix(name, "solr.core.") {
+			continue
+		}
+		if strings.Replace(strings.TrimPrefix(name, "solr.core."), ".", "_", -1) == core {
+			return metrics, true
+		}
+	}
+	return nil, false
+}
+
+func parseMetrics(r io.Reader, cores []string, stat map[string]float64) error {
+	var res struct {
+		Metrics map[string]map[string]map[string]interface{} `json:"metrics"`
+	}
+	if err := json.NewDecoder(r).Decode(&res); err != nil {
+		return err
+	}
+
+	for _, core := range cores {
+		metrics, ok := coreRegistry(res.Metrics, core)
+		if !ok {
+			log.Printf("no metrics registry of core %s", core)
+			continue
+		}
+		prefix := metricName(core) + "_"
+
+		set := func(key, field, name string, scale float64) {
+			if v, ok := toFloat(metrics[key][field]); ok {
+				stat[prefix+name] = v * scale
+			}
+		}
+
+		set("QUERY./select.requestTimes", "count", "query_requests", 1)
+		set("QUERY./select.requestTimes", "p95_ms", "query_p95", 1)
+		set("UPDATE./update.requestTimes", "count", "update_requests", 1)
+		set("UPDATE.updateHandler.commits", "count", "commits", 1)
+		set("UPDATE.updateHandler.optimizes", "count", "optimizes", 1)
+		for _, cache := range caches {
+			set("CACHE.searcher."+cache, "hitratio", cache+"_hitratio", 100)
+		}
+	}
+	return nil
+}
+
+func httpGet(url string) (io.ReadCloser, error) {
+	resp, err := http.Get(url)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("HTTP status error: %d", resp.StatusCode))
+	}
+	return resp.Body, nil
+}
+
+func (p SolrPlugin) fetchCoreStatus() (*SolrCoreStatus, error) {
+	body, err := httpGet(p.BaseUrl + "/admin/cores?action=STATUS&wt=json")
+	if err != nil {
+		return nil, err
+	}
+	defer body.Close()
+	return parseCoreStatus(body)
+}
+
+func (p *SolrPlugin) Prepare() error {
+	if len(p.Cores) > 0 {
+		return nil
+	}
+
+	s, err := p.fetchCoreStatus()
+	if err != nil {
+		return err
+	}
+	for core := range s.Status {
+		p.Cores = append(p.Cores, core)
+	}
+	if len(p.Cores) == 0 {
+		return errors.New("no cores found")
+	}
+	sort.Strings(p.Cores)
+	return nil
+}
+
+func (p SolrPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	s, err := p.fetchCoreStatus()
+	if err != nil {
+		return nil, err
+	}
+	for _, core := range p.Cores {
+		st, ok := s.Status[core]
+		if !ok {
+			continue
+		}
+		prefix := metricName(core) + "_"
+		stat[prefix+"num_docs"] = st.Index.NumDocs
+		stat[prefix+"index_size"] = st.Index.SizeInBytes
+	}
+
+	switch p.APIVersion {
+	case "mbeans":
+		for _, core := range p.Cores {
+			body, err := httpGet(p.BaseUrl + "/" + url.QueryEscape(core) + "/admin/mbeans?stats=true&wt=json&cat=QUERYHANDLER&cat=UPDATEHANDLER&cat=CACHE")
+			if err != nil {
+				return nil, err
+			}
+			err = parseMBeans(body, metricName(core)+"_", stat)
+			body.Close()
+			if err != nil {
+				return nil, err
+			}
+		}
+	case "metrics":
+		body, err := httpGet(p.BaseUrl + "/admin/metrics?group=core&wt=json&prefix=" + url.QueryEscape(strings.Join(metricsPrefixes, ",")))
+		if err != nil {
+			return nil, err
+		}
+		err = parseMetrics(body, p.Cores, stat)
+		body.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return stat, nil
+}
+
+func (p SolrPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphdef := make(map[string](mp.Graphs))
+
+	for _, core := range p.Cores {
+		prefix := metricName(core)
+		label := "Solr " + core + " "
+
+		graphdef["solr."+prefix+".requests"] = mp.Graphs{
+			Label: label + "Requests",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_query_requests", Label: "Query", Diff: true},
+				mp.Metrics{Name: prefix + "_update_requests", Label: "Update", Diff: true},
+			},
+		}
+		graphdef["solr."+prefix+".query_latency"] = mp.Graphs{
+			Label: label + "Query Latency (msec)",
+			Unit:  "float",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_query_p95", Label: "95th Percentile"},
+			},
+		}
+		var ratios [](mp.Metrics)
+		for _, cache := range caches {
+			ratios = append(ratios, mp.Metrics{Name: prefix + "_" + cache + "_hitratio", Label: cache})
+		}
+		graphdef["solr."+prefix+".cache_hit_ratio"] = mp.Graphs{
+			Label:   label + "Cache Hit Ratio",
+			Unit:    "percentage",
+			Metrics: ratios,
+		}
+		graphdef["solr."+prefix+".commits"] = mp.Graphs{
+			Label: label + "Commits",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_commits", Label: "Commits", Diff: true},
+				mp.Metrics{Name: prefix + "_optimizes", Label: "Optimizes", Diff: true},
+			},
+		}
+		graphdef["solr."+prefix+".index_size"] = mp.Graphs{
+			Label: label + "Index Size",
+			Unit:  "bytes",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_index_size", Label: "Size"},
+			},
+		}
+		graphdef["solr."+prefix+".docs"] = mp.Graphs{
+			Label: label + "Documents",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_num_docs", Label: "Documents"},
+			},
+		}
+	}
+
+	return graphdef
+}
+
+func main() {
+	optUrl := flag.String("url", "http://localhost:8983/solr", "Solr base URL")
+	optCore := flag.String("core", "", "Core name (default: all cores)")
+	optAPIVersion := flag.String("api-version", "metrics", "API to fetch statistics: metrics (/admin/metrics of Solr 7+) or mbeans (/admin/mbeans of Solr 4-6)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	if *optAPIVersion != "metrics" && *optAPIVersion != "mbeans" {
+		fmt.Fprintln(os.Stderr, "-api-version should be metrics or mbeans")
+		os.Exit(1)
+	}
+
+	var solr SolrPlugin
+	solr.BaseUrl = strings.TrimRight(*optUrl, "/")
+	solr.APIVersion = *optAPIVersion
+	if *optCore != "" {
+		solr.Cores = []string{*optCore}
+	}
+
+	err := solr.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(solr))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-solr-%s-%s", metricName(*optUrl), metricName(*optCore))
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCoreStatus(t *testing.T) {
+	stub := `{"responseHeader":{"status":0,"QTime":1},"initFailures":{},"status":{"collection1":{"name":"collection1","index":{"numDocs":1000,"maxDoc":1020,"sizeInBytes":1048576,"size":"1 MB"}}}}`
+
+	s, err := parseCoreStatus(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, s.Status["collection1"].Index.NumDocs, 1000.0)
+	assert.Equal(t, s.Status["collection1"].Index.SizeInBytes, 1048576.0)
+}
+
+func TestParseMBeans(t *testing.T) {
+	stub := `{"responseHeader":{"status":0,"QTime":2},"solr-mbeans":[
+"QUERYHANDLER",{"/select":{"class":"org.apache.solr.handler.component.SearchHandler","stats":{"requests":100,"avgTimePerRequest":5.2,"95thPcRequestTime":12.5}},
+"/update":{"class":"org.apache.solr.handler.UpdateRequestHandler","stats":{"requests":"20"}}},
+"UPDATEHANDLER",{"updateHandler":{"class":"org.apache.solr.update.DirectUpdateHandler2","stats":{"commits":10,"optimizes":1}}},
+"CACHE",{"filterCache":{"class":"org.apache.solr.search.FastLRUCache","stats":{"lookups":200,"hits":190,"hitratio":0.95}},
+"queryResultCache":{"class":"org.apache.solr.search.LRUCache","stats":{"hitratio":"0.50"}}}]}`
+
+	stat := make(map[string]float64)
+	err := parseMBeans(strings.NewReader(stub), "collection1_", stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["collection1_query_requests"], 100.0)
+	assert.Equal(t, stat["collection1_query_p95"], 12.5)
+	assert.Equal(t, stat["collection1_update_requests"], 20.0)
+	assert.Equal(t, stat["collection1_commits"], 10.0)
+	assert.Equal(t, stat["collection1_optimizes"], 1.0)
+	assert.Equal(t, stat["collection1_filterCache_hitratio"], 95.0)
+	assert.Equal(t, stat["collection1_queryResultCache_hitratio"], 50.0)
+	_, ok := stat["collection1_documentCache_hitratio"]
+	assert.False(t, ok)
+}
+
+func TestParseMetrics(t *testing.T) {
+	stub := `{"responseHeader":{"status":0,"QTime":3},"metrics":{
+"solr.core.collection1":{
+"CACHE.searcher.documentCache":{"lookups":0,"hits":0,"hitratio":0.0},
+"CACHE.searcher.filterCache":{"lookups":200,"hits":180,"hitratio":0.9},
+"QUERY./select.requestTimes":{"count":300,"meanRate":0.5,"p95_ms":20.25},
+"UPDATE./update.requestTimes":{"count":40},
+"UPDATE.updateHandler.commits":{"count":12}},
+"solr.core.other":{"QUERY./select.requestTimes":{"count":1}}}}`
+
+	stat := make(map[string]float64)
+	err := parseMetrics(strings.NewReader(stub), []string{"collection1"}, stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["collection1_query_requests"], 300.0)
+	assert.Equal(t, stat["collection1_query_p95"], 20.25)
+	assert.Equal(t, stat["collection1_update_requests"], 40.0)
+	assert.Equal(t, stat["collection1_commits"], 12.0)
+	assert.Equal(t, stat["collection1_filterCache_hitratio"], 90.0)
+	assert.Equal(t, stat["collection1_documentCache_hitratio"], 0.0)
+	_, ok := stat["other_query_requests"]
+	assert.False(t, ok)
+}
+
+func TestParseMetricsSolrCloud(t *testing.T) {
+	stub := `{"responseHeader":{"status":0,"QTime":3},"metrics":{
+"solr.core.gettingstarted.shard1.replica_n1":{"QUERY./select.requestTimes":{"count":300,"p95_ms":20.25}},
+"solr.core.gettingstarted.shard2.replica_n4":{"QUERY./select.requestTimes":{"count":100,"p95_ms":10.5}}}}`
+
+	stat := make(map[string]float64)
+	err := parseMetrics(strings.NewReader(stub), []string{"gettingstarted_shard1_replica_n1", "gettingstarted_shard2_replica_n4", "missing"}, stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["gettingstarted_shard1_replica_n1_query_requests"], 300.0)
+	assert.Equal(t, stat["gettingstarted_shard2_replica_n4_query_p95"], 10.5)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	solr := SolrPlugin{Cores: []string{"collection1"}}
+
+	graphs := solr.GraphDefinition()
+	assert.Equal(t, len(graphs), 6)
+	assert.Equal(t, len(graphs["solr.collection1.cache_hit_ratio"].Metrics), 3)
+	assert.Equal(t, graphs["solr.collection1.query_latency"].Metrics[0].Name, "collection1_query_p95")
+}
@@ -0,0 +1,198 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"spamassassin.messages": mp.Graphs{
+		Label: "Mail Filter Messages per sec",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "scanned", Label: "Scanned"},
+			mp.Metrics{Name: "ham", Label: "Ham"},
+			mp.Metrics{Name: "spam", Label: "Spam"},
+		},
+	},
+	"spamassassin.blocked": mp.Graphs{
+		Label: "Mail Filter Blocked Messages per sec",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "blocked", Label: "Blocked"},
+			mp.Metrics{Name: "quarantined", Label: "Quarantined"},
+		},
+	},
+	"spamassassin.scan_time": mp.Graphs{
+		Label: "Mail Filter Scan Time (sec)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "scan_time_avg", Label: "Average"},
+		},
+	},
+}
+
+type SpamAssassinPlugin struct {
+	Log       string
+	StateFile string
+}
+
+type scanResults struct {
+	scanned     float64
+	ham         float64
+	spam        float64
+	blocked     float64
+	quarantined float64
+	scanTime    float64
+	timed       float64
+}
+
+// Oct 14 10:00:00 mx spamd[1234]: spamd: clean message (2.1/5.0) for debian-spamd:110 in 0.8 seconds, 3456 bytes.
+// Oct 14 10:00:01 mx spamd[1234]: spamd: identified spam (12.3/5.0) for debian-spamd:110 in 1.2 seconds, 4567 bytes.
+var spamdLine = regexp.MustCompile(`spamd: (clean message|identified spam) \(.*\) for .* in ([\d.]+) seconds`)
+
+// Oct 14 10:00:02 mx amavis[2345]: (02345-01) Passed CLEAN {RelayedInbound}, [203.0.113.1]:12345 <a@example.com> -> <b@example.org>, ..., Hits: 1.2, size: 3456, queued_as: DEF, 850 ms
+// Oct 14 10:00:03 mx amavis[2345]: (02345-02) Blocked SPAM {DiscardedInbound,Quarantined}, ..., Hits: 12.3, size: 4567, 1200 ms
+// Oct 14 10:00:04 mx amavis[2345]: (02345-03) Blocked INFECTED (Eicar-Signature) {DiscardedInbound,Quarantined}, ..., 310 ms
+var amavisLine = regexp.MustCompile(`amavisd?\[\d+\]: \([\w-]+\) (Passed|Blocked) ([A-Z0-9-]+)(?: \([^)]*\))?(?: \{([^}]*)\})?`)
+
+var amavisElapsed = regexp.MustCompile(`, (\d+) ms$`)
+
+// parseLog counts the results of the scans by spamd and amavis in the log
+func parseLog(r io.Reader) (scanResults, error) {
+	var s scanResults
+
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return s, err
+		}
+		line = strings.TrimRight(line, "\r\n")
+
+		if m := spamdLine.FindStringSubmatch(line); m != nil {
+			s.scanned++
+			if m[1] == "identified spam" {
+				s.spam++
+			} else {
+				s.ham++
+			}
+			if t, err := strconv.ParseFloat(m[2], 64); err == nil {
+				s.scanTime += t
+				s.timed++
+			}
+			continue
+		}
+
+		m := amavisLine.FindStringSubmatch(line)
+		if m == nil {
+			continue
+		}
+		s.scanned++
+		// CLEAN, SPAM, SPAMMY (tagged but delivered), INFECTED, BANNED, BAD-HEADER-n, ...
+		switch m[2] {
+		case "CLEAN":
+			s.ham++
+		case "SPAM", "SPAMMY":
+			s.spam++
+		}
+		if m[1] == "Blocked" {
+			s.blocked++
+		}
+		for _, action := range strings.Split(m[3], ",") {
+			if action == "Quarantined" {
+				s.quarantined++
+				break
+			}
+		}
+		if e := amavisElapsed.FindStringSubmatch(line); e != nil {
+			if ms, err := strconv.ParseFloat(e[1], 64); err == nil {
+				s.scanTime += ms / 1000
+				s.timed++
+			}
+		}
+	}
+	return s, nil
+}
+
+// scanMetrics returns the rates of the counts in the elapsed seconds, and the average scan time
+// which is not defined without the scans
+func scanMetrics(s scanResults, elapsed float64) map[string]float64 {
+	stat := map[string]float64{
+		"scanned":     s.scanned / elapsed,
+		"ham":         s.ham / elapsed,
+		"spam":        s.spam / elapsed,
+		"blocked":     s.blocked / elapsed,
+		"quarantined": s.quarantined / elapsed,
+	}
+	if s.timed > 0 {
+		stat["scan_time_avg"] = s.scanTime / s.timed
+	}
+	return stat
+}
+
+func (p SpamAssassinPlugin) FetchMetrics() (map[string]float64, error) {
+	now := time.Now()
+
+	b, elapsed, ok, err := common.ReadNewLog(p.Log, p.StateFile, now)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		// the first run starts from the end of the log
+		return map[string]float64{}, nil
+	}
+
+	s, err := parseLog(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+
+	return scanMetrics(s, elapsed), nil
+}
+
+func (p SpamAssassinPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optLog := flag.String("log", "/var/log/mail.log", "Path of the log of spamd and/or amavis")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var spamassassin SpamAssassinPlugin
+	spamassassin.Log = *optLog
+
+	tempfile := common.Tempfile("mackerel-plugin-spamassassin", "log")
+	if *optTempfile != "" {
+		tempfile = *optTempfile
+	}
+	// the position in the log is kept beside the values of the last run
+	spamassassin.StateFile = tempfile + ".position"
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(spamassassin))
+	helper.Tempfile = tempfile
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
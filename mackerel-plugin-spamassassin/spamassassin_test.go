@@ -0,0 +1,44 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLog(t *testing.T) {
+	stub := `Oct 14 10:00:00 mx spamd[1234]: spamd: connection from localhost [127.0.0.1]:40000 to port 783, fd 5
+Oct 14 10:00:00 mx spamd[1234]: spamd: processing message <abc@example.com> for debian-spamd:110
+Oct 14 10:00:00 mx spamd[1234]: spamd: clean message (2.1/5.0) for debian-spamd:110 in 0.8 seconds, 3456 bytes.
+Oct 14 10:00:00 mx spamd[1234]: spamd: result: . 2 - BAYES_00,HTML_MESSAGE scantime=0.8,size=3456
+Oct 14 10:00:01 mx spamd[1234]: spamd: identified spam (12.3/5.0) for debian-spamd:110 in 1.2 seconds, 4567 bytes.
+Oct 14 10:00:02 mx amavis[2345]: (02345-01) Passed CLEAN {RelayedInbound}, [203.0.113.1]:12345 <a@example.com> -> <b@example.org>, Queue-ID: ABC123, Message-ID: <m1@example.com>, mail_id: x1, Hits: 1.2, size: 3456, queued_as: DEF456, 850 ms
+Oct 14 10:00:03 mx amavis[2345]: (02345-02) Blocked SPAM {DiscardedInbound,Quarantined}, [198.51.100.2]:23456 <s@example.net> -> <b@example.org>, quarantine: spam-x2, Queue-ID: GHI789, mail_id: x2, Hits: 15.4, size: 4567, 1200 ms
+Oct 14 10:00:04 mx amavis[2345]: (02345-03) Blocked INFECTED (Eicar-Signature) {DiscardedInbound,Quarantined}, [198.51.100.3]:34567 <v@example.net> -> <b@example.org>, quarantine: virus-x3, mail_id: x3, Hits: -, size: 789, 350 ms
+Oct 14 10:00:05 mx amavis[2345]: (02345-04) Passed SPAMMY {RelayedTaggedInbound}, [198.51.100.4]:45678 <t@example.net> -> <b@example.org>, mail_id: x4, Hits: 6.1, size: 1234, queued_as: JKL012, 600 ms
+Oct 14 10:00:06 mx postfix/smtp[3456]: ABC123: to=<b@example.org>, relay=127.0.0.1[127.0.0.1]:10024, status=sent (250 2.0.0 Ok)
+`
+
+	s, err := parseLog(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.EqualValues(t, s.scanned, 6)
+	assert.EqualValues(t, s.ham, 2)
+	assert.EqualValues(t, s.spam, 3)
+	assert.EqualValues(t, s.blocked, 2)
+	assert.EqualValues(t, s.quarantined, 2)
+	assert.EqualValues(t, s.timed, 6)
+	assert.InDelta(t, s.scanTime, 0.8+1.2+0.85+1.2+0.35+0.6, 0.0001)
+
+	stat := scanMetrics(s, 60)
+	assert.InDelta(t, stat["scanned"], 6.0/60, 0.0001)
+	assert.InDelta(t, stat["blocked"], 2.0/60, 0.0001)
+	assert.InDelta(t, stat["scan_time_avg"], 5.0/6, 0.0001)
+}
+
+func TestScanMetricsIdle(t *testing.T) {
+	stat := scanMetrics(scanResults{}, 60)
+	assert.Equal(t, stat["scanned"], 0.0)
+	_, ok := stat["scan_time_avg"]
+	assert.False(t, ok)
+}
@@ -0,0 +1,187 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+
+	_ "github.com/lib/pq"
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+	_ "github.com/ziutek/mymysql/godrv"
+)
+
+// -driver mapped to the names of database/sql drivers
+var drivers map[string]string = map[string]string{
+	"mysql":    "mymysql",
+	"postgres": "postgres",
+}
+
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+type SQLQuery struct {
+	Name  string
+	Query string
+	Diff  bool
+}
+
+type SQLCountPlugin struct {
+	Driver  string
+	DSN     string
+	Queries []SQLQuery
+}
+
+var validName = regexp.MustCompile("^[-a-zA-Z0-9_]+$")
+
+// parse "pending_orders=SELECT COUNT(*) FROM orders WHERE status = 'pending'"
+func parseQuerySpec(spec string) (SQLQuery, error) {
+	kv := strings.SplitN(spec, "=", 2)
+	if len(kv) != 2 || strings.TrimSpace(kv[1]) == "" {
+		return SQLQuery{}, errors.New(fmt.Sprintf("invalid query (must be <name>=<query>): %s", spec))
+	}
+
+	name := strings.TrimSpace(kv[0])
+	if !validName.MatchString(name) {
+		return SQLQuery{}, errors.New(fmt.Sprintf("invalid query name (must consist of [-a-zA-Z0-9_]): %s", name))
+	}
+
+	return SQLQuery{Name: name, Query: strings.TrimSpace(kv[1])}, nil
+}
+
+func parseQueries(specs []string, diffs []string) ([]SQLQuery, error) {
+	queries := make([]SQLQuery, 0, len(specs))
+	for _, spec := range specs {
+		q, err := parseQuerySpec(spec)
+		if err != nil {
+			return nil, err
+		}
+		queries = append(queries, q)
+	}
+
+	for _, d := range diffs {
+		for _, name := range strings.Split(d, ",") {
+			found := false
+			for i := range queries {
+				if queries[i].Name == name {
+					queries[i].Diff = true
+					found = true
+				}
+			}
+			if !found {
+				return nil, errors.New("-diff for an undefined query: " + name)
+			}
+		}
+	}
+
+	return queries, nil
+}
+
+func (p SQLCountPlugin) FetchMetrics() (map[string]float64, error) {
+	db, err := sql.Open(drivers[p.Driver], p.DSN)
+	if err != nil {
+		return nil, err
+	}
+	defer db.Close()
+
+	stat := make(map[string]float64)
+	for _, q := range p.Queries {
+		var v sql.NullFloat64
+		if err := db.QueryRow(q.Query).Scan(&v); err != nil {
+			common.LogFetchError(q.Name, err)
+			continue
+		}
+		if !v.Valid {
+			log.Printf("%s: the result is NULL", q.Name)
+			continue
+		}
+		stat[q.Name] = v.Float64
+	}
+
+	if len(stat) == 0 {
+		return nil, errors.New("no queries succeeded")
+	}
+
+	return stat, nil
+}
+
+func (p SQLCountPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphdef := make(map[string](mp.Graphs))
+
+	for _, q := range p.Queries {
+		graphdef["sql_count."+q.Name] = mp.Graphs{
+			Label: "SQL " + q.Name,
+			Unit:  "float",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: q.Name, Label: q.Name, Diff: q.Diff},
+			},
+		}
+	}
+
+	return graphdef
+}
+
+func main() {
+	optDriver := flag.String("driver", "mysql", "Database driver (mysql or postgres)")
+	optDSN := flag.String("dsn", "", "Data source name of the driver")
+	var optQueries, optDiffs stringSlice
+	flag.Var(&optQueries, "query", "Query which returns a single number and its metric name (<name>=<query>), can be specified multiple times")
+	flag.Var(&optDiffs, "diff", "Name of the query whose result is a counter (shown as the difference from the last run), can be specified multiple times")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	if _, ok := drivers[*optDriver]; !ok {
+		fmt.Fprintln(os.Stderr, "unknown driver: "+*optDriver)
+		os.Exit(1)
+	}
+	if *optDSN == "" || len(optQueries) == 0 {
+		fmt.Fprintln(os.Stderr, "-dsn and -query are required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	queries, err := parseQueries(optQueries, optDiffs)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var sqlcount SQLCountPlugin
+	sqlcount.Driver = *optDriver
+	sqlcount.DSN = *optDSN
+	sqlcount.Queries = queries
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(sqlcount))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		names := make([]string, 0, len(queries))
+		for _, q := range queries {
+			names = append(names, q.Name)
+		}
+		helper.Tempfile = common.Tempfile("mackerel-plugin-sql-count-"+strings.Join(names, "-"), "dsn")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
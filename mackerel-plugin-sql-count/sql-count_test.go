@@ -0,0 +1,39 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseQuerySpec(t *testing.T) {
+	q, err := parseQuerySpec("pending_orders=SELECT COUNT(*) FROM orders WHERE status = 'pending'")
+	assert.Nil(t, err)
+	assert.Equal(t, q.Name, "pending_orders")
+	assert.Equal(t, q.Query, "SELECT COUNT(*) FROM orders WHERE status = 'pending'")
+	assert.False(t, q.Diff)
+
+	// '=' in the query
+	q, err = parseQuerySpec("jobs=SELECT COUNT(*) FROM jobs WHERE state=1")
+	assert.Nil(t, err)
+	assert.Equal(t, q.Query, "SELECT COUNT(*) FROM jobs WHERE state=1")
+
+	_, err = parseQuerySpec("SELECT 1")
+	assert.NotNil(t, err)
+	_, err = parseQuerySpec("orders.count=SELECT 1")
+	assert.NotNil(t, err)
+	_, err = parseQuerySpec("empty=")
+	assert.NotNil(t, err)
+}
+
+func TestParseQueries(t *testing.T) {
+	queries, err := parseQueries([]string{"pending=SELECT 1", "orders=SELECT MAX(id) FROM orders", "users=SELECT 2"}, []string{"orders,users"})
+	assert.Nil(t, err)
+	assert.Equal(t, len(queries), 3)
+	assert.False(t, queries[0].Diff)
+	assert.True(t, queries[1].Diff)
+	assert.True(t, queries[2].Diff)
+
+	_, err = parseQueries([]string{"pending=SELECT 1"}, []string{"orders"})
+	assert.NotNil(t, err)
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"net"
 	"os"
 	"strconv"
@@ -31,11 +32,12 @@ var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
 
 type SquidPlugin struct {
 	Target   string
+	Network  string
 	Tempfile string
 }
 
 func (m SquidPlugin) FetchMetrics() (map[string]float64, error) {
-	conn, err := net.Dial("tcp", m.Target)
+	conn, err := net.Dial(m.Network, m.Target)
 	if err != nil {
 		return nil, err
 	}
@@ -76,12 +78,22 @@ func (m SquidPlugin) GraphDefinition() map[string](mp.Graphs) {
 func main() {
 	optHost := flag.String("host", "localhost", "Hostname")
 	optPort := flag.String("port", "3128", "Port")
+	optNetwork := common.NetworkFlag()
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
+	if err := common.CheckNetwork(*optNetwork); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
 	var squid SquidPlugin
-	squid.Target = fmt.Sprintf("%s:%s", *optHost, *optPort)
-	helper := mp.NewMackerelPlugin(squid)
+	squid.Target = common.HostPort(*optHost, *optPort)
+	squid.Network = *optNetwork
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(squid))
 
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
@@ -92,6 +104,7 @@ func main() {
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
@@ -0,0 +1,384 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+// process states of supervisord, which are reported as they are
+// http://supervisord.org/subprocess.html#process-states
+var processStates map[string]float64 = map[string]float64{
+	"STOPPED":  0,
+	"STARTING": 10,
+	"RUNNING":  20,
+	"BACKOFF":  30,
+	"STOPPING": 40,
+	"EXITED":   100,
+	"FATAL":    200,
+	"UNKNOWN":  1000,
+}
+
+// process states on a scale where RUNNING is 1 and FATAL is -1, so that "< 1" means not running
+// and "< 0" means failing (e.g. restarting in a loop)
+var processStatuses map[string]float64 = map[string]float64{
+	"RUNNING":  1,
+	"STARTING": 0.5,
+	"STOPPING": 0,
+	"STOPPED":  0,
+	"EXITED":   0,
+	"BACKOFF":  -0.5,
+	"FATAL":    -1,
+	"UNKNOWN":  -1,
+}
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"supervisord.processes": mp.Graphs{
+		Label: "Supervisord Processes",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "running", Label: "Running", Stacked: true},
+			mp.Metrics{Name: "starting", Label: "Starting", Stacked: true},
+			mp.Metrics{Name: "backoff", Label: "Backoff", Stacked: true},
+			mp.Metrics{Name: "stopping", Label: "Stopping", Stacked: true},
+			mp.Metrics{Name: "stopped", Label: "Stopped", Stacked: true},
+			mp.Metrics{Name: "exited", Label: "Exited", Stacked: true},
+			mp.Metrics{Name: "fatal", Label: "Fatal", Stacked: true},
+			mp.Metrics{Name: "unknown", Label: "Unknown", Stacked: true},
+		},
+	},
+	"supervisord.fatal": mp.Graphs{
+		Label: "Supervisord Fatal Processes",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "fatal", Label: "Fatal"},
+		},
+	},
+	"supervisord.started_processes": mp.Graphs{
+		Label: "Supervisord Processes Started since Last Run",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "started", Label: "Started"},
+		},
+	},
+
+	// "supervisord.state", "supervisord.status", "supervisord.uptime", "supervisord.started" will be generated dynamically
+}
+
+type processInfo struct {
+	Name      string
+	Group     string
+	StateName string
+	Start     float64
+	Now       float64
+}
+
+// FullName is the name used by supervisorctl
+func (p processInfo) FullName() string {
+	if p.Group == "" || p.Group == p.Name {
+		return p.Name
+	}
+	return p.Group + ":" + p.Name
+}
+
+type SupervisordPlugin struct {
+	Url       string
+	Socket    string
+	Username  string
+	Password  string
+	Tempfile  string
+	Processes []string
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// XML-RPC values, of which only the types returned by getAllProcessInfo are handled
+type xmlrpcValue struct {
+	Int     *string        `xml:"int"`
+	I4      *string        `xml:"i4"`
+	String  *string        `xml:"string"`
+	Members []xmlrpcMember `xml:"struct>member"`
+	Values  []xmlrpcValue  `xml:"array>data>value"`
+	Text    string         `xml:",chardata"`
+}
+
+type xmlrpcMember struct {
+	Name  string      `xml:"name"`
+	Value xmlrpcValue `xml:"value"`
+}
+
+func (v xmlrpcValue) str() string {
+	switch {
+	case v.String != nil:
+		return *v.String
+	case v.Int != nil:
+		return *v.Int
+	case v.I4 != nil:
+		return *v.I4
+	}
+	// a value without type is a string
+	return strings.TrimSpace(v.Text)
+}
+
+func (v xmlrpcValue) member(name string) xmlrpcValue {
+	for _, m := range v.Members {
+		if m.Name == name {
+			return m.Value
+		}
+	}
+	return xmlrpcValue{}
+}
+
+type xmlrpcResponse struct {
+	Params []xmlrpcValue `xml:"params>param>value"`
+	Fault  *xmlrpcValue  `xml:"fault>value"`
+}
+
+// <?xml version='1.0'?>
+// <methodResponse><params><param><value><array><data>
+// <value><struct>
+// <member><name>name</name><value><string>web</string></value></member>
+// <member><name>statename</name><value><string>RUNNING</string></value></member>
+// ...
+// </struct></value>
+// </data></array></value></param></params></methodResponse>
+func parseProcessInfo(r io.Reader) ([]processInfo, error) {
+	var res xmlrpcResponse
+	if err := xml.NewDecoder(r).Decode(&res); err != nil {
+		return nil, err
+	}
+	if res.Fault != nil {
+		return nil, errors.New(fmt.Sprintf("XML-RPC fault %s: %s", res.Fault.member("faultCode").str(), res.Fault.member("faultString").str()))
+	}
+	if len(res.Params) == 0 {
+		return nil, errors.New("no values in XML-RPC response")
+	}
+
+	var procs []processInfo
+	for _, v := range res.Params[0].Values {
+		proc := processInfo{
+			Name:      v.member("name").str(),
+			Group:     v.member("group").str(),
+			StateName: v.member("statename").str(),
+		}
+		proc.Start, _ = strconv.ParseFloat(v.member("start").str(), 64)
+		proc.Now, _ = strconv.ParseFloat(v.member("now").str(), 64)
+		procs = append(procs, proc)
+	}
+	return procs, nil
+}
+
+const getAllProcessInfo = `<?xml version="1.0"?>
+<methodCall><methodName>supervisor.getAllProcessInfo</methodName><params></params></methodCall>
+`
+
+func (p SupervisordPlugin) client() *http.Client {
+	if p.Socket == "" {
+		return &http.Client{Timeout: 10 * time.Second}
+	}
+	return &http.Client{
+		Timeout: 10 * time.Second,
+		Transport: &http.Transport{
+			Dial: func(network, addr string) (net.Conn, error) {
+				return net.Dial("unix", p.Socket)
+			},
+		},
+	}
+}
+
+func (p SupervisordPlugin) fetchProcessInfo() ([]processInfo, error) {
+	url := p.Url
+	if p.Socket != "" {
+		// the host is ignored with the unix socket
+		url = "http://localhost/RPC2"
+	}
+
+	req, err := http.NewRequest("POST", url, bytes.NewBufferString(getAllProcessInfo))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "text/xml")
+	if p.Username != "" {
+		req.SetBasicAuth(p.Username, p.Password)
+	}
+
+	resp, err := p.client().Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("HTTP status error: %d", resp.StatusCode))
+	}
+
+	return parseProcessInfo(resp.Body)
+}
+
+// setProcessMetrics sets the metrics of the processes. the processes started after lastTime
+// (the last run) are counted as started, which catches restart loops between the runs.
+// lastTime is 0 at the first run, when they are not counted
+func setProcessMetrics(procs []processInfo, stat map[string]float64, lastTime float64) {
+	for name := range processStates {
+		stat[strings.ToLower(name)] = 0
+	}
+	if lastTime > 0 {
+		stat["started"] = 0
+	}
+
+	for _, proc := range procs {
+		state, ok := processStates[proc.StateName]
+		if !ok {
+			proc.StateName = "UNKNOWN"
+			state = processStates["UNKNOWN"]
+		}
+		stat[strings.ToLower(proc.StateName)]++
+
+		prefix := metricName(proc.FullName()) + "_"
+		stat[prefix+"state"] = state
+		stat[prefix+"status"] = processStatuses[proc.StateName]
+		if lastTime > 0 {
+			if proc.Start > lastTime {
+				stat[prefix+"started"] = 1
+				stat["started"]++
+			} else {
+				stat[prefix+"started"] = 0
+			}
+		}
+		if proc.StateName == "RUNNING" && proc.Start > 0 {
+			stat[prefix+"uptime"] = proc.Now - proc.Start
+		} else {
+			stat[prefix+"uptime"] = 0
+		}
+	}
+}
+
+func (p *SupervisordPlugin) Prepare() error {
+	procs, err := p.fetchProcessInfo()
+	if err != nil {
+		return err
+	}
+	for _, proc := range procs {
+		p.Processes = append(p.Processes, proc.FullName())
+	}
+	return nil
+}
+
+func (p SupervisordPlugin) FetchMetrics() (map[string]float64, error) {
+	procs, err := p.fetchProcessInfo()
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	setProcessMetrics(procs, stat, common.LastValues(p.Tempfile)["_lastTime"])
+
+	return stat, nil
+}
+
+func (p SupervisordPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), len(graphdef)+4)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	for _, grp := range [...]string{"supervisord.state", "supervisord.status", "supervisord.uptime", "supervisord.started"} {
+		var name_suf string
+		var label string
+		var unit string
+		switch grp {
+		case "supervisord.state":
+			name_suf = "_state"
+			label = "Supervisord Process State (20:RUNNING, 200:FATAL)"
+			unit = "integer"
+		case "supervisord.status":
+			name_suf = "_status"
+			label = "Supervisord Process Status (1:RUNNING, -1:FATAL)"
+			unit = "float"
+		case "supervisord.uptime":
+			name_suf = "_uptime"
+			label = "Supervisord Process Uptime (sec)"
+			unit = "float"
+		case "supervisord.started":
+			name_suf = "_started"
+			label = "Supervisord Process Started since Last Run"
+			unit = "integer"
+		}
+
+		var metrics [](mp.Metrics)
+		for _, name := range p.Processes {
+			metrics = append(metrics, mp.Metrics{Name: metricName(name) + name_suf, Label: name})
+		}
+		if len(metrics) == 0 {
+			continue
+		}
+		graphs[grp] = mp.Graphs{
+			Label:   label,
+			Unit:    unit,
+			Metrics: metrics,
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optUrl := flag.String("url", "http://localhost:9001/RPC2", "XML-RPC URL of supervisord (inet_http_server), or unix:///path/to/supervisor.sock (unix_http_server)")
+	optSocket := flag.String("socket", "", "Unix socket of supervisord (unix_http_server), used instead of -url")
+	optUsername := flag.String("username", "", "Username")
+	optPassword := flag.String("password", "", "Password")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var supervisord SupervisordPlugin
+	supervisord.Url = *optUrl
+	supervisord.Socket = *optSocket
+	if strings.HasPrefix(*optUrl, "unix://") && *optSocket == "" {
+		supervisord.Socket = strings.TrimPrefix(*optUrl, "unix://")
+	}
+	supervisord.Username = *optUsername
+	supervisord.Password = *optPassword
+
+	supervisord.Tempfile = common.Tempfile("mackerel-plugin-supervisord", "url", "socket")
+	if *optTempfile != "" {
+		supervisord.Tempfile = *optTempfile
+	}
+
+	err := supervisord.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(supervisord))
+	helper.Tempfile = supervisord.Tempfile
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
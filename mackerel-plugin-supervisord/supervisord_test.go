@@ -0,0 +1,82 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func processStruct(name, group, statename, start, now string) string {
+	return "<value><struct>" +
+		"<member><name>name</name><value><string>" + name + "</string></value></member>" +
+		"<member><name>group</name><value><string>" + group + "</string></value></member>" +
+		"<member><name>statename</name><value><string>" + statename + "</string></value></member>" +
+		"<member><name>start</name><value><int>" + start + "</int></value></member>" +
+		"<member><name>now</name><value><int>" + now + "</int></value></member>" +
+		"<member><name>description</name><value>pid 1234, uptime 0:10:00</value></member>" +
+		"</struct></value>\n"
+}
+
+func TestParseProcessInfo(t *testing.T) {
+	stub := "<?xml version='1.0'?>\n<methodResponse>\n<params>\n<param>\n<value><array><data>\n" +
+		processStruct("web", "web", "RUNNING", "1420070400", "1420071000") +
+		processStruct("worker_00", "workers", "FATAL", "0", "1420071000") +
+		"</data></array></value>\n</param>\n</params>\n</methodResponse>\n"
+
+	procs, err := parseProcessInfo(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, len(procs), 2)
+	assert.Equal(t, procs[0].FullName(), "web")
+	assert.Equal(t, procs[0].StateName, "RUNNING")
+	assert.Equal(t, procs[0].Now-procs[0].Start, 600.0)
+	assert.Equal(t, procs[1].FullName(), "workers:worker_00")
+
+	stat := make(map[string]float64)
+	setProcessMetrics(procs, stat, 0)
+	assert.Equal(t, stat["running"], 1.0)
+	assert.Equal(t, stat["fatal"], 1.0)
+	assert.Equal(t, stat["stopped"], 0.0)
+	assert.Equal(t, stat["web_state"], 20.0)
+	assert.Equal(t, stat["web_uptime"], 600.0)
+	assert.Equal(t, stat["workers_worker_00_state"], 200.0)
+	assert.Equal(t, stat["workers_worker_00_uptime"], 0.0)
+	assert.Equal(t, stat["web_status"], 1.0)
+	assert.Equal(t, stat["workers_worker_00_status"], -1.0)
+	_, ok := stat["web_started"]
+	assert.False(t, ok, "not counted at the first run")
+
+	stat = make(map[string]float64)
+	setProcessMetrics(procs, stat, 1420070000)
+	assert.Equal(t, stat["web_started"], 1.0)
+	assert.Equal(t, stat["workers_worker_00_started"], 0.0)
+	assert.Equal(t, stat["started"], 1.0)
+
+	stat = make(map[string]float64)
+	setProcessMetrics(procs, stat, 1420070940)
+	assert.Equal(t, stat["web_started"], 0.0)
+	assert.Equal(t, stat["started"], 0.0)
+}
+
+func TestParseProcessInfoFault(t *testing.T) {
+	stub := "<?xml version='1.0'?>\n<methodResponse>\n<fault>\n<value><struct>\n" +
+		"<member>\n<name>faultCode</name>\n<value><int>1</int></value>\n</member>\n" +
+		"<member>\n<name>faultString</name>\n<value><string>UNKNOWN_METHOD</string></value>\n</member>\n" +
+		"</struct></value>\n</fault>\n</methodResponse>\n"
+
+	_, err := parseProcessInfo(strings.NewReader(stub))
+	assert.Equal(t, err.Error(), "XML-RPC fault 1: UNKNOWN_METHOD")
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var supervisord SupervisordPlugin
+	_, ok := supervisord.GraphDefinition()["supervisord.state"]
+	assert.False(t, ok)
+
+	supervisord.Processes = []string{"web", "workers:worker_00"}
+	graphs := supervisord.GraphDefinition()
+	assert.Equal(t, graphs["supervisord.state"].Metrics[1].Name, "workers_worker_00_state")
+	assert.Equal(t, graphs["supervisord.uptime"].Metrics[0].Name, "web_uptime")
+	assert.Equal(t, graphs["supervisord.status"].Metrics[0].Name, "web_status")
+	assert.Equal(t, graphs["supervisord.started"].Metrics[1].Name, "workers_worker_00_started")
+}
@@ -0,0 +1,296 @@
+package main
+
+import (
+	"encoding/xml"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"regexp"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"tomcat.jvm_memory": mp.Graphs{
+		Label: "Tomcat JVM Memory",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "memory_used", Label: "Used"},
+			mp.Metrics{Name: "memory_total", Label: "Total"},
+			mp.Metrics{Name: "memory_max", Label: "Max"},
+		},
+	},
+	"tomcat.jvm_heap": mp.Graphs{
+		Label: "Tomcat JVM Heap",
+		Unit:  "bytes",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "heap_used", Label: "Used"},
+			mp.Metrics{Name: "heap_committed", Label: "Committed"},
+			mp.Metrics{Name: "heap_max", Label: "Max"},
+		},
+	},
+
+	// "tomcat.<connector>.*" will be generated dynamically
+}
+
+// % curl -u user:pass 'http://localhost:8080/manager/status?XML=true'
+// <status><jvm><memory free='...' total='...' max='...'/>
+// <memorypool name='PS Eden Space' type='Heap memory' usageInit='...' usageCommitted='...' usageMax='...' usageUsed='...'/>...</jvm>
+// <connector name='"http-nio-8080"'><threadInfo maxThreads="200" currentThreadCount="10" currentThreadsBusy="1" />
+// <requestInfo maxTime="20" processingTime="50" requestCount="10" errorCount="1" bytesReceived="0" bytesSent="1024" />
+// <workers>...</workers></connector></status>
+type status struct {
+	JVM struct {
+		Memory struct {
+			Free  float64 `xml:"free,attr"`
+			Total float64 `xml:"total,attr"`
+			Max   float64 `xml:"max,attr"`
+		} `xml:"memory"`
+		MemoryPools []struct {
+			Type           string  `xml:"type,attr"`
+			UsageCommitted float64 `xml:"usageCommitted,attr"`
+			UsageMax       float64 `xml:"usageMax,attr"`
+			UsageUsed      float64 `xml:"usageUsed,attr"`
+		} `xml:"memorypool"`
+	} `xml:"jvm"`
+	Connectors []struct {
+		Name       string `xml:"name,attr"`
+		ThreadInfo struct {
+			MaxThreads         float64 `xml:"maxThreads,attr"`
+			CurrentThreadCount float64 `xml:"currentThreadCount,attr"`
+			CurrentThreadsBusy float64 `xml:"currentThreadsBusy,attr"`
+		} `xml:"threadInfo"`
+		RequestInfo struct {
+			ProcessingTime float64 `xml:"processingTime,attr"`
+			RequestCount   float64 `xml:"requestCount,attr"`
+			ErrorCount     float64 `xml:"errorCount,attr"`
+			BytesReceived  float64 `xml:"bytesReceived,attr"`
+			BytesSent      float64 `xml:"bytesSent,attr"`
+		} `xml:"requestInfo"`
+	} `xml:"connector"`
+}
+
+type TomcatPlugin struct {
+	Url        string
+	User       string
+	Password   string
+	Connectors []string
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+// connectorName strips the quotes, which Tomcat 7 or later puts around the name
+func connectorName(name string) string {
+	return strings.Trim(name, `"`)
+}
+
+func parseStatus(r io.Reader, stat map[string]float64) ([]string, error) {
+	var s status
+	if err := xml.NewDecoder(r).Decode(&s); err != nil {
+		return nil, err
+	}
+
+	stat["memory_used"] = s.JVM.Memory.Total - s.JVM.Memory.Free
+	stat["memory_total"] = s.JVM.Memory.Total
+	stat["memory_max"] = s.JVM.Memory.Max
+
+	// the pools of the heap (e.g. Eden, Survivor and Old Gen), whose max is -1 when undefined
+	if len(s.JVM.MemoryPools) > 0 {
+		var used, committed, max float64
+		for _, pool := range s.JVM.MemoryPools {
+			if pool.Type != "Heap memory" {
+				continue
+			}
+			used += pool.UsageUsed
+			committed += pool.UsageCommitted
+			if pool.UsageMax > 0 {
+				max += pool.UsageMax
+			}
+		}
+		stat["heap_used"] = used
+		stat["heap_committed"] = committed
+		if max > 0 {
+			stat["heap_max"] = max
+		}
+	}
+
+	connectors := make([]string, 0, len(s.Connectors))
+	for _, c := range s.Connectors {
+		name := connectorName(c.Name)
+		connectors = append(connectors, name)
+
+		prefix := metricName(name) + "_"
+		stat[prefix+"request_count"] = c.RequestInfo.RequestCount
+		stat[prefix+"error_count"] = c.RequestInfo.ErrorCount
+		stat[prefix+"processing_time"] = c.RequestInfo.ProcessingTime
+		stat[prefix+"bytes_received"] = c.RequestInfo.BytesReceived
+		stat[prefix+"bytes_sent"] = c.RequestInfo.BytesSent
+		// the threads are -1 when the connector uses a shared executor (Tomcat 7)
+		if c.ThreadInfo.MaxThreads < 0 {
+			continue
+		}
+		stat[prefix+"threads_busy"] = c.ThreadInfo.CurrentThreadsBusy
+		stat[prefix+"threads_current"] = c.ThreadInfo.CurrentThreadCount
+		stat[prefix+"threads_max"] = c.ThreadInfo.MaxThreads
+		if v, ok := busyPercentage(c.ThreadInfo.CurrentThreadsBusy, c.ThreadInfo.MaxThreads); ok {
+			stat[prefix+"threads_busy_percentage"] = v
+		}
+	}
+
+	return connectors, nil
+}
+
+// busyPercentage returns the percentage of the busy threads in the max threads, which reaches 100
+// when the thread pool is exhausted. It is not defined (false) without the max threads.
+func busyPercentage(busy, max float64) (float64, bool) {
+	if max <= 0 {
+		return 0, false
+	}
+	return busy / max * 100, true
+}
+
+func (p TomcatPlugin) fetchStatus(stat map[string]float64) ([]string, error) {
+	req, err := http.NewRequest("GET", p.Url, nil)
+	if err != nil {
+		return nil, err
+	}
+	if p.User != "" {
+		req.SetBasicAuth(p.User, p.Password)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	// the user needs the manager-status (or manager-gui) role
+	if resp.StatusCode != http.StatusOK {
+		return nil, errors.New(fmt.Sprintf("HTTP status error: %d", resp.StatusCode))
+	}
+
+	return parseStatus(resp.Body, stat)
+}
+
+func (p *TomcatPlugin) Prepare() error {
+	connectors, err := p.fetchStatus(make(map[string]float64))
+	if err != nil {
+		return err
+	}
+	p.Connectors = connectors
+	return nil
+}
+
+func (p TomcatPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+	if _, err := p.fetchStatus(stat); err != nil {
+		return nil, err
+	}
+	return stat, nil
+}
+
+func (p TomcatPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := make(map[string](mp.Graphs), len(graphdef)+5*len(p.Connectors))
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+
+	for _, c := range p.Connectors {
+		prefix := metricName(c)
+		label := "Tomcat " + c + " "
+
+		graphs["tomcat."+prefix+".requests"] = mp.Graphs{
+			Label: label + "Requests",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_request_count", Label: "Requests", Diff: true},
+				mp.Metrics{Name: prefix + "_error_count", Label: "Errors", Diff: true},
+			},
+		}
+		graphs["tomcat."+prefix+".processing_time"] = mp.Graphs{
+			Label: label + "Processing Time (msec)",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_processing_time", Label: "Processing Time", Diff: true},
+			},
+		}
+		graphs["tomcat."+prefix+".traffic"] = mp.Graphs{
+			Label: label + "Traffic",
+			Unit:  "bytes",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_bytes_received", Label: "Received", Diff: true},
+				mp.Metrics{Name: prefix + "_bytes_sent", Label: "Sent", Diff: true},
+			},
+		}
+		graphs["tomcat."+prefix+".threads"] = mp.Graphs{
+			Label: label + "Threads",
+			Unit:  "integer",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_threads_busy", Label: "Busy"},
+				mp.Metrics{Name: prefix + "_threads_current", Label: "Current"},
+				mp.Metrics{Name: prefix + "_threads_max", Label: "Max"},
+			},
+		}
+		graphs["tomcat."+prefix+".threads_busy_percentage"] = mp.Graphs{
+			Label: label + "Busy Threads Percentage",
+			Unit:  "percentage",
+			Metrics: [](mp.Metrics){
+				mp.Metrics{Name: prefix + "_threads_busy_percentage", Label: "Busy / Max"},
+			},
+		}
+	}
+
+	return graphs
+}
+
+func main() {
+	optUrl := flag.String("url", "", "URL of the server status of the manager app, used instead of -host and -port (e.g. https://localhost:8443/manager/status?XML=true)")
+	optHost := flag.String("host", "localhost", "Hostname")
+	optPort := flag.String("port", "8080", "Port")
+	optUser := flag.String("user", "", "Username of the manager app")
+	optPassword := flag.String("password", "", "Password of the manager app")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var tomcat TomcatPlugin
+	tomcat.Url = "http://" + common.HostPort(*optHost, *optPort) + "/manager/status?XML=true"
+	if *optUrl != "" {
+		tomcat.Url = *optUrl
+	}
+	tomcat.User = *optUser
+	tomcat.Password = *optPassword
+
+	err := tomcat.Prepare()
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(tomcat))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile(fmt.Sprintf("mackerel-plugin-tomcat-%s-%s", *optHost, *optPort), "url")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+var statusXML = `<?xml version="1.0" encoding="utf-8"?><?xml-stylesheet type="text/xsl" href="/manager/xform.xsl" ?>
+<status><jvm><memory free='100663296' total='268435456' max='536870912'/><memorypool name='PS Eden Space' type='Heap memory' usageInit='0' usageCommitted='67108864' usageMax='134217728' usageUsed='33554432'/>` +
+	`<memorypool name='PS Old Gen' type='Heap memory' usageInit='0' usageCommitted='134217728' usageMax='402653184' usageUsed='100663296'/>` +
+	`<memorypool name='Metaspace' type='Non-heap memory' usageInit='0' usageCommitted='50331648' usageMax='-1' usageUsed='47185920'/></jvm>` +
+	`<connector name='"http-nio-8080"'><threadInfo  maxThreads="200" currentThreadCount="10" currentThreadsBusy="2" />` +
+	`<requestInfo  maxTime="120" processingTime="3456" requestCount="789" errorCount="12" bytesReceived="2048" bytesSent="65536" />` +
+	`<workers><worker  stage="S" requestProcessingTime="1" requestBytesSent="0" requestBytesReceived="0" remoteAddr="127.0.0.1" virtualHost="localhost" method="GET" currentUri="/manager/status" currentQueryString="XML=true" protocol="HTTP/1.1" /></workers></connector>` +
+	`<connector name='"ajp-nio-8009"'><threadInfo  maxThreads="200" currentThreadCount="0" currentThreadsBusy="0" />` +
+	`<requestInfo  maxTime="0" processingTime="0" requestCount="0" errorCount="0" bytesReceived="0" bytesSent="0" /><workers></workers></connector>` +
+	`<connector name="http-bio-8081"><threadInfo  maxThreads="-1" currentThreadCount="-1" currentThreadsBusy="-1" />` +
+	`<requestInfo  maxTime="0" processingTime="0" requestCount="5" errorCount="0" bytesReceived="0" bytesSent="0" /><workers></workers></connector></status>`
+
+func TestParseStatus(t *testing.T) {
+	stat := make(map[string]float64)
+	connectors, err := parseStatus(strings.NewReader(statusXML), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, connectors, []string{"http-nio-8080", "ajp-nio-8009", "http-bio-8081"})
+
+	assert.Equal(t, stat["memory_used"], 167772160.0)
+	assert.Equal(t, stat["memory_max"], 536870912.0)
+	assert.Equal(t, stat["http-nio-8080_request_count"], 789.0)
+	assert.Equal(t, stat["http-nio-8080_error_count"], 12.0)
+	assert.Equal(t, stat["http-nio-8080_processing_time"], 3456.0)
+	assert.Equal(t, stat["http-nio-8080_bytes_sent"], 65536.0)
+	assert.Equal(t, stat["http-nio-8080_threads_busy"], 2.0)
+	assert.Equal(t, stat["ajp-nio-8009_threads_max"], 200.0)
+	assert.Equal(t, stat["http-nio-8080_threads_busy_percentage"], 1.0)
+	assert.Equal(t, stat["ajp-nio-8009_threads_busy_percentage"], 0.0)
+
+	assert.Equal(t, stat["heap_used"], 134217728.0)
+	assert.Equal(t, stat["heap_committed"], 201326592.0)
+	assert.Equal(t, stat["heap_max"], 536870912.0)
+
+	// a shared executor (Tomcat 7)
+	assert.Equal(t, stat["http-bio-8081_request_count"], 5.0)
+	_, ok := stat["http-bio-8081_threads_max"]
+	assert.False(t, ok)
+}
+
+func TestBusyPercentage(t *testing.T) {
+	v, ok := busyPercentage(150, 200)
+	assert.True(t, ok)
+	assert.Equal(t, v, 75.0)
+
+	_, ok = busyPercentage(0, 0)
+	assert.False(t, ok)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var tomcat TomcatPlugin
+	tomcat.Connectors = []string{"http-nio-8080"}
+
+	graphs := tomcat.GraphDefinition()
+	assert.Equal(t, len(graphs), 7)
+	assert.Equal(t, graphs["tomcat.http-nio-8080.threads_busy_percentage"].Metrics[0].Name, "http-nio-8080_threads_busy_percentage")
+	assert.Equal(t, graphs["tomcat.http-nio-8080.requests"].Metrics[0].Name, "http-nio-8080_request_count")
+	assert.True(t, graphs["tomcat.http-nio-8080.traffic"].Metrics[1].Diff)
+	assert.False(t, graphs["tomcat.http-nio-8080.threads"].Metrics[0].Diff)
+}
@@ -0,0 +1,218 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"twemproxy.connections": mp.Graphs{
+		Label: "Twemproxy Connections",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "curr_connections", Label: "Current"},
+			mp.Metrics{Name: "total_connections", Label: "New", Diff: true},
+		},
+	},
+}
+
+// metrics of each pool
+var poolMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "client_connections_", Unit: "integer",
+		Graph: "client_connections", GraphLabel: "Twemproxy Client Connections"},
+	common.DimensionMetric{Prefix: "client_err_", Unit: "integer", Diff: true,
+		Graph: "client_err", GraphLabel: "Twemproxy Client Errors"},
+	common.DimensionMetric{Prefix: "forward_error_", Unit: "integer", Diff: true,
+		Graph: "forward_error", GraphLabel: "Twemproxy Forward Errors"},
+	common.DimensionMetric{Prefix: "server_ejects_", Unit: "integer",
+		Graph: "server_ejects", GraphLabel: "Twemproxy Server Ejections"},
+}
+
+// metrics of each server, named <pool>_<server>
+var serverMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "requests_", Unit: "integer", Diff: true, Stacked: true,
+		Graph: "requests", GraphLabel: "Twemproxy Server Requests"},
+	common.DimensionMetric{Prefix: "request_bytes_", Unit: "bytes", Diff: true, Stacked: true,
+		Graph: "request_bytes", GraphLabel: "Twemproxy Server Request Bytes"},
+	common.DimensionMetric{Prefix: "response_bytes_", Unit: "bytes", Diff: true, Stacked: true,
+		Graph: "response_bytes", GraphLabel: "Twemproxy Server Response Bytes"},
+	common.DimensionMetric{Prefix: "server_connections_", Unit: "integer",
+		Graph: "server_connections", GraphLabel: "Twemproxy Server Connections"},
+	common.DimensionMetric{Prefix: "server_timedout_", Unit: "integer", Diff: true,
+		Graph: "server_timedout", GraphLabel: "Twemproxy Server Timeouts"},
+	common.DimensionMetric{Prefix: "server_err_", Unit: "integer", Diff: true,
+		Graph: "server_err", GraphLabel: "Twemproxy Server Errors"},
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+type TwemproxyPlugin struct {
+	Target  string
+	Network string
+	Timeout time.Duration
+	Pools   []string
+	Servers []string
+}
+
+type twemproxyStats struct {
+	stat    map[string]float64
+	pools   []string
+	servers []string
+}
+
+// copyNumbers sets the numbers of src in the metrics named <key>_<suffix>
+func copyNumbers(src map[string]interface{}, keys []string, suffix string, stat map[string]float64) {
+	for _, key := range keys {
+		if v, ok := src[key].(float64); ok {
+			stat[key+"_"+suffix] = v
+		}
+	}
+}
+
+// % nc localhost 22222
+// {"service":"nutcracker", "source":"proxy1", "version":"0.4.1", "uptime":3600, "timestamp":1500000000,
+// "total_connections":120, "curr_connections":12,
+// "alpha": {"client_eof":0, "client_err":0, "client_connections":10, "server_ejects":1, "forward_error":0, "fragments":0,
+// "server1": {"server_eof":0, "server_err":0, "server_timedout":2, "server_connections":1, "server_ejected_at":0,
+// "requests":1000, "request_bytes":50000, "responses":1000, "response_bytes":80000, "in_queue":0, ...}}}
+//
+// the objects are the pools, and the objects in a pool are its servers
+func parseStats(r io.Reader) (twemproxyStats, error) {
+	var raw map[string]interface{}
+	if err := json.NewDecoder(r).Decode(&raw); err != nil {
+		return twemproxyStats{}, err
+	}
+
+	st := twemproxyStats{stat: make(map[string]float64)}
+	for _, key := range []string{"curr_connections", "total_connections"} {
+		if v, ok := raw[key].(float64); ok {
+			st.stat[key] = v
+		}
+	}
+
+	for poolName, v := range raw {
+		pool, ok := v.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		p := metricName(poolName)
+		st.pools = append(st.pools, p)
+		copyNumbers(pool, []string{"client_connections", "client_err", "forward_error", "server_ejects"}, p, st.stat)
+
+		for serverName, v := range pool {
+			server, ok := v.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			s := p + "_" + metricName(serverName)
+			st.servers = append(st.servers, s)
+			copyNumbers(server, []string{"requests", "request_bytes", "response_bytes", "server_connections", "server_timedout", "server_err"}, s, st.stat)
+		}
+	}
+	sort.Strings(st.pools)
+	sort.Strings(st.servers)
+	return st, nil
+}
+
+func (p TwemproxyPlugin) fetchStats() (twemproxyStats, error) {
+	conn, err := net.DialTimeout(p.Network, p.Target, p.Timeout)
+	if err != nil {
+		return twemproxyStats{}, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(p.Timeout))
+
+	// twemproxy writes the stats and closes the connection
+	st, err := parseStats(conn)
+	if err != nil {
+		return twemproxyStats{}, errors.New(fmt.Sprintf("%s: %s", p.Target, err))
+	}
+	return st, nil
+}
+
+// Prepare lists the pools and the servers for the graphs
+func (p *TwemproxyPlugin) Prepare() error {
+	st, err := p.fetchStats()
+	if err != nil {
+		return err
+	}
+	p.Pools = st.pools
+	p.Servers = st.servers
+	return nil
+}
+
+func (p TwemproxyPlugin) FetchMetrics() (map[string]float64, error) {
+	st, err := p.fetchStats()
+	if err != nil {
+		return nil, err
+	}
+	return st.stat, nil
+}
+
+func (p TwemproxyPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphs := common.DimensionGraphs("twemproxy", poolMetrics, p.Pools, false)
+	for k, v := range common.DimensionGraphs("twemproxy", serverMetrics, p.Servers, false) {
+		graphs[k] = v
+	}
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	optHost := flag.String("host", "localhost", "Hostname of twemproxy")
+	optPort := flag.String("port", "22222", "Stats port of twemproxy")
+	optNetwork := common.NetworkFlag()
+	optTimeout := flag.Int("timeout", 5, "Timeout (sec) to read the stats")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	if err := common.CheckNetwork(*optNetwork); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	var twemproxy TwemproxyPlugin
+	twemproxy.Target = common.HostPort(*optHost, *optPort)
+	twemproxy.Network = *optNetwork
+	twemproxy.Timeout = time.Duration(*optTimeout) * time.Second
+
+	if err := twemproxy.Prepare(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(twemproxy))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = fmt.Sprintf("/tmp/mackerel-plugin-twemproxy-%s-%s", *optHost, *optPort)
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,43 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseStats(t *testing.T) {
+	stub := `{"service":"nutcracker", "source":"proxy1", "version":"0.4.1", "uptime":3600, "timestamp":1500000000, "total_connections":120, "curr_connections":12,
+"alpha": {"client_eof":0, "client_err":3, "client_connections":10, "server_ejects":1, "forward_error":2, "fragments":0,
+"server1": {"server_eof":0, "server_err":0, "server_timedout":2, "server_connections":1, "server_ejected_at":0, "requests":1000, "request_bytes":50000, "responses":1000, "response_bytes":80000, "in_queue":0, "in_queue_bytes":0, "out_queue":0, "out_queue_bytes":0},
+"10.0.0.2:6379": {"server_eof":0, "server_err":1, "server_timedout":0, "server_connections":1, "server_ejected_at":1499999000000000, "requests":900, "request_bytes":45000, "responses":900, "response_bytes":70000, "in_queue":0, "in_queue_bytes":0, "out_queue":0, "out_queue_bytes":0}},
+"beta": {"client_eof":0, "client_err":0, "client_connections":2, "server_ejects":0, "forward_error":0, "fragments":0}
+}`
+	st, err := parseStats(strings.NewReader(stub))
+	assert.Nil(t, err)
+	assert.Equal(t, st.pools, []string{"alpha", "beta"})
+	assert.Equal(t, st.servers, []string{"alpha_10_0_0_2_6379", "alpha_server1"})
+
+	assert.Equal(t, st.stat["curr_connections"], 12.0)
+	assert.Equal(t, st.stat["total_connections"], 120.0)
+	assert.Equal(t, st.stat["client_connections_alpha"], 10.0)
+	assert.Equal(t, st.stat["client_err_alpha"], 3.0)
+	assert.Equal(t, st.stat["server_ejects_alpha"], 1.0)
+	assert.Equal(t, st.stat["client_connections_beta"], 2.0)
+	assert.Equal(t, st.stat["requests_alpha_server1"], 1000.0)
+	assert.Equal(t, st.stat["response_bytes_alpha_server1"], 80000.0)
+	assert.Equal(t, st.stat["server_timedout_alpha_server1"], 2.0)
+	assert.Equal(t, st.stat["server_err_alpha_10_0_0_2_6379"], 1.0)
+
+	_, err = parseStats(strings.NewReader(`{"service":"nutcracker"`))
+	assert.NotNil(t, err)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	twemproxy := TwemproxyPlugin{Pools: []string{"alpha"}, Servers: []string{"alpha_server1", "alpha_server2"}}
+	graphs := twemproxy.GraphDefinition()
+	assert.Equal(t, graphs["twemproxy.server_ejects"].Metrics[0].Name, "server_ejects_alpha")
+	assert.Equal(t, len(graphs["twemproxy.requests"].Metrics), 2)
+	assert.True(t, graphs["twemproxy.requests"].Metrics[1].Diff)
+}
@@ -5,6 +5,7 @@ import (
 	"flag"
 	"fmt"
 	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
 	"os"
 	"os/exec"
 	"regexp"
@@ -70,22 +71,26 @@ func main() {
 	optVarnishStatPath := flag.String("varnishstat", "/usr/bin/varnishstat", "Path of varnishstat")
 	optVarnishName := flag.String("varnish-name", "", "Varnish name")
 	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
 	flag.Parse()
 
 	var varnish VarnishPlugin
 	varnish.VarnishStatPath = *optVarnishStatPath
 	varnish.VarnishName = *optVarnishName
-	helper := mp.NewMackerelPlugin(varnish)
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(varnish))
 
 	if *optTempfile != "" {
 		helper.Tempfile = *optTempfile
 	} else {
-		helper.Tempfile = "/tmp/mackerel-plugin-varnish"
+		helper.Tempfile = common.Tempfile("mackerel-plugin-varnish", "varnish-name")
 	}
 
 	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
 		helper.OutputDefinitions()
 	} else {
-		helper.OutputValues()
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
 	}
 }
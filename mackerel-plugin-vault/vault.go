@@ -0,0 +1,225 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"vault.sealed": mp.Graphs{
+		Label: "Vault Sealed",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "sealed", Label: "Sealed"},
+		},
+	},
+	"vault.requests": mp.Graphs{
+		Label: "Vault Requests",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "requests", Label: "Requests", Diff: true},
+		},
+	},
+	"vault.tokens": mp.Graphs{
+		Label: "Vault Tokens",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "token_creation", Label: "Created", Diff: true},
+			mp.Metrics{Name: "token_revocation", Label: "Revoked", Diff: true},
+		},
+	},
+	"vault.leases": mp.Graphs{
+		Label: "Vault Leases",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "leases", Label: "Leases"},
+			mp.Metrics{Name: "lease_revocation", Label: "Revoked", Diff: true},
+		},
+	},
+	"vault.storage_latency": mp.Graphs{
+		Label: "Vault Storage Latency (median, ms)",
+		Unit:  "float",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "barrier_get", Label: "Get"},
+			mp.Metrics{Name: "barrier_put", Label: "Put"},
+			mp.Metrics{Name: "barrier_delete", Label: "Delete"},
+			mp.Metrics{Name: "barrier_list", Label: "List"},
+		},
+	},
+}
+
+// prometheus metric names mapped to metric names.
+// series with different labels (e.g. of auth methods) are summed up.
+var promMetrics map[string]string = map[string]string{
+	"vault_core_handle_request_count": "requests",
+	"vault_token_creation":            "token_creation",
+	"vault_token_revoke_count":        "token_revocation",
+	"vault_expire_num_leases":         "leases",
+	"vault_expire_revoke_count":       "lease_revocation",
+}
+
+// summaries of prometheus metric names mapped to metric names, whose medians are reported
+var promLatencies map[string]string = map[string]string{
+	"vault_barrier_get":    "barrier_get",
+	"vault_barrier_put":    "barrier_put",
+	"vault_barrier_delete": "barrier_delete",
+	"vault_barrier_list":   "barrier_list",
+}
+
+type VaultPlugin struct {
+	Address string
+	Token   string
+}
+
+// parse samples of the prometheus text format, like
+// vault_barrier_get{quantile="0.5"} 0.0431
+// vault_token_creation{auth_method="token",mount_point="auth/token/",token_type="service"} 12
+func parsePrometheus(r io.Reader, stat map[string]float64) error {
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		name := line
+		labels := ""
+		if i := strings.Index(line, "{"); i >= 0 {
+			j := strings.LastIndex(line, "}")
+			if j < i {
+				continue
+			}
+			name = line[:i]
+			labels = line[i+1 : j]
+			line = name + line[j+1:]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+		name = fields[0]
+		v, err := strconv.ParseFloat(fields[1], 64)
+		if err != nil || math.IsNaN(v) || math.IsInf(v, 0) {
+			continue
+		}
+
+		if key, ok := promMetrics[name]; ok {
+			stat[key] += v
+		} else if key, ok := promLatencies[name]; ok && strings.Contains(labels, `quantile="0.5"`) {
+			stat[key] = v
+		}
+	}
+	return scanner.Err()
+}
+
+func (p VaultPlugin) get(path string, token bool) (*http.Response, error) {
+	req, err := http.NewRequest("GET", p.Address+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	if token && p.Token != "" {
+		req.Header.Set("X-Vault-Token", p.Token)
+	}
+
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+		return nil, errors.New(fmt.Sprintf("%s: HTTP status error: %d", path, resp.StatusCode))
+	}
+	return resp, nil
+}
+
+func (p VaultPlugin) fetchSealed() (bool, error) {
+	resp, err := p.get("/v1/sys/seal-status", false)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+
+	var status struct {
+		Sealed bool `json:"sealed"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return false, err
+	}
+	return status.Sealed, nil
+}
+
+func (p VaultPlugin) FetchMetrics() (map[string]float64, error) {
+	stat := make(map[string]float64)
+
+	sealed, err := p.fetchSealed()
+	if err != nil {
+		return nil, err
+	}
+	if sealed {
+		stat["sealed"] = 1
+		// a sealed vault serves no metrics
+		return stat, nil
+	}
+	stat["sealed"] = 0
+
+	resp, err := p.get("/v1/sys/metrics?format=prometheus", true)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if err := parsePrometheus(resp.Body, stat); err != nil {
+		return nil, err
+	}
+
+	return stat, nil
+}
+
+func (p VaultPlugin) GraphDefinition() map[string](mp.Graphs) {
+	return graphdef
+}
+
+func main() {
+	optAddress := flag.String("address", "http://127.0.0.1:8200", "Vault address")
+	optToken := flag.String("token", "", "Vault token to read /v1/sys/metrics (default: $VAULT_TOKEN)")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	httpOpts := common.HTTPFlags()
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+	httpOpts.Setup()
+
+	var vault VaultPlugin
+	vault.Address = strings.TrimRight(*optAddress, "/")
+	vault.Token = *optToken
+	if vault.Token == "" {
+		vault.Token = os.Getenv("VAULT_TOKEN")
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(vault))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = common.Tempfile("mackerel-plugin-vault", "address")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParsePrometheus(t *testing.T) {
+	stub := `# HELP vault_barrier_get vault_barrier_get
+# TYPE vault_barrier_get summary
+vault_barrier_get{quantile="0.5"} 0.0431
+vault_barrier_get{quantile="0.9"} 0.08
+vault_barrier_get{quantile="0.99"} NaN
+vault_barrier_get_sum 1234.5
+vault_barrier_get_count 10000
+vault_barrier_put{quantile="0.5"} NaN
+# TYPE vault_core_handle_request summary
+vault_core_handle_request{quantile="0.5"} 0.5
+vault_core_handle_request_sum 600.5
+vault_core_handle_request_count 1200
+# TYPE vault_token_creation counter
+vault_token_creation{auth_method="token",creation_ttl="+Inf",mount_point="auth/token/",namespace="root",token_type="service"} 12
+vault_token_creation{auth_method="approle",creation_ttl="1h",mount_point="auth/approle/",namespace="root",token_type="batch"} 30
+vault_token_revoke_count 7
+# TYPE vault_expire_num_leases gauge
+vault_expire_num_leases 42
+`
+	stat := make(map[string]float64)
+	err := parsePrometheus(strings.NewReader(stub), stat)
+	assert.Nil(t, err)
+	assert.Equal(t, stat["requests"], 1200.0)
+	assert.Equal(t, stat["token_creation"], 42.0)
+	assert.Equal(t, stat["token_revocation"], 7.0)
+	assert.Equal(t, stat["leases"], 42.0)
+	assert.Equal(t, stat["barrier_get"], 0.0431)
+	_, ok := stat["barrier_put"]
+	assert.False(t, ok)
+}
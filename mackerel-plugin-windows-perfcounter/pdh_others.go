@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+)
+
+func collectCounters(counters []PerfCounter) (map[int]float64, error) {
+	return nil, errors.New("Performance Counters are only available on Windows")
+}
@@ -0,0 +1,86 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"syscall"
+	"time"
+	"unsafe"
+)
+
+const (
+	pdhFmtDouble = 0x00000200
+	errorSuccess = 0
+)
+
+var (
+	modpdh                          = syscall.NewLazyDLL("pdh.dll")
+	procPdhOpenQuery                = modpdh.NewProc("PdhOpenQuery")
+	procPdhAddEnglishCounterW       = modpdh.NewProc("PdhAddEnglishCounterW")
+	procPdhCollectQueryData         = modpdh.NewProc("PdhCollectQueryData")
+	procPdhGetFormattedCounterValue = modpdh.NewProc("PdhGetFormattedCounterValue")
+	procPdhCloseQuery               = modpdh.NewProc("PdhCloseQuery")
+)
+
+// PDH_FMT_COUNTERVALUE with PDH_FMT_DOUBLE
+type pdhFmtCounterValueDouble struct {
+	CStatus     uint32
+	_           uint32
+	DoubleValue float64
+}
+
+// rate counters (e.g. "% Processor Time") need two samples
+const sampleInterval = 1 * time.Second
+
+func collectCounters(counters []PerfCounter) (map[int]float64, error) {
+	var query uintptr
+	r, _, _ := procPdhOpenQuery.Call(0, 0, uintptr(unsafe.Pointer(&query)))
+	if r != errorSuccess {
+		return nil, errors.New(fmt.Sprintf("PdhOpenQuery failed: 0x%x", r))
+	}
+	defer procPdhCloseQuery.Call(query)
+
+	handles := make(map[int]uintptr)
+	for i, c := range counters {
+		path, err := syscall.UTF16PtrFromString(c.Path)
+		if err != nil {
+			return nil, err
+		}
+
+		var counter uintptr
+		r, _, _ := procPdhAddEnglishCounterW.Call(query, uintptr(unsafe.Pointer(path)), 0, uintptr(unsafe.Pointer(&counter)))
+		if r != errorSuccess {
+			log.Printf("PdhAddEnglishCounter failed for %s: 0x%x", c.Path, r)
+			continue
+		}
+		handles[i] = counter
+	}
+	if len(handles) == 0 {
+		return nil, errors.New("no available counters")
+	}
+
+	if r, _, _ := procPdhCollectQueryData.Call(query); r != errorSuccess {
+		return nil, errors.New(fmt.Sprintf("PdhCollectQueryData failed: 0x%x", r))
+	}
+	time.Sleep(sampleInterval)
+	if r, _, _ := procPdhCollectQueryData.Call(query); r != errorSuccess {
+		return nil, errors.New(fmt.Sprintf("PdhCollectQueryData failed: 0x%x", r))
+	}
+
+	values := make(map[int]float64)
+	for i, counter := range handles {
+		var value pdhFmtCounterValueDouble
+		r, _, _ := procPdhGetFormattedCounterValue.Call(counter, pdhFmtDouble, 0, uintptr(unsafe.Pointer(&value)))
+		if r != errorSuccess {
+			log.Printf("PdhGetFormattedCounterValue failed for %s: 0x%x", counters[i].Path, r)
+			continue
+		}
+		values[i] = value.DoubleValue
+	}
+
+	return values, nil
+}
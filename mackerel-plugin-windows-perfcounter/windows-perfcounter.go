@@ -0,0 +1,149 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+type PerfCounter struct {
+	Path  string
+	Graph string
+	Name  string
+	Label string
+	Unit  string
+}
+
+type PerfCounterPlugin struct {
+	Counters []PerfCounter
+}
+
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]")
+
+// the graph name can be nested by dots
+var invalidGraphChars = regexp.MustCompile("[^-a-zA-Z0-9_.]")
+
+// parse '\Processor(_Total)\% Processor Time:cpu.total'
+// the part after the last dot of metric name becomes the metric label,
+// and the rest becomes the graph name.
+func parseCounterSpec(spec string, unit string) (PerfCounter, error) {
+	i := strings.LastIndex(spec, ":")
+	if i <= 0 || i == len(spec)-1 {
+		return PerfCounter{}, errors.New("invalid counter specification: " + spec)
+	}
+	path, name := spec[:i], spec[i+1:]
+
+	c := PerfCounter{Path: path, Unit: unit}
+	if c.Unit == "" {
+		c.Unit = "float"
+	}
+
+	if j := strings.LastIndex(name, "."); j > 0 {
+		c.Graph = invalidGraphChars.ReplaceAllString(name[:j], "_")
+		c.Label = name[j+1:]
+	} else {
+		c.Graph = invalidGraphChars.ReplaceAllString(name, "_")
+		c.Label = name
+	}
+	c.Name = invalidChars.ReplaceAllString(strings.Replace(name, ".", "_", -1), "_")
+
+	return c, nil
+}
+
+func (p PerfCounterPlugin) FetchMetrics() (map[string]float64, error) {
+	values, err := collectCounters(p.Counters)
+	if err != nil {
+		return nil, err
+	}
+
+	stat := make(map[string]float64)
+	for i, c := range p.Counters {
+		if v, ok := values[i]; ok {
+			stat[c.Name] = v
+		}
+	}
+
+	return stat, nil
+}
+
+func (p PerfCounterPlugin) GraphDefinition() map[string](mp.Graphs) {
+	graphdef := make(map[string](mp.Graphs))
+
+	for _, c := range p.Counters {
+		key := "perfcounter." + c.Graph
+		g, ok := graphdef[key]
+		if !ok {
+			g = mp.Graphs{
+				Label: "PerfCounter " + c.Graph,
+				Unit:  c.Unit,
+			}
+		}
+		g.Metrics = append(g.Metrics, mp.Metrics{Name: c.Name, Label: c.Label, Diff: false})
+		graphdef[key] = g
+	}
+
+	return graphdef
+}
+
+func main() {
+	var optCounters, optUnits stringSlice
+	flag.Var(&optCounters, "counter", "Counter path and metric name (<path>:<name>), can be specified multiple times")
+	flag.Var(&optUnits, "unit", "Graph unit for the counter of the same position")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	if len(optCounters) == 0 {
+		fmt.Fprintln(os.Stderr, "-counter is required")
+		flag.PrintDefaults()
+		os.Exit(1)
+	}
+
+	var perfcounter PerfCounterPlugin
+	for i, spec := range optCounters {
+		unit := ""
+		if i < len(optUnits) {
+			unit = optUnits[i]
+		}
+		c, err := parseCounterSpec(spec, unit)
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		perfcounter.Counters = append(perfcounter.Counters, c)
+	}
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(perfcounter))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = filepath.Join(os.TempDir(), "mackerel-plugin-windows-perfcounter")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseCounterSpec(t *testing.T) {
+	c, err := parseCounterSpec(`\Processor(_Total)\% Processor Time:cpu.total`, "percentage")
+	assert.Nil(t, err)
+	assert.Equal(t, c.Path, `\Processor(_Total)\% Processor Time`)
+	assert.Equal(t, c.Graph, "cpu")
+	assert.Equal(t, c.Name, "cpu_total")
+	assert.Equal(t, c.Label, "total")
+	assert.Equal(t, c.Unit, "percentage")
+
+	c, err = parseCounterSpec(`\Memory\Available Bytes:available`, "")
+	assert.Nil(t, err)
+	assert.Equal(t, c.Graph, "available")
+	assert.Equal(t, c.Name, "available")
+	assert.Equal(t, c.Unit, "float")
+
+	c, err = parseCounterSpec(`\PhysicalDisk(_Total)\% Disk Time:disk time%.total`, "percentage")
+	assert.Nil(t, err)
+	assert.Equal(t, c.Graph, "disk_time_")
+	assert.Equal(t, c.Name, "disk_time__total")
+	assert.Equal(t, c.Label, "total")
+
+	_, err = parseCounterSpec(`\Memory\Available Bytes`, "")
+	assert.NotNil(t, err)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var perfcounter PerfCounterPlugin
+	for _, spec := range []string{
+		`\Processor(0)\% Processor Time:cpu.core0`,
+		`\Processor(1)\% Processor Time:cpu.core1`,
+		`\Memory\Available Bytes:memory.available`,
+	} {
+		c, _ := parseCounterSpec(spec, "")
+		perfcounter.Counters = append(perfcounter.Counters, c)
+	}
+
+	graphdef := perfcounter.GraphDefinition()
+	assert.Equal(t, len(graphdef), 2)
+	assert.Equal(t, len(graphdef["perfcounter.cpu"].Metrics), 2)
+	assert.Equal(t, graphdef["perfcounter.cpu"].Metrics[1].Name, "cpu_core1")
+	assert.Equal(t, len(graphdef["perfcounter.memory"].Metrics), 1)
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"flag"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	mp "github.com/mackerelio/go-mackerel-plugin"
+	"github.com/mackerelio/mackerel-agent-plugins/common"
+)
+
+var graphdef map[string](mp.Graphs) = map[string](mp.Graphs){
+	"winservice.services": mp.Graphs{
+		Label: "Windows Services",
+		Unit:  "integer",
+		Metrics: [](mp.Metrics){
+			mp.Metrics{Name: "running", Label: "Running", Stacked: true},
+			mp.Metrics{Name: "stopped", Label: "Stopped", Stacked: true},
+			mp.Metrics{Name: "start_pending", Label: "Start Pending", Stacked: true},
+			mp.Metrics{Name: "other", Label: "Other", Stacked: true},
+		},
+	},
+}
+
+// the state of each service given by -service
+var serviceMetrics = []common.DimensionMetric{
+	common.DimensionMetric{Prefix: "state_", Unit: "integer",
+		Graph: "state", GraphLabel: "Windows Service State"},
+}
+
+// the values of the states, which are the ones of dwCurrentState of SERVICE_STATUS.
+// the unknown state is 0
+var stateValues = map[string]float64{
+	"Stopped":          1,
+	"Start Pending":    2,
+	"Stop Pending":     3,
+	"Running":          4,
+	"Continue Pending": 5,
+	"Pause Pending":    6,
+	"Paused":           7,
+}
+
+// the value of the services which don't exist
+const notFound = -1
+
+type service struct {
+	Name  string
+	State string
+}
+
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(v string) error {
+	*s = append(*s, v)
+	return nil
+}
+
+var invalidChars = regexp.MustCompile("[^-a-zA-Z0-9_]+")
+
+func metricName(s string) string {
+	return strings.Trim(invalidChars.ReplaceAllString(s, "_"), "_")
+}
+
+type WinServicePlugin struct {
+	Services []string
+}
+
+// serviceStat returns the counts of the services by state, and the states of the given services.
+// The names of the services are case-insensitive, as Windows does.
+func serviceStat(services []service, names []string) map[string]float64 {
+	stat := map[string]float64{"running": 0, "stopped": 0, "start_pending": 0, "other": 0}
+	states := make(map[string]string)
+	for _, s := range services {
+		states[strings.ToLower(s.Name)] = s.State
+		switch s.State {
+		case "Running":
+			stat["running"]++
+		case "Stopped":
+			stat["stopped"]++
+		case "Start Pending":
+			stat["start_pending"]++
+		default:
+			stat["other"]++
+		}
+	}
+
+	for _, name := range names {
+		state, ok := states[strings.ToLower(name)]
+		if !ok {
+			log.Printf("service %s is not found", name)
+			stat["state_"+metricName(name)] = notFound
+			continue
+		}
+		stat["state_"+metricName(name)] = stateValues[state]
+	}
+	return stat
+}
+
+func (p WinServicePlugin) FetchMetrics() (map[string]float64, error) {
+	services, err := queryServices()
+	if err != nil {
+		return nil, err
+	}
+	return serviceStat(services, p.Services), nil
+}
+
+func (p WinServicePlugin) GraphDefinition() map[string](mp.Graphs) {
+	names := make([]string, 0, len(p.Services))
+	for _, s := range p.Services {
+		names = append(names, metricName(s))
+	}
+
+	graphs := common.DimensionGraphs("winservice", serviceMetrics, names, false)
+	for k, v := range graphdef {
+		graphs[k] = v
+	}
+	return graphs
+}
+
+func main() {
+	var optServices stringSlice
+	flag.Var(&optServices, "service", "Name of the service to report the state of, can be specified multiple times")
+	optTempfile := flag.String("tempfile", "", "Temp file name")
+	statsd := common.StatsdFlags()
+	selfMetrics := common.SelfMetricsFlags()
+	postProcess := common.PostProcessFlags()
+	flag.Parse()
+
+	var winservice WinServicePlugin
+	winservice.Services = optServices
+
+	helper := mp.NewMackerelPlugin(selfMetrics.Wrap(winservice))
+	if *optTempfile != "" {
+		helper.Tempfile = *optTempfile
+	} else {
+		helper.Tempfile = filepath.Join(os.TempDir(), "mackerel-plugin-winservice")
+	}
+
+	if os.Getenv("MACKEREL_AGENT_PLUGIN_META") != "" {
+		helper.OutputDefinitions()
+	} else {
+		common.RecoverTempfile(helper.Tempfile)
+		common.OutputValues(&helper, statsd, postProcess)
+	}
+}
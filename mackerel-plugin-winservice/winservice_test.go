@@ -0,0 +1,36 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestServiceStat(t *testing.T) {
+	services := []service{
+		{Name: "W3SVC", State: "Running"},
+		{Name: "MSSQLSERVER", State: "Start Pending"},
+		{Name: "Spooler", State: "Stopped"},
+		{Name: "wuauserv", State: "Paused"},
+		{Name: "Dhcp", State: "Running"},
+	}
+
+	stat := serviceStat(services, []string{"w3svc", "MSSQLSERVER", "wuauserv", "NoSuchService"})
+	assert.EqualValues(t, stat["running"], 2)
+	assert.EqualValues(t, stat["stopped"], 1)
+	assert.EqualValues(t, stat["start_pending"], 1)
+	assert.EqualValues(t, stat["other"], 1)
+	assert.EqualValues(t, stat["state_w3svc"], 4)
+	assert.EqualValues(t, stat["state_MSSQLSERVER"], 2)
+	assert.EqualValues(t, stat["state_wuauserv"], 7)
+	assert.EqualValues(t, stat["state_NoSuchService"], notFound)
+}
+
+func TestGraphDefinition(t *testing.T) {
+	var winservice WinServicePlugin
+	winservice.Services = []string{"W3SVC", "MSSQL$SQLEXPRESS"}
+
+	graphs := winservice.GraphDefinition()
+	assert.Equal(t, len(graphs["winservice.state"].Metrics), 2)
+	assert.Equal(t, graphs["winservice.state"].Metrics[1].Name, "state_MSSQL_SQLEXPRESS")
+}
@@ -0,0 +1,12 @@
+//go:build !windows
+// +build !windows
+
+package main
+
+import (
+	"errors"
+)
+
+func queryServices() ([]service, error) {
+	return nil, errors.New("WMI is only available on Windows")
+}
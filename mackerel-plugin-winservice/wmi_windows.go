@@ -0,0 +1,27 @@
+//go:build windows
+// +build windows
+
+package main
+
+import (
+	"github.com/StackExchange/wmi"
+)
+
+// Win32_Service of WMI
+type win32Service struct {
+	Name  string
+	State string
+}
+
+func queryServices() ([]service, error) {
+	var dst []win32Service
+	if err := wmi.Query("SELECT Name, State FROM Win32_Service", &dst); err != nil {
+		return nil, err
+	}
+
+	services := make([]service, 0, len(dst))
+	for _, s := range dst {
+		services = append(services, service{Name: s.Name, State: s.State})
+	}
+	return services, nil
+}